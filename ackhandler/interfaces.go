@@ -10,20 +10,29 @@ import (
 // SentPacketHandler handles ACKs received for outgoing packets
 type SentPacketHandler interface {
 	SentPacket(packet *Packet) error
+	// SentPMTUProbe registers a PMTU probe, which is neither retransmitted nor counted as bytes in flight
+	SentPMTUProbe(packetNumber protocol.PacketNumber) error
 	ReceivedAck(ackFrame *frames.AckFrame, withPacketNumber protocol.PacketNumber, recvTime time.Time) error
 
 	GetStopWaitingFrame(force bool) *frames.StopWaitingFrame
 
 	MaybeQueueRTOs()
 	DequeuePacketForRetransmission() (packet *Packet)
+	CancelRetransmissionsForStream(streamID protocol.StreamID)
 
 	BytesInFlight() protocol.ByteCount
+	// HasOutstandingRetransmittablePackets returns true if there are retransmittable packets that were neither acknowledged nor retransmitted yet
+	HasOutstandingRetransmittablePackets() bool
 	GetLeastUnacked() protocol.PacketNumber
 
 	SendingAllowed() bool
+	// TimeUntilSend returns the time when the pacer allows sending the next packet, or the zero time if it may be sent right away
+	TimeUntilSend() time.Time
 	CheckForError() error
 
 	TimeOfFirstRTO() time.Time
+
+	OnConnectionMigration()
 }
 
 // ReceivedPacketHandler handles ACKs needed to send for incoming packets
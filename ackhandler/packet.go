@@ -30,6 +30,39 @@ func (p *Packet) GetStreamFramesForRetransmission() []*frames.StreamFrame {
 	return streamFrames
 }
 
+// isRetransmittable returns true if the packet contains frames that are retransmitted if the packet is lost
+func (p *Packet) isRetransmittable() bool {
+	return len(p.GetStreamFramesForRetransmission()) > 0 || len(p.GetControlFramesForRetransmission()) > 0
+}
+
+// copyForRetransmission returns a copy of the packet that only contains the retransmittable frames
+// The StreamFrames are copied, since they are modified when they are packed into a new packet.
+func (p *Packet) copyForRetransmission() *Packet {
+	var fs []frames.Frame
+	for _, streamFrame := range p.GetStreamFramesForRetransmission() {
+		f := *streamFrame
+		fs = append(fs, &f)
+	}
+	fs = append(fs, p.GetControlFramesForRetransmission()...)
+	return &Packet{
+		PacketNumber: p.PacketNumber,
+		Frames:       fs,
+		Length:       p.Length,
+	}
+}
+
+// removeStreamFrames removes all StreamFrames of a stream from the packet
+func (p *Packet) removeStreamFrames(streamID protocol.StreamID) {
+	fs := p.Frames[:0]
+	for _, frame := range p.Frames {
+		if streamFrame, isStreamFrame := frame.(*frames.StreamFrame); isStreamFrame && streamFrame.StreamID == streamID {
+			continue
+		}
+		fs = append(fs, frame)
+	}
+	p.Frames = fs
+}
+
 // GetControlFramesForRetransmission gets all the control frames for retransmission
 func (p *Packet) GetControlFramesForRetransmission() []frames.Frame {
 	var controlFrames []frames.Frame
@@ -90,5 +90,29 @@ var _ = Describe("Packet", func() {
 			controlFrames := packet.GetControlFramesForRetransmission()
 			Expect(controlFrames).To(BeEmpty())
 		})
+
+		It("copies the retransmittable frames", func() {
+			packet.Length = 1000
+			p := packet.copyForRetransmission()
+			Expect(p.PacketNumber).To(Equal(packet.PacketNumber))
+			Expect(p.Length).To(Equal(packet.Length))
+			Expect(p.Frames).To(HaveLen(4))
+			Expect(p.Frames).To(ContainElement(streamFrame1))
+			Expect(p.Frames).To(ContainElement(streamFrame2))
+			Expect(p.Frames).To(ContainElement(rstStreamFrame))
+			Expect(p.Frames).To(ContainElement(windowUpdateFrame))
+			Expect(p.Frames).ToNot(ContainElement(ackFrame1))
+			Expect(p.Frames).ToNot(ContainElement(stopWaitingFrame))
+		})
+
+		It("doesn't modify the original StreamFrames when modifying the copy", func() {
+			p := packet.copyForRetransmission()
+			streamFrames := p.GetStreamFramesForRetransmission()
+			Expect(streamFrames).To(HaveLen(2))
+			streamFrames[0].Offset = 100
+			streamFrames[0].DataLenPresent = true
+			Expect(streamFrame1.Offset).To(BeZero())
+			Expect(streamFrame1.DataLenPresent).To(BeFalse())
+		})
 	})
 })
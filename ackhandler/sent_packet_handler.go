@@ -42,27 +42,56 @@ type sentPacketHandler struct {
 
 	rttStats   *congestion.RTTStats
 	congestion congestion.SendAlgorithm
+	pacer      *congestion.Pacer
 
 	consecutiveRTOCount uint32
+
+	// earlyLossDetection enables tail loss probes and time-based loss detection
+	earlyLossDetection bool
+	// the number of tail loss probes sent since the last new packet was acknowledged
+	tlpCount uint32
+	// lossTime is the time when the first packet below the LargestAcked will be declared lost
+	// It is zero if there are no such packets.
+	lossTime time.Time
+
+	// maxTrackedSentPackets is the maximum number of sent packets saved for either later retransmission or entropy calculation
+	maxTrackedSentPackets protocol.PacketNumber
 }
 
 // NewSentPacketHandler creates a new sentPacketHandler
-func NewSentPacketHandler() SentPacketHandler {
+// The congestion windows are given in packets.
+// If earlyLossDetection is set, tail loss probes are sent before the RTO, and packets are also declared lost if they are outstanding for too long after a later packet was acknowledged.
+func NewSentPacketHandler(algorithm congestion.Algorithm, initialCongestionWindow, maxCongestionWindow protocol.PacketNumber, earlyLossDetection bool) SentPacketHandler {
 	rttStats := &congestion.RTTStats{}
 
-	congestion := congestion.NewCubicSender(
-		congestion.DefaultClock{},
-		rttStats,
-		false, /* don't use reno since chromium doesn't (why?) */
-		protocol.InitialCongestionWindow,
-		protocol.DefaultMaxCongestionWindow,
-	)
+	var sendAlgorithm congestion.SendAlgorithm
+	switch algorithm {
+	case congestion.AlgorithmBBR:
+		sendAlgorithm = congestion.NewBBRSender(
+			congestion.DefaultClock{},
+			rttStats,
+			initialCongestionWindow,
+			maxCongestionWindow,
+		)
+	default:
+		sendAlgorithm = congestion.NewCubicSender(
+			congestion.DefaultClock{},
+			rttStats,
+			false, /* don't use reno since chromium doesn't (why?) */
+			initialCongestionWindow,
+			maxCongestionWindow,
+		)
+	}
 
 	return &sentPacketHandler{
 		packetHistory:      NewPacketList(),
 		stopWaitingManager: stopWaitingManager{},
 		rttStats:           rttStats,
-		congestion:         congestion,
+		congestion:         sendAlgorithm,
+		pacer:              congestion.NewPacer(),
+		earlyLossDetection: earlyLossDetection,
+
+		maxTrackedSentPackets: 2 * maxCongestionWindow,
 	}
 }
 
@@ -111,14 +140,7 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 	if packet.PacketNumber <= h.lastSentPacketNumber {
 		return errPacketNumberNotIncreasing
 	}
-
-	for p := h.lastSentPacketNumber + 1; p < packet.PacketNumber; p++ {
-		h.skippedPackets = append(h.skippedPackets, p)
-
-		if len(h.skippedPackets) > protocol.MaxTrackedSkippedPackets {
-			h.skippedPackets = h.skippedPackets[1:]
-		}
-	}
+	h.trackSkippedPackets(packet.PacketNumber)
 
 	now := time.Now()
 	h.lastSentPacketTime = now
@@ -126,6 +148,7 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 	if packet.Length == 0 {
 		return errors.New("SentPacketHandler: packet cannot be empty")
 	}
+	priorInFlight := h.bytesInFlight
 	h.bytesInFlight += packet.Length
 
 	h.lastSentPacketNumber = packet.PacketNumber
@@ -138,10 +161,36 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 		packet.Length,
 		true, /* TODO: is retransmittable */
 	)
+	// ACK-only packets are sent whenever an ACK is due, they don't use up the pacing budget
+	if packet.isRetransmittable() {
+		h.pacer.OnPacketSent(now, priorInFlight, packet.Length, h.congestion.PacingRate())
+	}
+
+	return nil
+}
 
+// SentPMTUProbe registers the packet number of a PMTU probe
+// Probes are neither passed to the congestion controller nor retransmitted. Their loss is detected by the session.
+func (h *sentPacketHandler) SentPMTUProbe(packetNumber protocol.PacketNumber) error {
+	if packetNumber <= h.lastSentPacketNumber {
+		return errPacketNumberNotIncreasing
+	}
+	h.trackSkippedPackets(packetNumber)
+	h.lastSentPacketNumber = packetNumber
 	return nil
 }
 
+// trackSkippedPackets remembers the packet numbers skipped before sending the packet with the given packet number
+func (h *sentPacketHandler) trackSkippedPackets(packetNumber protocol.PacketNumber) {
+	for p := h.lastSentPacketNumber + 1; p < packetNumber; p++ {
+		h.skippedPackets = append(h.skippedPackets, p)
+
+		if len(h.skippedPackets) > protocol.MaxTrackedSkippedPackets {
+			h.skippedPackets = h.skippedPackets[1:]
+		}
+	}
+}
+
 func (h *sentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNumber protocol.PacketNumber, rcvTime time.Time) error {
 	if ackFrame.LargestAcked > h.lastSentPacketNumber {
 		return errAckForUnsentPacket
@@ -229,9 +278,14 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNum
 		}
 	}
 
+	if h.earlyLossDetection {
+		lostPackets = append(lostPackets, h.detectLostPackets(rcvTime)...)
+	}
+
 	if rttUpdated {
-		// Reset counter if a new packet was acked
+		// Reset counters if a new packet was acked
 		h.consecutiveRTOCount = 0
+		h.tlpCount = 0
 	}
 
 	h.garbageCollectSkippedPackets()
@@ -268,6 +322,18 @@ func (h *sentPacketHandler) BytesInFlight() protocol.ByteCount {
 	return h.bytesInFlight
 }
 
+func (h *sentPacketHandler) HasOutstandingRetransmittablePackets() bool {
+	if len(h.retransmissionQueue) > 0 {
+		return true
+	}
+	for el := h.packetHistory.Front(); el != nil; el = el.Next() {
+		if el.Value.isRetransmittable() {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *sentPacketHandler) GetLeastUnacked() protocol.PacketNumber {
 	return h.largestInOrderAcked() + 1
 }
@@ -278,23 +344,81 @@ func (h *sentPacketHandler) GetStopWaitingFrame(force bool) *frames.StopWaitingF
 
 func (h *sentPacketHandler) SendingAllowed() bool {
 	congestionLimited := h.BytesInFlight() > h.congestion.GetCongestionWindow()
-	maxTrackedLimited := protocol.PacketNumber(len(h.retransmissionQueue)+h.packetHistory.Len()) >= protocol.MaxTrackedSentPackets
+	maxTrackedLimited := protocol.PacketNumber(len(h.retransmissionQueue)+h.packetHistory.Len()) >= h.maxTrackedSentPackets
 	return !(congestionLimited || maxTrackedLimited)
 }
 
+func (h *sentPacketHandler) TimeUntilSend() time.Time {
+	return h.pacer.TimeUntilSend(time.Now(), h.bytesInFlight)
+}
+
 func (h *sentPacketHandler) CheckForError() error {
 	length := len(h.retransmissionQueue) + h.packetHistory.Len()
-	if protocol.PacketNumber(length) > protocol.MaxTrackedSentPackets {
+	if protocol.PacketNumber(length) > h.maxTrackedSentPackets {
 		return ErrTooManyTrackedSentPackets
 	}
 	return nil
 }
 
+// OnConnectionMigration resets the RTT and congestion state, since they don't apply to the new path
+func (h *sentPacketHandler) OnConnectionMigration() {
+	h.rttStats.OnConnectionMigration()
+	h.congestion.OnConnectionMigration()
+}
+
+// detectLostPackets queues the packets below the LargestAcked for retransmission that were sent too long ago
+// A packet is lost if it was sent more than 9/8 RTT ago.
+// It sets the lossTime to the time when the next packet would be declared lost.
+func (h *sentPacketHandler) detectLostPackets(now time.Time) congestion.PacketVector {
+	h.lossTime = time.Time{}
+
+	maxRTT := utils.MaxDuration(h.rttStats.LatestRTT(), h.rttStats.SmoothedRTT())
+	if maxRTT == 0 {
+		return nil
+	}
+	delayUntilLost := maxRTT + maxRTT/8
+
+	var lostPackets congestion.PacketVector
+	var el, elNext *PacketElement
+	for el = h.packetHistory.Front(); el != nil; el = elNext {
+		elNext = el.Next()
+		packet := el.Value
+		if packet.PacketNumber >= h.LargestAcked {
+			break
+		}
+
+		if now.Sub(packet.SendTime) > delayUntilLost {
+			utils.Debugf("\tQueueing packet 0x%x for retransmission (time threshold)", packet.PacketNumber)
+			lostPackets = append(lostPackets, congestion.PacketInfo{Number: packet.PacketNumber, Length: packet.Length})
+			h.queuePacketForRetransmission(el)
+		} else if h.lossTime.IsZero() {
+			// packets are sorted by send time, so this is the packet that will be lost first
+			h.lossTime = packet.SendTime.Add(delayUntilLost)
+		}
+	}
+	return lostPackets
+}
+
+// MaybeQueueRTOs is called when the timer returned by TimeOfFirstRTO fires
+// It declares packets lost by the time threshold, sends a tail loss probe, or queues the RTO retransmissions, whatever is due first.
 func (h *sentPacketHandler) MaybeQueueRTOs() {
-	if time.Now().Before(h.TimeOfFirstRTO()) {
+	now := time.Now()
+	if now.Before(h.TimeOfFirstRTO()) {
 		return
 	}
 
+	if h.earlyLossDetection {
+		if !h.lossTime.IsZero() {
+			lostPackets := h.detectLostPackets(now)
+			h.congestion.OnCongestionEvent(false, h.BytesInFlight(), nil, lostPackets)
+			return
+		}
+		if !h.timeOfTailLossProbe().IsZero() {
+			h.queueTailLossProbe()
+			return
+		}
+	}
+
 	// Always queue the two oldest packets
 	if h.packetHistory.Front() != nil {
 		h.queueRTO(h.packetHistory.Front())
@@ -308,6 +432,23 @@ func (h *sentPacketHandler) MaybeQueueRTOs() {
 	h.consecutiveRTOCount++
 }
 
+// queueTailLossProbe retransmits the data of the last retransmittable packet sent, hoping to elicit an ACK that allows fast recovery of the lost packets
+// The probe is not a loss signal. The original packet stays outstanding, so that an ACK for it is still processed, and the congestion controller is not notified.
+func (h *sentPacketHandler) queueTailLossProbe() {
+	for el := h.packetHistory.Back(); el != nil; el = el.Prev() {
+		if !el.Value.isRetransmittable() {
+			continue
+		}
+		utils.Debugf("\tQueueing a copy of packet 0x%x for retransmission (tail loss probe)", el.Value.PacketNumber)
+		h.retransmissionQueue = append(h.retransmissionQueue, el.Value.copyForRetransmission())
+		break
+	}
+
+	// Reset the timer here, in case the probe can't be sent right away
+	h.lastSentPacketTime = time.Now()
+	h.tlpCount++
+}
+
 func (h *sentPacketHandler) queueRTO(el *PacketElement) {
 	packet := &el.Value
 	packetsLost := congestion.PacketVector{congestion.PacketInfo{
@@ -320,6 +461,17 @@ func (h *sentPacketHandler) queueRTO(el *PacketElement) {
 	h.queuePacketForRetransmission(el)
 }
 
+// CancelRetransmissionsForStream removes the StreamFrames of a stream from all packets, so that they won't be retransmitted
+// It should be called when the stream is reset.
+func (h *sentPacketHandler) CancelRetransmissionsForStream(streamID protocol.StreamID) {
+	for el := h.packetHistory.Front(); el != nil; el = el.Next() {
+		el.Value.removeStreamFrames(streamID)
+	}
+	for _, packet := range h.retransmissionQueue {
+		packet.removeStreamFrames(streamID)
+	}
+}
+
 func (h *sentPacketHandler) getRTO() time.Duration {
 	rto := h.congestion.RetransmissionDelay()
 	if rto == 0 {
@@ -331,10 +483,40 @@ func (h *sentPacketHandler) getRTO() time.Duration {
 	return utils.MinDuration(rto, protocol.MaxRetransmissionTime)
 }
 
+// getTailLossProbeDelay gets the time between the last packet sent and the tail loss probe
+func (h *sentPacketHandler) getTailLossProbeDelay() time.Duration {
+	srtt := h.rttStats.SmoothedRTT()
+	// If only one packet is outstanding, the peer might delay the ACK
+	if h.packetHistory.Len() == 1 {
+		return utils.MaxDuration(2*srtt, srtt*3/2+protocol.PeerDelayedAckTime)
+	}
+	return utils.MaxDuration(2*srtt, protocol.MinTailLossProbeTime)
+}
+
+// timeOfTailLossProbe returns the time when the next tail loss probe is sent
+// It returns the zero time if no tail loss probe should be sent.
+func (h *sentPacketHandler) timeOfTailLossProbe() time.Time {
+	// without an RTT estimate, rely on the RTO
+	if h.tlpCount >= protocol.MaxTailLossProbes || h.packetHistory.Len() == 0 || h.rttStats.SmoothedRTT() == 0 {
+		return time.Time{}
+	}
+	return h.lastSentPacketTime.Add(utils.MinDuration(h.getTailLossProbeDelay(), h.getRTO()))
+}
+
+// TimeOfFirstRTO returns the time when MaybeQueueRTOs should be called next
+// If early loss detection is enabled, this is the time when the next packet is declared lost or the next tail loss probe is sent, if these happen before the RTO.
 func (h *sentPacketHandler) TimeOfFirstRTO() time.Time {
 	if h.lastSentPacketTime.IsZero() {
 		return time.Time{}
 	}
+	if h.earlyLossDetection {
+		if !h.lossTime.IsZero() {
+			return h.lossTime
+		}
+		if t := h.timeOfTailLossProbe(); !t.IsZero() {
+			return t
+		}
+	}
 	return h.lastSentPacketTime.Add(h.getRTO())
 }
 
@@ -15,6 +15,8 @@ type mockCongestion struct {
 	argsOnPacketSent        []interface{}
 	argsOnCongestionEvent   []interface{}
 	onRetransmissionTimeout bool
+	onConnectionMigration   bool
+	pacingRate              congestion.Bandwidth
 }
 
 func (m *mockCongestion) TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration {
@@ -32,6 +34,10 @@ func (m *mockCongestion) GetCongestionWindow() protocol.ByteCount {
 	return protocol.DefaultTCPMSS
 }
 
+func (m *mockCongestion) PacingRate() congestion.Bandwidth {
+	return m.pacingRate
+}
+
 func (m *mockCongestion) OnCongestionEvent(rttUpdated bool, bytesInFlight protocol.ByteCount, ackedPackets congestion.PacketVector, lostPackets congestion.PacketVector) {
 	m.nCalls++
 	m.argsOnCongestionEvent = []interface{}{rttUpdated, bytesInFlight, ackedPackets, lostPackets}
@@ -47,7 +53,7 @@ func (m *mockCongestion) RetransmissionDelay() time.Duration {
 }
 
 func (m *mockCongestion) SetNumEmulatedConnections(n int)         { panic("not implemented") }
-func (m *mockCongestion) OnConnectionMigration()                  { panic("not implemented") }
+func (m *mockCongestion) OnConnectionMigration()                  { m.onConnectionMigration = true }
 func (m *mockCongestion) SetSlowStartLargeReduction(enabled bool) { panic("not implemented") }
 
 var _ = Describe("SentPacketHandler", func() {
@@ -57,7 +63,7 @@ var _ = Describe("SentPacketHandler", func() {
 	)
 
 	BeforeEach(func() {
-		handler = NewSentPacketHandler().(*sentPacketHandler)
+		handler = NewSentPacketHandler(congestion.AlgorithmCubic, protocol.DefaultInitialCongestionWindow, protocol.DefaultMaxCongestionWindow, false).(*sentPacketHandler)
 		streamFrame = frames.StreamFrame{
 			StreamID: 5,
 			Data:     []byte{0x13, 0x37},
@@ -78,6 +84,36 @@ var _ = Describe("SentPacketHandler", func() {
 		Expect(handler.GetLeastUnacked()).To(Equal(protocol.PacketNumber(0x1337 + 1)))
 	})
 
+	Context("outstanding retransmittable packets", func() {
+		It("has no outstanding packets initially", func() {
+			Expect(handler.HasOutstandingRetransmittablePackets()).To(BeFalse())
+		})
+
+		It("has outstanding packets until they are acknowledged", func() {
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.HasOutstandingRetransmittablePackets()).To(BeTrue())
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 1, LowestAcked: 1}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.HasOutstandingRetransmittablePackets()).To(BeFalse())
+		})
+
+		It("ignores ACK-only packets", func() {
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{&frames.AckFrame{LargestAcked: 1}}, Length: 1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.HasOutstandingRetransmittablePackets()).To(BeFalse())
+		})
+
+		It("has outstanding packets if packets are queued for retransmission", func() {
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1})
+			Expect(err).ToNot(HaveOccurred())
+			handler.queuePacketForRetransmission(handler.packetHistory.Front())
+			Expect(handler.HasOutstandingRetransmittablePackets()).To(BeTrue())
+			Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
+			Expect(handler.HasOutstandingRetransmittablePackets()).To(BeFalse())
+		})
+	})
+
 	Context("registering sent packets", func() {
 		It("accepts two consecutive packets", func() {
 			packet1 := Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1}
@@ -132,6 +168,39 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.lastSentPacketTime.Unix()).To(BeNumerically("~", time.Now().Unix(), 1))
 		})
 
+		Context("PMTU probes", func() {
+			It("registers probes without tracking them", func() {
+				err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+				err = handler.SentPMTUProbe(2)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.lastSentPacketNumber).To(Equal(protocol.PacketNumber(2)))
+				Expect(handler.packetHistory.Len()).To(Equal(1))
+				Expect(handler.BytesInFlight()).To(Equal(protocol.ByteCount(1)))
+				Expect(handler.skippedPackets).To(BeEmpty())
+			})
+
+			It("rejects probes with decreasing packet numbers", func() {
+				err := handler.SentPacket(&Packet{PacketNumber: 2, Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+				err = handler.SentPMTUProbe(1)
+				Expect(err).To(MatchError(errPacketNumberNotIncreasing))
+			})
+
+			It("doesn't retransmit lost probes", func() {
+				err := handler.SentPMTUProbe(1)
+				Expect(err).ToNot(HaveOccurred())
+				for i := protocol.PacketNumber(2); i <= 6; i++ {
+					err = handler.SentPacket(&Packet{PacketNumber: i, Frames: []frames.Frame{&streamFrame}, Length: 1})
+					Expect(err).ToNot(HaveOccurred())
+				}
+				err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 6, LowestAcked: 2}, 1, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.retransmissionQueue).To(BeEmpty())
+				Expect(handler.BytesInFlight()).To(BeZero())
+			})
+		})
+
 		Context("skipped packet numbers", func() {
 			It("works with non-consecutive packet numbers", func() {
 				packet1 := Packet{PacketNumber: 1, Frames: []frames.Frame{&streamFrame}, Length: 1}
@@ -222,6 +291,28 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(err).To(MatchError(ErrTooManyTrackedSentPackets))
 		})
 
+		It("uses BBR", func() {
+			handler = NewSentPacketHandler(congestion.AlgorithmBBR, 10, 20, false).(*sentPacketHandler)
+			Expect(handler.congestion.GetCongestionWindow()).To(Equal(10 * protocol.DefaultTCPMSS))
+			// BBR doesn't use hybrid slow start
+			Expect(handler.congestion.(congestion.SendAlgorithmWithDebugInfo).HybridSlowStart()).To(BeNil())
+		})
+
+		It("limits the size of the packet history depending on the max congestion window", func() {
+			handler = NewSentPacketHandler(congestion.AlgorithmCubic, 10, 20, false).(*sentPacketHandler)
+			for i := protocol.PacketNumber(1); i <= 40; i++ {
+				packet := Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: 1}
+				err := handler.SentPacket(&packet)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(handler.CheckForError()).ToNot(HaveOccurred())
+			Expect(handler.SendingAllowed()).To(BeFalse())
+			packet := Packet{PacketNumber: 41, Frames: []frames.Frame{&streamFrame}, Length: 1}
+			err := handler.SentPacket(&packet)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.CheckForError()).To(MatchError(ErrTooManyTrackedSentPackets))
+		})
+
 		// TODO: add a test that the length of the retransmission queue is considered, even if packets have already been ACKed. Relevant once we drop support for QUIC 33 and earlier
 	})
 
@@ -608,6 +699,19 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(packet.PacketNumber).To(Equal(protocol.PacketNumber(4)))
 		})
 
+		It("cancels retransmissions for a stream", func() {
+			otherStreamFrame := &frames.StreamFrame{StreamID: streamFrame.StreamID + 2}
+			el := getPacketElement(3)
+			el.Value.Frames = append(el.Value.Frames, otherStreamFrame, &frames.WindowUpdateFrame{StreamID: streamFrame.StreamID})
+			handler.queuePacketForRetransmission(getPacketElement(2))
+			handler.CancelRetransmissionsForStream(streamFrame.StreamID)
+			Expect(getPacketElement(3).Value.Frames).To(Equal([]frames.Frame{otherStreamFrame, &frames.WindowUpdateFrame{StreamID: streamFrame.StreamID}}))
+			Expect(getPacketElement(4).Value.Frames).To(BeEmpty())
+			packet := handler.DequeuePacketForRetransmission()
+			Expect(packet.PacketNumber).To(Equal(protocol.PacketNumber(2)))
+			Expect(packet.GetStreamFramesForRetransmission()).To(BeEmpty())
+		})
+
 		Context("StopWaitings", func() {
 			It("gets a StopWaitingFrame", func() {
 				ack := frames.AckFrame{LargestAcked: 5, LowestAcked: 5}
@@ -699,6 +803,28 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(cong.argsOnPacketSent[4]).To(BeTrue())
 		})
 
+		It("paces packets after the initial burst", func() {
+			cong.pacingRate = congestion.BandwidthFromDelta(10, 10*time.Millisecond)
+			for i := protocol.PacketNumber(1); i <= 10; i++ {
+				Expect(handler.TimeUntilSend().IsZero()).To(BeTrue())
+				err := handler.SentPacket(&Packet{PacketNumber: i, Frames: []frames.Frame{&streamFrame}, Length: 10})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(handler.TimeUntilSend().IsZero()).To(BeTrue())
+			err := handler.SentPacket(&Packet{PacketNumber: 11, Frames: []frames.Frame{&streamFrame}, Length: 10})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.TimeUntilSend()).To(BeTemporally("~", time.Now().Add(10*time.Millisecond), 5*time.Millisecond))
+		})
+
+		It("doesn't pace ACK-only packets", func() {
+			cong.pacingRate = congestion.BandwidthFromDelta(10, 10*time.Millisecond)
+			for i := protocol.PacketNumber(1); i <= 20; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: i, Frames: []frames.Frame{&frames.AckFrame{LargestAcked: 1}}, Length: 10})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(handler.TimeUntilSend().IsZero()).To(BeTrue())
+		})
+
 		It("should call OnCongestionEvent", func() {
 			handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: 1})
 			handler.SentPacket(&Packet{PacketNumber: 2, Frames: []frames.Frame{}, Length: 2})
@@ -767,6 +893,14 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(cong.argsOnCongestionEvent[3]).To(Equal(congestion.PacketVector{{Number: 1, Length: 1}}))
 			Expect(cong.onRetransmissionTimeout).To(BeTrue())
 		})
+
+		It("resets the congestion state and the RTT on connection migration", func() {
+			handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
+			Expect(handler.rttStats.SmoothedRTT()).ToNot(BeZero())
+			handler.OnConnectionMigration()
+			Expect(cong.onConnectionMigration).To(BeTrue())
+			Expect(handler.rttStats.SmoothedRTT()).To(BeZero())
+		})
 	})
 
 	Context("calculating RTO", func() {
@@ -860,4 +994,164 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.DequeuePacketForRetransmission().PacketNumber).To(Equal(p.PacketNumber))
 		})
 	})
+
+	Context("early loss detection", func() {
+		BeforeEach(func() {
+			handler = NewSentPacketHandler(congestion.AlgorithmCubic, protocol.DefaultInitialCongestionWindow, protocol.DefaultMaxCongestionWindow, true).(*sentPacketHandler)
+		})
+
+		sendPackets := func(n int) {
+			for i := 1; i <= n; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+			}
+		}
+
+		Context("tail loss probes", func() {
+			It("uses the RTO if there's no RTT estimate", func() {
+				sendPackets(3)
+				Expect(handler.TimeOfFirstRTO()).To(Equal(handler.lastSentPacketTime.Add(protocol.DefaultRetransmissionTime)))
+			})
+
+			It("sends the probe after 2 RTTs", func() {
+				handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+				sendPackets(3)
+				Expect(handler.TimeOfFirstRTO()).To(Equal(handler.lastSentPacketTime.Add(200 * time.Millisecond)))
+			})
+
+			It("accounts for the delayed ACK if only one packet is outstanding", func() {
+				handler.rttStats.UpdateRTT(20*time.Millisecond, 0, time.Now())
+				sendPackets(1)
+				Expect(handler.TimeOfFirstRTO()).To(Equal(handler.lastSentPacketTime.Add(30*time.Millisecond + protocol.PeerDelayedAckTime)))
+			})
+
+			It("uses a minimum timeout", func() {
+				handler.rttStats.UpdateRTT(time.Millisecond, 0, time.Now())
+				sendPackets(3)
+				Expect(handler.TimeOfFirstRTO()).To(Equal(handler.lastSentPacketTime.Add(protocol.MinTailLossProbeTime)))
+			})
+
+			It("retransmits the last packet, without notifying the congestion controller", func() {
+				cong := &mockCongestion{}
+				handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+				sendPackets(3)
+				handler.congestion = cong
+				handler.lastSentPacketTime = time.Now().Add(-250 * time.Millisecond)
+				handler.MaybeQueueRTOs()
+				Expect(handler.retransmissionQueue).To(HaveLen(1))
+				Expect(handler.retransmissionQueue[0].PacketNumber).To(Equal(protocol.PacketNumber(3)))
+				Expect(handler.retransmissionQueue[0].Frames).To(Equal([]frames.Frame{&streamFrame}))
+				Expect(handler.tlpCount).To(Equal(uint32(1)))
+				Expect(handler.consecutiveRTOCount).To(BeZero())
+				Expect(cong.nCalls).To(BeZero())
+				Expect(time.Now().Sub(handler.lastSentPacketTime)).To(BeNumerically("<", 100*time.Millisecond))
+			})
+
+			It("keeps the original packet outstanding", func() {
+				handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+				sendPackets(3)
+				handler.lastSentPacketTime = time.Now().Add(-250 * time.Millisecond)
+				handler.MaybeQueueRTOs()
+				Expect(handler.retransmissionQueue).To(HaveLen(1))
+				Expect(handler.packetHistory.Len()).To(Equal(3))
+				Expect(getPacketElement(3)).ToNot(BeNil())
+				Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(3)))
+			})
+
+			It("processes an ACK for the original packet", func() {
+				cong := &mockCongestion{}
+				handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+				sendPackets(3)
+				handler.congestion = cong
+				handler.lastSentPacketTime = time.Now().Add(-250 * time.Millisecond)
+				handler.MaybeQueueRTOs()
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 3, LowestAcked: 1}, 1, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.packetHistory.Len()).To(BeZero())
+				Expect(handler.bytesInFlight).To(BeZero())
+				ackedPackets := cong.argsOnCongestionEvent[2].(congestion.PacketVector)
+				Expect(ackedPackets).To(HaveLen(3))
+				Expect(ackedPackets[2].Number).To(Equal(protocol.PacketNumber(3)))
+			})
+
+			It("skips packets that don't contain any retransmittable frames", func() {
+				handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+				sendPackets(2)
+				err := handler.SentPacket(&Packet{PacketNumber: 3, Frames: []frames.Frame{&frames.AckFrame{}}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+				handler.lastSentPacketTime = time.Now().Add(-250 * time.Millisecond)
+				handler.MaybeQueueRTOs()
+				Expect(handler.retransmissionQueue).To(HaveLen(1))
+				Expect(handler.retransmissionQueue[0].PacketNumber).To(Equal(protocol.PacketNumber(2)))
+				Expect(handler.packetHistory.Len()).To(Equal(3))
+			})
+
+			It("uses the RTO after sending two probes", func() {
+				handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+				sendPackets(4)
+				for i := 0; i < protocol.MaxTailLossProbes; i++ {
+					handler.lastSentPacketTime = time.Now().Add(-250 * time.Millisecond)
+					handler.MaybeQueueRTOs()
+				}
+				Expect(handler.retransmissionQueue).To(HaveLen(2))
+				Expect(handler.TimeOfFirstRTO()).To(Equal(handler.lastSentPacketTime.Add(handler.getRTO())))
+			})
+
+			It("resets the probe count when a new packet is acked", func() {
+				handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+				sendPackets(3)
+				handler.tlpCount = protocol.MaxTailLossProbes
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 1, LowestAcked: 1}, 1, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.tlpCount).To(BeZero())
+			})
+		})
+
+		Context("time-based loss detection", func() {
+			It("declares packets lost that were sent more than 9/8 RTT before the ACK", func() {
+				sendPackets(3)
+				getPacketElement(1).Value.SendTime = time.Now().Add(-time.Second)
+				// the RTT is 100ms
+				rcvTime := getPacketElement(3).Value.SendTime.Add(100 * time.Millisecond)
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 3, LowestAcked: 3}, 1, rcvTime)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.retransmissionQueue).To(HaveLen(1))
+				Expect(handler.retransmissionQueue[0].PacketNumber).To(Equal(protocol.PacketNumber(1)))
+				// packet 2 is not lost yet
+				sendTime := getPacketElement(2).Value.SendTime
+				Expect(handler.lossTime).To(Equal(sendTime.Add(100*time.Millisecond + 100*time.Millisecond/8)))
+				Expect(handler.TimeOfFirstRTO()).To(Equal(handler.lossTime))
+			})
+
+			It("declares packets lost when the loss timer fires", func() {
+				cong := &mockCongestion{}
+				sendPackets(3)
+				rcvTime := getPacketElement(3).Value.SendTime.Add(100 * time.Millisecond)
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 3, LowestAcked: 3}, 1, rcvTime)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.retransmissionQueue).To(BeEmpty())
+				Expect(handler.lossTime.IsZero()).To(BeFalse())
+				handler.congestion = cong
+				getPacketElement(1).Value.SendTime = time.Now().Add(-time.Second)
+				getPacketElement(2).Value.SendTime = time.Now().Add(-time.Second)
+				handler.lossTime = time.Now().Add(-time.Millisecond)
+				handler.MaybeQueueRTOs()
+				Expect(handler.retransmissionQueue).To(HaveLen(2))
+				Expect(handler.lossTime.IsZero()).To(BeTrue())
+				Expect(cong.argsOnCongestionEvent[3]).To(Equal(congestion.PacketVector{{Number: 1, Length: 1}, {Number: 2, Length: 1}}))
+				Expect(cong.onRetransmissionTimeout).To(BeFalse())
+			})
+		})
+
+		It("can be disabled", func() {
+			handler = NewSentPacketHandler(congestion.AlgorithmCubic, protocol.DefaultInitialCongestionWindow, protocol.DefaultMaxCongestionWindow, false).(*sentPacketHandler)
+			handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			sendPackets(3)
+			getPacketElement(1).Value.SendTime = time.Now().Add(-time.Second)
+			err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 3, LowestAcked: 3}, 1, time.Now().Add(100*time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.retransmissionQueue).To(BeEmpty())
+			Expect(handler.TimeOfFirstRTO()).To(Equal(handler.lastSentPacketTime.Add(handler.getRTO())))
+		})
+	})
 })
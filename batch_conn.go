@@ -0,0 +1,43 @@
+package quic
+
+import "net"
+
+// A batchConn reads and writes multiple packets with a single syscall, if the platform supports it
+type batchConn interface {
+	// ReadBatch reads at least one packet.
+	// It returns the number of packets read, and sets the length of the buffers and the remote addresses accordingly.
+	ReadBatch(buffers [][]byte, addrs []net.Addr) (int, error)
+	// WriteBatch writes all packets to the remote address
+	WriteBatch(packets [][]byte, addr net.Addr) error
+}
+
+// simpleBatchConn reads and writes one packet per call
+// It is used on platforms that don't support batching, and for connections that are not UDP sockets.
+type simpleBatchConn struct {
+	conn net.PacketConn
+}
+
+var _ batchConn = &simpleBatchConn{}
+
+func newSimpleBatchConn(conn net.PacketConn) batchConn {
+	return &simpleBatchConn{conn: conn}
+}
+
+func (c *simpleBatchConn) ReadBatch(buffers [][]byte, addrs []net.Addr) (int, error) {
+	n, addr, err := c.conn.ReadFrom(buffers[0])
+	if err != nil {
+		return 0, err
+	}
+	buffers[0] = buffers[0][:n]
+	addrs[0] = addr
+	return 1, nil
+}
+
+func (c *simpleBatchConn) WriteBatch(packets [][]byte, addr net.Addr) error {
+	for _, p := range packets {
+		if _, err := c.conn.WriteTo(p, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package quic
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// socket options for UDP segmentation offload, see linux/udp.h
+const (
+	udpSegment = 103
+	udpGRO     = 104
+)
+
+const (
+	// maxGSOSegments is the maximum number of packets that the kernel accepts in one GSO buffer
+	maxGSOSegments = 64
+	// maxGSOBufferSize is the maximum size of a GSO buffer, which is sent as a single UDP datagram to the kernel
+	maxGSOBufferSize = 65507
+	// groBufferSize is the size of the buffers for reading GRO-coalesced packets
+	groBufferSize = 65535
+	// groBatchSize is the number of GRO buffers read with a single syscall
+	groBatchSize = 16
+)
+
+// batchPacketConn is implemented by both ipv4.PacketConn and ipv6.PacketConn
+type batchPacketConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+type groSegment struct {
+	data []byte
+	addr net.Addr
+}
+
+// linuxBatchConn uses recvmmsg and sendmmsg
+// If supported by the kernel, it uses UDP segmentation offload (GSO) for sending, and UDP receive offload (GRO) for receiving.
+type linuxBatchConn struct {
+	udpConn *net.UDPConn
+	conn    batchPacketConn
+
+	gso bool
+	// GRO is enabled on the first call to ReadBatch, such that connections only used for writing don't change the socket options
+	groInitialized bool
+	gro            bool
+
+	readMessages  []ipv4.Message
+	writeMessages []ipv4.Message
+	// gsoControlMessages contains one control message for every write message
+	gsoControlMessages [][]byte
+
+	// when using GRO, packets are read into the groBuffers, and then split into segments
+	groBuffers      [][]byte
+	groSegments     []groSegment
+	groNextSegments []groSegment
+}
+
+var _ batchConn = &linuxBatchConn{}
+
+func newBatchConn(conn net.PacketConn) batchConn {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return newSimpleBatchConn(conn)
+	}
+	var pconn batchPacketConn
+	if addr, ok := udpConn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		pconn = ipv6.NewPacketConn(udpConn)
+	} else {
+		pconn = ipv4.NewPacketConn(udpConn)
+	}
+	c := &linuxBatchConn{
+		udpConn:       udpConn,
+		conn:          pconn,
+		gso:           isGSOSupported(udpConn),
+		readMessages:  make([]ipv4.Message, protocol.PacketBatchSize),
+		writeMessages: make([]ipv4.Message, protocol.PacketBatchSize),
+	}
+	for i := range c.readMessages {
+		c.readMessages[i].Buffers = make([][]byte, 1)
+	}
+	if c.gso {
+		c.gsoControlMessages = make([][]byte, protocol.PacketBatchSize)
+		for i := range c.gsoControlMessages {
+			c.gsoControlMessages[i] = make([]byte, syscall.CmsgSpace(2))
+		}
+	}
+	return c
+}
+
+// isGSOSupported checks if the kernel supports UDP segmentation offload
+func isGSOSupported(conn *net.UDPConn) bool {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var supported bool
+	if err := rawConn.Control(func(fd uintptr) {
+		_, err := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_UDP, udpSegment)
+		supported = err == nil
+	}); err != nil {
+		return false
+	}
+	return supported
+}
+
+// enableGRO enables UDP receive offload, if supported by the kernel
+func enableGRO(conn *net.UDPConn) bool {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var enabled bool
+	if err := rawConn.Control(func(fd uintptr) {
+		enabled = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_UDP, udpGRO, 1) == nil
+	}); err != nil {
+		return false
+	}
+	return enabled
+}
+
+func (c *linuxBatchConn) ReadBatch(buffers [][]byte, addrs []net.Addr) (int, error) {
+	if !c.groInitialized {
+		c.groInitialized = true
+		c.gro = enableGRO(c.udpConn)
+		if c.gro {
+			c.groBuffers = make([][]byte, groBatchSize)
+			for i := range c.groBuffers {
+				c.groBuffers[i] = make([]byte, groBufferSize)
+				c.readMessages[i].OOB = make([]byte, syscall.CmsgSpace(4))
+			}
+		}
+	}
+	if c.gro {
+		return c.readGROBatch(buffers, addrs)
+	}
+
+	ms := c.readMessages
+	if len(buffers) < len(ms) {
+		ms = ms[:len(buffers)]
+	}
+	for i := range ms {
+		ms[i].Buffers[0] = buffers[i]
+	}
+	n, err := c.conn.ReadBatch(ms, 0)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		buffers[i] = buffers[i][:ms[i].N]
+		addrs[i] = ms[i].Addr
+		ms[i].Buffers[0] = nil
+	}
+	return n, nil
+}
+
+// readGROBatch reads into the GRO buffers, splits the coalesced packets, and copies them into the buffers
+// If there are more segments than buffers, the remaining segments are returned by the next call.
+// Segments that don't fit into the buffers are dropped.
+func (c *linuxBatchConn) readGROBatch(buffers [][]byte, addrs []net.Addr) (int, error) {
+	if len(c.groNextSegments) == 0 {
+		ms := c.readMessages[:groBatchSize]
+		for i := range ms {
+			ms[i].Buffers[0] = c.groBuffers[i]
+		}
+		n, err := c.conn.ReadBatch(ms, 0)
+		if err != nil {
+			return 0, err
+		}
+		c.groSegments = c.groSegments[:0]
+		for i := 0; i < n; i++ {
+			data := c.groBuffers[i][:ms[i].N]
+			addr := ms[i].Addr
+			segmentSize := parseGROSegmentSize(ms[i].OOB[:ms[i].NN])
+			if segmentSize <= 0 {
+				segmentSize = len(data)
+			}
+			for len(data) > 0 {
+				l := utils.Min(segmentSize, len(data))
+				c.groSegments = append(c.groSegments, groSegment{data: data[:l], addr: addr})
+				data = data[l:]
+			}
+		}
+		c.groNextSegments = c.groSegments
+	}
+
+	var n int
+	for n < len(buffers) && len(c.groNextSegments) > 0 {
+		s := c.groNextSegments[0]
+		c.groNextSegments = c.groNextSegments[1:]
+		if len(s.data) > len(buffers[n]) {
+			utils.Infof("Dropping a coalesced packet of %d bytes from %s, since it is larger than the receive buffer", len(s.data), s.addr)
+			continue
+		}
+		buffers[n] = buffers[n][:copy(buffers[n], s.data)]
+		addrs[n] = s.addr
+		n++
+	}
+	return n, nil
+}
+
+// parseGROSegmentSize gets the segment size from the control message
+// It returns 0 if the packet was not coalesced.
+func parseGROSegmentSize(oob []byte) int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.IPPROTO_UDP && msg.Header.Type == udpGRO && len(msg.Data) >= 4 {
+			return int(*(*int32)(unsafe.Pointer(&msg.Data[0])))
+		}
+	}
+	return 0
+}
+
+func (c *linuxBatchConn) WriteBatch(packets [][]byte, addr net.Addr) error {
+	for len(packets) > 0 {
+		ms := c.writeMessages
+		remaining := packets
+		var numMessages int
+		for ; numMessages < len(ms) && len(remaining) > 0; numMessages++ {
+			n := 1
+			if c.gso {
+				n = numGSOSegments(remaining)
+			}
+			m := &ms[numMessages]
+			m.Buffers = remaining[:n]
+			m.Addr = addr
+			m.OOB = nil
+			if n > 1 {
+				m.OOB = c.gsoControlMessages[numMessages]
+				setGSOSegmentSize(m.OOB, len(remaining[0]))
+			}
+			remaining = remaining[n:]
+		}
+		// sendmmsg returns after the first message that couldn't be sent
+		sent, err := c.conn.WriteBatch(ms[:numMessages], 0)
+		for i := 0; i < numMessages; i++ {
+			if i < sent {
+				packets = packets[len(ms[i].Buffers):]
+			}
+			ms[i].Buffers = nil
+			ms[i].OOB = nil
+		}
+		if err != nil {
+			// The kernel supports GSO, but the network interface might not.
+			if c.gso && (errors.Is(err, syscall.EIO) || errors.Is(err, syscall.EINVAL)) {
+				utils.Infof("Disabling UDP segmentation offload: %s", err.Error())
+				c.gso = false
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// numGSOSegments returns how many packets can be sent in one GSO buffer
+// All packets need to have the same size, except for the last one, which may be smaller.
+func numGSOSegments(packets [][]byte) int {
+	segmentSize := len(packets[0])
+	size := segmentSize
+	n := 1
+	for n < len(packets) && n < maxGSOSegments {
+		l := len(packets[n])
+		if l > segmentSize || size+l > maxGSOBufferSize {
+			break
+		}
+		size += l
+		n++
+		if l < segmentSize {
+			break
+		}
+	}
+	return n
+}
+
+func setGSOSegmentSize(oob []byte, size int) {
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = syscall.IPPROTO_UDP
+	h.Type = udpSegment
+	h.SetLen(syscall.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = uint16(size)
+}
@@ -0,0 +1,145 @@
+package quic
+
+import (
+	"bytes"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Linux Batch Connection", func() {
+	makePackets := func(sizes ...int) [][]byte {
+		packets := make([][]byte, len(sizes))
+		for i, s := range sizes {
+			packets[i] = bytes.Repeat([]byte{byte(i)}, s)
+		}
+		return packets
+	}
+
+	Context("grouping packets for GSO", func() {
+		It("groups packets of the same size", func() {
+			Expect(numGSOSegments(makePackets(1000, 1000, 1000))).To(Equal(3))
+		})
+
+		It("adds a smaller packet at the end", func() {
+			Expect(numGSOSegments(makePackets(1000, 1000, 500, 1000))).To(Equal(3))
+		})
+
+		It("doesn't add larger packets", func() {
+			Expect(numGSOSegments(makePackets(1000, 1000, 1200))).To(Equal(2))
+		})
+
+		It("respects the maximum number of segments", func() {
+			sizes := make([]int, maxGSOSegments+10)
+			for i := range sizes {
+				sizes[i] = 100
+			}
+			Expect(numGSOSegments(makePackets(sizes...))).To(Equal(maxGSOSegments))
+		})
+
+		It("respects the maximum buffer size", func() {
+			sizes := make([]int, 60)
+			for i := range sizes {
+				sizes[i] = 1350
+			}
+			Expect(numGSOSegments(makePackets(sizes...))).To(Equal(maxGSOBufferSize / 1350))
+		})
+	})
+
+	It("parses the GRO segment size", func() {
+		oob := make([]byte, syscall.CmsgSpace(4))
+		h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+		h.Level = syscall.IPPROTO_UDP
+		h.Type = udpGRO
+		h.SetLen(syscall.CmsgLen(4))
+		*(*int32)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = 1337
+		Expect(parseGROSegmentSize(oob)).To(Equal(1337))
+		Expect(parseGROSegmentSize(nil)).To(BeZero())
+	})
+
+	It("drops GRO segments that are larger than the buffers", func() {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		c := newBatchConn(conn).(*linuxBatchConn)
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337}
+		packets := makePackets(50, 150, 60)
+		c.groNextSegments = []groSegment{
+			{data: packets[0], addr: addr},
+			{data: packets[1], addr: addr},
+			{data: packets[2], addr: addr},
+		}
+		buffers := [][]byte{make([]byte, 100), make([]byte, 100), make([]byte, 100)}
+		addrs := make([]net.Addr, 3)
+		n, err := c.readGROBatch(buffers, addrs)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(2))
+		Expect(buffers[0]).To(Equal(packets[0]))
+		Expect(buffers[1]).To(Equal(packets[2]))
+		Expect(c.groNextSegments).To(BeEmpty())
+	})
+
+	Context("segmentation offload", func() {
+		var senderConn, receiverConn *net.UDPConn
+
+		BeforeEach(func() {
+			var err error
+			senderConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			receiverConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			senderConn.Close()
+			receiverConn.Close()
+		})
+
+		It("sends packets using GSO", func() {
+			sender := newBatchConn(senderConn).(*linuxBatchConn)
+			if !sender.gso {
+				Skip("GSO not supported")
+			}
+			packets := makePackets(1000, 1000, 1000, 500, 1000, 1000)
+			err := sender.WriteBatch(packets, receiverConn.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sender.gso).To(BeTrue())
+			// the receiver doesn't use GRO, so it receives every packet individually
+			for _, p := range packets {
+				b := make([]byte, protocol.MaxReceivePacketSize)
+				n, _, err := receiverConn.ReadFromUDP(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b[:n]).To(Equal(p))
+			}
+		})
+
+		It("splits GRO-coalesced packets", func() {
+			sender := newBatchConn(senderConn).(*linuxBatchConn)
+			receiver := newBatchConn(receiverConn).(*linuxBatchConn)
+			if !sender.gso || !enableGRO(receiverConn) {
+				Skip("GSO or GRO not supported")
+			}
+			packets := makePackets(1000, 1000, 1000, 1000, 500)
+			err := sender.WriteBatch(packets, receiverConn.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+			var received [][]byte
+			for len(received) < len(packets) {
+				// use fewer buffers than packets, to test that the remaining segments are returned by the next call
+				buffers := [][]byte{make([]byte, protocol.MaxReceivePacketSize), make([]byte, protocol.MaxReceivePacketSize)}
+				addrs := make([]net.Addr, 2)
+				n, err := receiver.ReadBatch(buffers, addrs)
+				Expect(err).ToNot(HaveOccurred())
+				for i := 0; i < n; i++ {
+					Expect(addrs[i]).To(Equal(senderConn.LocalAddr()))
+					received = append(received, buffers[i])
+				}
+			}
+			Expect(receiver.gro).To(BeTrue())
+			Expect(received).To(Equal(packets))
+		})
+	})
+})
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package quic
+
+import "net"
+
+func newBatchConn(conn net.PacketConn) batchConn {
+	return newSimpleBatchConn(conn)
+}
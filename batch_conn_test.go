@@ -0,0 +1,74 @@
+package quic
+
+import (
+	"net"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch Connection", func() {
+	var (
+		senderConn, receiverConn *net.UDPConn
+		sender, receiver         batchConn
+	)
+
+	BeforeEach(func() {
+		var err error
+		senderConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		receiverConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		sender = newBatchConn(senderConn)
+		receiver = newBatchConn(receiverConn)
+	})
+
+	AfterEach(func() {
+		senderConn.Close()
+		receiverConn.Close()
+	})
+
+	// readPackets reads until n packets were received
+	readPackets := func(n int) [][]byte {
+		var packets [][]byte
+		buffers := make([][]byte, protocol.PacketBatchSize)
+		addrs := make([]net.Addr, protocol.PacketBatchSize)
+		for len(packets) < n {
+			for i := range buffers {
+				buffers[i] = make([]byte, protocol.MaxReceivePacketSize)
+			}
+			num, err := receiver.ReadBatch(buffers, addrs)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(num).To(BeNumerically(">", 0))
+			for i := 0; i < num; i++ {
+				Expect(addrs[i]).To(Equal(senderConn.LocalAddr()))
+				packets = append(packets, buffers[i])
+			}
+		}
+		return packets
+	}
+
+	It("writes and reads multiple packets", func() {
+		err := sender.WriteBatch([][]byte{[]byte("foo"), []byte("bar"), []byte("foobar")}, receiverConn.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readPackets(3)).To(Equal([][]byte{[]byte("foo"), []byte("bar"), []byte("foobar")}))
+	})
+
+	It("writes more packets than fit into one batch", func() {
+		packets := make([][]byte, protocol.PacketBatchSize+10)
+		for i := range packets {
+			packets[i] = []byte{byte(i)}
+		}
+		err := sender.WriteBatch(packets, receiverConn.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readPackets(len(packets))).To(Equal(packets))
+	})
+
+	It("returns read errors", func() {
+		receiverConn.Close()
+		buffers := [][]byte{make([]byte, 100)}
+		_, err := receiver.ReadBatch(buffers, make([]net.Addr, 1))
+		Expect(err).To(HaveOccurred())
+	})
+})
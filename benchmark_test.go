@@ -20,7 +20,6 @@ import (
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
-	"github.com/lucas-clemente/quic-go/utils"
 )
 
 type linkedConnection struct {
@@ -40,7 +39,7 @@ func newLinkedConnection(other *Session) *linkedConnection {
 				return
 			}
 			r := bytes.NewReader(packet)
-			hdr, err := ParsePublicHeader(r)
+			hdr, err := ParsePublicHeader(r, protocol.PerspectiveServer)
 			if err != nil {
 				Expect(err).NotTo(HaveOccurred())
 			}
@@ -52,7 +51,7 @@ func newLinkedConnection(other *Session) *linkedConnection {
 }
 
 func (c *linkedConnection) write(p []byte) error {
-	packet := getPacketBuffer()
+	packet := getPacketBuffer(protocol.ByteCount(len(p)))
 	packet = packet[:len(p)]
 	copy(packet, p)
 	select {
@@ -62,10 +61,17 @@ func (c *linkedConnection) write(p []byte) error {
 	return nil
 }
 
-func (*linkedConnection) setCurrentRemoteAddr(addr interface{}) {}
-func (*linkedConnection) RemoteAddr() *net.UDPAddr              { return &net.UDPAddr{} }
+func (c *linkedConnection) writeBatch(ps [][]byte) error {
+	for _, p := range ps {
+		c.write(p)
+	}
+	return nil
+}
 
-func setAEAD(cs *handshake.CryptoSetup, aead crypto.AEAD) {
+func (*linkedConnection) setCurrentRemoteAddr(addr net.Addr) {}
+func (*linkedConnection) RemoteAddr() net.Addr               { return &net.UDPAddr{} }
+
+func setAEAD(cs handshake.CryptoSetup, aead crypto.AEAD) {
 	*(*bool)(unsafe.Pointer(reflect.ValueOf(cs).Elem().FieldByName("receivedForwardSecurePacket").UnsafeAddr())) = true
 	*(*crypto.AEAD)(unsafe.Pointer(reflect.ValueOf(cs).Elem().FieldByName("forwardSecureAEAD").UnsafeAddr())) = aead
 }
@@ -73,8 +79,8 @@ func setAEAD(cs *handshake.CryptoSetup, aead crypto.AEAD) {
 func setFlowControlParameters(mgr *handshake.ConnectionParametersManager) {
 	sfcw := make([]byte, 4)
 	cfcw := make([]byte, 4)
-	binary.LittleEndian.PutUint32(sfcw, uint32(protocol.ReceiveStreamFlowControlWindow))
-	binary.LittleEndian.PutUint32(cfcw, uint32(protocol.ReceiveConnectionFlowControlWindow))
+	binary.LittleEndian.PutUint32(sfcw, uint32(protocol.DefaultReceiveStreamFlowControlWindow))
+	binary.LittleEndian.PutUint32(cfcw, uint32(protocol.DefaultReceiveConnectionFlowControlWindow))
 	mgr.SetFromMap(map[handshake.Tag][]byte{
 		handshake.TagSFCW: sfcw,
 		handshake.TagCFCW: cfcw,
@@ -97,15 +103,18 @@ var _ = Describe("Benchmarks", func() {
 
 				connID := protocol.ConnectionID(mrand.Uint32())
 
+				config, err := populateConfig(nil)
+				Expect(err).NotTo(HaveOccurred())
+
 				c1 := newLinkedConnection(nil)
-				session1I, err := newSession(c1, version, connID, nil, func(*Session, utils.Stream) {}, func(id protocol.ConnectionID) {})
+				session1I, err := newSession(c1, version, connID, nil, config, nil, func(protocol.ConnectionID, *closedSession) {}, func(*Session, bool) {})
 				if err != nil {
 					Expect(err).NotTo(HaveOccurred())
 				}
 				session1 := session1I.(*Session)
 
 				c2 := newLinkedConnection(session1)
-				session2I, err := newSession(c2, version, connID, nil, func(*Session, utils.Stream) {}, func(id protocol.ConnectionID) {})
+				session2I, err := newSession(c2, version, connID, nil, config, nil, func(protocol.ConnectionID, *closedSession) {}, func(*Session, bool) {})
 				if err != nil {
 					Expect(err).NotTo(HaveOccurred())
 				}
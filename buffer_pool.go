@@ -6,21 +6,38 @@ import (
 	"github.com/lucas-clemente/quic-go/protocol"
 )
 
-var bufferPool sync.Pool
+// packetBufferSize is the capacity of the buffers used for most packets
+// It is the maximum UDP payload of an IPv4 packet on a path with an Ethernet MTU of 1500 bytes.
+const packetBufferSize = 1472
 
-func getPacketBuffer() []byte {
-	return bufferPool.Get().([]byte)
+// Larger packets, up to protocol.MaxReceivePacketSize, use buffers from a separate pool,
+// so that jumbo frame support doesn't increase the size of all buffers.
+var bufferPool, jumboBufferPool sync.Pool
+
+// getPacketBuffer returns an empty buffer that can hold a packet of the given size
+func getPacketBuffer(size protocol.ByteCount) []byte {
+	if size <= packetBufferSize {
+		return bufferPool.Get().([]byte)
+	}
+	return jumboBufferPool.Get().([]byte)
 }
 
 func putPacketBuffer(buf []byte) {
-	if cap(buf) != int(protocol.MaxPacketSize) {
+	switch cap(buf) {
+	case packetBufferSize:
+		bufferPool.Put(buf[:0])
+	case int(protocol.MaxReceivePacketSize):
+		jumboBufferPool.Put(buf[:0])
+	default:
 		panic("putPacketBuffer called with packet of wrong size!")
 	}
-	bufferPool.Put(buf[:0])
 }
 
 func init() {
 	bufferPool.New = func() interface{} {
-		return make([]byte, 0, protocol.MaxPacketSize)
+		return make([]byte, 0, packetBufferSize)
+	}
+	jumboBufferPool.New = func() interface{} {
+		return make([]byte, 0, protocol.MaxReceivePacketSize)
 	}
 }
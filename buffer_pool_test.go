@@ -9,18 +9,34 @@ import (
 
 var _ = Describe("Buffer Pool", func() {
 	It("returns buffers of correct len and cap", func() {
-		buf := getPacketBuffer()
+		buf := getPacketBuffer(protocol.DefaultMaxPacketSize)
 		Expect(buf).To(HaveLen(0))
-		Expect(buf).To(HaveCap(int(protocol.MaxPacketSize)))
+		Expect(buf).To(HaveCap(packetBufferSize))
+	})
+
+	It("returns jumbo buffers for large packets", func() {
+		buf := getPacketBuffer(packetBufferSize + 1)
+		Expect(buf).To(HaveLen(0))
+		Expect(buf).To(HaveCap(int(protocol.MaxReceivePacketSize)))
 	})
 
 	It("zeroes put buffers' length", func() {
 		for i := 0; i < 1000; i++ {
-			buf := getPacketBuffer()
+			buf := getPacketBuffer(protocol.DefaultMaxPacketSize)
+			putPacketBuffer(buf[0:10])
+			buf = getPacketBuffer(protocol.DefaultMaxPacketSize)
+			Expect(buf).To(HaveLen(0))
+			Expect(buf).To(HaveCap(packetBufferSize))
+		}
+	})
+
+	It("puts jumbo buffers back into the jumbo pool", func() {
+		for i := 0; i < 1000; i++ {
+			buf := getPacketBuffer(protocol.MaxReceivePacketSize)
 			putPacketBuffer(buf[0:10])
-			buf = getPacketBuffer()
+			buf = getPacketBuffer(protocol.MaxReceivePacketSize)
 			Expect(buf).To(HaveLen(0))
-			Expect(buf).To(HaveCap(int(protocol.MaxPacketSize)))
+			Expect(buf).To(HaveCap(int(protocol.MaxReceivePacketSize)))
 		}
 	})
 
@@ -0,0 +1,283 @@
+package quic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+type client struct {
+	mutex sync.Mutex
+
+	conn     net.PacketConn
+	addr     net.Addr
+	hostname string
+	// closeConn is set if the connection was created by Dial, and has to be closed with the session
+	closeConn bool
+
+	config *Config
+
+	connectionID protocol.ConnectionID
+	version      protocol.VersionNumber
+	// versionNegotiated is set once the server sent a packet without the version flag
+	versionNegotiated bool
+
+	// handshakeChan receives nil once the handshake completes, or the error that caused the session to close
+	handshakeChan chan error
+
+	session *Session
+}
+
+var errCloseSessionForNewVersion = errors.New("closing session in order to recreate it with a new version")
+
+// Dial establishes a new QUIC connection to a server
+// It blocks until the forward secure handshake has completed.
+func Dial(addr string, tlsConfig *tls.Config) (*Session, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return dial(udpConn, udpAddr, hostname, tlsConfig, true)
+}
+
+// DialConn establishes a new QUIC connection to a server, using an existing net.PacketConn
+// The host is used to verify the server's certificate, if the tls.Config doesn't set a ServerName.
+// The connection is not closed when the session is closed.
+// It blocks until the forward secure handshake has completed.
+func DialConn(conn net.PacketConn, remoteAddr net.Addr, host string, tlsConfig *tls.Config) (*Session, error) {
+	return dial(conn, remoteAddr, host, tlsConfig, false)
+}
+
+func dial(conn net.PacketConn, remoteAddr net.Addr, hostname string, tlsConfig *tls.Config, closeConn bool) (*Session, error) {
+	if tlsConfig != nil && tlsConfig.ServerName != "" {
+		hostname = tlsConfig.ServerName
+	}
+
+	closeConnOnError := func() {
+		if closeConn {
+			conn.Close()
+		}
+	}
+
+	connID, err := generateConnectionID()
+	if err != nil {
+		closeConnOnError()
+		return nil, err
+	}
+
+	config, err := populateConfig(&Config{TLSConfig: tlsConfig})
+	if err != nil {
+		closeConnOnError()
+		return nil, err
+	}
+
+	c := &client{
+		conn:          conn,
+		addr:          remoteAddr,
+		hostname:      hostname,
+		closeConn:     closeConn,
+		config:        prepareForPMTUDiscovery(conn, config),
+		connectionID:  connID,
+		version:       protocol.SupportedVersions[len(protocol.SupportedVersions)-1],
+		handshakeChan: make(chan error, 1),
+	}
+
+	utils.Infof("Starting new connection to %s (%s), connectionID %x, version %d", hostname, remoteAddr.String(), connID, c.version)
+
+	c.mutex.Lock()
+	err = c.createNewSession()
+	c.mutex.Unlock()
+	if err != nil {
+		closeConnOnError()
+		return nil, err
+	}
+
+	go c.listen()
+
+	if err := <-c.handshakeChan; err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.session, nil
+}
+
+// listen listens on the underlying connection and passes packets on for handling
+func (c *client) listen() {
+	buf := make([]byte, protocol.MaxReceivePacketSize)
+	for {
+		n, addr, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if !strings.HasSuffix(err.Error(), "use of closed network connection") {
+				c.getSession().Close(err)
+			}
+			return
+		}
+		data := append(getPacketBuffer(protocol.ByteCount(n)), buf[:n]...)
+
+		if err := c.handlePacket(addr, data); err != nil {
+			utils.Errorf("error handling packet: %s", err.Error())
+			c.getSession().Close(err)
+			return
+		}
+	}
+}
+
+func (c *client) handlePacket(remoteAddr net.Addr, packet []byte) error {
+	if protocol.ByteCount(len(packet)) > protocol.MaxReceivePacketSize {
+		return qerr.PacketTooLarge
+	}
+
+	rcvTime := time.Now()
+
+	r := bytes.NewReader(packet)
+	hdr, err := ParsePublicHeader(r, protocol.PerspectiveServer)
+	if err != nil {
+		return qerr.Error(qerr.InvalidPacketHeader, err.Error())
+	}
+	hdr.Raw = packet[:len(packet)-r.Len()]
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// ignore packets sent for a different connection
+	if hdr.ConnectionID != c.connectionID {
+		return nil
+	}
+
+	if hdr.ResetFlag {
+		pr, err := ParsePublicReset(r)
+		if err != nil {
+			// ignore invalid public resets, they might have been sent by an attacker
+			utils.Infof("Received an invalid public reset for connection %x: %s", c.connectionID, err.Error())
+			return nil
+		}
+		c.session.handlePublicReset(pr)
+		return nil
+	}
+
+	if hdr.VersionFlag {
+		// ignore delayed version negotiation packets
+		if c.versionNegotiated {
+			return nil
+		}
+		return c.handleVersionNegotiationPacket(hdr)
+	}
+
+	// this is the first packet after the client sent a packet with the VersionFlag set
+	// if the server doesn't send a version negotiation packet, it supports the suggested version
+	c.versionNegotiated = true
+
+	c.session.handlePacket(&receivedPacket{
+		remoteAddr:   remoteAddr,
+		publicHeader: hdr,
+		data:         packet[len(packet)-r.Len():],
+		rcvTime:      rcvTime,
+	})
+	return nil
+}
+
+// handleVersionNegotiationPacket must be called with the mutex held
+func (c *client) handleVersionNegotiationPacket(hdr *PublicHeader) error {
+	// a server that supports our version must not send a version negotiation packet
+	for _, v := range hdr.SupportedVersions {
+		if v == c.version {
+			return nil
+		}
+	}
+
+	ok, highestSupportedVersion := protocol.HighestSupportedVersion(hdr.SupportedVersions)
+	if !ok {
+		return qerr.InvalidVersion
+	}
+
+	utils.Infof("Switching to QUIC version %d", highestSupportedVersion)
+	c.version = highestSupportedVersion
+	c.versionNegotiated = true
+
+	// the server didn't create a session for this connection yet, so there is no need to send a CONNECTION_CLOSE
+	c.session.closeImpl(errCloseSessionForNewVersion, true)
+	return c.createNewSession()
+}
+
+func (c *client) cryptoChangeCallback(_ *Session, isForwardSecure bool) {
+	if isForwardSecure {
+		c.signalHandshakeResult(nil)
+	}
+}
+
+func (c *client) closeCallback(protocol.ConnectionID, *closedSession) {}
+
+// signalHandshakeResult passes the result of the handshake to Dial
+// only the first result is delivered, all subsequent calls are no-ops
+func (c *client) signalHandshakeResult(err error) {
+	select {
+	case c.handshakeChan <- err:
+	default:
+	}
+}
+
+// createNewSession must be called with the mutex held
+func (c *client) createNewSession() error {
+	session, err := newClientSession(
+		&udpConn{conn: c.conn, currentAddr: c.addr},
+		c.hostname,
+		c.version,
+		c.connectionID,
+		c.config,
+		c.closeCallback,
+		c.cryptoChangeCallback,
+	)
+	if err != nil {
+		return err
+	}
+	c.session = session
+
+	go func() {
+		err := session.run()
+		if err == errCloseSessionForNewVersion {
+			return
+		}
+		c.signalHandshakeResult(err)
+		if c.closeConn {
+			c.conn.Close()
+		}
+	}()
+	return nil
+}
+
+func (c *client) getSession() *Session {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.session
+}
+
+func generateConnectionID() (protocol.ConnectionID, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return 0, err
+	}
+	return protocol.ConnectionID(binary.LittleEndian.Uint64(b)), nil
+}
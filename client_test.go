@@ -0,0 +1,216 @@
+package quic
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		cl         *client
+		serverConn *net.UDPConn
+	)
+
+	// setClientNonce sets the client nonce of the session's crypto setup, as if a full CHLO had been sent
+	setClientNonce := func(s *Session) {
+		*(*[]byte)(unsafe.Pointer(reflect.ValueOf(s.cryptoSetup).Elem().FieldByName("nonc").UnsafeAddr())) = bytes.Repeat([]byte{'a'}, 32)
+	}
+
+	BeforeEach(func() {
+		var err error
+		serverConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		config, err := populateConfig(nil)
+		Expect(err).ToNot(HaveOccurred())
+		cl = &client{
+			conn:          udpConn,
+			closeConn:     true,
+			config:        config,
+			addr:          serverConn.LocalAddr(),
+			hostname:      "quic.clemente.io",
+			connectionID:  0x1337,
+			version:       protocol.Version36,
+			handshakeChan: make(chan error, 1),
+		}
+		cl.mutex.Lock()
+		err = cl.createNewSession()
+		cl.mutex.Unlock()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cl.session.Close(nil)
+		serverConn.Close()
+	})
+
+	It("generates random connection IDs", func() {
+		connID1, err := generateConnectionID()
+		Expect(err).ToNot(HaveOccurred())
+		connID2, err := generateConnectionID()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(connID1).ToNot(Equal(connID2))
+	})
+
+	It("errors when dialing an invalid address", func() {
+		_, err := Dial("quic.clemente.io", nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns the error when the session is closed during the handshake", func() {
+		cl.session.Close(qerr.ProofInvalid)
+		var err error
+		Eventually(cl.handshakeChan).Should(Receive(&err))
+		Expect(err).To(MatchError(qerr.ProofInvalid))
+	})
+
+	It("signals when the forward secure handshake is complete", func() {
+		cl.cryptoChangeCallback(nil, false)
+		Expect(cl.handshakeChan).ToNot(Receive())
+		cl.cryptoChangeCallback(nil, true)
+		Expect(cl.handshakeChan).To(Receive(BeNil()))
+	})
+
+	Context("handling packets", func() {
+		It("errors on too large packets", func() {
+			err := cl.handlePacket(nil, bytes.Repeat([]byte{'f'}, int(protocol.MaxReceivePacketSize+1)))
+			Expect(err).To(MatchError(qerr.PacketTooLarge))
+		})
+
+		It("errors on invalid public headers", func() {
+			err := cl.handlePacket(nil, nil)
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidPacketHeader))
+		})
+
+		It("ignores packets for a different connection", func() {
+			b := &bytes.Buffer{}
+			hdr := &PublicHeader{ConnectionID: 0x42, PacketNumber: 1, PacketNumberLen: protocol.PacketNumberLen1}
+			err := hdr.Write(b, protocol.Version36, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			err = cl.handlePacket(nil, b.Bytes())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.versionNegotiated).To(BeFalse())
+		})
+
+		It("passes packets to the session, and sets the version as negotiated", func() {
+			b := &bytes.Buffer{}
+			hdr := &PublicHeader{ConnectionID: 0x1337, PacketNumber: 1, PacketNumberLen: protocol.PacketNumberLen1}
+			err := hdr.Write(b, protocol.Version36, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			err = cl.handlePacket(nil, b.Bytes())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.versionNegotiated).To(BeTrue())
+		})
+
+		It("closes the session when receiving a public reset", func() {
+			var err error
+			session := cl.session
+			setClientNonce(session)
+			err = cl.handlePacket(nil, writePublicReset(0x1337, 42, session.cryptoSetup.PublicResetNonceProof(), nil))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(atomic.LoadUint32(&session.closed)).To(Equal(uint32(1)))
+			Eventually(cl.handshakeChan).Should(Receive(&err))
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PublicReset))
+		})
+
+		It("ignores public resets before the client nonce was sent", func() {
+			session := cl.session
+			Expect(session.cryptoSetup.PublicResetNonceProof()).To(BeZero())
+			err := cl.handlePacket(nil, writePublicReset(0x1337, 42, 0, nil))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(atomic.LoadUint32(&session.closed)).To(BeZero())
+		})
+
+		It("ignores public resets with an invalid nonce proof", func() {
+			session := cl.session
+			setClientNonce(session)
+			proof := session.cryptoSetup.PublicResetNonceProof() + 1
+			err := cl.handlePacket(nil, writePublicReset(0x1337, 42, proof, nil))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(atomic.LoadUint32(&session.closed)).To(BeZero())
+		})
+
+		It("ignores invalid public resets", func() {
+			session := cl.session
+			err := cl.handlePacket(nil, []byte{0x02 | 0x0c, 0x37, 0x13, 0, 0, 0, 0, 0, 0})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(atomic.LoadUint32(&session.closed)).To(BeZero())
+		})
+	})
+
+	Context("version negotiation", func() {
+		composeVersionNegotiationPacket := func(connID protocol.ConnectionID, versions []protocol.VersionNumber) []byte {
+			b := &bytes.Buffer{}
+			hdr := &PublicHeader{ConnectionID: connID, VersionFlag: true}
+			err := hdr.Write(b, protocol.Version36, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			for _, v := range versions {
+				utils.WriteUint32(b, protocol.VersionNumberToTag(v))
+			}
+			return b.Bytes()
+		}
+
+		It("changes the version and recreates the session", func() {
+			oldSession := cl.session
+			err := cl.handlePacket(nil, composeVersionNegotiationPacket(0x1337, []protocol.VersionNumber{protocol.Version35, 77}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.version).To(Equal(protocol.Version35))
+			Expect(cl.versionNegotiated).To(BeTrue())
+			Expect(cl.session).ToNot(Equal(oldSession))
+			Expect(cl.session.version).To(Equal(protocol.Version35))
+			Expect(atomic.LoadUint32(&oldSession.closed)).To(Equal(uint32(1)))
+			// closing the old session must not be reported as a handshake error
+			Consistently(cl.handshakeChan).ShouldNot(Receive())
+		})
+
+		It("ignores version negotiation packets that contain the current version", func() {
+			oldSession := cl.session
+			err := cl.handlePacket(nil, composeVersionNegotiationPacket(0x1337, []protocol.VersionNumber{protocol.Version35, protocol.Version36}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.version).To(Equal(protocol.Version36))
+			Expect(cl.session).To(Equal(oldSession))
+		})
+
+		It("ignores delayed version negotiation packets", func() {
+			cl.versionNegotiated = true
+			err := cl.handlePacket(nil, composeVersionNegotiationPacket(0x1337, []protocol.VersionNumber{protocol.Version35}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.version).To(Equal(protocol.Version36))
+		})
+
+		It("errors if no common version is found", func() {
+			err := cl.handlePacket(nil, composeVersionNegotiationPacket(0x1337, []protocol.VersionNumber{1}))
+			Expect(err).To(MatchError(qerr.InvalidVersion))
+		})
+	})
+
+	It("closes the connection when the session is closed", func() {
+		cl.session.Close(nil)
+		Eventually(func() error {
+			_, err := cl.conn.WriteTo([]byte("foobar"), cl.addr)
+			return err
+		}, time.Second).Should(HaveOccurred())
+	})
+
+	It("doesn't close connections passed to DialConn", func() {
+		cl.closeConn = false
+		cl.session.Close(nil)
+		Consistently(func() error {
+			_, err := cl.conn.WriteTo([]byte("foobar"), cl.addr)
+			return err
+		}).ShouldNot(HaveOccurred())
+		cl.conn.Close()
+	})
+})
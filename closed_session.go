@@ -0,0 +1,44 @@
+package quic
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// A closedSession answers packets for a recently closed connection
+// It replies with the CONNECTION_CLOSE packet sent when the session was closed, or with a public reset if the session didn't send one.
+// The public reset contains the nonce proof of the session, and the address the packet was received from.
+// To limit the amount of data sent in response to a flood of packets, only the 1st, 2nd, 4th, 8th, ... packet is answered.
+type closedSession struct {
+	connectionID    protocol.ConnectionID
+	connectionClose []byte
+	nonceProof      uint64
+
+	packetCount uint64
+}
+
+func newClosedSession(connectionID protocol.ConnectionID, connectionClose []byte, nonceProof uint64) *closedSession {
+	return &closedSession{
+		connectionID:    connectionID,
+		connectionClose: connectionClose,
+		nonceProof:      nonceProof,
+	}
+}
+
+func (s *closedSession) handlePacket(conn net.PacketConn, remoteAddr net.Addr, hdr *PublicHeader) error {
+	n := atomic.AddUint64(&s.packetCount, 1)
+	if n&(n-1) != 0 {
+		return nil
+	}
+	if s.connectionClose != nil {
+		utils.Debugf("Received packet 0x%x for closed connection %x, resending CONNECTION_CLOSE", hdr.PacketNumber, s.connectionID)
+		_, err := conn.WriteTo(s.connectionClose, remoteAddr)
+		return err
+	}
+	utils.Debugf("Received packet 0x%x for closed connection %x, sending public reset", hdr.PacketNumber, s.connectionID)
+	_, err := conn.WriteTo(writePublicReset(s.connectionID, hdr.PacketNumber, s.nonceProof, remoteAddr), remoteAddr)
+	return err
+}
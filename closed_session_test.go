@@ -0,0 +1,57 @@
+package quic
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Closed Session", func() {
+	var conn *mockPacketConn
+
+	BeforeEach(func() {
+		conn = newMockPacketConn(mockAddr("server"))
+	})
+
+	It("resends the CONNECTION_CLOSE packet", func() {
+		s := newClosedSession(0x1337, []byte("connection close"), 0)
+		err := s.handlePacket(conn, mockAddr("client"), &PublicHeader{ConnectionID: 0x1337, PacketNumber: 10})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.dataWritten).To(Receive(Equal(mockPacket{data: []byte("connection close"), addr: mockAddr("client")})))
+	})
+
+	It("sends a public reset if there's no CONNECTION_CLOSE packet", func() {
+		s := newClosedSession(0x1337, nil, 0xdecafbad)
+		clientAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234}
+		err := s.handlePacket(conn, clientAddr, &PublicHeader{ConnectionID: 0x1337, PacketNumber: 10})
+		Expect(err).ToNot(HaveOccurred())
+		var p mockPacket
+		Expect(conn.dataWritten).To(Receive(&p))
+		Expect(p.addr).To(Equal(clientAddr))
+		pr, err := ParsePublicReset(bytes.NewReader(p.data[9:]))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pr.RejectedPacketNumber).To(Equal(protocol.PacketNumber(10)))
+		Expect(pr.NonceProof).To(Equal(uint64(0xdecafbad)))
+		Expect(pr.ClientAddress.IP.Equal(clientAddr.IP)).To(BeTrue())
+		Expect(pr.ClientAddress.Port).To(Equal(1234))
+	})
+
+	It("answers exponentially fewer packets", func() {
+		s := newClosedSession(0x1337, []byte("connection close"), 0)
+		var answered []int
+		for i := 1; i <= 20; i++ {
+			err := s.handlePacket(conn, mockAddr("client"), &PublicHeader{ConnectionID: 0x1337, PacketNumber: 10})
+			Expect(err).ToNot(HaveOccurred())
+			select {
+			case <-conn.dataWritten:
+				answered = append(answered, i)
+			default:
+			}
+		}
+		Expect(answered).To(Equal([]int{1, 2, 4, 8, 16}))
+	})
+})
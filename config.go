@@ -0,0 +1,205 @@
+package quic
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/congestion"
+	"github.com/lucas-clemente/quic-go/handshake"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// Config contains all configuration data needed for a QUIC server or client.
+// Every value that is left zero is replaced by its default.
+type Config struct {
+	// TLSConfig is used for the crypto handshake.
+	// A server needs at least one certificate.
+	TLSConfig *tls.Config
+
+	// ReceiveStreamFlowControlWindow is the stream-level flow control window for receiving data.
+	// Defaults to protocol.DefaultReceiveStreamFlowControlWindow.
+	ReceiveStreamFlowControlWindow protocol.ByteCount
+	// ReceiveConnectionFlowControlWindow is the connection-level flow control window for receiving data.
+	// Defaults to protocol.DefaultReceiveConnectionFlowControlWindow.
+	ReceiveConnectionFlowControlWindow protocol.ByteCount
+
+	// IdleTimeout is the idle timeout we use if the peer doesn't request a different one.
+	// Defaults to protocol.DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// MaxIdleTimeout is the maximum idle timeout that the peer can negotiate.
+	// Defaults to protocol.DefaultMaxIdleTimeout.
+	MaxIdleTimeout time.Duration
+	// HandshakeTimeout is the time a connection has to complete the crypto handshake.
+	// Defaults to protocol.DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+	// ClosedSessionTimeout is the time a server keeps the connection ID of a closed session.
+	// During that time, packets for this connection are answered with the CONNECTION_CLOSE packet sent when closing the session, or with a public reset.
+	// Defaults to protocol.DefaultClosedSessionTimeout.
+	ClosedSessionTimeout time.Duration
+
+	// MaxStreamsPerConnection is the maximum number of streams per connection that the peer can negotiate.
+	// Defaults to protocol.DefaultMaxStreamsPerConnection.
+	MaxStreamsPerConnection uint32
+	// MaxIncomingDynamicStreams is the number of streams the peer is allowed to open.
+	// Defaults to protocol.DefaultMaxIncomingDynamicStreams.
+	MaxIncomingDynamicStreams uint32
+
+	// InitialCongestionWindow is the initial congestion window, in packets.
+	// Defaults to protocol.DefaultInitialCongestionWindow.
+	InitialCongestionWindow protocol.PacketNumber
+	// MaxCongestionWindow is the maximum congestion window, in packets.
+	// Defaults to protocol.DefaultMaxCongestionWindow.
+	MaxCongestionWindow protocol.PacketNumber
+	// CongestionControl is the congestion control algorithm.
+	// Defaults to congestion.AlgorithmCubic.
+	CongestionControl congestion.Algorithm
+	// CongestionControlForConnection, if set, selects the congestion control algorithm for every new connection.
+	// It overrides CongestionControl.
+	CongestionControlForConnection func(remoteAddr net.Addr) congestion.Algorithm
+	// DisableEarlyLossDetection disables tail loss probes and time-based loss detection.
+	// Lost packets at the end of a transmission are then only retransmitted after an RTO.
+	DisableEarlyLossDetection bool
+
+	// MaxPacketSize is the maximum size of the packets we send, including the public header.
+	// It can't be larger than protocol.MaxReceivePacketSize.
+	// Defaults to protocol.DefaultMaxPacketSize.
+	MaxPacketSize protocol.ByteCount
+
+	// EnablePMTUDiscovery enables path MTU discovery.
+	// After the handshake, the session sends padded probe packets to find out if packets larger than MaxPacketSize can be sent, up to protocol.MaxReceivePacketSize.
+	// It requires setting the Don't Fragment bit, which is only supported on Linux.
+	EnablePMTUDiscovery bool
+
+	// AcceptQueueSize is the number of sessions that completed the handshake, which the server queues until they are returned by Accept.
+	// If the queue is full when a session completes the handshake, the session is closed with a PeerGoingAway error.
+	// It is not used by servers created with a StreamCallback, which don't queue any sessions.
+	// Defaults to protocol.DefaultAcceptQueueSize.
+	AcceptQueueSize int
+
+	// NumSockets is the number of UDP sockets a server binds to its address, using SO_REUSEPORT.
+	// Every socket has its own read loop, and every session belongs to the socket selected by its connection ID.
+	// It is only used by Server.ListenAndServe, and only supported on Linux.
+	// Defaults to 1.
+	NumSockets int
+
+	// Orbit is the 8 byte orbit of the server's strike register, which prevents the replay of 0-RTT handshakes.
+	// Clients include the orbit in their nonces, and the server only accepts nonces for its orbit.
+	// Every server needs an orbit of its own, unless the servers share a StrikeRegister,
+	// since the strike register of one server doesn't know about the nonces accepted by another one.
+	// Defaults to a random value.
+	Orbit []byte
+
+	// StrikeRegister records the client nonces accepted by all servers that use the same orbit.
+	// If it is nil, the server keeps its strike register in memory.
+	StrikeRegister handshake.StrikeRegister
+
+	// ServerConfigRotationInterval is the interval after which the server replaces its server config, and the keys used for the handshake.
+	// Clients that cached an older server config can still do a 0-RTT handshake with it for two rotation intervals after its creation.
+	// Defaults to protocol.DefaultServerConfigRotationInterval.
+	ServerConfigRotationInterval time.Duration
+
+	// ServerSecrets are the PEM encoded server config and source address token secrets, as returned by Server.ExportServerSecrets.
+	// Servers of the same deployment that use the same ServerSecrets accept the 0-RTT handshakes and source address tokens of each other.
+	// They derive the same server configs from the secret, and rotate them at the same time, using the orbit and the rotation interval of the exporting server.
+	// Since they share the orbit, they must use the same StrikeRegister, and neither Orbit nor ServerConfigRotationInterval can be set.
+	// Defaults to randomly generated secrets.
+	ServerSecrets []byte
+
+	// ConnectionMigrationCallback is called when the peer of a session changes its address, e.g. due to a NAT rebinding.
+	// Only packets that were successfully decrypted cause a migration.
+	// It is called from the session's run loop, so it must not block.
+	ConnectionMigrationCallback ConnectionMigrationCallback
+}
+
+// populateConfig returns a copy of the config, with all unset values set to their defaults
+// it returns an error if the values are inconsistent
+func populateConfig(config *Config) (*Config, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	c := *config
+
+	if c.ReceiveStreamFlowControlWindow == 0 {
+		c.ReceiveStreamFlowControlWindow = protocol.DefaultReceiveStreamFlowControlWindow
+	}
+	if c.ReceiveConnectionFlowControlWindow == 0 {
+		c.ReceiveConnectionFlowControlWindow = protocol.DefaultReceiveConnectionFlowControlWindow
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = protocol.DefaultIdleTimeout
+	}
+	if c.MaxIdleTimeout == 0 {
+		c.MaxIdleTimeout = utils.MaxDuration(protocol.DefaultMaxIdleTimeout, c.IdleTimeout)
+	}
+	if c.HandshakeTimeout == 0 {
+		c.HandshakeTimeout = protocol.DefaultHandshakeTimeout
+	}
+	if c.ClosedSessionTimeout == 0 {
+		c.ClosedSessionTimeout = protocol.DefaultClosedSessionTimeout
+	}
+	if c.MaxStreamsPerConnection == 0 {
+		c.MaxStreamsPerConnection = protocol.DefaultMaxStreamsPerConnection
+	}
+	if c.MaxIncomingDynamicStreams == 0 {
+		c.MaxIncomingDynamicStreams = protocol.DefaultMaxIncomingDynamicStreams
+	}
+	if c.InitialCongestionWindow == 0 {
+		c.InitialCongestionWindow = protocol.DefaultInitialCongestionWindow
+	}
+	if c.MaxCongestionWindow == 0 {
+		c.MaxCongestionWindow = utils.MaxPacketNumber(protocol.DefaultMaxCongestionWindow, c.InitialCongestionWindow)
+	}
+	if c.MaxPacketSize == 0 {
+		c.MaxPacketSize = protocol.DefaultMaxPacketSize
+	}
+	if c.AcceptQueueSize == 0 {
+		c.AcceptQueueSize = protocol.DefaultAcceptQueueSize
+	}
+	if c.NumSockets == 0 {
+		c.NumSockets = 1
+	}
+	if c.ServerConfigRotationInterval == 0 && c.ServerSecrets == nil {
+		c.ServerConfigRotationInterval = protocol.DefaultServerConfigRotationInterval
+	}
+
+	if c.IdleTimeout > c.MaxIdleTimeout {
+		return nil, errors.New("quic.Config: IdleTimeout must not be larger than MaxIdleTimeout")
+	}
+	if c.InitialCongestionWindow > c.MaxCongestionWindow {
+		return nil, errors.New("quic.Config: InitialCongestionWindow must not be larger than MaxCongestionWindow")
+	}
+	if c.CongestionControl != congestion.AlgorithmCubic && c.CongestionControl != congestion.AlgorithmBBR {
+		return nil, errors.New("quic.Config: unknown CongestionControl")
+	}
+	if c.MaxPacketSize > protocol.MaxReceivePacketSize {
+		return nil, errors.New("quic.Config: MaxPacketSize must not be larger than protocol.MaxReceivePacketSize")
+	}
+	if c.MaxPacketSize < protocol.MinMaxPacketSize {
+		return nil, errors.New("quic.Config: MaxPacketSize too small")
+	}
+	if c.AcceptQueueSize < 0 {
+		return nil, errors.New("quic.Config: AcceptQueueSize must not be negative")
+	}
+	if c.NumSockets < 0 {
+		return nil, errors.New("quic.Config: NumSockets must not be negative")
+	}
+	if c.Orbit != nil && len(c.Orbit) != 8 {
+		return nil, errors.New("quic.Config: Orbit must be 8 bytes")
+	}
+	if c.Orbit != nil && c.ServerSecrets != nil {
+		return nil, errors.New("quic.Config: Orbit must not be set together with ServerSecrets")
+	}
+	if c.ServerConfigRotationInterval != 0 && c.ServerSecrets != nil {
+		return nil, errors.New("quic.Config: ServerConfigRotationInterval must not be set together with ServerSecrets")
+	}
+	if c.StrikeRegister == nil && c.ServerSecrets != nil {
+		return nil, errors.New("quic.Config: ServerSecrets require a StrikeRegister")
+	}
+	if c.ServerConfigRotationInterval < 0 {
+		return nil, errors.New("quic.Config: ServerConfigRotationInterval must not be negative")
+	}
+	return &c, nil
+}
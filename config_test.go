@@ -0,0 +1,151 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/congestion"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	It("uses the default values for a nil config", func() {
+		config, err := populateConfig(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.TLSConfig).To(BeNil())
+		Expect(config.ReceiveStreamFlowControlWindow).To(Equal(protocol.DefaultReceiveStreamFlowControlWindow))
+		Expect(config.ReceiveConnectionFlowControlWindow).To(Equal(protocol.DefaultReceiveConnectionFlowControlWindow))
+		Expect(config.IdleTimeout).To(Equal(protocol.DefaultIdleTimeout))
+		Expect(config.MaxIdleTimeout).To(Equal(protocol.DefaultMaxIdleTimeout))
+		Expect(config.HandshakeTimeout).To(Equal(protocol.DefaultHandshakeTimeout))
+		Expect(config.ClosedSessionTimeout).To(Equal(protocol.DefaultClosedSessionTimeout))
+		Expect(config.MaxStreamsPerConnection).To(Equal(uint32(protocol.DefaultMaxStreamsPerConnection)))
+		Expect(config.MaxIncomingDynamicStreams).To(Equal(uint32(protocol.DefaultMaxIncomingDynamicStreams)))
+		Expect(config.InitialCongestionWindow).To(Equal(protocol.PacketNumber(protocol.DefaultInitialCongestionWindow)))
+		Expect(config.MaxCongestionWindow).To(Equal(protocol.PacketNumber(protocol.DefaultMaxCongestionWindow)))
+		Expect(config.MaxPacketSize).To(Equal(protocol.DefaultMaxPacketSize))
+		Expect(config.CongestionControl).To(Equal(congestion.AlgorithmCubic))
+		Expect(config.DisableEarlyLossDetection).To(BeFalse())
+		Expect(config.EnablePMTUDiscovery).To(BeFalse())
+		Expect(config.AcceptQueueSize).To(Equal(protocol.DefaultAcceptQueueSize))
+		Expect(config.NumSockets).To(Equal(1))
+		Expect(config.ServerConfigRotationInterval).To(Equal(protocol.DefaultServerConfigRotationInterval))
+	})
+
+	It("keeps the values that are set", func() {
+		tlsConfig := testdata.GetTLSConfig()
+		config := &Config{
+			TLSConfig:                          tlsConfig,
+			ReceiveStreamFlowControlWindow:     0x1000,
+			ReceiveConnectionFlowControlWindow: 0x2000,
+			IdleTimeout:                        5 * time.Second,
+			MaxIdleTimeout:                     10 * time.Second,
+			HandshakeTimeout:                   3 * time.Second,
+			ClosedSessionTimeout:               20 * time.Second,
+			MaxStreamsPerConnection:            20,
+			MaxIncomingDynamicStreams:          30,
+			InitialCongestionWindow:            10,
+			MaxCongestionWindow:                100,
+			MaxPacketSize:                      1300,
+			CongestionControl:                  congestion.AlgorithmBBR,
+			DisableEarlyLossDetection:          true,
+			EnablePMTUDiscovery:                true,
+			AcceptQueueSize:                    10,
+			NumSockets:                         4,
+			Orbit:                              []byte("orbitorb"),
+			ServerConfigRotationInterval:       time.Hour,
+		}
+		populated, err := populateConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(populated).To(Equal(config))
+	})
+
+	It("doesn't modify the config", func() {
+		config := &Config{IdleTimeout: 5 * time.Second}
+		populated, err := populateConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(populated).ToNot(BeIdenticalTo(config))
+		Expect(config.MaxIdleTimeout).To(BeZero())
+	})
+
+	It("raises the default max idle timeout if the idle timeout is larger", func() {
+		config, err := populateConfig(&Config{IdleTimeout: 2 * time.Minute})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.MaxIdleTimeout).To(Equal(2 * time.Minute))
+	})
+
+	It("raises the default max congestion window if the initial congestion window is larger", func() {
+		config, err := populateConfig(&Config{InitialCongestionWindow: 2000})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.MaxCongestionWindow).To(Equal(protocol.PacketNumber(2000)))
+	})
+
+	It("errors if the idle timeout is larger than the max idle timeout", func() {
+		_, err := populateConfig(&Config{IdleTimeout: 2 * time.Minute, MaxIdleTimeout: time.Minute})
+		Expect(err).To(MatchError("quic.Config: IdleTimeout must not be larger than MaxIdleTimeout"))
+	})
+
+	It("errors if the initial congestion window is larger than the max congestion window", func() {
+		_, err := populateConfig(&Config{InitialCongestionWindow: 20, MaxCongestionWindow: 10})
+		Expect(err).To(MatchError("quic.Config: InitialCongestionWindow must not be larger than MaxCongestionWindow"))
+	})
+
+	It("errors if the congestion control algorithm is unknown", func() {
+		_, err := populateConfig(&Config{CongestionControl: 42})
+		Expect(err).To(MatchError("quic.Config: unknown CongestionControl"))
+	})
+
+	It("errors if the max packet size is too large", func() {
+		_, err := populateConfig(&Config{MaxPacketSize: protocol.MaxReceivePacketSize + 1})
+		Expect(err).To(MatchError("quic.Config: MaxPacketSize must not be larger than protocol.MaxReceivePacketSize"))
+	})
+
+	It("errors if the max packet size is too small", func() {
+		_, err := populateConfig(&Config{MaxPacketSize: protocol.MinMaxPacketSize - 1})
+		Expect(err).To(MatchError("quic.Config: MaxPacketSize too small"))
+	})
+
+	It("errors if the accept queue size is negative", func() {
+		_, err := populateConfig(&Config{AcceptQueueSize: -1})
+		Expect(err).To(MatchError("quic.Config: AcceptQueueSize must not be negative"))
+	})
+
+	It("errors if the number of sockets is negative", func() {
+		_, err := populateConfig(&Config{NumSockets: -1})
+		Expect(err).To(MatchError("quic.Config: NumSockets must not be negative"))
+	})
+
+	It("errors if the orbit has an invalid length", func() {
+		_, err := populateConfig(&Config{Orbit: []byte("orbit")})
+		Expect(err).To(MatchError("quic.Config: Orbit must be 8 bytes"))
+	})
+
+	It("errors if both the orbit and the server secrets are set", func() {
+		_, err := populateConfig(&Config{Orbit: []byte("orbitorb"), ServerSecrets: []byte("secrets")})
+		Expect(err).To(MatchError("quic.Config: Orbit must not be set together with ServerSecrets"))
+	})
+
+	It("errors if both the server config rotation interval and the server secrets are set", func() {
+		_, err := populateConfig(&Config{ServerConfigRotationInterval: time.Hour, ServerSecrets: []byte("secrets"), StrikeRegister: &mockStrikeRegister{}})
+		Expect(err).To(MatchError("quic.Config: ServerConfigRotationInterval must not be set together with ServerSecrets"))
+	})
+
+	It("errors if the server secrets are set without a strike register", func() {
+		_, err := populateConfig(&Config{ServerSecrets: []byte("secrets")})
+		Expect(err).To(MatchError("quic.Config: ServerSecrets require a StrikeRegister"))
+	})
+
+	It("doesn't set a server config rotation interval when using server secrets", func() {
+		config, err := populateConfig(&Config{ServerSecrets: []byte("secrets"), StrikeRegister: &mockStrikeRegister{}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.ServerConfigRotationInterval).To(BeZero())
+	})
+
+	It("errors if the server config rotation interval is negative", func() {
+		_, err := populateConfig(&Config{ServerConfigRotationInterval: -time.Second})
+		Expect(err).To(MatchError("quic.Config: ServerConfigRotationInterval must not be negative"))
+	})
+})
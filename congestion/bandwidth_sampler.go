@@ -0,0 +1,120 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// infBandwidth is larger than any bandwidth that can be measured
+const infBandwidth = Bandwidth(^uint64(0))
+
+// A bandwidthSample is a delivery rate sample, taken when a packet is acknowledged
+type bandwidthSample struct {
+	bandwidth Bandwidth
+	rtt       time.Duration
+}
+
+// the state of the connection at the time a packet was sent
+type sentPacketState struct {
+	sentTime time.Time
+	size     protocol.ByteCount
+
+	// the total number of bytes sent, including this packet
+	totalBytesSent protocol.ByteCount
+	// the total number of bytes sent when the last acknowledged packet was sent
+	totalBytesSentAtLastAckedPacket protocol.ByteCount
+	// the total number of bytes acknowledged when this packet was sent
+	totalBytesAckedAtLastAckedPacket protocol.ByteCount
+	lastAckedPacketSentTime          time.Time
+	lastAckedPacketAckTime           time.Time
+}
+
+// A bandwidthSampler measures the delivery rate of the connection.
+// For every acknowledged packet, it compares the amount of data acknowledged since the packet was sent to the time that passed.
+// To avoid overestimating the bandwidth when ACKs are compressed, the send rate over the same interval is an upper bound for the sample.
+// See https://tools.ietf.org/html/draft-cheng-iccrg-delivery-rate-estimation.
+type bandwidthSampler struct {
+	totalBytesSent  protocol.ByteCount
+	totalBytesAcked protocol.ByteCount
+
+	totalBytesSentAtLastAckedPacket protocol.ByteCount
+	lastAckedPacketSentTime         time.Time
+	lastAckedPacketAckTime          time.Time
+
+	packets map[protocol.PacketNumber]*sentPacketState
+}
+
+func newBandwidthSampler() *bandwidthSampler {
+	return &bandwidthSampler{
+		packets: make(map[protocol.PacketNumber]*sentPacketState),
+	}
+}
+
+// OnPacketSent records the state of the connection at the time a retransmittable packet is sent
+// bytesInFlight are the bytes in flight before this packet was sent.
+func (s *bandwidthSampler) OnPacketSent(sentTime time.Time, packetNumber protocol.PacketNumber, bytes protocol.ByteCount, bytesInFlight protocol.ByteCount) {
+	s.totalBytesSent += bytes
+
+	// If there are no packets in flight, the time between the last acknowledged packet and this packet was spent idling.
+	// Start the measurement interval at this packet, so that the idle time doesn't lower the sample.
+	if bytesInFlight == 0 {
+		s.lastAckedPacketAckTime = sentTime
+		s.lastAckedPacketSentTime = sentTime
+		s.totalBytesSentAtLastAckedPacket = s.totalBytesSent
+	}
+
+	s.packets[packetNumber] = &sentPacketState{
+		sentTime:                         sentTime,
+		size:                             bytes,
+		totalBytesSent:                   s.totalBytesSent,
+		totalBytesSentAtLastAckedPacket:  s.totalBytesSentAtLastAckedPacket,
+		totalBytesAckedAtLastAckedPacket: s.totalBytesAcked,
+		lastAckedPacketSentTime:          s.lastAckedPacketSentTime,
+		lastAckedPacketAckTime:           s.lastAckedPacketAckTime,
+	}
+}
+
+// OnPacketAcked takes a bandwidth sample
+// If no sample can be taken, the bandwidth of the sample is zero.
+func (s *bandwidthSampler) OnPacketAcked(ackTime time.Time, packetNumber protocol.PacketNumber) bandwidthSample {
+	p, ok := s.packets[packetNumber]
+	if !ok {
+		return bandwidthSample{}
+	}
+	delete(s.packets, packetNumber)
+
+	s.totalBytesAcked += p.size
+	s.totalBytesSentAtLastAckedPacket = p.totalBytesSent
+	s.lastAckedPacketSentTime = p.sentTime
+	s.lastAckedPacketAckTime = ackTime
+
+	// no packet was acknowledged before this packet was sent
+	if p.lastAckedPacketSentTime.IsZero() {
+		return bandwidthSample{}
+	}
+
+	sendRate := infBandwidth
+	if p.sentTime.After(p.lastAckedPacketSentTime) {
+		sendRate = BandwidthFromDelta(p.totalBytesSent-p.totalBytesSentAtLastAckedPacket, p.sentTime.Sub(p.lastAckedPacketSentTime))
+	}
+	ackTimeDelta := ackTime.Sub(p.lastAckedPacketAckTime)
+	if ackTimeDelta <= 0 {
+		return bandwidthSample{}
+	}
+	ackRate := BandwidthFromDelta(s.totalBytesAcked-p.totalBytesAckedAtLastAckedPacket, ackTimeDelta)
+
+	sample := bandwidthSample{
+		bandwidth: ackRate,
+		rtt:       ackTime.Sub(p.sentTime),
+	}
+	if sendRate < ackRate {
+		sample.bandwidth = sendRate
+	}
+	return sample
+}
+
+// OnPacketLost forgets about a lost packet
+func (s *bandwidthSampler) OnPacketLost(packetNumber protocol.PacketNumber) {
+	delete(s.packets, packetNumber)
+}
@@ -0,0 +1,103 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bandwidth sampler", func() {
+	var (
+		sampler       *bandwidthSampler
+		now           time.Time
+		bytesInFlight protocol.ByteCount
+	)
+
+	const packetSize = protocol.DefaultTCPMSS
+
+	BeforeEach(func() {
+		sampler = newBandwidthSampler()
+		now = time.Now()
+		bytesInFlight = 0
+	})
+
+	sendPacket := func(p protocol.PacketNumber) {
+		sampler.OnPacketSent(now, p, packetSize, bytesInFlight)
+		bytesInFlight += packetSize
+	}
+
+	ackPacket := func(p protocol.PacketNumber) bandwidthSample {
+		bytesInFlight -= packetSize
+		return sampler.OnPacketAcked(now, p)
+	}
+
+	It("measures the bandwidth of packets sent at a constant rate", func() {
+		// send one packet every 10ms, the RTT is 100ms
+		for p := protocol.PacketNumber(1); p <= 40; p++ {
+			sendPacket(p)
+			if p > 10 {
+				sample := ackPacket(p - 10)
+				Expect(sample.rtt).To(Equal(100 * time.Millisecond))
+				// the packets sent during the first RTT are compared to the time when the first packet was sent
+				if p > 21 {
+					Expect(sample.bandwidth).To(Equal(BandwidthFromDelta(packetSize, 10*time.Millisecond)))
+				}
+			}
+			now = now.Add(10 * time.Millisecond)
+		}
+	})
+
+	It("limits the sample to the send rate, if ACKs arrive in a burst", func() {
+		start := now
+		// send one packet every 10ms, the RTT is 100ms
+		for p := protocol.PacketNumber(1); p <= 20; p++ {
+			sendPacket(p)
+			if p > 10 {
+				ackPacket(p - 10)
+			}
+			now = now.Add(10 * time.Millisecond)
+		}
+		// the ACKs for the remaining packets all arrive at the same time
+		now = start.Add(211 * time.Millisecond)
+		var sample bandwidthSample
+		for p := protocol.PacketNumber(11); p <= 20; p++ {
+			sample = ackPacket(p)
+		}
+		Expect(sample.bandwidth).To(Equal(BandwidthFromDelta(packetSize, 10*time.Millisecond)))
+	})
+
+	It("doesn't include idle time in the sample", func() {
+		sendPacket(1)
+		now = now.Add(100 * time.Millisecond)
+		ackPacket(1)
+		Expect(bytesInFlight).To(BeZero())
+		now = now.Add(time.Second)
+		sendPacket(2)
+		now = now.Add(10 * time.Millisecond)
+		sendPacket(3)
+		now = now.Add(100 * time.Millisecond)
+		ackPacket(2)
+		now = now.Add(10 * time.Millisecond)
+		sample := ackPacket(3)
+		// two packets were acknowledged in the 120ms since packet 2 was sent
+		Expect(sample.bandwidth).To(Equal(BandwidthFromDelta(2*packetSize, 120*time.Millisecond)))
+	})
+
+	It("doesn't take a sample for unknown packets", func() {
+		Expect(sampler.OnPacketAcked(now, 42).bandwidth).To(BeZero())
+	})
+
+	It("forgets lost packets", func() {
+		sendPacket(1)
+		sendPacket(2)
+		sampler.OnPacketLost(1)
+		Expect(sampler.packets).To(HaveLen(1))
+		now = now.Add(100 * time.Millisecond)
+		Expect(ackPacket(1).bandwidth).To(BeZero())
+		Expect(sampler.totalBytesAcked).To(BeZero())
+		Expect(ackPacket(2).bandwidth).ToNot(BeZero())
+		Expect(sampler.packets).To(BeEmpty())
+	})
+})
@@ -0,0 +1,500 @@
+package congestion
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// This is an implementation of BBR, following the implementation in Chromium.
+// See https://queue.acm.org/detail.cfm?id=3022184 for a description of the algorithm.
+
+const (
+	// the gain used in startup, 2/ln(2), the smallest gain that allows the sending rate to double every round trip
+	bbrHighGain float32 = 2.885
+	// the gain used in drain, to drain the queue created in startup within one round trip
+	bbrDrainGain float32 = 1 / bbrHighGain
+	// the congestion window gain used in probe bandwidth mode
+	bbrCongestionWindowGain float32 = 2
+	// the bandwidth needs to grow by at least 25% per round trip in startup
+	bbrStartupGrowthTarget float32 = 1.25
+	// the number of round trips without sufficient bandwidth growth before leaving startup
+	bbrRoundTripsWithoutGrowthBeforeExitingStartup = 3
+
+	// the number of round trips over which the maximum bandwidth is tracked
+	bbrBandwidthWindowSize = 10
+	// the time after which the minimum RTT expires, causing a transition to probe RTT
+	bbrMinRTTExpiry = 10 * time.Second
+	// the time spent in probe RTT
+	bbrProbeRTTTime = 200 * time.Millisecond
+
+	bbrMinimumCongestionWindow = 4 * protocol.DefaultTCPMSS
+)
+
+// the cycle of pacing gains used in probe bandwidth mode
+var bbrPacingGainCycle = []float32{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+type bbrMode int
+
+const (
+	// bbrStartup ramps up the sending rate exponentially, until the bandwidth stops growing
+	bbrStartup bbrMode = iota
+	// bbrDrain drains the queue that was created in startup
+	bbrDrain
+	// bbrProbeBandwidth cycles through the pacing gains, to probe for more bandwidth
+	bbrProbeBandwidth
+	// bbrProbeRTT reduces the congestion window to measure a new minimum RTT
+	bbrProbeRTT
+)
+
+type bbrRecoveryState int
+
+const (
+	bbrNotInRecovery bbrRecoveryState = iota
+	// bbrConservation doesn't allow any growth of the recovery window during the first round trip of the recovery
+	bbrConservation
+	// bbrGrowth allows the recovery window to grow by the number of bytes acknowledged
+	bbrGrowth
+)
+
+type bbrSender struct {
+	clock    Clock
+	rttStats *RTTStats
+	sampler  *bandwidthSampler
+
+	mode bbrMode
+
+	lastSentPacket protocol.PacketNumber
+	// a new round trip starts when the ACK for this packet is received
+	currentRoundTripEnd protocol.PacketNumber
+	roundTripCount      uint64
+
+	// maxBandwidth tracks the maximum bandwidth over the last bbrBandwidthWindowSize round trips, in bits per second
+	maxBandwidth *utils.WindowedMaxFilter
+
+	minRTT          time.Duration
+	minRTTTimestamp time.Time
+
+	congestionWindow        protocol.ByteCount
+	initialCongestionWindow protocol.ByteCount
+	maxCongestionWindow     protocol.ByteCount
+
+	pacingRate           Bandwidth
+	pacingGain           float32
+	congestionWindowGain float32
+
+	// the current offset in bbrPacingGainCycle, and the time the current phase started
+	cycleCurrentOffset int
+	lastCycleStart     time.Time
+
+	isAtFullBandwidth          bool
+	roundsWithoutBandwidthGain int
+	bandwidthAtLastRound       Bandwidth
+
+	// exitProbeRTTAt is set as soon as the bytes in flight dropped to the probe RTT congestion window
+	exitProbeRTTAt      time.Time
+	probeRTTRoundPassed bool
+
+	recoveryState bbrRecoveryState
+	// recovery ends when the ACK for this packet is received
+	endRecoveryAt  protocol.PacketNumber
+	recoveryWindow protocol.ByteCount
+}
+
+// NewBBRSender makes a new BBR sender
+// The congestion windows are given in packets.
+func NewBBRSender(clock Clock, rttStats *RTTStats, initialCongestionWindow, maxCongestionWindow protocol.PacketNumber) SendAlgorithmWithDebugInfo {
+	b := &bbrSender{
+		clock:                   clock,
+		rttStats:                rttStats,
+		initialCongestionWindow: protocol.ByteCount(initialCongestionWindow) * protocol.DefaultTCPMSS,
+		maxCongestionWindow:     protocol.ByteCount(maxCongestionWindow) * protocol.DefaultTCPMSS,
+	}
+	b.reset()
+	return b
+}
+
+func (b *bbrSender) reset() {
+	b.sampler = newBandwidthSampler()
+	b.maxBandwidth = utils.NewWindowedMaxFilter(bbrBandwidthWindowSize)
+	b.lastSentPacket = 0
+	b.currentRoundTripEnd = 0
+	b.roundTripCount = 0
+	b.minRTT = 0
+	b.minRTTTimestamp = time.Time{}
+	b.congestionWindow = b.initialCongestionWindow
+	b.pacingRate = 0
+	b.isAtFullBandwidth = false
+	b.roundsWithoutBandwidthGain = 0
+	b.bandwidthAtLastRound = 0
+	b.exitProbeRTTAt = time.Time{}
+	b.probeRTTRoundPassed = false
+	b.recoveryState = bbrNotInRecovery
+	b.endRecoveryAt = 0
+	b.recoveryWindow = b.maxCongestionWindow
+	b.enterStartupMode()
+}
+
+func (b *bbrSender) TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration {
+	if bytesInFlight < b.GetCongestionWindow() {
+		return 0
+	}
+	return utils.InfDuration
+}
+
+// OnPacketSent is called when a packet is sent
+// The sentPacketHandler passes the bytes in flight including the packet that is being sent.
+func (b *bbrSender) OnPacketSent(sentTime time.Time, bytesInFlight protocol.ByteCount, packetNumber protocol.PacketNumber, bytes protocol.ByteCount, isRetransmittable bool) bool {
+	b.lastSentPacket = packetNumber
+	if !isRetransmittable {
+		return false
+	}
+	b.sampler.OnPacketSent(sentTime, packetNumber, bytes, bytesInFlight-bytes)
+	return true
+}
+
+func (b *bbrSender) GetCongestionWindow() protocol.ByteCount {
+	if b.mode == bbrProbeRTT {
+		return bbrMinimumCongestionWindow
+	}
+	// The drain gain only reduces the pacing rate. Limit the bytes in flight as well,
+	// so that the queue is drained even if packets are not paced.
+	if b.mode == bbrDrain {
+		return utils.MinByteCount(b.congestionWindow, b.getTargetCongestionWindow(1))
+	}
+	if b.InRecovery() {
+		return utils.MinByteCount(b.congestionWindow, b.recoveryWindow)
+	}
+	return b.congestionWindow
+}
+
+// OnCongestionEvent updates the model of the network path, and calculates the new pacing rate and congestion window
+// The sentPacketHandler passes the bytes in flight after the acknowledged and lost packets were removed.
+func (b *bbrSender) OnCongestionEvent(rttUpdated bool, bytesInFlight protocol.ByteCount, ackedPackets PacketVector, lostPackets PacketVector) {
+	now := b.clock.Now()
+
+	var bytesAcked, bytesLost protocol.ByteCount
+	for _, p := range ackedPackets {
+		bytesAcked += p.Length
+	}
+	for _, p := range lostPackets {
+		bytesLost += p.Length
+		b.sampler.OnPacketLost(p.Number)
+	}
+	priorInFlight := bytesInFlight + bytesAcked + bytesLost
+	hasLosses := len(lostPackets) > 0
+
+	var isRoundStart, minRTTExpired bool
+	var lastAcked protocol.PacketNumber
+	if len(ackedPackets) > 0 {
+		lastAcked = ackedPackets[len(ackedPackets)-1].Number
+		isRoundStart = b.updateRoundTripCounter(lastAcked)
+		minRTTExpired = b.updateBandwidthAndMinRTT(now, ackedPackets)
+	}
+	// Losses detected by a retransmission timeout are reported without any acknowledged packets.
+	if len(ackedPackets) > 0 || hasLosses {
+		b.updateRecoveryState(lastAcked, hasLosses, isRoundStart)
+	}
+
+	if b.mode == bbrProbeBandwidth {
+		b.updateGainCyclePhase(now, priorInFlight, hasLosses)
+	}
+	if isRoundStart && !b.isAtFullBandwidth {
+		b.checkIfFullBandwidthReached()
+	}
+	b.maybeExitStartupOrDrain(now, bytesInFlight)
+	b.maybeEnterOrExitProbeRTT(now, isRoundStart, minRTTExpired, bytesInFlight)
+
+	b.calculatePacingRate()
+	b.calculateCongestionWindow(bytesAcked)
+	b.calculateRecoveryWindow(bytesAcked, bytesLost, bytesInFlight)
+}
+
+func (b *bbrSender) updateRoundTripCounter(lastAcked protocol.PacketNumber) bool {
+	if lastAcked > b.currentRoundTripEnd {
+		b.roundTripCount++
+		b.currentRoundTripEnd = b.lastSentPacket
+		return true
+	}
+	return false
+}
+
+// updateBandwidthAndMinRTT takes bandwidth and RTT samples for the acknowledged packets
+// It returns if the minimum RTT expired.
+func (b *bbrSender) updateBandwidthAndMinRTT(now time.Time, ackedPackets PacketVector) bool {
+	sampleMinRTT := utils.InfDuration
+	for _, p := range ackedPackets {
+		sample := b.sampler.OnPacketAcked(now, p.Number)
+		if sample.bandwidth == 0 {
+			continue
+		}
+		sampleMinRTT = utils.MinDuration(sampleMinRTT, sample.rtt)
+		b.maxBandwidth.Update(uint64(sample.bandwidth), b.roundTripCount)
+	}
+
+	if sampleMinRTT == utils.InfDuration {
+		return false
+	}
+	minRTTExpired := b.minRTT != 0 && now.After(b.minRTTTimestamp.Add(bbrMinRTTExpiry))
+	if minRTTExpired || sampleMinRTT < b.minRTT || b.minRTT == 0 {
+		b.minRTT = sampleMinRTT
+		b.minRTTTimestamp = now
+	}
+	return minRTTExpired
+}
+
+func (b *bbrSender) updateGainCyclePhase(now time.Time, priorInFlight protocol.ByteCount, hasLosses bool) {
+	// Each phase lasts (roughly) one minimum RTT.
+	shouldAdvance := now.Sub(b.lastCycleStart) > b.getMinRTT()
+	// When probing for more bandwidth, stay in the phase until the pipe is actually filled, unless there are losses.
+	if b.pacingGain > 1 && !hasLosses && priorInFlight < b.getTargetCongestionWindow(b.pacingGain) {
+		shouldAdvance = false
+	}
+	// When draining the queue created by probing, leave the phase early once the queue is drained.
+	if b.pacingGain < 1 && priorInFlight <= b.getTargetCongestionWindow(1) {
+		shouldAdvance = true
+	}
+	if shouldAdvance {
+		b.cycleCurrentOffset = (b.cycleCurrentOffset + 1) % len(bbrPacingGainCycle)
+		b.lastCycleStart = now
+		b.pacingGain = bbrPacingGainCycle[b.cycleCurrentOffset]
+	}
+}
+
+func (b *bbrSender) checkIfFullBandwidthReached() {
+	bandwidth := b.BandwidthEstimate()
+	target := Bandwidth(float32(b.bandwidthAtLastRound) * bbrStartupGrowthTarget)
+	if bandwidth >= target {
+		b.bandwidthAtLastRound = bandwidth
+		b.roundsWithoutBandwidthGain = 0
+		return
+	}
+	b.roundsWithoutBandwidthGain++
+	if b.roundsWithoutBandwidthGain >= bbrRoundTripsWithoutGrowthBeforeExitingStartup {
+		b.isAtFullBandwidth = true
+	}
+}
+
+func (b *bbrSender) maybeExitStartupOrDrain(now time.Time, bytesInFlight protocol.ByteCount) {
+	if b.mode == bbrStartup && b.isAtFullBandwidth {
+		b.mode = bbrDrain
+		b.pacingGain = bbrDrainGain
+		b.congestionWindowGain = bbrHighGain
+	}
+	if b.mode == bbrDrain && bytesInFlight <= b.getTargetCongestionWindow(1) {
+		b.enterProbeBandwidthMode(now)
+	}
+}
+
+func (b *bbrSender) maybeEnterOrExitProbeRTT(now time.Time, isRoundStart, minRTTExpired bool, bytesInFlight protocol.ByteCount) {
+	if minRTTExpired && b.mode != bbrProbeRTT {
+		b.mode = bbrProbeRTT
+		b.pacingGain = 1
+		// Don't start the probe RTT timer until the bytes in flight dropped to the probe RTT congestion window.
+		b.exitProbeRTTAt = time.Time{}
+	}
+	if b.mode != bbrProbeRTT {
+		return
+	}
+	if b.exitProbeRTTAt.IsZero() {
+		if bytesInFlight < bbrMinimumCongestionWindow+protocol.DefaultTCPMSS {
+			b.exitProbeRTTAt = now.Add(bbrProbeRTTTime)
+			b.probeRTTRoundPassed = false
+		}
+		return
+	}
+	if isRoundStart {
+		b.probeRTTRoundPassed = true
+	}
+	if !now.Before(b.exitProbeRTTAt) && b.probeRTTRoundPassed {
+		b.minRTTTimestamp = now
+		if b.isAtFullBandwidth {
+			b.enterProbeBandwidthMode(now)
+		} else {
+			b.enterStartupMode()
+		}
+	}
+}
+
+func (b *bbrSender) updateRecoveryState(lastAcked protocol.PacketNumber, hasLosses, isRoundStart bool) {
+	// Exit recovery when there are no losses for a round.
+	if hasLosses {
+		b.endRecoveryAt = b.lastSentPacket
+	}
+
+	switch b.recoveryState {
+	case bbrNotInRecovery:
+		if hasLosses {
+			b.recoveryState = bbrConservation
+			// the recovery window is initialized in calculateRecoveryWindow
+			b.recoveryWindow = 0
+			// Since the conservation phase is meant to last for a whole round, extend the current round as if it were started right now.
+			b.currentRoundTripEnd = b.lastSentPacket
+		}
+	case bbrConservation, bbrGrowth:
+		if b.recoveryState == bbrConservation && isRoundStart {
+			b.recoveryState = bbrGrowth
+		}
+		if !hasLosses && lastAcked > b.endRecoveryAt {
+			b.recoveryState = bbrNotInRecovery
+		}
+	}
+}
+
+func (b *bbrSender) calculatePacingRate() {
+	bandwidth := b.BandwidthEstimate()
+	if bandwidth == 0 {
+		return
+	}
+	target := Bandwidth(b.pacingGain * float32(bandwidth))
+	if b.isAtFullBandwidth {
+		b.pacingRate = target
+		return
+	}
+	// Pace at the rate of the initial congestion window over the RTT, as soon as the first RTT sample is available.
+	if b.pacingRate == 0 && b.rttStats.MinRTT() != 0 {
+		b.pacingRate = BandwidthFromDelta(b.initialCongestionWindow, b.rttStats.MinRTT())
+		return
+	}
+	// Don't decrease the pacing rate during startup.
+	if target > b.pacingRate {
+		b.pacingRate = target
+	}
+}
+
+func (b *bbrSender) calculateCongestionWindow(bytesAcked protocol.ByteCount) {
+	if b.mode == bbrProbeRTT {
+		return
+	}
+	target := b.getTargetCongestionWindow(b.congestionWindowGain)
+	if b.isAtFullBandwidth {
+		// If the bandwidth was reached, approach the target at most as fast as in slow start.
+		b.congestionWindow = utils.MinByteCount(target, b.congestionWindow+bytesAcked)
+	} else if b.congestionWindow < target || b.sampler.totalBytesAcked < b.initialCongestionWindow {
+		// If the bandwidth hasn't been reached yet, only grow the window.
+		b.congestionWindow += bytesAcked
+	}
+	if b.congestionWindow < bbrMinimumCongestionWindow {
+		b.congestionWindow = bbrMinimumCongestionWindow
+	}
+	b.congestionWindow = utils.MinByteCount(b.congestionWindow, b.maxCongestionWindow)
+}
+
+func (b *bbrSender) calculateRecoveryWindow(bytesAcked, bytesLost, bytesInFlight protocol.ByteCount) {
+	if b.recoveryState == bbrNotInRecovery {
+		return
+	}
+	// Set up the initial recovery window.
+	if b.recoveryWindow == 0 {
+		b.recoveryWindow = utils.MaxByteCount(bytesInFlight+bytesAcked, bbrMinimumCongestionWindow)
+		return
+	}
+	// Remove the losses from the recovery window, while accounting for a potential integer underflow.
+	if b.recoveryWindow >= bytesLost {
+		b.recoveryWindow -= bytesLost
+	} else {
+		b.recoveryWindow = protocol.DefaultTCPMSS
+	}
+	// In conservation mode, just subtracting losses is sufficient. In growth mode, release additional bytes for every acknowledged byte.
+	if b.recoveryState == bbrGrowth {
+		b.recoveryWindow += bytesAcked
+	}
+	// Sanity checks. Ensure that we always allow to send at least an ACK worth of data.
+	b.recoveryWindow = utils.MaxByteCount(b.recoveryWindow, bytesInFlight+bytesAcked)
+	b.recoveryWindow = utils.MaxByteCount(b.recoveryWindow, bbrMinimumCongestionWindow)
+}
+
+// getTargetCongestionWindow returns the bandwidth-delay product, multiplied by gain
+func (b *bbrSender) getTargetCongestionWindow(gain float32) protocol.ByteCount {
+	bdp := protocol.ByteCount(uint64(b.BandwidthEstimate()/BytesPerSecond) * uint64(b.getMinRTT()) / uint64(time.Second))
+	congestionWindow := protocol.ByteCount(gain * float32(bdp))
+	// If we have no bandwidth samples yet, use the initial congestion window.
+	if congestionWindow == 0 {
+		congestionWindow = protocol.ByteCount(gain * float32(b.initialCongestionWindow))
+	}
+	return utils.MaxByteCount(congestionWindow, bbrMinimumCongestionWindow)
+}
+
+// getMinRTT returns the minimum RTT, or the initial RTT if no RTT sample was taken yet
+func (b *bbrSender) getMinRTT() time.Duration {
+	if b.minRTT != 0 {
+		return b.minRTT
+	}
+	return time.Duration(b.rttStats.InitialRTTus()) * time.Microsecond
+}
+
+func (b *bbrSender) enterStartupMode() {
+	b.mode = bbrStartup
+	b.pacingGain = bbrHighGain
+	b.congestionWindowGain = bbrHighGain
+}
+
+func (b *bbrSender) enterProbeBandwidthMode(now time.Time) {
+	b.mode = bbrProbeBandwidth
+	b.congestionWindowGain = bbrCongestionWindowGain
+	// Pick a random offset for the gain cycle out of {0, 2..7} range. 1 is excluded because in that case
+	// increased gain and decreased gain would not follow each other.
+	b.cycleCurrentOffset = rand.Intn(len(bbrPacingGainCycle) - 1)
+	if b.cycleCurrentOffset >= 1 {
+		b.cycleCurrentOffset++
+	}
+	b.lastCycleStart = now
+	b.pacingGain = bbrPacingGainCycle[b.cycleCurrentOffset]
+}
+
+// PacingRate returns the rate at which packets should be sent
+// It is zero until the first RTT was measured, so the first flight isn't paced.
+func (b *bbrSender) PacingRate() Bandwidth {
+	return b.pacingRate
+}
+
+// BandwidthEstimate returns the maximum bandwidth measured during the last bbrBandwidthWindowSize round trips
+func (b *bbrSender) BandwidthEstimate() Bandwidth {
+	return Bandwidth(b.maxBandwidth.Get())
+}
+
+// InRecovery returns if the sender is in loss recovery
+func (b *bbrSender) InRecovery() bool {
+	return b.recoveryState != bbrNotInRecovery
+}
+
+// SetNumEmulatedConnections is not applicable to BBR
+func (b *bbrSender) SetNumEmulatedConnections(n int) {}
+
+// OnRetransmissionTimeout is called on an retransmission timeout
+// The lost packets were already reported in OnCongestionEvent, so BBR doesn't need to take any further action.
+func (b *bbrSender) OnRetransmissionTimeout(packetsRetransmitted bool) {}
+
+// OnConnectionMigration is called when the connection is migrated
+// The model of the old path doesn't apply to the new path, so BBR starts from scratch.
+func (b *bbrSender) OnConnectionMigration() {
+	b.reset()
+}
+
+// SetSlowStartLargeReduction is not applicable to BBR
+func (b *bbrSender) SetSlowStartLargeReduction(enabled bool) {}
+
+// RetransmissionDelay gives the time to retransmission
+func (b *bbrSender) RetransmissionDelay() time.Duration {
+	if b.rttStats.SmoothedRTT() == 0 {
+		return 0
+	}
+	return b.rttStats.SmoothedRTT() + b.rttStats.MeanDeviation()*4
+}
+
+// HybridSlowStart is not used by BBR
+func (b *bbrSender) HybridSlowStart() *HybridSlowStart {
+	return nil
+}
+
+// SlowstartThreshold is not applicable to BBR
+func (b *bbrSender) SlowstartThreshold() protocol.PacketNumber {
+	return 0
+}
+
+// RenoBeta is not applicable to BBR
+func (b *bbrSender) RenoBeta() float32 {
+	return 0
+}
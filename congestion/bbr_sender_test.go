@@ -0,0 +1,179 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BBR Sender", func() {
+	const (
+		packetSize = protocol.DefaultTCPMSS
+		// the link used in the simulation
+		linkBandwidth = 1000 * packetSize // bytes per second
+		linkRTT       = 100 * time.Millisecond
+		// the bandwidth-delay product of the link
+		linkBDP = linkBandwidth / 10
+	)
+
+	type simPacket struct {
+		number   protocol.PacketNumber
+		sentTime time.Time
+		ackTime  time.Time
+	}
+
+	var (
+		sender        *bbrSender
+		clock         mockClock
+		rttStats      *RTTStats
+		bytesInFlight protocol.ByteCount
+		packetNumber  protocol.PacketNumber
+		inFlight      []simPacket
+		// the time when the bottleneck link is available to transmit the next packet
+		linkFree time.Time
+	)
+
+	BeforeEach(func() {
+		clock = mockClock(time.Now())
+		rttStats = NewRTTStats()
+		sender = NewBBRSender(&clock, rttStats, initialCongestionWindowPackets, MaxCongestionWindow).(*bbrSender)
+		bytesInFlight = 0
+		packetNumber = 0
+		inFlight = nil
+		linkFree = time.Time{}
+	})
+
+	// sendPackets sends as many packets as the congestion window allows
+	sendPackets := func() {
+		for sender.TimeUntilSend(clock.Now(), bytesInFlight) == 0 {
+			packetNumber++
+			bytesInFlight += packetSize
+			sender.OnPacketSent(clock.Now(), bytesInFlight, packetNumber, packetSize, true)
+			if linkFree.Before(clock.Now()) {
+				linkFree = clock.Now()
+			}
+			linkFree = linkFree.Add(time.Duration(packetSize) * time.Second / time.Duration(linkBandwidth))
+			inFlight = append(inFlight, simPacket{number: packetNumber, sentTime: clock.Now(), ackTime: linkFree.Add(linkRTT)})
+		}
+	}
+
+	// ackNextPacket advances the clock until the next ACK arrives
+	ackNextPacket := func() {
+		p := inFlight[0]
+		inFlight = inFlight[1:]
+		clock = mockClock(p.ackTime)
+		bytesInFlight -= packetSize
+		rttStats.UpdateRTT(p.ackTime.Sub(p.sentTime), 0, clock.Now())
+		sender.OnCongestionEvent(true, bytesInFlight, PacketVector{{Number: p.number, Length: packetSize}}, nil)
+	}
+
+	// loseLastPacket declares the most recently sent packet lost
+	loseLastPacket := func() {
+		p := inFlight[len(inFlight)-1]
+		inFlight = inFlight[:len(inFlight)-1]
+		bytesInFlight -= packetSize
+		sender.OnCongestionEvent(false, bytesInFlight, nil, PacketVector{{Number: p.number, Length: packetSize}})
+	}
+
+	run := func(d time.Duration) {
+		end := clock.Now().Add(d)
+		for clock.Now().Before(end) {
+			sendPackets()
+			ackNextPacket()
+		}
+	}
+
+	It("starts in startup, with the initial congestion window", func() {
+		Expect(sender.mode).To(Equal(bbrStartup))
+		Expect(sender.GetCongestionWindow()).To(Equal(defaultWindowTCP))
+		Expect(sender.TimeUntilSend(clock.Now(), 0)).To(BeZero())
+		Expect(sender.TimeUntilSend(clock.Now(), defaultWindowTCP)).To(Equal(utils.InfDuration))
+		Expect(sender.BandwidthEstimate()).To(BeZero())
+		Expect(sender.PacingRate()).To(BeZero())
+		Expect(sender.InRecovery()).To(BeFalse())
+	})
+
+	It("grows the congestion window by the number of bytes acknowledged in startup", func() {
+		sendPackets()
+		ackNextPacket()
+		Expect(sender.GetCongestionWindow()).To(Equal(defaultWindowTCP + packetSize))
+		ackNextPacket()
+		Expect(sender.GetCongestionWindow()).To(Equal(defaultWindowTCP + 2*packetSize))
+	})
+
+	It("paces at a multiple of the bandwidth in startup", func() {
+		run(500 * time.Millisecond)
+		Expect(sender.mode).To(Equal(bbrStartup))
+		Expect(sender.PacingRate()).To(BeNumerically(">=", Bandwidth(bbrHighGain*float32(sender.BandwidthEstimate()))))
+	})
+
+	It("measures the bandwidth, and leaves startup and drain", func() {
+		run(3 * time.Second)
+		Expect(sender.isAtFullBandwidth).To(BeTrue())
+		Expect(sender.mode).To(Equal(bbrProbeBandwidth))
+		Expect(sender.BandwidthEstimate()).To(BeNumerically("~", Bandwidth(linkBandwidth)*BytesPerSecond, Bandwidth(linkBandwidth)*BytesPerSecond/20))
+		Expect(sender.minRTT).To(BeNumerically("~", linkRTT, 5*time.Millisecond))
+		// in probe bandwidth mode, the congestion window is twice the bandwidth-delay product
+		Expect(sender.GetCongestionWindow()).To(BeNumerically("~", 2*linkBDP, linkBDP/5))
+		Expect(rttStats.SmoothedRTT()).To(BeNumerically("<", 3*linkRTT))
+	})
+
+	It("cycles through the pacing gains in probe bandwidth mode", func() {
+		run(3 * time.Second)
+		Expect(sender.mode).To(Equal(bbrProbeBandwidth))
+		gains := make(map[float32]bool)
+		for i := 0; i < 1000; i++ {
+			sendPackets()
+			ackNextPacket()
+			gains[sender.pacingGain] = true
+		}
+		Expect(gains).To(HaveKey(float32(1.25)))
+		Expect(gains).To(HaveKey(float32(0.75)))
+		Expect(gains).To(HaveKey(float32(1)))
+	})
+
+	It("enters probe RTT when the minimum RTT expires, and returns to probe bandwidth", func() {
+		run(3 * time.Second)
+		Expect(sender.mode).To(Equal(bbrProbeBandwidth))
+		var enteredProbeRTT bool
+		end := clock.Now().Add(bbrMinRTTExpiry + time.Second)
+		for clock.Now().Before(end) {
+			sendPackets()
+			ackNextPacket()
+			if sender.mode == bbrProbeRTT {
+				enteredProbeRTT = true
+				Expect(sender.GetCongestionWindow()).To(Equal(bbrMinimumCongestionWindow))
+			}
+		}
+		Expect(enteredProbeRTT).To(BeTrue())
+		Expect(sender.mode).To(Equal(bbrProbeBandwidth))
+	})
+
+	It("enters and leaves recovery", func() {
+		run(3 * time.Second)
+		congestionWindow := sender.GetCongestionWindow()
+		loseLastPacket()
+		Expect(sender.InRecovery()).To(BeTrue())
+		Expect(sender.GetCongestionWindow()).To(BeNumerically("<", congestionWindow))
+		run(time.Second)
+		Expect(sender.InRecovery()).To(BeFalse())
+	})
+
+	It("starts from scratch when the connection is migrated", func() {
+		run(3 * time.Second)
+		sender.OnConnectionMigration()
+		Expect(sender.mode).To(Equal(bbrStartup))
+		Expect(sender.GetCongestionWindow()).To(Equal(defaultWindowTCP))
+		Expect(sender.BandwidthEstimate()).To(BeZero())
+		Expect(sender.isAtFullBandwidth).To(BeFalse())
+	})
+
+	It("doesn't exceed the maximum congestion window", func() {
+		sender = NewBBRSender(&clock, rttStats, initialCongestionWindowPackets, 20).(*bbrSender)
+		run(3 * time.Second)
+		Expect(sender.GetCongestionWindow()).To(Equal(20 * packetSize))
+	})
+})
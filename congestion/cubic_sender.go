@@ -257,6 +257,18 @@ func (c *cubicSender) BandwidthEstimate() Bandwidth {
 	return BandwidthFromDelta(c.GetCongestionWindow(), srtt)
 }
 
+// PacingRate returns the rate at which packets should be paced
+// It is slightly higher than the bandwidth estimate, so that the congestion window (and not the pacer) limits the sending rate.
+// It is zero if no RTT was measured yet.
+func (c *cubicSender) PacingRate() Bandwidth {
+	bandwidth := c.BandwidthEstimate()
+	if c.InSlowStart() {
+		// slow start doubles the congestion window every RTT
+		return 2 * bandwidth
+	}
+	return bandwidth * 5 / 4
+}
+
 // HybridSlowStart returns the hybrid slow start instance for testing
 func (c *cubicSender) HybridSlowStart() *HybridSlowStart {
 	return &c.hybridSlowStart
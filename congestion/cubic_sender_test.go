@@ -820,4 +820,15 @@ var _ = Describe("Cubic Sender", func() {
 		Expect(sender.SlowstartThreshold()).To(Equal(MaxCongestionWindow))
 		Expect(sender.HybridSlowStart().Started()).To(BeFalse())
 	})
+
+	It("paces faster than the bandwidth estimate", func() {
+		Expect(sender.PacingRate()).To(BeZero())
+		rttStats.UpdateRTT(100*time.Millisecond, 0, clock.Now())
+		// in slow start
+		Expect(sender.PacingRate()).To(Equal(2 * BandwidthFromDelta(defaultWindowTCP, 100*time.Millisecond)))
+		// in congestion avoidance
+		SendAvailableSendWindow()
+		LoseNPackets(1)
+		Expect(sender.PacingRate()).To(Equal(BandwidthFromDelta(sender.GetCongestionWindow(), 100*time.Millisecond) * 5 / 4))
+	})
 })
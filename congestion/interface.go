@@ -6,11 +6,22 @@ import (
 	"github.com/lucas-clemente/quic-go/protocol"
 )
 
+// An Algorithm is a congestion control algorithm
+type Algorithm int
+
+const (
+	// AlgorithmCubic is CUBIC, as implemented in Chromium
+	AlgorithmCubic Algorithm = iota
+	// AlgorithmBBR is BBR, as implemented in Chromium
+	AlgorithmBBR
+)
+
 // A SendAlgorithm performs congestion control and calculates the congestion window
 type SendAlgorithm interface {
 	TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration
 	OnPacketSent(sentTime time.Time, bytesInFlight protocol.ByteCount, packetNumber protocol.PacketNumber, bytes protocol.ByteCount, isRetransmittable bool) bool
 	GetCongestionWindow() protocol.ByteCount
+	PacingRate() Bandwidth
 	OnCongestionEvent(rttUpdated bool, bytesInFlight protocol.ByteCount, ackedPackets PacketVector, lostPackets PacketVector)
 	SetNumEmulatedConnections(n int)
 	OnRetransmissionTimeout(packetsRetransmitted bool)
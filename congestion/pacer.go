@@ -0,0 +1,58 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+const (
+	// pacerInitialBurstSize is the number of packets that are sent without pacing, when sending starts after the connection was quiescent
+	pacerInitialBurstSize = 10
+	// pacerGranularity is the precision of the pacer. Packets that are due within this time are sent right away.
+	pacerGranularity = time.Millisecond
+)
+
+// A Pacer spreads out packets according to a pacing rate, instead of sending them as a burst whenever the congestion window opens
+type Pacer struct {
+	burstTokens  int
+	nextSendTime time.Time
+}
+
+// NewPacer makes a new Pacer
+func NewPacer() *Pacer {
+	return &Pacer{burstTokens: pacerInitialBurstSize}
+}
+
+// OnPacketSent is called when a retransmittable packet is sent
+// bytesInFlight are the bytes in flight before the packet was sent.
+func (p *Pacer) OnPacketSent(sentTime time.Time, bytesInFlight, bytes protocol.ByteCount, pacingRate Bandwidth) {
+	// Allow a small burst after quiescence. The congestion window still applies to the burst.
+	if bytesInFlight == 0 {
+		p.burstTokens = pacerInitialBurstSize
+	}
+	if p.burstTokens > 0 {
+		p.burstTokens--
+		p.nextSendTime = time.Time{}
+		return
+	}
+	if pacingRate == 0 {
+		p.nextSendTime = time.Time{}
+		return
+	}
+	delay := time.Duration(uint64(bytes) * uint64(BytesPerSecond) * uint64(time.Second) / uint64(pacingRate))
+	// Don't accumulate credit while not sending.
+	if p.nextSendTime.Before(sentTime) {
+		p.nextSendTime = sentTime
+	}
+	p.nextSendTime = p.nextSendTime.Add(delay)
+}
+
+// TimeUntilSend returns the time when the next packet may be sent
+// If the next packet may be sent right away, it returns the zero time.
+func (p *Pacer) TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Time {
+	if bytesInFlight == 0 || p.burstTokens > 0 || p.nextSendTime.Before(now.Add(pacerGranularity)) {
+		return time.Time{}
+	}
+	return p.nextSendTime
+}
@@ -0,0 +1,91 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pacer", func() {
+	const packetSize = protocol.DefaultTCPMSS
+	// one packet every 10ms
+	pacingRate := BandwidthFromDelta(packetSize, 10*time.Millisecond)
+
+	var (
+		pacer         *Pacer
+		now           time.Time
+		bytesInFlight protocol.ByteCount
+	)
+
+	BeforeEach(func() {
+		pacer = NewPacer()
+		now = time.Now()
+		bytesInFlight = 0
+	})
+
+	sendPacket := func() {
+		pacer.OnPacketSent(now, bytesInFlight, packetSize, pacingRate)
+		bytesInFlight += packetSize
+	}
+
+	It("allows an initial burst", func() {
+		for i := 0; i < pacerInitialBurstSize; i++ {
+			Expect(pacer.TimeUntilSend(now, bytesInFlight).IsZero()).To(BeTrue())
+			sendPacket()
+		}
+		Expect(pacer.TimeUntilSend(now, bytesInFlight).IsZero()).To(BeTrue())
+		sendPacket()
+		Expect(pacer.TimeUntilSend(now, bytesInFlight)).To(Equal(now.Add(10 * time.Millisecond)))
+	})
+
+	It("paces packets after the burst", func() {
+		for i := 0; i < pacerInitialBurstSize; i++ {
+			sendPacket()
+		}
+		for i := 1; i <= 5; i++ {
+			sendPacket()
+			next := pacer.TimeUntilSend(now, bytesInFlight)
+			Expect(next).To(Equal(now.Add(10 * time.Millisecond)))
+			now = next
+		}
+	})
+
+	It("allows sending packets that are due within the granularity", func() {
+		for i := 0; i <= pacerInitialBurstSize; i++ {
+			sendPacket()
+		}
+		Expect(pacer.TimeUntilSend(now, bytesInFlight).IsZero()).To(BeFalse())
+		Expect(pacer.TimeUntilSend(now.Add(10*time.Millisecond-pacerGranularity/2), bytesInFlight).IsZero()).To(BeTrue())
+	})
+
+	It("doesn't accumulate credit while not sending", func() {
+		for i := 0; i <= pacerInitialBurstSize; i++ {
+			sendPacket()
+		}
+		now = now.Add(time.Second)
+		sendPacket()
+		Expect(pacer.TimeUntilSend(now, bytesInFlight)).To(Equal(now.Add(10 * time.Millisecond)))
+	})
+
+	It("allows a new burst after quiescence", func() {
+		for i := 0; i <= pacerInitialBurstSize; i++ {
+			sendPacket()
+		}
+		bytesInFlight = 0
+		Expect(pacer.TimeUntilSend(now, bytesInFlight).IsZero()).To(BeTrue())
+		for i := 0; i < pacerInitialBurstSize; i++ {
+			Expect(pacer.TimeUntilSend(now, bytesInFlight).IsZero()).To(BeTrue())
+			sendPacket()
+		}
+	})
+
+	It("doesn't pace if the pacing rate is unknown", func() {
+		for i := 0; i < 2*pacerInitialBurstSize; i++ {
+			pacer.OnPacketSent(now, bytesInFlight, packetSize, 0)
+			bytesInFlight += packetSize
+			Expect(pacer.TimeUntilSend(now, bytesInFlight).IsZero()).To(BeTrue())
+		}
+	})
+})
@@ -161,4 +161,52 @@ var _ = Describe("Cert compression", func() {
 		_, err = compressChain(chain, nil, []byte("foo"))
 		Expect(err).To(MatchError("expected a multiple of 8 bytes for CCS / CCRT hashes"))
 	})
+
+	Context("decompression", func() {
+		It("decompresses empty", func() {
+			chain, err := decompressChain([]byte{0})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(chain).To(BeEmpty())
+		})
+
+		It("decompresses a compressed chain", func() {
+			chain := [][]byte{
+				{0xde, 0xca, 0xfb, 0xad},
+				{0xde, 0xad, 0xbe, 0xef},
+			}
+			compressed, err := compressChain(chain, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			decompressed, err := decompressChain(compressed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decompressed).To(Equal(chain))
+		})
+
+		It("rejects cached certificates", func() {
+			data := []byte{0x02}
+			data = append(data, byteHash([]byte{0xde, 0xca, 0xfb, 0xad})...)
+			data = append(data, 0x00)
+			_, err := decompressChain(data)
+			Expect(err).To(MatchError("unimplemented: cached certificates"))
+		})
+
+		It("rejects unknown common certificate sets", func() {
+			setHash := make([]byte, 8)
+			binary.LittleEndian.PutUint64(setHash, 0xdeadbeef)
+			data := []byte{0x03}
+			data = append(data, setHash...)
+			data = append(data, []byte{42, 0, 0, 0, 0x00}...)
+			_, err := decompressChain(data)
+			Expect(err).To(MatchError("unknown certSet"))
+		})
+
+		It("errors on unknown entry types", func() {
+			_, err := decompressChain([]byte{0x05})
+			Expect(err).To(MatchError("unknown entryType"))
+		})
+
+		It("errors on incomplete data", func() {
+			_, err := decompressChain([]byte{0x01})
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
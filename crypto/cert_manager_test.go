@@ -0,0 +1,150 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cert Manager", func() {
+	var cm *certManager
+	var key *ecdsa.PrivateKey
+	var cert []byte
+
+	BeforeEach(func() {
+		cm = NewCertManager(nil).(*certManager)
+
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "quic.clemente.io"},
+			DNSNames:              []string{"quic.clemente.io"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		}
+		cert, err = x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	setChain := func(chain [][]byte) {
+		compressed, err := compressChain(chain, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		err = cm.SetData(compressed)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("errors when given invalid data", func() {
+		err := cm.SetData([]byte("foobar"))
+		Expect(err).To(MatchError(qerr.Error(qerr.InvalidCryptoMessageParameter, "Certificate data invalid")))
+	})
+
+	It("decompresses a certificate chain", func() {
+		setChain([][]byte{cert})
+		Expect(cm.chain).To(HaveLen(1))
+		Expect(cm.chain[0].Raw).To(Equal(cert))
+	})
+
+	Context("getting the leaf cert", func() {
+		It("gets it", func() {
+			setChain([][]byte{cert})
+			Expect(cm.GetLeafCert()).To(Equal(cert))
+		})
+
+		It("returns nil if the chain hasn't been set yet", func() {
+			Expect(cm.GetLeafCert()).To(BeNil())
+		})
+	})
+
+	Context("verifying the certificate chain", func() {
+		It("errors if the chain hasn't been set yet", func() {
+			err := cm.Verify("quic.clemente.io")
+			Expect(err).To(MatchError(errNoCertificateChain))
+		})
+
+		It("accepts a certificate signed by a trusted root", func() {
+			leaf, err := x509.ParseCertificate(cert)
+			Expect(err).ToNot(HaveOccurred())
+			pool := x509.NewCertPool()
+			pool.AddCert(leaf)
+			cm.config = &tls.Config{RootCAs: pool}
+			setChain([][]byte{cert})
+			err = cm.Verify("quic.clemente.io")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rejects a certificate for a different hostname", func() {
+			leaf, err := x509.ParseCertificate(cert)
+			Expect(err).ToNot(HaveOccurred())
+			pool := x509.NewCertPool()
+			pool.AddCert(leaf)
+			cm.config = &tls.Config{RootCAs: pool}
+			setChain([][]byte{cert})
+			err = cm.Verify("google.com")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects a certificate signed by an unknown authority", func() {
+			cm.config = &tls.Config{RootCAs: x509.NewCertPool()}
+			setChain([][]byte{cert})
+			err := cm.Verify("quic.clemente.io")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("doesn't verify the chain if InsecureSkipVerify is set", func() {
+			cm.config = &tls.Config{InsecureSkipVerify: true}
+			setChain([][]byte{cert})
+			err := cm.Verify("google.com")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("verifying the server config signature", func() {
+		It("returns false if the chain hasn't been set yet", func() {
+			Expect(cm.VerifyServerProof([]byte("proof"), []byte("chlo"), []byte("scfg"))).To(BeFalse())
+		})
+
+		It("verifies an RSA signature", func() {
+			tlsConfig := testdata.GetTLSConfig()
+			ps, err := NewProofSource(tlsConfig)
+			Expect(err).ToNot(HaveOccurred())
+			proof, err := ps.SignServerProof("", []byte("chlo"), []byte("scfg"))
+			Expect(err).ToNot(HaveOccurred())
+			setChain(tlsConfig.Certificates[0].Certificate)
+			Expect(cm.VerifyServerProof(proof, []byte("chlo"), []byte("scfg"))).To(BeTrue())
+			Expect(cm.VerifyServerProof(proof, []byte("chlo"), []byte("foobar"))).To(BeFalse())
+		})
+
+		It("verifies an ECDSA signature", func() {
+			ps, err := NewProofSource(&tls.Config{
+				Certificates: []tls.Certificate{{Certificate: [][]byte{cert}, PrivateKey: key}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			proof, err := ps.SignServerProof("", []byte("chlo"), []byte("scfg"))
+			Expect(err).ToNot(HaveOccurred())
+			setChain([][]byte{cert})
+			Expect(cm.VerifyServerProof(proof, []byte("chlo"), []byte("scfg"))).To(BeTrue())
+			Expect(cm.VerifyServerProof(proof, []byte("foobar"), []byte("scfg"))).To(BeFalse())
+		})
+
+		It("rejects invalid ECDSA signatures", func() {
+			setChain([][]byte{cert})
+			Expect(cm.VerifyServerProof([]byte("invalid"), []byte("chlo"), []byte("scfg"))).To(BeFalse())
+		})
+	})
+})
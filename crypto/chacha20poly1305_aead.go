@@ -1,12 +1,13 @@
-// +build ignore
-
 package crypto
 
 import (
 	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
 	"errors"
 
-	"github.com/aead/chacha20"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/poly1305"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 )
@@ -18,29 +19,16 @@ type aeadChacha20Poly1305 struct {
 	decrypter cipher.AEAD
 }
 
-// NewAEADChacha20Poly1305 creates a AEAD using chacha20poly1305
+// NewAEADChacha20Poly1305 creates a AEAD using chacha20poly1305 with 12 bytes tag size
 func NewAEADChacha20Poly1305(otherKey []byte, myKey []byte, otherIV []byte, myIV []byte) (AEAD, error) {
 	if len(myKey) != 32 || len(otherKey) != 32 || len(myIV) != 4 || len(otherIV) != 4 {
 		return nil, errors.New("chacha20poly1305: expected 32-byte keys and 4-byte IVs")
 	}
-	// copy because ChaCha20Poly1305 expects array pointers
-	var MyKey, OtherKey [32]byte
-	copy(MyKey[:], myKey)
-	copy(OtherKey[:], otherKey)
-
-	encrypter, err := chacha20.NewChaCha20Poly1305WithTagSize(&MyKey, 12)
-	if err != nil {
-		return nil, err
-	}
-	decrypter, err := chacha20.NewChaCha20Poly1305WithTagSize(&OtherKey, 12)
-	if err != nil {
-		return nil, err
-	}
 	return &aeadChacha20Poly1305{
 		otherIV:   otherIV,
 		myIV:      myIV,
-		encrypter: encrypter,
-		decrypter: decrypter,
+		encrypter: newChacha20Poly1305(myKey),
+		decrypter: newChacha20Poly1305(otherKey),
 	}, nil
 }
 
@@ -51,3 +39,106 @@ func (aead *aeadChacha20Poly1305) Open(dst, src []byte, packetNumber protocol.Pa
 func (aead *aeadChacha20Poly1305) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
 	return aead.encrypter.Seal(dst, makeNonce(aead.myIV, packetNumber), src, associatedData)
 }
+
+const chacha20Poly1305TagSize = 12
+
+var errChacha20Poly1305Open = errors.New("chacha20poly1305: message authentication failed")
+
+// chacha20Poly1305 implements the ChaCha20-Poly1305 construction of RFC 7539 with the tag truncated to 12 bytes
+//
+// Similar to AES-GCM, the go stdlib (and golang.org/x/crypto/chacha20poly1305) only supports 16 byte tags.
+type chacha20Poly1305 struct {
+	key [32]byte
+}
+
+var _ cipher.AEAD = &chacha20Poly1305{}
+
+func newChacha20Poly1305(key []byte) cipher.AEAD {
+	c := &chacha20Poly1305{}
+	copy(c.key[:], key)
+	return c
+}
+
+func (c *chacha20Poly1305) NonceSize() int {
+	return chacha20.NonceSize
+}
+
+func (c *chacha20Poly1305) Overhead() int {
+	return chacha20Poly1305TagSize
+}
+
+func (c *chacha20Poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	s, polyKey := c.newCipher(nonce)
+	ret, out := sliceForAppend(dst, len(plaintext)+chacha20Poly1305TagSize)
+	ciphertext := out[:len(plaintext)]
+	s.XORKeyStream(ciphertext, plaintext)
+	tag := calculateChacha20Poly1305Tag(&polyKey, additionalData, ciphertext)
+	copy(out[len(plaintext):], tag[:chacha20Poly1305TagSize])
+	return ret
+}
+
+func (c *chacha20Poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < chacha20Poly1305TagSize {
+		return nil, errChacha20Poly1305Open
+	}
+	tag := ciphertext[len(ciphertext)-chacha20Poly1305TagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-chacha20Poly1305TagSize]
+
+	s, polyKey := c.newCipher(nonce)
+	expectedTag := calculateChacha20Poly1305Tag(&polyKey, additionalData, ciphertext)
+	if subtle.ConstantTimeCompare(expectedTag[:chacha20Poly1305TagSize], tag) != 1 {
+		return nil, errChacha20Poly1305Open
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	s.XORKeyStream(out, ciphertext)
+	return ret, nil
+}
+
+// newCipher creates the ChaCha20 cipher for a nonce, and returns it together with the one-time Poly1305 key
+// The Poly1305 key is the first 32 bytes of the key stream, the payload is encrypted starting from block 1.
+func (c *chacha20Poly1305) newCipher(nonce []byte) (*chacha20.Cipher, [32]byte) {
+	var polyKey [32]byte
+	s, err := chacha20.NewUnauthenticatedCipher(c.key[:], nonce)
+	if err != nil {
+		panic("chacha20poly1305: " + err.Error())
+	}
+	s.XORKeyStream(polyKey[:], polyKey[:])
+	s.SetCounter(1)
+	return s, polyKey
+}
+
+func calculateChacha20Poly1305Tag(polyKey *[32]byte, additionalData, ciphertext []byte) [poly1305.TagSize]byte {
+	var padding [16]byte
+	mac := poly1305.New(polyKey)
+	mac.Write(additionalData)
+	if rem := len(additionalData) % 16; rem != 0 {
+		mac.Write(padding[:16-rem])
+	}
+	mac.Write(ciphertext)
+	if rem := len(ciphertext) % 16; rem != 0 {
+		mac.Write(padding[:16-rem])
+	}
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	mac.Write(lengths[:])
+
+	var tag [poly1305.TagSize]byte
+	mac.Sum(tag[:0])
+	return tag
+}
+
+// sliceForAppend extends dst by n bytes
+// It returns the extended slice, and the n new bytes at its end.
+func sliceForAppend(dst []byte, n int) ([]byte, []byte) {
+	total := len(dst) + n
+	var ret []byte
+	if cap(dst) >= total {
+		ret = dst[:total]
+	} else {
+		ret = make([]byte, total)
+		copy(ret, dst)
+	}
+	return ret, ret[len(dst):]
+}
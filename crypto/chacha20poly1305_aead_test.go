@@ -1,10 +1,10 @@
-// +build ignore
-
 package crypto
 
 import (
 	"crypto/rand"
 
+	"golang.org/x/crypto/chacha20poly1305"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -56,6 +56,45 @@ var _ = Describe("Chacha20poly1305", func() {
 		Expect(err).To(HaveOccurred())
 	})
 
+	It("fails with a modified ciphertext", func() {
+		b := alice.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+		b[0] ^= 0xff
+		_, err := bob.Open(nil, b, 42, []byte("aad"))
+		Expect(err).To(MatchError(errChacha20Poly1305Open))
+	})
+
+	It("fails with a wrong packet number", func() {
+		b := alice.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+		_, err := bob.Open(nil, b, 43, []byte("aad"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails with a too short ciphertext", func() {
+		_, err := bob.Open(nil, make([]byte, 11), 42, []byte("aad"))
+		Expect(err).To(MatchError(errChacha20Poly1305Open))
+	})
+
+	It("produces the RFC 7539 output with a truncated tag", func() {
+		ref, err := chacha20poly1305.New(keyAlice)
+		Expect(err).ToNot(HaveOccurred())
+		plaintext := make([]byte, 1000)
+		rand.Reader.Read(plaintext)
+		aad := []byte("associated data")
+		nonce := makeNonce(ivAlice, 42)
+		expected := ref.Seal(nil, nonce, plaintext, aad)
+		b := alice.Seal(nil, plaintext, 42, aad)
+		Expect(b).To(Equal(expected[:len(expected)-4]))
+	})
+
+	It("seals and opens in place", func() {
+		buf := make([]byte, 6, 6+12)
+		copy(buf, "foobar")
+		b := alice.Seal(buf[:0], buf, 42, []byte("aad"))
+		text, err := bob.Open(b[:0], b, 42, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(text).To(Equal([]byte("foobar")))
+	})
+
 	It("rejects wrong key and iv sizes", func() {
 		var err error
 		e := "chacha20poly1305: expected 32-byte keys and 4-byte IVs"
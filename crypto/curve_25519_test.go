@@ -18,6 +18,20 @@ var _ = Describe("ProofRsa", func() {
 		Expect(sA).To(Equal(sB))
 	})
 
+	It("recreates a KEX from its private key", func() {
+		a, err := NewCurve25519KEX()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a.PrivateKey()).To(HaveLen(32))
+		b, err := NewCurve25519KEXFromPrivateKey(a.PrivateKey())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.PublicKey()).To(Equal(a.PublicKey()))
+	})
+
+	It("rejects private keys with an invalid length", func() {
+		_, err := NewCurve25519KEXFromPrivateKey([]byte("foobar"))
+		Expect(err).To(MatchError("Curve25519: expected private key of 32 byte"))
+	})
+
 	It("rejects short public keys", func() {
 		a, err := NewCurve25519KEX()
 		Expect(err).ToNot(HaveOccurred())
@@ -3,6 +3,7 @@ package crypto
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"io"
 
 	"github.com/lucas-clemente/quic-go/protocol"
@@ -12,24 +13,27 @@ import (
 )
 
 // DeriveKeysChacha20 derives the client and server keys and creates a matching chacha20poly1305 AEAD instance
-// func DeriveKeysChacha20(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte) (AEAD, error) {
-// 	otherKey, myKey, otherIV, myIV, err := deriveKeys(version, forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, 32)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	return NewAEADChacha20Poly1305(otherKey, myKey, otherIV, myIV)
-// }
+func DeriveKeysChacha20(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (AEAD, error) {
+	otherKey, myKey, otherIV, myIV, err := deriveKeys(forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, 32, pers)
+	if err != nil {
+		return nil, err
+	}
+	return NewAEADChacha20Poly1305(otherKey, myKey, otherIV, myIV)
+}
 
 // DeriveKeysAESGCM derives the client and server keys and creates a matching AES-GCM AEAD instance
-func DeriveKeysAESGCM(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte) (AEAD, error) {
-	otherKey, myKey, otherIV, myIV, err := deriveKeys(forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, 16)
+func DeriveKeysAESGCM(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (AEAD, error) {
+	otherKey, myKey, otherIV, myIV, err := deriveKeys(forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, 16, pers)
 	if err != nil {
 		return nil, err
 	}
 	return NewAEADAESGCM(otherKey, myKey, otherIV, myIV)
 }
 
-func deriveKeys(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo, scfg, cert, divNonce []byte, keyLen int) ([]byte, []byte, []byte, []byte, error) {
+// deriveKeys derives the keys and the IVs
+// the HKDF output contains the client key, the server key, the client IV and the server IV, in this order
+// the diversification nonce is only applied to the server's key and IV
+func deriveKeys(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo, scfg, cert, divNonce []byte, keyLen int, pers protocol.Perspective) ([]byte, []byte, []byte, []byte, error) {
 	var info bytes.Buffer
 	if forwardSecure {
 		info.Write([]byte("QUIC forward secure key expansion\x00"))
@@ -47,18 +51,36 @@ func deriveKeys(forwardSecure bool, sharedSecret, nonces []byte, connID protocol
 	if _, err := io.ReadFull(r, s); err != nil {
 		return nil, nil, nil, nil, err
 	}
-	otherKey := s[:keyLen]
-	myKey := s[keyLen : 2*keyLen]
-	otherIV := s[2*keyLen : 2*keyLen+4]
-	myIV := s[2*keyLen+4:]
+	clientKey := s[:keyLen]
+	serverKey := s[keyLen : 2*keyLen]
+	clientIV := s[2*keyLen : 2*keyLen+4]
+	serverIV := s[2*keyLen+4:]
 
 	if !forwardSecure {
-		if err := diversify(myKey, myIV, divNonce); err != nil {
+		if err := diversify(serverKey, serverIV, divNonce); err != nil {
 			return nil, nil, nil, nil, err
 		}
 	}
 
-	return otherKey, myKey, otherIV, myIV, nil
+	if pers == protocol.PerspectiveClient {
+		return serverKey, clientKey, serverIV, clientIV, nil
+	}
+	return clientKey, serverKey, clientIV, serverIV, nil
+}
+
+// DerivePublicResetNonceProof derives the nonce proof sent in public resets from the client nonce
+// Since an off-path attacker doesn't know the client nonce, the client can use the proof to check that a public reset was sent by the server.
+func DerivePublicResetNonceProof(clientNonce []byte, connID protocol.ConnectionID) uint64 {
+	var info bytes.Buffer
+	info.Write([]byte("QUIC public reset nonce proof\x00"))
+	utils.WriteUint64(&info, uint64(connID))
+
+	r := hkdf.New(sha256.New, clientNonce, nil, info.Bytes())
+
+	proof := make([]byte, 8)
+	// reading 8 bytes from the HKDF never fails
+	_, _ = io.ReadFull(r, proof)
+	return binary.LittleEndian.Uint64(proof)
 }
 
 func diversify(key, iv, divNonce []byte) error {
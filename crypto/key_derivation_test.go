@@ -8,83 +8,69 @@ import (
 )
 
 var _ = Describe("KeyDerivation", func() {
-	// Context("chacha20poly1305", func() {
-	// 	It("derives non-fs keys", func() {
-	// 		aead, err := DeriveKeysChacha20(
-	// 			protocol.Version32,
-	// 			false,
-	// 			[]byte("0123456789012345678901"),
-	// 			[]byte("nonce"),
-	// 			protocol.ConnectionID(42),
-	// 			[]byte("chlo"),
-	// 			[]byte("scfg"),
-	// 			[]byte("cert"),
-	// 			nil,
-	// 		)
-	// 		Expect(err).ToNot(HaveOccurred())
-	// 		chacha := aead.(*aeadChacha20Poly1305)
-	// 		// If the IVs match, the keys will match too, since the keys are read earlier
-	// 		Expect(chacha.myIV).To(Equal([]byte{0xf0, 0xf5, 0x4c, 0xa8}))
-	// 		Expect(chacha.otherIV).To(Equal([]byte{0x75, 0xd8, 0xa2, 0x8d}))
-	// 	})
-	//
-	// 	It("derives fs keys", func() {
-	// 		aead, err := DeriveKeysChacha20(
-	// 			protocol.Version32,
-	// 			true,
-	// 			[]byte("0123456789012345678901"),
-	// 			[]byte("nonce"),
-	// 			protocol.ConnectionID(42),
-	// 			[]byte("chlo"),
-	// 			[]byte("scfg"),
-	// 			[]byte("cert"),
-	// 			nil,
-	// 		)
-	// 		Expect(err).ToNot(HaveOccurred())
-	// 		chacha := aead.(*aeadChacha20Poly1305)
-	// 		// If the IVs match, the keys will match too, since the keys are read earlier
-	// 		Expect(chacha.myIV).To(Equal([]byte{0xf5, 0x73, 0x11, 0x79}))
-	// 		Expect(chacha.otherIV).To(Equal([]byte{0xf7, 0x26, 0x4d, 0x2c}))
-	// 	})
-	//
-	// 	It("does not use diversification nonces in FS key derivation", func() {
-	// 		aead, err := DeriveKeysChacha20(
-	// 			protocol.Version33,
-	// 			true,
-	// 			[]byte("0123456789012345678901"),
-	// 			[]byte("nonce"),
-	// 			protocol.ConnectionID(42),
-	// 			[]byte("chlo"),
-	// 			[]byte("scfg"),
-	// 			[]byte("cert"),
-	// 			[]byte("divnonce"),
-	// 		)
-	// 		Expect(err).ToNot(HaveOccurred())
-	// 		chacha := aead.(*aeadChacha20Poly1305)
-	// 		// If the IVs match, the keys will match too, since the keys are read earlier
-	// 		Expect(chacha.myIV).To(Equal([]byte{0xf5, 0x73, 0x11, 0x79}))
-	// 		Expect(chacha.otherIV).To(Equal([]byte{0xf7, 0x26, 0x4d, 0x2c}))
-	// 	})
-	//
-	// 	It("uses diversification nonces in initial key derivation", func() {
-	// 		aead, err := DeriveKeysChacha20(
-	// 			protocol.Version33,
-	// 			false,
-	// 			[]byte("0123456789012345678901"),
-	// 			[]byte("nonce"),
-	// 			protocol.ConnectionID(42),
-	// 			[]byte("chlo"),
-	// 			[]byte("scfg"),
-	// 			[]byte("cert"),
-	// 			[]byte("divnonce"),
-	// 		)
-	// 		Expect(err).ToNot(HaveOccurred())
-	// 		chacha := aead.(*aeadChacha20Poly1305)
-	// 		// If the IVs match, the keys will match too, since the keys are read earlier
-	// 		Expect(chacha.myIV).To(Equal([]byte{0xc4, 0x12, 0x25, 0x64}))
-	// 		Expect(chacha.otherIV).To(Equal([]byte{0x75, 0xd8, 0xa2, 0x8d}))
-	// 	})
-	// })
+	Context("chacha20poly1305", func() {
+		It("derives non-fs keys", func() {
+			aead, err := DeriveKeysChacha20(
+				false,
+				[]byte("0123456789012345678901"),
+				[]byte("nonce"),
+				protocol.ConnectionID(42),
+				[]byte("chlo"),
+				[]byte("scfg"),
+				[]byte("cert"),
+				[]byte("divnonce"),
+				protocol.PerspectiveServer,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			chacha := aead.(*aeadChacha20Poly1305)
+			// If the IVs match, the keys will match too, since the keys are read earlier
+			Expect(chacha.myIV).To(Equal([]byte{0xc4, 0x12, 0x25, 0x64}))
+			Expect(chacha.otherIV).To(Equal([]byte{0x75, 0xd8, 0xa2, 0x8d}))
+		})
+
+		It("derives fs keys", func() {
+			aead, err := DeriveKeysChacha20(
+				true,
+				[]byte("0123456789012345678901"),
+				[]byte("nonce"),
+				protocol.ConnectionID(42),
+				[]byte("chlo"),
+				[]byte("scfg"),
+				[]byte("cert"),
+				nil,
+				protocol.PerspectiveServer,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			chacha := aead.(*aeadChacha20Poly1305)
+			// If the IVs match, the keys will match too, since the keys are read earlier
+			Expect(chacha.myIV).To(Equal([]byte{0xf5, 0x73, 0x11, 0x79}))
+			Expect(chacha.otherIV).To(Equal([]byte{0xf7, 0x26, 0x4d, 0x2c}))
+		})
+
+		It("derives matching keys for the client and the server", func() {
+			derive := func(pers protocol.Perspective) AEAD {
+				aead, err := DeriveKeysChacha20(
+					false,
+					[]byte("0123456789012345678901"),
+					[]byte("nonce"),
+					protocol.ConnectionID(42),
+					[]byte("chlo"),
+					[]byte("scfg"),
+					[]byte("cert"),
+					[]byte("divnonce"),
+					pers,
+				)
+				Expect(err).ToNot(HaveOccurred())
+				return aead
+			}
+			server := derive(protocol.PerspectiveServer)
+			client := derive(protocol.PerspectiveClient)
+			b := server.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+			text, err := client.Open(nil, b, 42, []byte("aad"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(text).To(Equal([]byte("foobar")))
+		})
+	})
 
 	Context("AES-GCM", func() {
 		It("derives non-fs keys", func() {
@@ -97,6 +83,7 @@ var _ = Describe("KeyDerivation", func() {
 				[]byte("scfg"),
 				[]byte("cert"),
 				[]byte("divnonce"),
+				protocol.PerspectiveServer,
 			)
 			Expect(err).ToNot(HaveOccurred())
 			chacha := aead.(*aeadAESGCM)
@@ -115,6 +102,7 @@ var _ = Describe("KeyDerivation", func() {
 				[]byte("scfg"),
 				[]byte("cert"),
 				nil,
+				protocol.PerspectiveServer,
 			)
 			Expect(err).ToNot(HaveOccurred())
 			chacha := aead.(*aeadAESGCM)
@@ -133,6 +121,7 @@ var _ = Describe("KeyDerivation", func() {
 				[]byte("scfg"),
 				[]byte("cert"),
 				[]byte("divnonce"),
+				protocol.PerspectiveServer,
 			)
 			Expect(err).ToNot(HaveOccurred())
 			chacha := aead.(*aeadAESGCM)
@@ -140,5 +129,39 @@ var _ = Describe("KeyDerivation", func() {
 			Expect(chacha.myIV).To(Equal([]byte{0x7, 0xad, 0xab, 0xb8}))
 			Expect(chacha.otherIV).To(Equal([]byte{0xf2, 0x7a, 0xcc, 0x42}))
 		})
+
+		It("swaps the keys for the client", func() {
+			aead, err := DeriveKeysAESGCM(
+				false,
+				[]byte("0123456789012345678901"),
+				[]byte("nonce"),
+				protocol.ConnectionID(42),
+				[]byte("chlo"),
+				[]byte("scfg"),
+				[]byte("cert"),
+				[]byte("divnonce"),
+				protocol.PerspectiveClient,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			chacha := aead.(*aeadAESGCM)
+			Expect(chacha.otherIV).To(Equal([]byte{0x1c, 0xec, 0xac, 0x9b}))
+			Expect(chacha.myIV).To(Equal([]byte{0x64, 0xef, 0x3c, 0x9}))
+		})
+	})
+
+	Context("public reset nonce proof", func() {
+		It("derives the same proof for the same nonce and connection ID", func() {
+			proof := DerivePublicResetNonceProof([]byte("client nonce"), 42)
+			Expect(proof).ToNot(BeZero())
+			Expect(DerivePublicResetNonceProof([]byte("client nonce"), 42)).To(Equal(proof))
+		})
+
+		It("derives different proofs for different nonces", func() {
+			Expect(DerivePublicResetNonceProof([]byte("client nonce"), 42)).ToNot(Equal(DerivePublicResetNonceProof([]byte("other nonce"), 42)))
+		})
+
+		It("derives different proofs for different connection IDs", func() {
+			Expect(DerivePublicResetNonceProof([]byte("client nonce"), 42)).ToNot(Equal(DerivePublicResetNonceProof([]byte("client nonce"), 43)))
+		})
 	})
 })
@@ -3,5 +3,7 @@ package crypto
 // KeyExchange manages the exchange of keys
 type KeyExchange interface {
 	PublicKey() []byte
+	// PrivateKey returns the private key, e.g. to share it between servers
+	PrivateKey() []byte
 	CalculateSharedKey(otherPublic []byte) ([]byte, error)
 }
@@ -0,0 +1,50 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+)
+
+type p256KEX struct {
+	secret *ecdh.PrivateKey
+}
+
+var _ KeyExchange = &p256KEX{}
+
+// NewP256KEX creates a new KeyExchange using ECDH on the NIST P-256 curve
+// Public keys are encoded as uncompressed points.
+func NewP256KEX() (KeyExchange, error) {
+	secret, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.New("P256: could not create private key")
+	}
+	return &p256KEX{secret: secret}, nil
+}
+
+// NewP256KEXFromPrivateKey creates a KeyExchange using ECDH on the NIST P-256 curve with a given private key
+func NewP256KEXFromPrivateKey(secret []byte) (KeyExchange, error) {
+	// NewPrivateKey checks the length, and that the key is in the range of the curve order
+	priv, err := ecdh.P256().NewPrivateKey(secret)
+	if err != nil {
+		return nil, errors.New("P256: invalid private key")
+	}
+	return &p256KEX{secret: priv}, nil
+}
+
+func (p *p256KEX) PublicKey() []byte {
+	return p.secret.PublicKey().Bytes()
+}
+
+func (p *p256KEX) PrivateKey() []byte {
+	return p.secret.Bytes()
+}
+
+func (p *p256KEX) CalculateSharedKey(otherPublic []byte) ([]byte, error) {
+	// NewPublicKey checks that the point is on the curve
+	pub, err := ecdh.P256().NewPublicKey(otherPublic)
+	if err != nil {
+		return nil, errors.New("P256: invalid public key")
+	}
+	return p.secret.ECDH(pub)
+}
@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("P-256", func() {
+	It("works", func() {
+		a, err := NewP256KEX()
+		Expect(err).ToNot(HaveOccurred())
+		b, err := NewP256KEX()
+		Expect(err).ToNot(HaveOccurred())
+		sA, err := a.CalculateSharedKey(b.PublicKey())
+		Expect(err).ToNot(HaveOccurred())
+		sB, err := b.CalculateSharedKey(a.PublicKey())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sA).To(Equal(sB))
+		Expect(sA).To(HaveLen(32))
+	})
+
+	It("recreates a KEX from its private key", func() {
+		a, err := NewP256KEX()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a.PrivateKey()).To(HaveLen(32))
+		b, err := NewP256KEXFromPrivateKey(a.PrivateKey())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.PublicKey()).To(Equal(a.PublicKey()))
+	})
+
+	It("rejects private keys with an invalid length", func() {
+		_, err := NewP256KEXFromPrivateKey([]byte("foobar"))
+		Expect(err).To(MatchError("P256: invalid private key"))
+	})
+
+	It("rejects private keys that are not in the range of the curve order", func() {
+		_, err := NewP256KEXFromPrivateKey(make([]byte, 32))
+		Expect(err).To(MatchError("P256: invalid private key"))
+	})
+
+	It("uses uncompressed public keys", func() {
+		a, err := NewP256KEX()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a.PublicKey()).To(HaveLen(65))
+		Expect(a.PublicKey()[0]).To(Equal(byte(0x04)))
+	})
+
+	It("rejects short public keys", func() {
+		a, err := NewP256KEX()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = a.CalculateSharedKey(nil)
+		Expect(err).To(MatchError("P256: invalid public key"))
+	})
+
+	It("rejects public keys that are not on the curve", func() {
+		a, err := NewP256KEX()
+		Expect(err).ToNot(HaveOccurred())
+		pub := make([]byte, 65)
+		copy(pub, a.PublicKey())
+		pub[64] ^= 0x1
+		_, err = a.CalculateSharedKey(pub)
+		Expect(err).To(MatchError("P256: invalid public key"))
+	})
+})
@@ -27,13 +27,6 @@ func (ps *proofSource) SignServerProof(sni string, chlo []byte, serverConfigData
 		return nil, err
 	}
 
-	hash := sha256.New()
-	hash.Write([]byte("QUIC CHLO and server config signature\x00"))
-	chloHash := sha256.Sum256(chlo)
-	hash.Write([]byte{32, 0, 0, 0})
-	hash.Write(chloHash[:])
-	hash.Write(serverConfigData)
-
 	key, ok := cert.PrivateKey.(crypto.Signer)
 	if !ok {
 		return nil, errors.New("expected PrivateKey to implement crypto.Signer")
@@ -45,7 +38,18 @@ func (ps *proofSource) SignServerProof(sni string, chlo []byte, serverConfigData
 		opts = &rsa.PSSOptions{SaltLength: 32, Hash: crypto.SHA256}
 	}
 
-	return key.Sign(rand.Reader, hash.Sum(nil), opts)
+	return key.Sign(rand.Reader, getServerProofHash(chlo, serverConfigData), opts)
+}
+
+// getServerProofHash calculates the hash that is signed in the server proof
+func getServerProofHash(chlo []byte, serverConfigData []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte("QUIC CHLO and server config signature\x00"))
+	chloHash := sha256.Sum256(chlo)
+	hash.Write([]byte{32, 0, 0, 0})
+	hash.Write(chloHash[:])
+	hash.Write(serverConfigData)
+	return hash.Sum(nil)
 }
 
 // GetCertsCompressed gets the certificate in the format described by the QUIC crypto doc
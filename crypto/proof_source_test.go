@@ -11,7 +11,6 @@ import (
 	"crypto/rsa"
 	"crypto/tls"
 	"encoding/asn1"
-	"math/big"
 
 	"github.com/lucas-clemente/quic-go/testdata"
 
@@ -19,10 +18,6 @@ import (
 	. "github.com/onsi/gomega"
 )
 
-type ecdsaSignature struct {
-	R, S *big.Int
-}
-
 var _ = Describe("ProofRsa", func() {
 	It("compresses certs", func() {
 		cert := []byte{0xde, 0xca, 0xfb, 0xad}
@@ -17,7 +17,7 @@ var _ = Describe("Source Address Tokens", func() {
 	Context("tokens", func() {
 		It("serializes", func() {
 			ip := []byte{127, 0, 0, 1}
-			token := &sourceAddressToken{ip: ip, timestamp: 0xdeadbeef}
+			token := &sourceAddressToken{sourceAddr: ip, timestamp: 0xdeadbeef}
 			Expect(token.serialize()).To(Equal([]byte{
 				0xef, 0xbe, 0xad, 0xde, 0x00, 0x00, 0x00, 0x00,
 				127, 0, 0, 1,
@@ -30,7 +30,7 @@ var _ = Describe("Source Address Tokens", func() {
 				127, 0, 0, 1,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			Expect(token.ip).To(Equal(net.IP{127, 0, 0, 1}))
+			Expect(token.sourceAddr).To(Equal([]byte{127, 0, 0, 1}))
 			Expect(token.timestamp).To(Equal(uint64(0xdeadbeef)))
 		})
 
@@ -38,6 +38,12 @@ var _ = Describe("Source Address Tokens", func() {
 			_, err := parseToken(nil)
 			Expect(err).To(MatchError("invalid STK length: 0"))
 		})
+
+		It("reads tokens with source addresses of arbitrary length", func() {
+			token, err := parseToken(append([]byte{0xef, 0xbe, 0xad, 0xde, 0x00, 0x00, 0x00, 0x00}, []byte("pipe-1")...))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token.sourceAddr).To(Equal([]byte("pipe-1")))
+		})
 	})
 
 	Context("source", func() {
@@ -96,23 +102,32 @@ var _ = Describe("Source Address Tokens", func() {
 
 		It("should reject outdated tokens", func() {
 			stk, err := encryptToken(source.aead, &sourceAddressToken{
-				ip:        ip4,
-				timestamp: uint64(time.Now().Unix() - protocol.STKExpiryTimeSec - 1),
+				sourceAddr: ip4,
+				timestamp:  uint64(time.Now().Unix() - protocol.STKExpiryTimeSec - 1),
 			})
 			Expect(err).NotTo(HaveOccurred())
 			err = source.VerifyToken(ip4, stk)
 			Expect(err).To(MatchError("STK expired"))
 		})
 
+		It("should generate and verify tokens for non-IP source addresses", func() {
+			stk, err := source.NewToken([]byte("pipe-1"))
+			Expect(err).NotTo(HaveOccurred())
+			err = source.VerifyToken([]byte("pipe-1"), stk)
+			Expect(err).NotTo(HaveOccurred())
+			err = source.VerifyToken([]byte("pipe-2"), stk)
+			Expect(err).To(MatchError("invalid source address in STK"))
+		})
+
 		It("should reject tokens with wrong IP addresses", func() {
 			otherIP := net.ParseIP("4.3.2.1")
 			stk, err := encryptToken(source.aead, &sourceAddressToken{
-				ip:        otherIP,
-				timestamp: uint64(time.Now().Unix()),
+				sourceAddr: otherIP,
+				timestamp:  uint64(time.Now().Unix()),
 			})
 			Expect(err).NotTo(HaveOccurred())
 			err = source.VerifyToken(ip4, stk)
-			Expect(err).To(MatchError("invalid ip in STK"))
+			Expect(err).To(MatchError("invalid source address in STK"))
 		})
 	})
 })
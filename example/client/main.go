@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/h2quic"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "verbose")
+	flag.Parse()
+	urls := flag.Args()
+
+	if *verbose {
+		utils.SetLogLevel(utils.LogLevelDebug)
+	} else {
+		utils.SetLogLevel(utils.LogLevelInfo)
+	}
+
+	roundTripper := &h2quic.RoundTripper{}
+	defer roundTripper.Close()
+	hclient := &http.Client{
+		Transport: roundTripper,
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(urls))
+	for _, addr := range urls {
+		utils.Infof("GET %s", addr)
+		go func(addr string) {
+			defer wg.Done()
+			rsp, err := hclient.Get(addr)
+			if err != nil {
+				utils.Errorf("GET %s failed: %s", addr, err.Error())
+				return
+			}
+			defer rsp.Body.Close()
+			utils.Infof("Got response for %s: %#v", addr, rsp)
+
+			body := &bytes.Buffer{}
+			_, err = io.Copy(body, rsp.Body)
+			if err != nil {
+				utils.Errorf("reading the body of %s failed: %s", addr, err.Error())
+				return
+			}
+			utils.Infof("Response Body:")
+			utils.Infof("%s", body.Bytes())
+		}(addr)
+	}
+	wg.Wait()
+}
@@ -52,8 +52,14 @@ func (f *flowControlManager) NewStream(streamID protocol.StreamID, contributesTo
 }
 
 // RemoveStream removes a closed stream from flow control
+// Data that was received on the stream, but never read (e.g. because the stream was reset), is counted as read on the connection level.
 func (f *flowControlManager) RemoveStream(streamID protocol.StreamID) {
 	f.mutex.Lock()
+	if streamFlowController, ok := f.streamFlowController[streamID]; ok && f.contributesToConnectionFlowControl[streamID] {
+		if unread := streamFlowController.highestReceived - streamFlowController.bytesRead; unread > 0 {
+			f.streamFlowController[0].AddBytesRead(unread)
+		}
+	}
 	delete(f.streamFlowController, streamID)
 	delete(f.contributesToConnectionFlowControl, streamID)
 	f.mutex.Unlock()
@@ -41,6 +41,24 @@ var _ = Describe("Flow Control Manager", func() {
 		Expect(fcm.contributesToConnectionFlowControl).ToNot(HaveKey(protocol.StreamID(5)))
 	})
 
+	It("counts unread data of removed streams as read on the connection level", func() {
+		fcm.NewStream(5, true)
+		err := fcm.UpdateHighestReceived(5, 0x100)
+		Expect(err).ToNot(HaveOccurred())
+		err = fcm.AddBytesRead(5, 0x20)
+		Expect(err).ToNot(HaveOccurred())
+		fcm.RemoveStream(5)
+		Expect(fcm.streamFlowController[0].bytesRead).To(Equal(protocol.ByteCount(0x100)))
+	})
+
+	It("doesn't count unread data on the connection level, if the stream doesn't contribute", func() {
+		fcm.NewStream(1, false)
+		err := fcm.UpdateHighestReceived(1, 0x100)
+		Expect(err).ToNot(HaveOccurred())
+		fcm.RemoveStream(1)
+		Expect(fcm.streamFlowController[0].bytesRead).To(BeZero())
+	})
+
 	Context("receiving data", func() {
 		BeforeEach(func() {
 			fcm.NewStream(1, false)
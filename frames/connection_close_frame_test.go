@@ -31,7 +31,7 @@ var _ = Describe("ConnectionCloseFrame", func() {
 		})
 
 		It("rejects long reason phrases", func() {
-			b := bytes.NewReader([]byte{0x02, 0xAD, 0xFB, 0xCA, 0xDE, 0xff, 0xf})
+			b := bytes.NewReader([]byte{0x02, 0xAD, 0xFB, 0xCA, 0xDE, 0xff, 0xff})
 			_, err := ParseConnectionCloseFrame(b)
 			Expect(err).To(MatchError(qerr.Error(qerr.InvalidConnectionCloseData, "reason phrase too long")))
 		})
@@ -60,7 +60,7 @@ func ParseStreamFrame(r *bytes.Reader) (*StreamFrame, error) {
 		}
 	}
 
-	if dataLen > uint16(protocol.MaxPacketSize) {
+	if dataLen > uint16(protocol.MaxReceivePacketSize) {
 		return nil, qerr.Error(qerr.InvalidStreamData, "data len too large")
 	}
 
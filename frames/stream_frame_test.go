@@ -60,7 +60,7 @@ var _ = Describe("StreamFrame", func() {
 		})
 
 		It("rejects frames to too large dataLen", func() {
-			b := bytes.NewReader([]byte{0xa0, 0x1, 0xff, 0xf})
+			b := bytes.NewReader([]byte{0xa0, 0x1, 0xff, 0xff})
 			_, err := ParseStreamFrame(b)
 			Expect(err).To(MatchError(qerr.Error(qerr.InvalidStreamData, "data len too large")))
 		})
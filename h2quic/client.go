@@ -0,0 +1,363 @@
+package h2quic
+
+import (
+	"compress/gzip"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+type quicSession interface {
+	OpenStream() (utils.Stream, error)
+	Close(error) error
+}
+
+// errorCodeStreamCancelled is the error code sent in the RST_STREAM when the response body is closed before it was read completely
+const errorCodeStreamCancelled = 6
+
+type roundTripperOpts struct {
+	DisableCompression bool
+}
+
+var dialAddr = func(hostname string, tlsConfig *tls.Config) (quicSession, error) {
+	session, err := quic.Dial(hostname, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// client is a HTTP2 client doing QUIC requests
+type client struct {
+	mutex sync.RWMutex
+
+	tlsConf *tls.Config
+	opts    *roundTripperOpts
+
+	hostname string
+
+	dialOnce sync.Once
+	dialErr  error
+
+	session       quicSession
+	headerStream  utils.Stream
+	headerErr     *qerr.QuicError
+	headerErrored chan struct{} // this channel is closed if an error occurs on the header stream
+	requestWriter *requestWriter
+
+	responses map[protocol.StreamID]chan *http.Response
+
+	// broken is set once no new requests can be sent using this client, e.g. because dialing failed, the session was closed or the server sent a GOAWAY
+	broken bool
+}
+
+var _ http.RoundTripper = &client{}
+
+// newClient creates a new client
+func newClient(hostname string, tlsConfig *tls.Config, opts *roundTripperOpts) *client {
+	return &client{
+		hostname:      authorityAddr("https", hostname),
+		tlsConf:       tlsConfig,
+		opts:          opts,
+		responses:     make(map[protocol.StreamID]chan *http.Response),
+		headerErrored: make(chan struct{}),
+	}
+}
+
+// dial dials the connection
+func (c *client) dial() error {
+	var err error
+	c.session, err = dialAddr(c.hostname, c.tlsConf)
+	if err != nil {
+		return err
+	}
+
+	// once the version has been negotiated, open the header stream
+	c.headerStream, err = c.session.OpenStream()
+	if err != nil {
+		return err
+	}
+	if c.headerStream.StreamID() != 3 {
+		return errors.New("h2quic Client BUG: StreamID of Header Stream is not 3")
+	}
+	c.requestWriter = newRequestWriter(c.headerStream)
+	go c.handleHeaderStream()
+	return nil
+}
+
+func (c *client) handleHeaderStream() {
+	decoder := hpack.NewDecoder(4096, func(hf hpack.HeaderField) {})
+	h2framer := http2.NewFramer(nil, c.headerStream)
+
+	var lastStream protocol.StreamID
+
+	for {
+		frame, err := h2framer.ReadFrame()
+		if err != nil {
+			// errors returned by the stream are QuicErrors if the session was closed
+			if quicErr, ok := err.(*qerr.QuicError); ok {
+				c.headerErr = quicErr
+			} else {
+				c.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, "cannot read frame")
+			}
+			break
+		}
+		lastStream = protocol.StreamID(frame.Header().StreamID)
+		hframe, ok := frame.(*http2.HeadersFrame)
+		if !ok {
+			c.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, "not a headers frame")
+			break
+		}
+		headers, err := decoder.DecodeFull(hframe.HeaderBlockFragment())
+		if err != nil {
+			c.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, "cannot read header fields")
+			break
+		}
+
+		c.mutex.RLock()
+		responseChan, ok := c.responses[protocol.StreamID(hframe.StreamID)]
+		c.mutex.RUnlock()
+		if !ok {
+			c.headerErr = qerr.Error(qerr.InternalError, fmt.Sprintf("h2client BUG: response channel for stream %d not found", lastStream))
+			break
+		}
+
+		rsp, err := responseFromHeaders(headers)
+		if err != nil {
+			c.headerErr = qerr.Error(qerr.InternalError, err.Error())
+			break
+		}
+		responseChan <- rsp
+	}
+
+	// stop all running requests
+	utils.Debugf("Error handling header stream %d: %s", lastStream, c.headerErr.Error())
+	c.setBroken()
+	close(c.headerErrored)
+}
+
+// setBroken marks the client as unusable for new requests
+func (c *client) setBroken() {
+	c.mutex.Lock()
+	c.broken = true
+	c.mutex.Unlock()
+}
+
+// isBroken returns true if no new requests can be sent using this client
+// The RoundTripper then replaces it by a new client, which dials a new session.
+func (c *client) isBroken() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.broken
+}
+
+// RoundTrip executes a request and returns a response
+func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return nil, errors.New("quic http2: unsupported scheme")
+	}
+	if authorityAddr("https", hostnameFromRequest(req)) != c.hostname {
+		return nil, fmt.Errorf("h2quic Client BUG: RoundTrip called for the wrong client (expected %s, got %s)", c.hostname, req.Host)
+	}
+
+	c.dialOnce.Do(func() {
+		c.dialErr = c.dial()
+	})
+	if c.dialErr != nil {
+		c.setBroken()
+		return nil, c.dialErr
+	}
+
+	hasBody := actualContentLength(req) != 0
+
+	c.mutex.Lock()
+	dataStream, err := c.session.OpenStream()
+	if err != nil {
+		// the session was closed, or the server sent a GOAWAY
+		c.broken = true
+		c.mutex.Unlock()
+		c.Close(err)
+		return nil, err
+	}
+	responseChan := make(chan *http.Response, 1)
+	c.responses[dataStream.StreamID()] = responseChan
+	c.mutex.Unlock()
+
+	var requestedGzip bool
+	if !c.opts.DisableCompression && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD" {
+		requestedGzip = true
+	}
+	// TODO: add support for trailers
+	endStream := !hasBody
+	err = c.requestWriter.WriteRequest(req, dataStream.StreamID(), endStream, requestedGzip)
+	if err != nil {
+		c.setBroken()
+		c.Close(err)
+		return nil, err
+	}
+
+	resc := make(chan error, 1)
+	if hasBody {
+		go func() {
+			resc <- c.writeRequestBody(dataStream, req.Body)
+		}()
+	} else {
+		dataStream.Close()
+	}
+
+	var res *http.Response
+
+	var receivedResponse bool
+	bodySent := !hasBody
+	for !(bodySent && receivedResponse) {
+		select {
+		case res = <-responseChan:
+			receivedResponse = true
+			c.mutex.Lock()
+			delete(c.responses, dataStream.StreamID())
+			c.mutex.Unlock()
+		case err := <-resc:
+			bodySent = true
+			if err != nil {
+				return nil, err
+			}
+		case <-c.headerErrored:
+			// an error occured on the header stream
+			c.Close(c.headerErr)
+			return nil, c.headerErr
+		}
+	}
+
+	res = setLength(res)
+	if req.Method == "HEAD" {
+		res.Body = noBody
+	} else {
+		// the read side of the data stream is closed by the FIN sent by the server
+		res.Body = &responseBody{dataStream: dataStream}
+		if requestedGzip && res.Header.Get("Content-Encoding") == "gzip" {
+			res.Header.Del("Content-Encoding")
+			res.Header.Del("Content-Length")
+			res.ContentLength = -1
+			res.Body = &gzipReader{body: res.Body}
+			res.Uncompressed = true
+		}
+	}
+
+	res.Request = req
+	return res, nil
+}
+
+func (c *client) writeRequestBody(dataStream utils.Stream, body io.ReadCloser) (err error) {
+	defer func() {
+		cerr := body.Close()
+		if err == nil {
+			// TODO: what to do with dataStream here? Maybe reset it?
+			err = cerr
+		}
+	}()
+
+	_, err = io.Copy(dataStream, body)
+	if err != nil {
+		// TODO: what to do with dataStream here? Maybe reset it?
+		return err
+	}
+	return dataStream.Close()
+}
+
+// responseBody is the body of a response
+// Closing it before it was read completely resets the data stream, so that the server stops sending.
+type responseBody struct {
+	dataStream utils.Stream
+	eof        bool
+}
+
+var _ io.ReadCloser = &responseBody{}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.dataStream.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	if !b.eof {
+		b.dataStream.Reset(errorCodeStreamCancelled)
+	}
+	return nil
+}
+
+// Close closes the client
+func (c *client) Close(e error) error {
+	if c.session == nil {
+		return nil
+	}
+	return c.session.Close(e)
+}
+
+// copied from net/transport.go
+
+// authorityAddr returns a given authority (a host/IP, or host:port / ip:port)
+// and returns a host:port. The port 443 is added if needed.
+func authorityAddr(scheme string, authority string) (addr string) {
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil { // authority didn't have a port
+		port = "443"
+		if scheme == "http" {
+			port = "80"
+		}
+		host = authority
+	}
+	// IPv6 address literal, without a port:
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host + ":" + port
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func hostnameFromRequest(req *http.Request) string {
+	if req.URL != nil && req.URL.Host != "" {
+		return req.URL.Host
+	}
+	return req.Host
+}
+
+// copied from net/http2/transport.go
+
+var noBody io.ReadCloser = ioutil.NopCloser(strings.NewReader(""))
+
+// gzipReader wraps a response body so it can lazily
+// call gzip.NewReader on the first call to Read
+type gzipReader struct {
+	body io.ReadCloser // underlying Response.Body
+	zr   io.Reader     // lazily-initialized gzip reader
+}
+
+func (gz *gzipReader) Read(p []byte) (n int, err error) {
+	if gz.zr == nil {
+		gz.zr, err = gzip.NewReader(gz.body)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return gz.zr.Read(p)
+}
+
+func (gz *gzipReader) Close() error {
+	return gz.body.Close()
+}
@@ -0,0 +1,433 @@
+package h2quic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockClientSession struct {
+	closed          bool
+	closedWithError error
+	streamOpenErr   error
+	streamsToOpen   []utils.Stream
+}
+
+func (s *mockClientSession) OpenStream() (utils.Stream, error) {
+	if s.streamOpenErr != nil {
+		return nil, s.streamOpenErr
+	}
+	str := s.streamsToOpen[0]
+	s.streamsToOpen = s.streamsToOpen[1:]
+	return str, nil
+}
+
+func (s *mockClientSession) Close(e error) error {
+	s.closed = true
+	s.closedWithError = e
+	return nil
+}
+
+var _ = Describe("Client", func() {
+	var (
+		client       *client
+		session      *mockClientSession
+		headerStream *mockStream
+		req          *http.Request
+		origDialAddr = dialAddr
+	)
+
+	BeforeEach(func() {
+		origDialAddr = dialAddr
+		hostname := "quic.clemente.io:1337"
+		client = newClient(hostname, nil, &roundTripperOpts{})
+		Expect(client.hostname).To(Equal(hostname))
+		headerStream = &mockStream{id: 3}
+		session = &mockClientSession{streamsToOpen: []utils.Stream{headerStream}}
+		dialAddr = func(string, *tls.Config) (quicSession, error) {
+			return session, nil
+		}
+		var err error
+		req, err = http.NewRequest("GET", "https://quic.clemente.io:1337/file1.dat", nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		dialAddr = origDialAddr
+	})
+
+	It("adds the port to the hostname, if none is given", func() {
+		client = newClient("quic.clemente.io", nil, &roundTripperOpts{})
+		Expect(client.hostname).To(Equal("quic.clemente.io:443"))
+	})
+
+	It("dials", func() {
+		err := client.dial()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.session).To(Equal(session))
+		Expect(client.headerStream).To(Equal(headerStream))
+	})
+
+	It("saves the TLS config", func() {
+		tlsConf := &tls.Config{InsecureSkipVerify: true}
+		client = newClient("", tlsConf, &roundTripperOpts{})
+		Expect(client.tlsConf).To(Equal(tlsConf))
+	})
+
+	It("errors when dialing fails", func() {
+		testErr := errors.New("handshake error")
+		dialAddr = func(string, *tls.Config) (quicSession, error) {
+			return nil, testErr
+		}
+		err := client.dial()
+		Expect(err).To(MatchError(testErr))
+	})
+
+	It("errors if the header stream has the wrong stream ID", func() {
+		session.streamsToOpen = []utils.Stream{&mockStream{id: 2}}
+		err := client.dial()
+		Expect(err).To(MatchError("h2quic Client BUG: StreamID of Header Stream is not 3"))
+	})
+
+	It("errors if it can't open a stream", func() {
+		testErr := errors.New("you shall not pass")
+		session.streamOpenErr = testErr
+		err := client.dial()
+		Expect(err).To(MatchError(testErr))
+	})
+
+	It("returns an error when dialing fails", func() {
+		testErr := errors.New("dial error")
+		dialAddr = func(string, *tls.Config) (quicSession, error) {
+			return nil, testErr
+		}
+		_, err := client.RoundTrip(req)
+		Expect(err).To(MatchError(testErr))
+		Expect(client.isBroken()).To(BeTrue())
+	})
+
+	Context("Doing requests", func() {
+		var (
+			dataStream *mockStream
+			request    *http.Request
+		)
+
+		getRequest := func(data []byte) *http2.MetaHeadersFrame {
+			r := bytes.NewReader(data)
+			decoder := hpack.NewDecoder(4096, func(hf hpack.HeaderField) {})
+			h2framer := http2.NewFramer(nil, r)
+			frame, err := h2framer.ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			mhframe := &http2.MetaHeadersFrame{HeadersFrame: frame.(*http2.HeadersFrame)}
+			mhframe.Fields, err = decoder.DecodeFull(mhframe.HeadersFrame.HeaderBlockFragment())
+			Expect(err).ToNot(HaveOccurred())
+			return mhframe
+		}
+
+		getHeaderFields := func(f *http2.MetaHeadersFrame) map[string]string {
+			fields := make(map[string]string)
+			for _, hf := range f.Fields {
+				fields[hf.Name] = hf.Value
+			}
+			return fields
+		}
+
+		getResponseChan := func(id protocol.StreamID) chan *http.Response {
+			client.mutex.RLock()
+			defer client.mutex.RUnlock()
+			return client.responses[id]
+		}
+
+		BeforeEach(func() {
+			// the client is already connected
+			client.dialOnce.Do(func() {})
+			client.session = session
+			client.headerStream = headerStream
+			client.requestWriter = newRequestWriter(headerStream)
+			dataStream = &mockStream{id: 5}
+			session.streamsToOpen = []utils.Stream{dataStream}
+			var err error
+			request, err = http.NewRequest("GET", "https://quic.clemente.io:1337/file1.dat", nil)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("does a request", func() {
+			var doRsp *http.Response
+			var doErr error
+			var doReturned bool
+			go func() {
+				doRsp, doErr = client.RoundTrip(request)
+				doReturned = true
+			}()
+
+			Eventually(func() chan *http.Response { return getResponseChan(5) }).ShouldNot(BeNil())
+			rsp := &http.Response{
+				Status:     "418 I'm a teapot",
+				StatusCode: 418,
+				Header:     http.Header{},
+			}
+			getResponseChan(5) <- rsp
+			Eventually(func() bool { return doReturned }).Should(BeTrue())
+			Expect(doErr).ToNot(HaveOccurred())
+			Expect(doRsp).To(Equal(rsp))
+			Expect(doRsp.Body).ToNot(BeNil())
+			Expect(doRsp.ContentLength).To(BeEquivalentTo(-1))
+			Expect(doRsp.Request).To(Equal(request))
+		})
+
+		It("writes the request headers on the header stream", func() {
+			go client.RoundTrip(request)
+			Eventually(func() []byte { return headerStream.Bytes() }).ShouldNot(BeEmpty())
+			hframe := getRequest(headerStream.Bytes())
+			Expect(hframe.StreamID).To(BeEquivalentTo(5))
+			Expect(hframe.StreamEnded()).To(BeTrue())
+			fields := getHeaderFields(hframe)
+			Expect(fields).To(HaveKeyWithValue(":authority", "quic.clemente.io:1337"))
+			Expect(fields).To(HaveKeyWithValue(":path", "/file1.dat"))
+			Expect(fields).To(HaveKeyWithValue(":scheme", "https"))
+		})
+
+		It("sends the request body on the data stream", func() {
+			request, err := http.NewRequest("POST", "https://quic.clemente.io:1337/upload", bytes.NewReader([]byte("foobar")))
+			Expect(err).ToNot(HaveOccurred())
+			var doReturned bool
+			go func() {
+				client.RoundTrip(request)
+				doReturned = true
+			}()
+			Eventually(func() chan *http.Response { return getResponseChan(5) }).ShouldNot(BeNil())
+			hframe := getRequest(headerStream.Bytes())
+			Expect(hframe.StreamEnded()).To(BeFalse())
+			Expect(getHeaderFields(hframe)).To(HaveKeyWithValue("content-length", "6"))
+			getResponseChan(5) <- &http.Response{Header: http.Header{}}
+			Eventually(func() bool { return doReturned }).Should(BeTrue())
+			Expect(dataStream.Bytes()).To(Equal([]byte("foobar")))
+		})
+
+		It("returns a response with a body for HEAD requests", func() {
+			request, err := http.NewRequest("HEAD", "https://quic.clemente.io:1337/file1.dat", nil)
+			Expect(err).ToNot(HaveOccurred())
+			var doRsp *http.Response
+			var doReturned bool
+			go func() {
+				doRsp, _ = client.RoundTrip(request)
+				doReturned = true
+			}()
+			Eventually(func() chan *http.Response { return getResponseChan(5) }).ShouldNot(BeNil())
+			getResponseChan(5) <- &http.Response{Header: http.Header{"Content-Length": []string{"1337"}}}
+			Eventually(func() bool { return doReturned }).Should(BeTrue())
+			Expect(doRsp.ContentLength).To(BeEquivalentTo(1337))
+			Expect(doRsp.Body).To(Equal(noBody))
+		})
+
+		It("closes the session when an error occurs on the header stream", func() {
+			var doErr error
+			var doReturned bool
+			go func() {
+				_, doErr = client.RoundTrip(request)
+				doReturned = true
+			}()
+			Eventually(func() chan *http.Response { return getResponseChan(5) }).ShouldNot(BeNil())
+			client.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, "foobar")
+			close(client.headerErrored)
+			Eventually(func() bool { return doReturned }).Should(BeTrue())
+			Expect(doErr).To(MatchError(client.headerErr))
+			Expect(session.closedWithError).To(MatchError(client.headerErr))
+		})
+
+		It("is broken after an error on the header stream", func() {
+			headerStream.Write([]byte("invalid frame"))
+			Expect(client.isBroken()).To(BeFalse())
+			client.handleHeaderStream()
+			Expect(client.isBroken()).To(BeTrue())
+		})
+
+		It("returns an error for subsequent requests if there was an error on the header stream before", func() {
+			client.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, "foobar")
+			close(client.headerErrored)
+			_, err := client.RoundTrip(request)
+			Expect(err).To(MatchError(client.headerErr))
+		})
+
+		It("errors if it can't open a data stream", func() {
+			testErr := errors.New("you shall not pass")
+			session.streamOpenErr = testErr
+			_, err := client.RoundTrip(request)
+			Expect(err).To(MatchError(testErr))
+			Expect(session.closedWithError).To(MatchError(testErr))
+			Expect(client.isBroken()).To(BeTrue())
+		})
+
+		Context("closing the response body", func() {
+			var rsp *http.Response
+
+			BeforeEach(func() {
+				var doReturned bool
+				go func() {
+					defer GinkgoRecover()
+					var err error
+					rsp, err = client.RoundTrip(request)
+					Expect(err).ToNot(HaveOccurred())
+					doReturned = true
+				}()
+				Eventually(func() chan *http.Response { return getResponseChan(5) }).ShouldNot(BeNil())
+				dataStream.Write([]byte("foobar"))
+				getResponseChan(5) <- &http.Response{Header: http.Header{}}
+				Eventually(func() bool { return doReturned }).Should(BeTrue())
+			})
+
+			It("resets the data stream if the body is closed before it was read completely", func() {
+				b := make([]byte, 3)
+				_, err := rsp.Body.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rsp.Body.Close()).To(Succeed())
+				Expect(dataStream.reset).To(BeTrue())
+			})
+
+			It("doesn't reset the data stream if the body was read completely", func() {
+				data, err := ioutil.ReadAll(rsp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foobar")))
+				Expect(rsp.Body.Close()).To(Succeed())
+				Expect(dataStream.reset).To(BeFalse())
+			})
+		})
+
+		It("refuses to do plain HTTP requests", func() {
+			request, err := http.NewRequest("GET", "http://quic.clemente.io:1337/foobar.html", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = client.RoundTrip(request)
+			Expect(err).To(MatchError("quic http2: unsupported scheme"))
+		})
+
+		It("refuses requests for a different host", func() {
+			request, err := http.NewRequest("GET", "https://quic.clemente.io:443/foobar.html", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = client.RoundTrip(request)
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("gzip compression", func() {
+			It("adds the gzip header to requests", func() {
+				go client.RoundTrip(request)
+				Eventually(func() []byte { return headerStream.Bytes() }).ShouldNot(BeEmpty())
+				headers := getHeaderFields(getRequest(headerStream.Bytes()))
+				Expect(headers).To(HaveKeyWithValue("accept-encoding", "gzip"))
+			})
+
+			It("doesn't add the gzip header if compression is disabled", func() {
+				client.opts.DisableCompression = true
+				go client.RoundTrip(request)
+				Eventually(func() []byte { return headerStream.Bytes() }).ShouldNot(BeEmpty())
+				headers := getHeaderFields(getRequest(headerStream.Bytes()))
+				Expect(headers).ToNot(HaveKey("accept-encoding"))
+			})
+
+			It("only decompresses the response if the response contains the right content-encoding header", func() {
+				var doRsp *http.Response
+				var doReturned bool
+				go func() {
+					doRsp, _ = client.RoundTrip(request)
+					doReturned = true
+				}()
+				Eventually(func() chan *http.Response { return getResponseChan(5) }).ShouldNot(BeNil())
+				dataStream.Write([]byte("not gzipped"))
+				getResponseChan(5) <- &http.Response{Header: http.Header{}}
+				Eventually(func() bool { return doReturned }).Should(BeTrue())
+				data, err := ioutil.ReadAll(doRsp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("not gzipped")))
+			})
+
+			It("decompresses the response", func() {
+				var doRsp *http.Response
+				var doReturned bool
+				go func() {
+					doRsp, _ = client.RoundTrip(request)
+					doReturned = true
+				}()
+				Eventually(func() chan *http.Response { return getResponseChan(5) }).ShouldNot(BeNil())
+				w := gzip.NewWriter(dataStream)
+				w.Write([]byte("gzipped data"))
+				w.Close()
+				getResponseChan(5) <- &http.Response{Header: http.Header{"Content-Encoding": []string{"gzip"}}}
+				Eventually(func() bool { return doReturned }).Should(BeTrue())
+				Expect(doRsp.Uncompressed).To(BeTrue())
+				Expect(doRsp.ContentLength).To(BeEquivalentTo(-1))
+				data, err := ioutil.ReadAll(doRsp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("gzipped data")))
+			})
+		})
+
+		Context("handling the header stream", func() {
+			var h2framer *http2.Framer
+
+			BeforeEach(func() {
+				h2framer = http2.NewFramer(&headerStream.Buffer, nil)
+			})
+
+			It("reads header values from a response", func() {
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				data := []byte{0x48, 0x03, 0x33, 0x30, 0x32, 0x58, 0x07, 0x70, 0x72, 0x69, 0x76, 0x61, 0x74, 0x65, 0x61, 0x1d, 0x4d, 0x6f, 0x6e, 0x2c, 0x20, 0x32, 0x31, 0x20, 0x4f, 0x63, 0x74, 0x20, 0x32, 0x30, 0x31, 0x33, 0x20, 0x32, 0x30, 0x3a, 0x31, 0x33, 0x3a, 0x32, 0x31, 0x20, 0x47, 0x4d, 0x54, 0x6e, 0x17, 0x68, 0x74, 0x74, 0x70, 0x73, 0x3a, 0x2f, 0x2f, 0x77, 0x77, 0x77, 0x2e, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d}
+				headerStream.Write([]byte{0x0, 0x0, byte(len(data)), 0x1, 0x5, 0x0, 0x0, 0x0, 0x5})
+				headerStream.Write(data)
+				client.responses[5] = make(chan *http.Response, 1)
+				client.handleHeaderStream()
+				var rsp *http.Response
+				Expect(client.responses[5]).To(Receive(&rsp))
+				Expect(rsp.Proto).To(Equal("HTTP/2.0"))
+				Expect(rsp.ProtoMajor).To(BeEquivalentTo(2))
+				Expect(rsp.StatusCode).To(BeEquivalentTo(302))
+				Expect(rsp.Status).To(Equal("302 Found"))
+				Expect(rsp.Header).To(HaveKeyWithValue("Location", []string{"https://www.example.com"}))
+				Expect(rsp.Header).To(HaveKeyWithValue("Cache-Control", []string{"private"}))
+			})
+
+			It("errors if the H2 frame is not a HeadersFrame", func() {
+				h2framer.WritePing(true, [8]byte{0, 0, 0, 0, 0, 0, 0, 0})
+				client.handleHeaderStream()
+				Expect(client.headerErrored).To(BeClosed())
+				Expect(client.headerErr).To(MatchError(qerr.Error(qerr.InvalidHeadersStreamData, "not a headers frame")))
+			})
+
+			It("errors if it can't read the HPACK encoded header fields", func() {
+				h2framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      5,
+					EndHeaders:    true,
+					BlockFragment: []byte("invalid HPACK data"),
+				})
+				client.handleHeaderStream()
+				Expect(client.headerErrored).To(BeClosed())
+				Expect(client.headerErr).To(MatchError(qerr.Error(qerr.InvalidHeadersStreamData, "cannot read header fields")))
+			})
+
+			It("errors if it receives a response for a stream that doesn't exist", func() {
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+				h2framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      7,
+					EndHeaders:    true,
+					BlockFragment: headers.Bytes(),
+				})
+				client.handleHeaderStream()
+				Expect(client.headerErrored).To(BeClosed())
+				Expect(client.headerErr.ErrorCode).To(Equal(qerr.InternalError))
+			})
+		})
+	})
+})
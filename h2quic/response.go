@@ -0,0 +1,52 @@
+package h2quic
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// responseFromHeaders creates a response from the header fields sent by the server
+func responseFromHeaders(headers []hpack.HeaderField) (*http.Response, error) {
+	var status string
+	httpHeaders := http.Header{}
+
+	for _, h := range headers {
+		if h.Name == ":status" {
+			status = h.Value
+			continue
+		}
+		if !h.IsPseudo() {
+			httpHeaders.Add(h.Name, h.Value)
+		}
+	}
+
+	if len(status) == 0 {
+		return nil, errors.New("malformed response: missing :status")
+	}
+	statusCode, err := strconv.Atoi(status)
+	if err != nil {
+		return nil, errors.New("malformed non-numeric status pseudo header")
+	}
+
+	return &http.Response{
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     httpHeaders,
+		StatusCode: statusCode,
+		Status:     status + " " + http.StatusText(statusCode),
+	}, nil
+}
+
+// setLength sets the ContentLength of a response from its Content-Length header
+func setLength(res *http.Response) *http.Response {
+	res.ContentLength = -1
+	if clens := res.Header["Content-Length"]; len(clens) == 1 {
+		if clen64, err := strconv.ParseInt(clens[0], 10, 64); err == nil {
+			res.ContentLength = clen64
+		}
+	}
+	return res
+}
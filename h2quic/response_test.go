@@ -0,0 +1,59 @@
+package h2quic
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http2/hpack"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Response", func() {
+	It("creates a response from header fields", func() {
+		headers := []hpack.HeaderField{
+			{Name: ":status", Value: "404"},
+			{Name: "content-type", Value: "text/plain"},
+			{Name: "set-cookie", Value: "foo=bar"},
+			{Name: "set-cookie", Value: "lorem=ipsum"},
+		}
+		rsp, err := responseFromHeaders(headers)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.StatusCode).To(Equal(404))
+		Expect(rsp.Status).To(Equal("404 Not Found"))
+		Expect(rsp.Proto).To(Equal("HTTP/2.0"))
+		Expect(rsp.ProtoMajor).To(Equal(2))
+		Expect(rsp.ProtoMinor).To(BeZero())
+		Expect(rsp.Header).To(Equal(http.Header{
+			"Content-Type": []string{"text/plain"},
+			"Set-Cookie":   []string{"foo=bar", "lorem=ipsum"},
+		}))
+	})
+
+	It("errors if the :status header is missing", func() {
+		_, err := responseFromHeaders([]hpack.HeaderField{{Name: "content-type", Value: "text/plain"}})
+		Expect(err).To(MatchError("malformed response: missing :status"))
+	})
+
+	It("errors on a non-numeric :status header", func() {
+		_, err := responseFromHeaders([]hpack.HeaderField{{Name: ":status", Value: "foo"}})
+		Expect(err).To(MatchError("malformed non-numeric status pseudo header"))
+	})
+
+	Context("setting the content length", func() {
+		It("reads the Content-Length header", func() {
+			rsp := setLength(&http.Response{Header: http.Header{"Content-Length": []string{"42"}}})
+			Expect(rsp.ContentLength).To(BeEquivalentTo(42))
+		})
+
+		It("uses -1 if the Content-Length header is missing", func() {
+			rsp := setLength(&http.Response{Header: http.Header{}})
+			Expect(rsp.ContentLength).To(BeEquivalentTo(-1))
+		})
+
+		It("uses -1 if the Content-Length header is invalid", func() {
+			rsp := setLength(&http.Response{Header: http.Header{"Content-Length": []string{"foo"}}})
+			Expect(rsp.ContentLength).To(BeEquivalentTo(-1))
+		})
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 	. "github.com/onsi/ginkgo"
@@ -14,11 +15,16 @@ type mockStream struct {
 	id protocol.StreamID
 	bytes.Buffer
 	remoteClosed bool
+	reset        bool
 }
 
 func (mockStream) Close() error                             { return nil }
 func (s *mockStream) CloseRemote(offset protocol.ByteCount) { s.remoteClosed = true }
+func (s *mockStream) Reset(errorCode uint32)                { s.reset = true }
 func (s mockStream) StreamID() protocol.StreamID            { return s.id }
+func (mockStream) SetDeadline(time.Time) error              { panic("not implemented") }
+func (mockStream) SetReadDeadline(time.Time) error          { panic("not implemented") }
+func (mockStream) SetWriteDeadline(time.Time) error         { panic("not implemented") }
 
 var _ = Describe("Response Writer", func() {
 	var (
@@ -0,0 +1,152 @@
+package h2quic
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+type roundTripCloser interface {
+	http.RoundTripper
+	Close(error) error
+	isBroken() bool
+}
+
+// RoundTripper implements the http.RoundTripper interface
+// It opens one QUIC session per authority, and reuses it for all requests to this authority.
+type RoundTripper struct {
+	mutex sync.Mutex
+
+	// DisableCompression, if true, prevents the Transport from
+	// requesting compression with an "Accept-Encoding: gzip"
+	// request header when the Request contains no existing
+	// Accept-Encoding value. If the Transport requests gzip on
+	// its own and gets a gzipped response, it's transparently
+	// decoded in the Response.Body. However, if the user
+	// explicitly requested gzip it is not automatically
+	// uncompressed.
+	DisableCompression bool
+
+	// TLSClientConfig specifies the TLS configuration to use with
+	// tls.Client. If nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	clients map[string]roundTripCloser
+}
+
+var _ http.RoundTripper = &RoundTripper{}
+
+// NewClient returns an http.Client that sends all requests over QUIC, using a new RoundTripper with the given TLS configuration
+// The sessions are kept open for reuse. They can be closed by calling Close on the client's Transport, which is a *RoundTripper.
+func NewClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &RoundTripper{TLSClientConfig: tlsConfig},
+	}
+}
+
+// RoundTrip does a round trip
+func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL == nil {
+		closeRequestBody(req)
+		return nil, errors.New("quic: nil Request.URL")
+	}
+	if req.URL.Host == "" {
+		closeRequestBody(req)
+		return nil, errors.New("quic: no Host in request URL")
+	}
+	if req.Header == nil {
+		closeRequestBody(req)
+		return nil, errors.New("quic: nil Request.Header")
+	}
+
+	if req.URL.Scheme == "https" {
+		for k, vv := range req.Header {
+			if !httpguts.ValidHeaderFieldName(k) {
+				return nil, fmt.Errorf("quic: invalid http header field name %q", k)
+			}
+			for _, v := range vv {
+				if !httpguts.ValidHeaderFieldValue(v) {
+					return nil, fmt.Errorf("quic: invalid http header field value %q for key %v", v, k)
+				}
+			}
+		}
+	} else {
+		closeRequestBody(req)
+		return nil, fmt.Errorf("quic: unsupported protocol scheme: %s", req.URL.Scheme)
+	}
+
+	if req.Method != "" && !validMethod(req.Method) {
+		closeRequestBody(req)
+		return nil, fmt.Errorf("quic: invalid method %q", req.Method)
+	}
+
+	hostname := authorityAddr("https", hostnameFromRequest(req))
+	client := r.getClient(hostname)
+	rsp, err := client.RoundTrip(req)
+	if err != nil && client.isBroken() {
+		r.removeClient(hostname, client)
+	}
+	return rsp, err
+}
+
+// getClient returns the client for a hostname
+// If there's no client yet, or if the existing client is broken (e.g. because its session was closed), a new client is created.
+func (r *RoundTripper) getClient(hostname string) roundTripCloser {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.clients == nil {
+		r.clients = make(map[string]roundTripCloser)
+	}
+
+	client, ok := r.clients[hostname]
+	if !ok || client.isBroken() {
+		client = newClient(hostname, r.TLSClientConfig, &roundTripperOpts{DisableCompression: r.DisableCompression})
+		r.clients[hostname] = client
+	}
+	return client
+}
+
+// removeClient removes a broken client, so that the next request to the hostname dials a new session
+func (r *RoundTripper) removeClient(hostname string, client roundTripCloser) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// another request might already have replaced the client
+	if r.clients[hostname] == client {
+		delete(r.clients, hostname)
+	}
+}
+
+// Close closes the QUIC sessions that this RoundTripper has used
+func (r *RoundTripper) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, client := range r.clients {
+		if err := client.Close(nil); err != nil {
+			return err
+		}
+	}
+	r.clients = nil
+	return nil
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// validMethod checks that the method is a token, as defined in RFC 2616, section 5.1.1
+func validMethod(method string) bool {
+	return len(method) > 0 && strings.IndexFunc(method, isNotToken) == -1
+}
+
+// copied from net/http/http.go
+func isNotToken(r rune) bool {
+	return !httpguts.IsTokenRune(r)
+}
@@ -0,0 +1,237 @@
+package h2quic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockClient struct {
+	closed bool
+	broken bool
+	err    error
+	// if set, the client breaks when a request fails
+	breakOnError bool
+}
+
+func (m *mockClient) RoundTrip(req *http.Request) (*http.Response, error) {
+	if m.err != nil {
+		m.broken = m.breakOnError
+		return nil, m.err
+	}
+	return &http.Response{Request: req}, nil
+}
+func (m *mockClient) Close(error) error {
+	m.closed = true
+	return nil
+}
+
+func (m *mockClient) isBroken() bool {
+	return m.broken
+}
+
+var _ roundTripCloser = &mockClient{}
+
+type mockBody struct {
+	reader   bytes.Reader
+	readErr  error
+	closeErr error
+	closed   bool
+}
+
+func (m *mockBody) Read(p []byte) (int, error) {
+	if m.readErr != nil {
+		return 0, m.readErr
+	}
+	return m.reader.Read(p)
+}
+
+func (m *mockBody) SetData(data []byte) {
+	m.reader = *bytes.NewReader(data)
+}
+
+func (m *mockBody) Close() error {
+	m.closed = true
+	return m.closeErr
+}
+
+// make sure the mockBody can be used as a http.Request.Body
+var _ io.ReadCloser = &mockBody{}
+
+var _ = Describe("RoundTripper", func() {
+	var (
+		rt   *RoundTripper
+		req1 *http.Request
+	)
+
+	BeforeEach(func() {
+		rt = &RoundTripper{}
+		var err error
+		req1, err = http.NewRequest("GET", "https://www.example.org/file1.html", nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("reuses existing clients", func() {
+		rt.clients = make(map[string]roundTripCloser)
+		rt.clients["www.example.org:443"] = &mockClient{}
+		rsp, err := rt.RoundTrip(req1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.Request).To(Equal(req1))
+		Expect(rt.clients).To(HaveLen(1))
+	})
+
+	It("creates a new client for a new authority", func() {
+		client := rt.getClient("www.example.org:443")
+		Expect(client).ToNot(BeNil())
+		Expect(rt.getClient("www.example.org:443")).To(Equal(client))
+		Expect(rt.getClient("www.example.com:443")).ToNot(Equal(client))
+		Expect(rt.clients).To(HaveLen(2))
+	})
+
+	It("replaces broken clients", func() {
+		broken := &mockClient{broken: true}
+		rt.clients = map[string]roundTripCloser{"www.example.org:443": broken}
+		client := rt.getClient("www.example.org:443")
+		Expect(client).ToNot(Equal(broken))
+		Expect(rt.clients["www.example.org:443"]).To(Equal(client))
+	})
+
+	It("removes clients that broke during a request", func() {
+		testErr := errors.New("session closed")
+		client := &mockClient{err: testErr, breakOnError: true}
+		rt.clients = map[string]roundTripCloser{"www.example.org:443": client}
+		_, err := rt.RoundTrip(req1)
+		Expect(err).To(MatchError(testErr))
+		Expect(rt.clients).ToNot(HaveKey("www.example.org:443"))
+	})
+
+	It("keeps clients that returned an error for a single request", func() {
+		testErr := errors.New("request error")
+		client := &mockClient{err: testErr}
+		rt.clients = map[string]roundTripCloser{"www.example.org:443": client}
+		_, err := rt.RoundTrip(req1)
+		Expect(err).To(MatchError(testErr))
+		Expect(rt.clients).To(HaveKeyWithValue("www.example.org:443", client))
+	})
+
+	It("passes the TLS config and the options to new clients", func() {
+		rt.DisableCompression = true
+		client := rt.getClient("www.example.org:443").(*client)
+		Expect(client.opts.DisableCompression).To(BeTrue())
+	})
+
+	It("closes all clients", func() {
+		client1 := &mockClient{}
+		client2 := &mockClient{}
+		rt.clients = map[string]roundTripCloser{
+			"www.example.org:443": client1,
+			"www.example.com:443": client2,
+		}
+		err := rt.Close()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client1.closed).To(BeTrue())
+		Expect(client2.closed).To(BeTrue())
+		Expect(rt.clients).To(BeEmpty())
+	})
+
+	It("creates an http.Client that uses a RoundTripper", func() {
+		tlsConf := &tls.Config{ServerName: "foo.bar"}
+		c := NewClient(tlsConf)
+		Expect(c.Transport).To(BeAssignableToTypeOf(&RoundTripper{}))
+		Expect(c.Transport.(*RoundTripper).TLSClientConfig).To(Equal(tlsConf))
+	})
+
+	Context("validating request", func() {
+		It("rejects plain HTTP requests", func() {
+			req, err := http.NewRequest("GET", "http://www.example.org/", nil)
+			req.Body = &mockBody{}
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req)
+			Expect(err).To(MatchError("quic: unsupported protocol scheme: http"))
+			Expect(req.Body.(*mockBody).closed).To(BeTrue())
+		})
+
+		It("rejects requests without a URL", func() {
+			req1.URL = nil
+			req1.Body = &mockBody{}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError("quic: nil Request.URL"))
+			Expect(req1.Body.(*mockBody).closed).To(BeTrue())
+		})
+
+		It("rejects request without a URL Host", func() {
+			req1.URL.Host = ""
+			req1.Body = &mockBody{}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError("quic: no Host in request URL"))
+			Expect(req1.Body.(*mockBody).closed).To(BeTrue())
+		})
+
+		It("doesn't try to close the body if the request doesn't have one", func() {
+			req1.URL = nil
+			Expect(req1.Body).To(BeNil())
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError("quic: nil Request.URL"))
+		})
+
+		It("rejects requests without a header", func() {
+			req1.Header = nil
+			req1.Body = &mockBody{}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError("quic: nil Request.Header"))
+			Expect(req1.Body.(*mockBody).closed).To(BeTrue())
+		})
+
+		It("rejects requests with invalid header name fields", func() {
+			req1.Header.Add("foobär", "value")
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError("quic: invalid http header field name \"foobär\""))
+		})
+
+		It("rejects requests with invalid header name values", func() {
+			req1.Header.Add("foo", string([]byte{0x7}))
+			_, err := rt.RoundTrip(req1)
+			Expect(err.Error()).To(ContainSubstring("quic: invalid http header field value"))
+		})
+
+		It("rejects requests with an invalid request method", func() {
+			req1.Method = "foobär"
+			req1.Body = &mockBody{}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError("quic: invalid method \"foobär\""))
+			Expect(req1.Body.(*mockBody).closed).To(BeTrue())
+		})
+	})
+
+	It("returns the errors of the client", func() {
+		testErr := errors.New("dial error")
+		origDialAddr := dialAddr
+		defer func() { dialAddr = origDialAddr }()
+		dialAddr = func(string, *tls.Config) (quicSession, error) {
+			return nil, testErr
+		}
+		_, err := rt.RoundTrip(req1)
+		Expect(err).To(MatchError(testErr))
+	})
+
+	It("redials after dialing failed", func() {
+		testErr := errors.New("dial error")
+		origDialAddr := dialAddr
+		defer func() { dialAddr = origDialAddr }()
+		var dials int
+		dialAddr = func(string, *tls.Config) (quicSession, error) {
+			dials++
+			return nil, testErr
+		}
+		_, err := rt.RoundTrip(req1)
+		Expect(err).To(MatchError(testErr))
+		_, err = rt.RoundTrip(req1)
+		Expect(err).To(MatchError(testErr))
+		Expect(dials).To(Equal(2))
+	})
+})
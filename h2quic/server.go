@@ -1,6 +1,7 @@
 package h2quic
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -23,22 +24,32 @@ import (
 type streamCreator interface {
 	GetOrOpenStream(protocol.StreamID) (utils.Stream, error)
 	Close(error) error
-	RemoteAddr() *net.UDPAddr
+	RemoteAddr() net.Addr
 }
 
 // Server is a HTTP2 server listening for QUIC connections.
 type Server struct {
 	*http.Server
 
+	// QuicConfig is used to configure the QUIC server. Its TLSConfig is ignored, the tls.Config of the http.Server is used instead.
+	// If nil, the default values are used.
+	QuicConfig *quic.Config
+
 	// Private flag for demo, do not use
 	CloseAfterFirstRequest bool
 
 	port uint32 // used atomically
 
+	// activeRequests is the number of running ServeHTTP calls, used atomically
+	activeRequests int32
+
 	server      *quic.Server
 	serverMutex sync.Mutex
 }
 
+// shutdownPollInterval is how often Shutdown checks if all requests are completed
+var shutdownPollInterval = 50 * time.Millisecond
+
 // ListenAndServe listens on the UDP address s.Addr and calls s.Handler to handle HTTP/2 requests on incoming connections.
 func (s *Server) ListenAndServe() error {
 	if s.Server == nil {
@@ -63,12 +74,13 @@ func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
 	return s.serveImpl(config, nil)
 }
 
-// Serve an existing UDP connection.
-func (s *Server) Serve(conn *net.UDPConn) error {
+// Serve an existing connection.
+// Unlike ListenAndServeTLS, it uses the certificates from the Server's TLSConfig.
+func (s *Server) Serve(conn net.PacketConn) error {
 	return s.serveImpl(s.TLSConfig, conn)
 }
 
-func (s *Server) serveImpl(tlsConfig *tls.Config, conn *net.UDPConn) error {
+func (s *Server) serveImpl(tlsConfig *tls.Config, conn net.PacketConn) error {
 	if s.Server == nil {
 		return errors.New("use of h2quic.Server without http.Server")
 	}
@@ -77,8 +89,12 @@ func (s *Server) serveImpl(tlsConfig *tls.Config, conn *net.UDPConn) error {
 		s.serverMutex.Unlock()
 		return errors.New("ListenAndServe may only be called once")
 	}
-	var err error
-	server, err := quic.NewServer(s.Addr, tlsConfig, s.handleStreamCb)
+	quicConfig := &quic.Config{}
+	if s.QuicConfig != nil {
+		*quicConfig = *s.QuicConfig
+	}
+	quicConfig.TLSConfig = tlsConfig
+	server, err := quic.NewServer(s.Addr, quicConfig, s.handleStreamCb)
 	if err != nil {
 		s.serverMutex.Unlock()
 		return err
@@ -151,6 +167,11 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 	if err != nil {
 		return err
 	}
+	// this can happen if the session is going away, and the client opened the stream after we sent the GOAWAY
+	if dataStream == nil {
+		utils.Debugf("Ignoring request on data stream %d, the session is going away", h2headersFrame.StreamID)
+		return nil
+	}
 
 	if h2headersFrame.StreamEnded() {
 		dataStream.CloseRemote(0)
@@ -162,7 +183,9 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 
 	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, protocol.StreamID(h2headersFrame.StreamID))
 
+	atomic.AddInt32(&s.activeRequests, 1)
 	go func() {
+		defer atomic.AddInt32(&s.activeRequests, -1)
 		handler := s.Handler
 		if handler == nil {
 			handler = http.DefaultServeMux
@@ -214,8 +237,45 @@ func (s *Server) Close() error {
 // CloseGracefully shuts down the server gracefully. The server sends a GOAWAY frame first, then waits for either timeout to trigger, or for all running requests to complete.
 // CloseGracefully in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
 func (s *Server) CloseGracefully(timeout time.Duration) error {
-	// TODO: implement
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// Shutdown shuts down the server gracefully, like http.Server.Shutdown.
+// It stops accepting new sessions and sends a GOAWAY frame on every open session.
+// It then waits for all running requests to complete and all sessions to close, before closing the UDP socket.
+// If the context expires first, the remaining sessions are closed immediately and the context's error is returned.
+// Shutdown in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.serverMutex.Lock()
+	server := s.server
+	s.serverMutex.Unlock()
+	if server == nil {
+		return nil
+	}
+
+	if err := server.CloseGracefully(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&s.activeRequests) > 0 {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	err := server.Shutdown(ctx)
+	s.serverMutex.Lock()
+	if s.server == server {
+		s.server = nil
+	}
+	s.serverMutex.Unlock()
+	return err
 }
 
 // SetQuicHeaders can be used to set the proper headers that announce that this server supports QUIC.
@@ -315,8 +375,8 @@ func ListenAndServe(addr, certFile, keyFile string, handler http.Handler) error
 		handler.ServeHTTP(w, r)
 	})
 
-	hErr := make(chan error)
-	qErr := make(chan error)
+	hErr := make(chan error, 1)
+	qErr := make(chan error, 1)
 	go func() {
 		hErr <- httpServer.Serve(tcpConn)
 	}()
@@ -326,10 +386,10 @@ func ListenAndServe(addr, certFile, keyFile string, handler http.Handler) error
 
 	select {
 	case err := <-hErr:
-		quicServer.Close()
+		quicServer.Shutdown(context.Background())
 		return err
 	case err := <-qErr:
-		// Cannot close the HTTP server or wait for requests to complete properly :/
+		httpServer.Shutdown(context.Background())
 		return err
 	}
 }
@@ -1,12 +1,17 @@
 package h2quic
 
 import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
@@ -25,10 +30,13 @@ type mockSession struct {
 }
 
 func (s *mockSession) GetOrOpenStream(id protocol.StreamID) (utils.Stream, error) {
+	if s.dataStream == nil {
+		return nil, nil
+	}
 	return s.dataStream, nil
 }
 func (s *mockSession) Close(error) error { s.closed = true; return nil }
-func (s *mockSession) RemoteAddr() *net.UDPAddr {
+func (s *mockSession) RemoteAddr() net.Addr {
 	return &net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 42}
 }
 
@@ -130,6 +138,40 @@ var _ = Describe("H2 server", func() {
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeFalse())
 		})
+
+		It("ignores requests if the session doesn't accept the data stream", func() {
+			var handlerCalled bool
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+			})
+			session.dataStream = nil
+			headerStream.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Consistently(func() bool { return handlerCalled }).Should(BeFalse())
+			Expect(atomic.LoadInt32(&s.activeRequests)).To(BeZero())
+		})
+
+		It("counts the running requests", func() {
+			handlerDone := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-handlerDone
+			})
+			headerStream.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(atomic.LoadInt32(&s.activeRequests)).To(Equal(int32(1)))
+			close(handlerDone)
+			Eventually(func() int32 { return atomic.LoadInt32(&s.activeRequests) }).Should(BeZero())
+		})
 	})
 
 	It("handles the header stream", func() {
@@ -314,6 +356,104 @@ var _ = Describe("H2 server", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	Context("shutting down", func() {
+		var (
+			serveDone   chan struct{}
+			requestSent chan struct{}
+			addr        net.Addr
+			body        []byte
+		)
+
+		BeforeEach(func() {
+			serveDone = make(chan struct{})
+			requestSent = make(chan struct{})
+			body = make([]byte, 200*1024)
+			for i := range body {
+				body[i] = byte(i % 256)
+			}
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(body[:len(body)/2])
+				w.(http.Flusher).Flush()
+				close(requestSent)
+				time.Sleep(200 * time.Millisecond)
+				w.Write(body[len(body)/2:])
+			})
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			addr = conn.LocalAddr()
+			go func() {
+				defer GinkgoRecover()
+				err := s.Serve(conn)
+				Expect(err).ToNot(HaveOccurred())
+				close(serveDone)
+			}()
+			Eventually(func() bool {
+				s.serverMutex.Lock()
+				defer s.serverMutex.Unlock()
+				return s.server != nil
+			}).Should(BeTrue())
+		})
+
+		AfterEach(func() {
+			Eventually(serveDone).Should(BeClosed())
+		})
+
+		It("closes the server when there are no running requests", func() {
+			err := s.Shutdown(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.server).To(BeNil())
+		})
+
+		It("waits for running requests to complete", func() {
+			atomic.StoreInt32(&s.activeRequests, 1)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				err := s.Shutdown(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			Consistently(done).ShouldNot(BeClosed())
+			Expect(serveDone).ToNot(BeClosed())
+			atomic.StoreInt32(&s.activeRequests, 0)
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("closes the server when the context expires", func() {
+			atomic.StoreInt32(&s.activeRequests, 1)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err := s.Shutdown(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("delivers the full response of a running request", func() {
+			rt := &RoundTripper{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+					ServerName:         "quic.clemente.io",
+				},
+			}
+			defer rt.Close()
+			respChan := make(chan []byte)
+			go func() {
+				defer GinkgoRecover()
+				rsp, err := (&http.Client{Transport: rt}).Get("https://" + addr.String() + "/slow")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rsp.StatusCode).To(Equal(200))
+				data, err := ioutil.ReadAll(rsp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				respChan <- data
+			}()
+			Eventually(requestSent, 5*time.Second).Should(BeClosed())
+			err := s.Shutdown(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			var data []byte
+			Eventually(respChan, 5*time.Second).Should(Receive(&data))
+			Expect(data).To(Equal(body))
+		})
+	})
+
 	It("at least errors in global ListenAndServeQUIC", func() {
 		// It's quite hard to test this, since we cannot properly shutdown the server
 		// once it's started. So, we open a socket on the same port before the test,
@@ -14,7 +14,7 @@ import (
 
 // ConnectionParametersManager stores the connection parameters
 // Warning: Writes may only be done from the crypto stream, see the comment
-// in GetSHLOMap().
+// in GetHelloMap().
 type ConnectionParametersManager struct {
 	params map[Tag][]byte
 	mutex  sync.RWMutex
@@ -22,11 +22,17 @@ type ConnectionParametersManager struct {
 	flowControlNegotiated bool // have the flow control parameters for sending already been negotiated
 
 	maxStreamsPerConnection            uint32
+	maxOutgoingDynamicStreams          uint32 // the number of streams the peer allows us to open
 	idleConnectionStateLifetime        time.Duration
 	sendStreamFlowControlWindow        protocol.ByteCount
 	sendConnectionFlowControlWindow    protocol.ByteCount
 	receiveStreamFlowControlWindow     protocol.ByteCount
 	receiveConnectionFlowControlWindow protocol.ByteCount
+
+	// the limits for values negotiated by the peer
+	maxStreamsPerConnectionLimit uint32
+	maxIdleTimeout               time.Duration
+	maxIncomingDynamicStreams    uint32
 }
 
 var errTagNotInConnectionParameterMap = errors.New("ConnectionParametersManager: Tag not found in ConnectionsParameter map")
@@ -38,15 +44,24 @@ var (
 )
 
 // NewConnectionParamatersManager creates a new connection parameters manager
-func NewConnectionParamatersManager() *ConnectionParametersManager {
+// The idle timeout and the maximum number of streams per connection can be lowered by the peer, but never raised above maxIdleTimeout and maxStreamsPerConnection.
+func NewConnectionParamatersManager(
+	receiveStreamFlowControlWindow, receiveConnectionFlowControlWindow protocol.ByteCount,
+	idleTimeout, maxIdleTimeout time.Duration,
+	maxStreamsPerConnection, maxIncomingDynamicStreams uint32,
+) *ConnectionParametersManager {
 	return &ConnectionParametersManager{
-		params: make(map[Tag][]byte),
-		idleConnectionStateLifetime:        protocol.DefaultIdleTimeout,
+		params:                             make(map[Tag][]byte),
+		idleConnectionStateLifetime:        idleTimeout,
 		sendStreamFlowControlWindow:        protocol.InitialStreamFlowControlWindow,     // can only be changed by the client
 		sendConnectionFlowControlWindow:    protocol.InitialConnectionFlowControlWindow, // can only be changed by the client
-		receiveStreamFlowControlWindow:     protocol.ReceiveStreamFlowControlWindow,
-		receiveConnectionFlowControlWindow: protocol.ReceiveConnectionFlowControlWindow,
-		maxStreamsPerConnection:            protocol.MaxStreamsPerConnection,
+		receiveStreamFlowControlWindow:     receiveStreamFlowControlWindow,
+		receiveConnectionFlowControlWindow: receiveConnectionFlowControlWindow,
+		maxStreamsPerConnection:            maxStreamsPerConnection,
+		maxOutgoingDynamicStreams:          protocol.DefaultMaxIncomingDynamicStreams, // can only be changed by the peer
+		maxStreamsPerConnectionLimit:       maxStreamsPerConnection,
+		maxIdleTimeout:                     maxIdleTimeout,
+		maxIncomingDynamicStreams:          maxIncomingDynamicStreams,
 	}
 }
 
@@ -65,6 +80,12 @@ func (h *ConnectionParametersManager) SetFromMap(params map[Tag][]byte) error {
 				return ErrMalformedTag
 			}
 			h.maxStreamsPerConnection = h.negotiateMaxStreamsPerConnection(clientValue)
+		case TagMIDS:
+			peerValue, err := utils.ReadUint32(bytes.NewBuffer(value))
+			if err != nil {
+				return ErrMalformedTag
+			}
+			h.maxOutgoingDynamicStreams = peerValue
 		case TagICSL:
 			clientValue, err := utils.ReadUint32(bytes.NewBuffer(value))
 			if err != nil {
@@ -102,11 +123,11 @@ func (h *ConnectionParametersManager) SetFromMap(params map[Tag][]byte) error {
 }
 
 func (h *ConnectionParametersManager) negotiateMaxStreamsPerConnection(clientValue uint32) uint32 {
-	return utils.MinUint32(clientValue, protocol.MaxStreamsPerConnection)
+	return utils.MinUint32(clientValue, h.maxStreamsPerConnectionLimit)
 }
 
 func (h *ConnectionParametersManager) negotiateIdleConnectionStateLifetime(clientValue time.Duration) time.Duration {
-	return utils.MinDuration(clientValue, protocol.MaxIdleTimeout)
+	return utils.MinDuration(clientValue, h.maxIdleTimeout)
 }
 
 // getRawValue gets the byte-slice for a tag
@@ -121,8 +142,8 @@ func (h *ConnectionParametersManager) getRawValue(tag Tag) ([]byte, error) {
 	return rawValue, nil
 }
 
-// GetSHLOMap gets all values (except crypto values) needed for the SHLO
-func (h *ConnectionParametersManager) GetSHLOMap() map[Tag][]byte {
+// GetHelloMap gets all values (except crypto values) needed for the SHLO or the CHLO
+func (h *ConnectionParametersManager) GetHelloMap() map[Tag][]byte {
 	sfcw := bytes.NewBuffer([]byte{})
 	utils.WriteUint32(sfcw, uint32(h.GetReceiveStreamFlowControlWindow()))
 	cfcw := bytes.NewBuffer([]byte{})
@@ -130,7 +151,7 @@ func (h *ConnectionParametersManager) GetSHLOMap() map[Tag][]byte {
 	mspc := bytes.NewBuffer([]byte{})
 	utils.WriteUint32(mspc, h.GetMaxStreamsPerConnection())
 	mids := bytes.NewBuffer([]byte{})
-	utils.WriteUint32(mids, protocol.MaxIncomingDynamicStreams)
+	utils.WriteUint32(mids, h.maxIncomingDynamicStreams)
 	icsl := bytes.NewBuffer([]byte{})
 	utils.WriteUint32(icsl, uint32(h.GetIdleConnectionStateLifetime()/time.Second))
 
@@ -178,6 +199,20 @@ func (h *ConnectionParametersManager) GetMaxStreamsPerConnection() uint32 {
 	return h.maxStreamsPerConnection
 }
 
+// GetMaxIncomingStreams gets the maximum number of streams the peer is allowed to open
+func (h *ConnectionParametersManager) GetMaxIncomingStreams() uint32 {
+	// this value is never changed after construction, no need to lock the mutex
+	return h.maxIncomingDynamicStreams
+}
+
+// GetMaxOutgoingStreams gets the maximum number of streams we are allowed to open
+// This is the smaller value of the MIDS sent by the peer and the negotiated MSPC.
+func (h *ConnectionParametersManager) GetMaxOutgoingStreams() uint32 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return utils.MinUint32(h.maxOutgoingDynamicStreams, h.maxStreamsPerConnection)
+}
+
 // GetIdleConnectionStateLifetime gets the idle timeout
 func (h *ConnectionParametersManager) GetIdleConnectionStateLifetime() time.Duration {
 	h.mutex.RLock()
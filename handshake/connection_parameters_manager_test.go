@@ -8,10 +8,21 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+func newDefaultConnectionParametersManager() *ConnectionParametersManager {
+	return NewConnectionParamatersManager(
+		protocol.DefaultReceiveStreamFlowControlWindow,
+		protocol.DefaultReceiveConnectionFlowControlWindow,
+		protocol.DefaultIdleTimeout,
+		protocol.DefaultMaxIdleTimeout,
+		protocol.DefaultMaxStreamsPerConnection,
+		protocol.DefaultMaxIncomingDynamicStreams,
+	)
+}
+
 var _ = Describe("ConnectionsParameterManager", func() {
 	var cpm *ConnectionParametersManager
 	BeforeEach(func() {
-		cpm = NewConnectionParamatersManager()
+		cpm = newDefaultConnectionParametersManager()
 	})
 
 	It("stores and retrieves a value", func() {
@@ -34,7 +45,7 @@ var _ = Describe("ConnectionsParameterManager", func() {
 
 	Context("SHLO", func() {
 		It("returns all parameters necessary for the SHLO", func() {
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagICSL))
 			Expect(entryMap).To(HaveKey(TagMSPC))
 			Expect(entryMap).To(HaveKey(TagMIDS))
@@ -42,34 +53,34 @@ var _ = Describe("ConnectionsParameterManager", func() {
 
 		It("sets the stream-level flow control windows in SHLO", func() {
 			cpm.receiveStreamFlowControlWindow = 0xDEADBEEF
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagSFCW))
 			Expect(entryMap[TagSFCW]).To(Equal([]byte{0xEF, 0xBE, 0xAD, 0xDE}))
 		})
 
 		It("sets the connection-level flow control windows in SHLO", func() {
 			cpm.receiveConnectionFlowControlWindow = 0xDECAFBAD
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagCFCW))
 			Expect(entryMap[TagCFCW]).To(Equal([]byte{0xAD, 0xFB, 0xCA, 0xDE}))
 		})
 
 		It("sets the connection-level flow control windows in SHLO", func() {
 			cpm.idleConnectionStateLifetime = 0xDECAFBAD * time.Second
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagICSL))
 			Expect(entryMap[TagICSL]).To(Equal([]byte{0xAD, 0xFB, 0xCA, 0xDE}))
 		})
 
 		It("sets the maximum streams per connection in SHLO", func() {
 			cpm.maxStreamsPerConnection = 0xDEADBEEF
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagMSPC))
 			Expect(entryMap[TagMSPC]).To(Equal([]byte{0xEF, 0xBE, 0xAD, 0xDE}))
 		})
 
 		It("sets the maximum incoming dynamic streams per connection in SHLO", func() {
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagMIDS))
 			Expect(entryMap[TagMIDS]).To(Equal([]byte{100, 0, 0, 0}))
 		})
@@ -99,11 +110,11 @@ var _ = Describe("ConnectionsParameterManager", func() {
 		})
 
 		It("has the correct default stream-level flow control window for receiving", func() {
-			Expect(cpm.GetReceiveStreamFlowControlWindow()).To(Equal(protocol.ReceiveStreamFlowControlWindow))
+			Expect(cpm.GetReceiveStreamFlowControlWindow()).To(Equal(protocol.DefaultReceiveStreamFlowControlWindow))
 		})
 
 		It("has the correct default connection-level flow control window for receiving", func() {
-			Expect(cpm.GetReceiveConnectionFlowControlWindow()).To(Equal(protocol.ReceiveConnectionFlowControlWindow))
+			Expect(cpm.GetReceiveConnectionFlowControlWindow()).To(Equal(protocol.DefaultReceiveConnectionFlowControlWindow))
 		})
 
 		It("sets a new stream-level flow control window for sending", func() {
@@ -166,11 +177,11 @@ var _ = Describe("ConnectionsParameterManager", func() {
 		})
 
 		It("negotiates correctly when the client wants a longer lifetime", func() {
-			Expect(cpm.negotiateIdleConnectionStateLifetime(protocol.MaxIdleTimeout + 10*time.Second)).To(Equal(protocol.MaxIdleTimeout))
+			Expect(cpm.negotiateIdleConnectionStateLifetime(protocol.DefaultMaxIdleTimeout + 10*time.Second)).To(Equal(protocol.DefaultMaxIdleTimeout))
 		})
 
 		It("negotiates correctly when the client wants a shorter lifetime", func() {
-			Expect(cpm.negotiateIdleConnectionStateLifetime(protocol.MaxIdleTimeout - 1*time.Second)).To(Equal(protocol.MaxIdleTimeout - 1*time.Second))
+			Expect(cpm.negotiateIdleConnectionStateLifetime(protocol.DefaultMaxIdleTimeout - 1*time.Second)).To(Equal(protocol.DefaultMaxIdleTimeout - 1*time.Second))
 		})
 
 		It("sets the negotiated lifetime", func() {
@@ -201,15 +212,15 @@ var _ = Describe("ConnectionsParameterManager", func() {
 
 	Context("max streams per connection", func() {
 		It("negotiates correctly when the client wants a larger number", func() {
-			Expect(cpm.negotiateMaxStreamsPerConnection(protocol.MaxStreamsPerConnection + 10)).To(Equal(uint32(protocol.MaxStreamsPerConnection)))
+			Expect(cpm.negotiateMaxStreamsPerConnection(protocol.DefaultMaxStreamsPerConnection + 10)).To(Equal(uint32(protocol.DefaultMaxStreamsPerConnection)))
 		})
 
 		It("negotiates correctly when the client wants a smaller number", func() {
-			Expect(cpm.negotiateMaxStreamsPerConnection(protocol.MaxStreamsPerConnection - 1)).To(Equal(uint32(protocol.MaxStreamsPerConnection - 1)))
+			Expect(cpm.negotiateMaxStreamsPerConnection(protocol.DefaultMaxStreamsPerConnection - 1)).To(Equal(uint32(protocol.DefaultMaxStreamsPerConnection - 1)))
 		})
 
 		It("sets the negotiated max streams per connection value", func() {
-			// this test only works if the value given here is smaller than protocol.MaxStreamsPerConnection
+			// this test only works if the value given here is smaller than protocol.DefaultMaxStreamsPerConnection
 			values := map[Tag][]byte{
 				TagMSPC: {2, 0, 0, 0},
 			}
@@ -232,4 +243,71 @@ var _ = Describe("ConnectionsParameterManager", func() {
 			Expect(cpm.GetMaxStreamsPerConnection()).To(Equal(value))
 		})
 	})
+
+	Context("max outgoing streams", func() {
+		It("uses the default before the peer sent its values", func() {
+			Expect(cpm.GetMaxOutgoingStreams()).To(Equal(uint32(protocol.DefaultMaxIncomingDynamicStreams)))
+		})
+
+		It("uses the MIDS sent by the peer", func() {
+			err := cpm.SetFromMap(map[Tag][]byte{TagMIDS: {20, 0, 0, 0}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cpm.GetMaxOutgoingStreams()).To(Equal(uint32(20)))
+		})
+
+		It("is limited by the negotiated MSPC", func() {
+			err := cpm.SetFromMap(map[Tag][]byte{
+				TagMIDS: {50, 0, 0, 0},
+				TagMSPC: {10, 0, 0, 0},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cpm.GetMaxOutgoingStreams()).To(Equal(uint32(10)))
+		})
+
+		It("errors when given an invalid MIDS value", func() {
+			err := cpm.SetFromMap(map[Tag][]byte{TagMIDS: {20, 0, 0}}) // 1 byte too short
+			Expect(err).To(MatchError(ErrMalformedTag))
+		})
+	})
+
+	Context("with non-default values", func() {
+		BeforeEach(func() {
+			cpm = NewConnectionParamatersManager(0x1000, 0x2000, 20*time.Second, 40*time.Second, 50, 60)
+		})
+
+		It("uses the receive flow control windows", func() {
+			Expect(cpm.GetReceiveStreamFlowControlWindow()).To(Equal(protocol.ByteCount(0x1000)))
+			Expect(cpm.GetReceiveConnectionFlowControlWindow()).To(Equal(protocol.ByteCount(0x2000)))
+		})
+
+		It("uses the idle timeout", func() {
+			Expect(cpm.GetIdleConnectionStateLifetime()).To(Equal(20 * time.Second))
+		})
+
+		It("limits the negotiated idle timeout", func() {
+			Expect(cpm.negotiateIdleConnectionStateLifetime(time.Minute)).To(Equal(40 * time.Second))
+		})
+
+		It("limits the negotiated max streams per connection", func() {
+			Expect(cpm.GetMaxStreamsPerConnection()).To(Equal(uint32(50)))
+			Expect(cpm.negotiateMaxStreamsPerConnection(100)).To(Equal(uint32(50)))
+		})
+
+		It("doesn't lower the limit for the max streams per connection when renegotiating", func() {
+			err := cpm.SetFromMap(map[Tag][]byte{TagMSPC: {10, 0, 0, 0}})
+			Expect(err).ToNot(HaveOccurred())
+			err = cpm.SetFromMap(map[Tag][]byte{TagMSPC: {20, 0, 0, 0}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cpm.GetMaxStreamsPerConnection()).To(Equal(uint32(20)))
+		})
+
+		It("sends the values in the hello map", func() {
+			entryMap := cpm.GetHelloMap()
+			Expect(entryMap).To(HaveKeyWithValue(TagSFCW, []byte{0x00, 0x10, 0x00, 0x00}))
+			Expect(entryMap).To(HaveKeyWithValue(TagCFCW, []byte{0x00, 0x20, 0x00, 0x00}))
+			Expect(entryMap).To(HaveKeyWithValue(TagICSL, []byte{20, 0, 0, 0}))
+			Expect(entryMap).To(HaveKeyWithValue(TagMSPC, []byte{50, 0, 0, 0}))
+			Expect(entryMap).To(HaveKeyWithValue(TagMIDS, []byte{60, 0, 0, 0}))
+		})
+	})
 })
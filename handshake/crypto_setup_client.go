@@ -0,0 +1,470 @@
+package handshake
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+type cryptoSetupClient struct {
+	mutex sync.RWMutex
+
+	hostname string
+	connID   protocol.ConnectionID
+	version  protocol.VersionNumber
+
+	cryptoStream utils.Stream
+
+	serverConfig *serverConfigClient
+
+	stk                  []byte
+	sno                  []byte
+	nonc                 []byte
+	proof                []byte
+	diversificationNonce []byte
+	chloForSignature     []byte
+	lastSentCHLO         []byte
+	certManager          crypto.CertManager
+
+	clientHelloCounter int
+	serverVerified     bool // has the certificate chain and the proof already been verified
+	keyDerivations     map[string]KeyDerivationFunction
+
+	receivedSecurePacket bool
+	secureAEAD           crypto.AEAD
+	forwardSecureAEAD    crypto.AEAD
+	aeadChanged          chan struct{}
+
+	connectionParameters *ConnectionParametersManager
+}
+
+var _ crypto.AEAD = &cryptoSetupClient{}
+var _ CryptoSetup = &cryptoSetupClient{}
+
+var (
+	errNoObitForClientNonce             = errors.New("CryptoSetup BUG: No OBIT for client nonce available")
+	errClientNonceAlreadyExists         = errors.New("CryptoSetup BUG: A client nonce was already generated")
+	errConflictingDiversificationNonces = errors.New("Received two different diversification nonces")
+)
+
+// NewCryptoSetupClient creates a new CryptoSetup instance for a client
+func NewCryptoSetupClient(
+	hostname string,
+	connID protocol.ConnectionID,
+	version protocol.VersionNumber,
+	cryptoStream utils.Stream,
+	tlsConfig *tls.Config,
+	connectionParameters *ConnectionParametersManager,
+	aeadChanged chan struct{},
+) (CryptoSetup, error) {
+	return &cryptoSetupClient{
+		hostname:             hostname,
+		connID:               connID,
+		version:              version,
+		cryptoStream:         cryptoStream,
+		certManager:          crypto.NewCertManager(tlsConfig),
+		connectionParameters: connectionParameters,
+		keyDerivations:       keyDerivations,
+		aeadChanged:          aeadChanged,
+	}, nil
+}
+
+// HandleCryptoStream sends CHLOs and handles the REJs and the SHLO sent by the server
+func (h *cryptoSetupClient) HandleCryptoStream() error {
+	for {
+		err := h.maybeUpgradeCrypto()
+		if err != nil {
+			return err
+		}
+
+		// send CHLOs until the forward secure encryption is established
+		if !h.HandshakeComplete() {
+			err = h.sendCHLO()
+			if err != nil {
+				return err
+			}
+		}
+
+		var shloData bytes.Buffer
+		messageTag, cryptoData, err := ParseHandshakeMessage(io.TeeReader(h.cryptoStream, &shloData))
+		if err != nil {
+			return qerr.HandshakeFailed
+		}
+
+		switch messageTag {
+		case TagREJ:
+			utils.Debugf("Got REJ:\n%s", printHandshakeMessage(cryptoData))
+			err = h.handleREJMessage(cryptoData)
+			if err != nil {
+				return err
+			}
+		case TagSHLO:
+			utils.Debugf("Got SHLO:\n%s", printHandshakeMessage(cryptoData))
+			return h.handleSHLOMessage(cryptoData)
+		default:
+			return qerr.InvalidCryptoMessageType
+		}
+	}
+}
+
+func (h *cryptoSetupClient) handleREJMessage(cryptoData map[Tag][]byte) error {
+	var err error
+
+	if stk, ok := cryptoData[TagSTK]; ok {
+		h.stk = stk
+	}
+
+	if sno, ok := cryptoData[TagSNO]; ok {
+		h.sno = sno
+	}
+
+	// the server rejected our full CHLO, e.g. because it didn't accept the client nonce
+	// a new nonce is generated for the next CHLO
+	if len(h.nonc) > 0 {
+		if rrej, ok := cryptoData[TagRREJ]; ok {
+			utils.Infof("Server rejected CHLO, reasons: %x", rrej)
+		}
+		h.mutex.Lock()
+		h.nonc = nil
+		h.mutex.Unlock()
+	}
+
+	// TODO: what happens if the server sends a different server config in two packets?
+	if scfg, ok := cryptoData[TagSCFG]; ok {
+		h.serverConfig, err = parseServerConfig(scfg)
+		if err != nil {
+			return err
+		}
+
+		if h.serverConfig.IsExpired() {
+			return qerr.CryptoServerConfigExpired
+		}
+	}
+
+	if proof, ok := cryptoData[TagPROF]; ok {
+		h.proof = proof
+		h.chloForSignature = h.lastSentCHLO
+	}
+
+	if crt, ok := cryptoData[TagCERT]; ok {
+		err = h.certManager.SetData(crt)
+		if err != nil {
+			return qerr.Error(qerr.InvalidCryptoMessageParameter, "Certificate data invalid")
+		}
+
+		err = h.certManager.Verify(h.hostname)
+		if err != nil {
+			utils.Infof("Certificate validation failed: %s", err.Error())
+			return qerr.ProofInvalid
+		}
+	}
+
+	if h.serverConfig != nil && len(h.proof) != 0 && h.certManager.GetLeafCert() != nil {
+		validProof := h.certManager.VerifyServerProof(h.proof, h.chloForSignature, h.serverConfig.Get())
+		if !validProof {
+			utils.Infof("Server proof verification failed")
+			return qerr.ProofInvalid
+		}
+
+		h.mutex.Lock()
+		h.serverVerified = true
+		h.mutex.Unlock()
+	}
+
+	return nil
+}
+
+func (h *cryptoSetupClient) handleSHLOMessage(cryptoData map[Tag][]byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.receivedSecurePacket {
+		return qerr.Error(qerr.CryptoEncryptionLevelIncorrect, "unencrypted SHLO message")
+	}
+
+	serverPubs, ok := cryptoData[TagPUBS]
+	if !ok {
+		return qerr.Error(qerr.CryptoMessageParameterNotFound, "PUBS")
+	}
+
+	serverNonce, ok := cryptoData[TagSNO]
+	if !ok {
+		return qerr.Error(qerr.CryptoMessageParameterNotFound, "SNO")
+	}
+
+	var nonce bytes.Buffer
+	nonce.Write(h.nonc)
+	nonce.Write(serverNonce)
+
+	ephermalSharedSecret, err := h.serverConfig.kex.CalculateSharedKey(serverPubs)
+	if err != nil {
+		return err
+	}
+
+	h.forwardSecureAEAD, err = h.keyDerivations[string(h.serverConfig.aead)](
+		true,
+		ephermalSharedSecret,
+		nonce.Bytes(),
+		h.connID,
+		h.lastSentCHLO,
+		h.serverConfig.Get(),
+		h.certManager.GetLeafCert(),
+		nil,
+		protocol.PerspectiveClient,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = h.connectionParameters.SetFromMap(cryptoData)
+	if err != nil {
+		return qerr.InvalidCryptoMessageParameter
+	}
+
+	h.signalAEADChanged()
+
+	return nil
+}
+
+// Open a message
+func (h *cryptoSetupClient) Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
+	// Open sets receivedSecurePacket, so it needs the write lock
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.forwardSecureAEAD != nil {
+		data, err := h.forwardSecureAEAD.Open(dst, src, packetNumber, associatedData)
+		if err == nil {
+			return data, nil
+		}
+		// the server might still send packets sealed with the initial key, e.g. retransmissions
+	}
+	if h.secureAEAD != nil {
+		data, err := h.secureAEAD.Open(dst, src, packetNumber, associatedData)
+		if err == nil {
+			h.receivedSecurePacket = true
+			return data, nil
+		}
+		if h.receivedSecurePacket {
+			return nil, err
+		}
+	}
+	return (&crypto.NullAEAD{}).Open(dst, src, packetNumber, associatedData)
+}
+
+// Seal a message, call LockForSealing() before!
+func (h *cryptoSetupClient) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
+	if h.forwardSecureAEAD != nil {
+		return h.forwardSecureAEAD.Seal(dst, src, packetNumber, associatedData)
+	}
+	if h.secureAEAD != nil {
+		return h.secureAEAD.Seal(dst, src, packetNumber, associatedData)
+	}
+	return (&crypto.NullAEAD{}).Seal(dst, src, packetNumber, associatedData)
+}
+
+// DiversificationNonce is only needed for the server
+func (h *cryptoSetupClient) DiversificationNonce() []byte {
+	return nil
+}
+
+// SetDiversificationNonce sets the diversification nonce sent by the server
+// The initial encryption can only be established once this nonce is known.
+func (h *cryptoSetupClient) SetDiversificationNonce(data []byte) error {
+	h.mutex.Lock()
+	if len(h.diversificationNonce) > 0 {
+		defer h.mutex.Unlock()
+		if !bytes.Equal(h.diversificationNonce, data) {
+			return errConflictingDiversificationNonces
+		}
+		return nil
+	}
+	h.diversificationNonce = data
+	h.mutex.Unlock()
+
+	return h.maybeUpgradeCrypto()
+}
+
+// LockForSealing should be called before Seal(). It is needed so that the AEADs are not changed while a packet is sealed.
+func (h *cryptoSetupClient) LockForSealing() {
+	h.mutex.RLock()
+}
+
+// UnlockForSealing should be called after Seal() is complete, see LockForSealing().
+func (h *cryptoSetupClient) UnlockForSealing() {
+	h.mutex.RUnlock()
+}
+
+// PublicResetNonceProof returns the nonce proof that a public reset sent by the server has to contain
+func (h *cryptoSetupClient) PublicResetNonceProof() uint64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if len(h.nonc) == 0 {
+		return 0
+	}
+	return crypto.DerivePublicResetNonceProof(h.nonc, h.connID)
+}
+
+// HandshakeComplete returns true after the forward secure keys have been derived from the SHLO
+func (h *cryptoSetupClient) HandshakeComplete() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.forwardSecureAEAD != nil
+}
+
+func (h *cryptoSetupClient) sendCHLO() error {
+	h.clientHelloCounter++
+	if h.clientHelloCounter > protocol.MaxClientHellos {
+		return qerr.Error(qerr.CryptoTooManyRejects, fmt.Sprintf("More than %d rejects", protocol.MaxClientHellos))
+	}
+
+	b := &bytes.Buffer{}
+
+	tags, err := h.getTags()
+	if err != nil {
+		return err
+	}
+	h.addPadding(tags)
+
+	utils.Debugf("Sending CHLO:\n%s", printHandshakeMessage(tags))
+	WriteHandshakeMessage(b, TagCHLO, tags)
+
+	h.mutex.Lock()
+	h.lastSentCHLO = b.Bytes()
+	h.mutex.Unlock()
+
+	_, err = h.cryptoStream.Write(b.Bytes())
+	return err
+}
+
+func (h *cryptoSetupClient) getTags() (map[Tag][]byte, error) {
+	tags := h.connectionParameters.GetHelloMap()
+	tags[TagSNI] = []byte(h.hostname)
+	tags[TagPDMD] = []byte("X509")
+
+	versionTag := make([]byte, 4)
+	binary.LittleEndian.PutUint32(versionTag, protocol.VersionNumberToTag(h.version))
+	tags[TagVER] = versionTag
+
+	if len(h.stk) > 0 {
+		tags[TagSTK] = h.stk
+	}
+	if len(h.sno) > 0 {
+		tags[TagSNO] = h.sno
+	}
+
+	if h.serverConfig != nil {
+		tags[TagSCID] = h.serverConfig.ID
+
+		h.mutex.RLock()
+		serverVerified := h.serverVerified
+		h.mutex.RUnlock()
+
+		// only send a full CHLO once the server's identity has been verified
+		if serverVerified {
+			if len(h.nonc) == 0 {
+				if err := h.generateClientNonce(); err != nil {
+					return nil, err
+				}
+			}
+			tags[TagNONC] = h.nonc
+			tags[TagKEXS] = h.serverConfig.kexs
+			tags[TagAEAD] = h.serverConfig.aead
+			tags[TagPUBS] = h.serverConfig.kex.PublicKey()
+		}
+	}
+
+	return tags, nil
+}
+
+// add a TagPAD to a tagMap, such that the total size will be bigger than the ClientHelloMinimumSize
+func (h *cryptoSetupClient) addPadding(tags map[Tag][]byte) {
+	var size int
+	for _, tag := range tags {
+		size += 8 + len(tag) // 4 bytes for the tag + 4 bytes for the offset + the length of the data
+	}
+	paddingSize := protocol.ClientHelloMinimumSize - size
+	if paddingSize > 0 {
+		tags[TagPAD] = bytes.Repeat([]byte{0}, paddingSize)
+	}
+}
+
+func (h *cryptoSetupClient) maybeUpgradeCrypto() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.serverVerified || h.secureAEAD != nil {
+		return nil
+	}
+
+	leafCert := h.certManager.GetLeafCert()
+	// the server's initial key can only be derived after receiving the diversification nonce
+	if len(h.nonc) == 0 || len(h.diversificationNonce) == 0 || leafCert == nil {
+		return nil
+	}
+
+	var err error
+	h.secureAEAD, err = h.keyDerivations[string(h.serverConfig.aead)](
+		false,
+		h.serverConfig.sharedSecret,
+		h.nonc,
+		h.connID,
+		h.lastSentCHLO,
+		h.serverConfig.Get(),
+		leafCert,
+		h.diversificationNonce,
+		protocol.PerspectiveClient,
+	)
+	if err != nil {
+		return err
+	}
+
+	h.signalAEADChanged()
+	return nil
+}
+
+// signalAEADChanged notifies the session that the AEAD changed
+// The channel has a capacity of 1, so the notification is dropped if one is already pending.
+func (h *cryptoSetupClient) signalAEADChanged() {
+	select {
+	case h.aeadChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (h *cryptoSetupClient) generateClientNonce() error {
+	if len(h.nonc) > 0 {
+		return errClientNonceAlreadyExists
+	}
+
+	nonc := make([]byte, 32)
+	binary.BigEndian.PutUint32(nonc, uint32(time.Now().Unix()))
+
+	if len(h.serverConfig.obit) != 8 {
+		return errNoObitForClientNonce
+	}
+
+	copy(nonc[4:12], h.serverConfig.obit)
+
+	_, err := rand.Read(nonc[12:])
+	if err != nil {
+		return err
+	}
+
+	// the nonce is read by PublicResetNonceProof, which is called from the session's run loop
+	h.mutex.Lock()
+	h.nonc = nonc
+	h.mutex.Unlock()
+	return nil
+}
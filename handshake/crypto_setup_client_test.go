@@ -0,0 +1,503 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockCertManager struct {
+	setDataCalledWith []byte
+	setDataError      error
+
+	leafCert []byte
+
+	verifyServerProofResult bool
+	verifyServerProofCalled bool
+
+	verifyError  error
+	verifyCalled bool
+}
+
+func (m *mockCertManager) SetData(data []byte) error {
+	m.setDataCalledWith = data
+	return m.setDataError
+}
+
+func (m *mockCertManager) GetLeafCert() []byte {
+	return m.leafCert
+}
+
+func (m *mockCertManager) VerifyServerProof(proof, chlo, serverConfigData []byte) bool {
+	m.verifyServerProofCalled = true
+	return m.verifyServerProofResult
+}
+
+func (m *mockCertManager) Verify(hostname string) error {
+	m.verifyCalled = true
+	return m.verifyError
+}
+
+var _ = Describe("Client Crypto Setup", func() {
+	var (
+		cs          *cryptoSetupClient
+		certManager *mockCertManager
+		stream      *mockStream
+		aeadChanged chan struct{}
+	)
+
+	BeforeEach(func() {
+		expectedInitialNonceLen = 32
+		expectedFSNonceLen = 64
+		stream = &mockStream{}
+		certManager = &mockCertManager{}
+		aeadChanged = make(chan struct{}, 1)
+		csInt, err := NewCryptoSetupClient("hostname", 0, protocol.Version34, stream, nil, newDefaultConnectionParametersManager(), aeadChanged)
+		Expect(err).ToNot(HaveOccurred())
+		cs = csInt.(*cryptoSetupClient)
+		cs.certManager = certManager
+		cs.keyDerivations = map[string]KeyDerivationFunction{"AESG": mockKeyDerivation, "CC20": mockKeyDerivation}
+	})
+
+	Context("Reading REJ", func() {
+		var tagMap map[Tag][]byte
+
+		BeforeEach(func() {
+			tagMap = make(map[Tag][]byte)
+		})
+
+		It("rejects handshake messages with the wrong message tag", func() {
+			WriteHandshakeMessage(&stream.dataToRead, TagCHLO, tagMap)
+			err := cs.HandleCryptoStream()
+			Expect(err).To(MatchError(qerr.InvalidCryptoMessageType))
+		})
+
+		It("errors on invalid handshake messages", func() {
+			b := &bytes.Buffer{}
+			WriteHandshakeMessage(b, TagCHLO, tagMap)
+			stream.dataToRead.Write(b.Bytes()[:b.Len()-2]) // cut the handshake message
+			err := cs.HandleCryptoStream()
+			Expect(err).To(MatchError(qerr.HandshakeFailed))
+		})
+
+		It("passes the message on for parsing, and reads the source address token", func() {
+			stk := []byte("foobar")
+			tagMap[TagSTK] = stk
+			WriteHandshakeMessage(&stream.dataToRead, TagREJ, tagMap)
+			// the stream doesn't contain any more data, so HandleCryptoStream returns an error after handling the REJ
+			err := cs.HandleCryptoStream()
+			Expect(err).To(MatchError(qerr.HandshakeFailed))
+			Expect(cs.stk).Should(Equal(stk))
+		})
+
+		It("saves the server nonce", func() {
+			tagMap[TagSNO] = []byte("server nonce")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.sno).To(Equal(tagMap[TagSNO]))
+		})
+
+		It("generates a new client nonce after a full CHLO was rejected", func() {
+			cs.nonc = []byte("client nonce")
+			tagMap[TagRREJ] = []byte{0x3, 0x0, 0x0, 0x0}
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.nonc).To(BeEmpty())
+		})
+
+		It("saves the proof and the CHLO it was calculated for", func() {
+			cs.lastSentCHLO = []byte("last CHLO")
+			tagMap[TagPROF] = []byte("proof")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.proof).To(Equal(tagMap[TagPROF]))
+			Expect(cs.chloForSignature).To(Equal(cs.lastSentCHLO))
+		})
+
+		It("saves the server config", func() {
+			b := &bytes.Buffer{}
+			WriteHandshakeMessage(b, TagSCFG, getDefaultServerConfigClient())
+			tagMap[TagSCFG] = b.Bytes()
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.serverConfig).ToNot(BeNil())
+			Expect(cs.serverConfig.Get()).To(Equal(b.Bytes()))
+		})
+
+		It("rejects invalid server configs", func() {
+			scfg := getDefaultServerConfigClient()
+			scfg[TagSCID] = []byte("foo")
+			b := &bytes.Buffer{}
+			WriteHandshakeMessage(b, TagSCFG, scfg)
+			tagMap[TagSCFG] = b.Bytes()
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "SCID")))
+		})
+
+		It("rejects expired server configs", func() {
+			scfg := getDefaultServerConfigClient()
+			scfg[TagEXPY] = []byte{0x80, 0x54, 0x72, 0x4F, 0, 0, 0, 0} // 2012-03-28
+			b := &bytes.Buffer{}
+			WriteHandshakeMessage(b, TagSCFG, scfg)
+			tagMap[TagSCFG] = b.Bytes()
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).To(MatchError(qerr.CryptoServerConfigExpired))
+		})
+
+		It("passes the certificates to the CertManager", func() {
+			tagMap[TagCERT] = []byte("cert")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(certManager.setDataCalledWith).To(Equal(tagMap[TagCERT]))
+			Expect(certManager.verifyCalled).To(BeTrue())
+		})
+
+		It("returns an InvalidCryptoMessageParameter error if it can't parse the cert chain", func() {
+			tagMap[TagCERT] = []byte("cert")
+			certManager.setDataError = errors.New("can't parse")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidCryptoMessageParameter, "Certificate data invalid")))
+		})
+
+		It("returns a ProofInvalid error if the certificate chain is not valid", func() {
+			tagMap[TagCERT] = []byte("cert")
+			certManager.verifyError = errors.New("invalid")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).To(MatchError(qerr.ProofInvalid))
+		})
+
+		Context("verifying the server proof", func() {
+			BeforeEach(func() {
+				b := &bytes.Buffer{}
+				WriteHandshakeMessage(b, TagSCFG, getDefaultServerConfigClient())
+				tagMap[TagSCFG] = b.Bytes()
+				tagMap[TagPROF] = []byte("proof")
+				certManager.leafCert = []byte("leafcert")
+			})
+
+			It("marks the server as verified if the proof is valid", func() {
+				certManager.verifyServerProofResult = true
+				err := cs.handleREJMessage(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(certManager.verifyServerProofCalled).To(BeTrue())
+				Expect(cs.serverVerified).To(BeTrue())
+			})
+
+			It("returns a ProofInvalid error if the proof is invalid", func() {
+				certManager.verifyServerProofResult = false
+				err := cs.handleREJMessage(tagMap)
+				Expect(err).To(MatchError(qerr.ProofInvalid))
+				Expect(cs.serverVerified).To(BeFalse())
+			})
+		})
+	})
+
+	Context("CHLO generation", func() {
+		It("is longer than the minimum client hello size", func() {
+			err := cs.sendCHLO()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stream.dataWritten.Len()).To(BeNumerically(">", protocol.ClientHelloMinimumSize))
+		})
+
+		It("saves the last sent CHLO", func() {
+			err := cs.sendCHLO()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.lastSentCHLO).To(Equal(stream.dataWritten.Bytes()))
+		})
+
+		It("doesn't send more than MaxClientHellos CHLOs", func() {
+			for i := 0; i < protocol.MaxClientHellos; i++ {
+				err := cs.sendCHLO()
+				Expect(err).ToNot(HaveOccurred())
+			}
+			err := cs.sendCHLO()
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoTooManyRejects, "More than 3 rejects")))
+		})
+
+		It("has the right values for an inchoate CHLO", func() {
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags[TagSNI]).To(Equal([]byte("hostname")))
+			Expect(tags[TagPDMD]).To(Equal([]byte("X509")))
+			Expect(tags[TagVER]).To(Equal([]byte("Q034")))
+			Expect(tags).ToNot(HaveKey(TagSCID))
+			Expect(tags).ToNot(HaveKey(TagNONC))
+		})
+
+		It("includes the connection parameters", func() {
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags).To(HaveKey(TagCFCW))
+			Expect(tags).To(HaveKey(TagSFCW))
+			Expect(tags).To(HaveKey(TagICSL))
+		})
+
+		It("includes the source address token and the server nonce", func() {
+			cs.stk = []byte("stk")
+			cs.sno = []byte("sno")
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags[TagSTK]).To(Equal(cs.stk))
+			Expect(tags[TagSNO]).To(Equal(cs.sno))
+		})
+
+		It("includes the server config id, but doesn't send a full CHLO before the server was verified", func() {
+			cs.serverConfig = &serverConfigClient{ID: []byte("foobar")}
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags[TagSCID]).To(Equal(cs.serverConfig.ID))
+			Expect(tags).ToNot(HaveKey(TagNONC))
+			Expect(tags).ToNot(HaveKey(TagPUBS))
+		})
+
+		It("sends a full CHLO after the server was verified", func() {
+			kex, err := crypto.NewCurve25519KEX()
+			Expect(err).ToNot(HaveOccurred())
+			cs.serverConfig = &serverConfigClient{
+				ID:   []byte("foobar"),
+				obit: []byte("obitobit"),
+				kex:  kex,
+				kexs: []byte("C255"),
+				aead: []byte("AESG"),
+			}
+			cs.serverVerified = true
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags[TagNONC]).To(HaveLen(32))
+			Expect(tags[TagNONC]).To(Equal(cs.nonc))
+			Expect(tags[TagKEXS]).To(Equal([]byte("C255")))
+			Expect(tags[TagAEAD]).To(Equal([]byte("AESG")))
+			Expect(tags[TagPUBS]).To(Equal(kex.PublicKey()))
+		})
+
+		It("sends the AEAD chosen from the server config", func() {
+			kex, err := crypto.NewCurve25519KEX()
+			Expect(err).ToNot(HaveOccurred())
+			cs.serverConfig = &serverConfigClient{
+				ID:   []byte("foobar"),
+				obit: []byte("obitobit"),
+				kex:  kex,
+				kexs: []byte("C255"),
+				aead: []byte("CC20"),
+			}
+			cs.serverVerified = true
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags[TagAEAD]).To(Equal([]byte("CC20")))
+		})
+	})
+
+	Context("client nonce generation", func() {
+		BeforeEach(func() {
+			cs.serverConfig = &serverConfigClient{obit: []byte{0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8}}
+		})
+
+		It("generates a client nonce", func() {
+			now := time.Now()
+			err := cs.generateClientNonce()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.nonc).To(HaveLen(32))
+			Expect(time.Unix(int64(binary.BigEndian.Uint32(cs.nonc[0:4])), 0)).To(BeTemporally("~", now, 1*time.Second))
+			Expect(cs.nonc[4:12]).To(Equal(cs.serverConfig.obit))
+		})
+
+		It("uses random values for the last 20 bytes", func() {
+			err := cs.generateClientNonce()
+			Expect(err).ToNot(HaveOccurred())
+			nonce1 := cs.nonc
+			cs.nonc = []byte{}
+			err = cs.generateClientNonce()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nonce1[0:12]).To(Equal(cs.nonc[0:12]))
+			Expect(nonce1[12:]).ToNot(Equal(cs.nonc[12:]))
+		})
+
+		It("errors if a client nonce has already been generated", func() {
+			err := cs.generateClientNonce()
+			Expect(err).ToNot(HaveOccurred())
+			err = cs.generateClientNonce()
+			Expect(err).To(MatchError(errClientNonceAlreadyExists))
+		})
+
+		It("errors if no OBIT value is available", func() {
+			cs.serverConfig.obit = []byte{}
+			err := cs.generateClientNonce()
+			Expect(err).To(MatchError(errNoObitForClientNonce))
+		})
+
+		It("derives the public reset nonce proof from the client nonce", func() {
+			Expect(cs.PublicResetNonceProof()).To(BeZero())
+			err := cs.generateClientNonce()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.PublicResetNonceProof()).To(Equal(crypto.DerivePublicResetNonceProof(cs.nonc, cs.connID)))
+		})
+	})
+
+	Context("key derivation", func() {
+		BeforeEach(func() {
+			cs.serverConfig = &serverConfigClient{
+				kex:          &mockKEX{},
+				sharedSecret: []byte("shared key"),
+				aead:         []byte("AESG"),
+			}
+			cs.serverVerified = true
+			cs.nonc = bytes.Repeat([]byte{'n'}, 32)
+			certManager.leafCert = []byte("leafcert")
+		})
+
+		It("doesn't derive the initial keys before receiving the diversification nonce", func() {
+			err := cs.maybeUpgradeCrypto()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.secureAEAD).To(BeNil())
+		})
+
+		It("derives the initial keys after receiving the diversification nonce", func() {
+			err := cs.SetDiversificationNonce([]byte("div"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.secureAEAD).ToNot(BeNil())
+			Expect(aeadChanged).To(Receive())
+		})
+
+		It("uses the key derivation for the AEAD chosen from the server config", func() {
+			var usedChacha bool
+			cs.keyDerivations["CC20"] = func(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (crypto.AEAD, error) {
+				usedChacha = true
+				return mockKeyDerivation(forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, pers)
+			}
+			cs.serverConfig.aead = []byte("CC20")
+			err := cs.SetDiversificationNonce([]byte("div"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.secureAEAD).ToNot(BeNil())
+			Expect(usedChacha).To(BeTrue())
+		})
+
+		It("doesn't derive the initial keys before the server was verified", func() {
+			cs.serverVerified = false
+			err := cs.SetDiversificationNonce([]byte("div"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.secureAEAD).To(BeNil())
+		})
+
+		It("accepts the same diversification nonce twice", func() {
+			err := cs.SetDiversificationNonce([]byte("div"))
+			Expect(err).ToNot(HaveOccurred())
+			err = cs.SetDiversificationNonce([]byte("div"))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rejects a different diversification nonce", func() {
+			err := cs.SetDiversificationNonce([]byte("div1"))
+			Expect(err).ToNot(HaveOccurred())
+			err = cs.SetDiversificationNonce([]byte("div2"))
+			Expect(err).To(MatchError(errConflictingDiversificationNonces))
+		})
+
+		Context("reading the SHLO", func() {
+			var tagMap map[Tag][]byte
+
+			BeforeEach(func() {
+				tagMap = map[Tag][]byte{
+					TagPUBS: []byte("pubs"),
+					TagSNO:  bytes.Repeat([]byte{'s'}, 32),
+				}
+				err := cs.SetDiversificationNonce([]byte("div"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(aeadChanged).To(Receive())
+			})
+
+			It("rejects unencrypted SHLOs", func() {
+				err := cs.handleSHLOMessage(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoEncryptionLevelIncorrect, "unencrypted SHLO message")))
+				Expect(cs.HandshakeComplete()).To(BeFalse())
+			})
+
+			It("derives the forward secure keys", func() {
+				cs.receivedSecurePacket = true
+				err := cs.handleSHLOMessage(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.forwardSecureAEAD).ToNot(BeNil())
+				Expect(cs.HandshakeComplete()).To(BeTrue())
+				Expect(aeadChanged).To(Receive())
+			})
+
+			It("errors if the SHLO doesn't contain PUBS", func() {
+				cs.receivedSecurePacket = true
+				delete(tagMap, TagPUBS)
+				err := cs.handleSHLOMessage(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "PUBS")))
+			})
+
+			It("errors if the SHLO doesn't contain a server nonce", func() {
+				cs.receivedSecurePacket = true
+				delete(tagMap, TagSNO)
+				err := cs.handleSHLOMessage(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "SNO")))
+			})
+
+			It("reads the connection parameters", func() {
+				cs.receivedSecurePacket = true
+				tagMap[TagICSL] = []byte{3, 0, 0, 0} // 3 seconds
+				err := cs.handleSHLOMessage(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.connectionParameters.GetIdleConnectionStateLifetime()).To(Equal(3 * time.Second))
+			})
+		})
+	})
+
+	Context("encryption", func() {
+		It("uses the null AEAD before the initial keys are derived", func() {
+			cs.LockForSealing()
+			d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+			cs.UnlockForSealing()
+			Expect(d).To(Equal((&crypto.NullAEAD{}).Seal(nil, []byte("foobar"), 0, []byte{})))
+			d, err := cs.Open(nil, d, 0, []byte{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d).To(Equal([]byte("foobar")))
+		})
+
+		It("uses the initial keys for sealing and opening", func() {
+			cs.secureAEAD = &mockAEAD{}
+			d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+			Expect(d).To(Equal([]byte("foobar  normal sec")))
+			d, err := cs.Open(nil, []byte("encrypted"), 0, []byte{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d).To(Equal([]byte("decrypted")))
+			Expect(cs.receivedSecurePacket).To(BeTrue())
+		})
+
+		It("doesn't accept unencrypted packets after receiving an encrypted packet", func() {
+			cs.secureAEAD = &mockAEAD{}
+			_, err := cs.Open(nil, []byte("encrypted"), 0, []byte{})
+			Expect(err).ToNot(HaveOccurred())
+			unencrypted := (&crypto.NullAEAD{}).Seal(nil, []byte("foobar"), 0, []byte{})
+			_, err = cs.Open(nil, unencrypted, 0, []byte{})
+			Expect(err).To(MatchError("authentication failed"))
+		})
+
+		It("uses the forward secure keys once they are available", func() {
+			cs.secureAEAD = &mockAEAD{}
+			cs.forwardSecureAEAD = &mockAEAD{forwardSecure: true}
+			d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+			Expect(d).To(Equal([]byte("foobar forward sec")))
+			d, err := cs.Open(nil, []byte("forward secure encrypted"), 0, []byte{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d).To(Equal([]byte("decrypted")))
+		})
+
+		It("still accepts packets sealed with the initial keys after the forward secure keys are available", func() {
+			cs.secureAEAD = &mockAEAD{}
+			cs.forwardSecureAEAD = &mockAEAD{forwardSecure: true}
+			d, err := cs.Open(nil, []byte("encrypted"), 0, []byte{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d).To(Equal([]byte("decrypted")))
+		})
+	})
+})
@@ -0,0 +1,461 @@
+package handshake
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// KeyDerivationFunction is used for key derivation
+type KeyDerivationFunction func(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (crypto.AEAD, error)
+
+// KeyExchangeFunction is used to get a KEX for the key exchange algorithm chosen by the client
+type KeyExchangeFunction func(kexs string) crypto.KeyExchange
+
+// supportedKEXs are the key exchange algorithms a server config can offer, in order of preference
+var supportedKEXs = []byte("C255P256")
+
+// kexConstructors maps the supported key exchange algorithms to the function creating a new key
+var kexConstructors = map[string]func() (crypto.KeyExchange, error){
+	"C255": crypto.NewCurve25519KEX,
+	"P256": crypto.NewP256KEX,
+}
+
+// kexImporters maps the supported key exchange algorithms to the function recreating a key from its private key
+var kexImporters = map[string]func(secret []byte) (crypto.KeyExchange, error){
+	"C255": crypto.NewCurve25519KEXFromPrivateKey,
+	"P256": crypto.NewP256KEXFromPrivateKey,
+}
+
+// supportedAEADs are the AEADs offered in the server config, in order of preference
+var supportedAEADs = []byte("AESGCC20")
+
+// keyDerivations maps the supported AEADs to the matching key derivation function
+var keyDerivations = map[string]KeyDerivationFunction{
+	"AESG": crypto.DeriveKeysAESGCM,
+	"CC20": crypto.DeriveKeysChacha20,
+}
+
+// The cryptoSetupServer handles all things crypto for the Session
+type cryptoSetupServer struct {
+	connID               protocol.ConnectionID
+	sourceAddr           []byte
+	version              protocol.VersionNumber
+	scfgs                *ServerConfigStore
+	scfg                 *ServerConfig // the server config used for the 0-RTT handshake
+	diversificationNonce []byte
+	clientNonce          []byte
+
+	secureAEAD                  crypto.AEAD
+	forwardSecureAEAD           crypto.AEAD
+	receivedForwardSecurePacket bool
+	receivedSecurePacket        bool
+	aeadChanged                 chan struct{}
+
+	keyDerivations map[string]KeyDerivationFunction
+	keyExchange    KeyExchangeFunction
+
+	cryptoStream utils.Stream
+
+	connectionParametersManager *ConnectionParametersManager
+
+	mutex sync.RWMutex
+}
+
+var _ crypto.AEAD = &cryptoSetupServer{}
+
+// NewCryptoSetup creates a new CryptoSetup instance for a server
+func NewCryptoSetup(
+	connID protocol.ConnectionID,
+	sourceAddr []byte,
+	version protocol.VersionNumber,
+	scfgs *ServerConfigStore,
+	cryptoStream utils.Stream,
+	connectionParametersManager *ConnectionParametersManager,
+	aeadChanged chan struct{},
+) (CryptoSetup, error) {
+	return &cryptoSetupServer{
+		connID:                      connID,
+		sourceAddr:                  sourceAddr,
+		version:                     version,
+		scfgs:                       scfgs,
+		keyDerivations:              keyDerivations,
+		keyExchange:                 getEphermalKEX,
+		cryptoStream:                cryptoStream,
+		connectionParametersManager: connectionParametersManager,
+		aeadChanged:                 aeadChanged,
+	}, nil
+}
+
+// HandleCryptoStream reads and writes messages on the crypto stream
+func (h *cryptoSetupServer) HandleCryptoStream() error {
+	for {
+		var chloData bytes.Buffer
+		messageTag, cryptoData, err := ParseHandshakeMessage(io.TeeReader(h.cryptoStream, &chloData))
+		if err != nil {
+			return qerr.HandshakeFailed
+		}
+		if messageTag != TagCHLO {
+			return qerr.InvalidCryptoMessageType
+		}
+
+		utils.Debugf("Got CHLO:\n%s", printHandshakeMessage(cryptoData))
+
+		done, err := h.handleMessage(chloData.Bytes(), cryptoData)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+func (h *cryptoSetupServer) handleMessage(chloData []byte, cryptoData map[Tag][]byte) (bool, error) {
+	sniSlice, ok := cryptoData[TagSNI]
+	if !ok {
+		return false, qerr.Error(qerr.CryptoMessageParameterNotFound, "SNI required")
+	}
+	sni := string(sniSlice)
+	if sni == "" {
+		return false, qerr.Error(qerr.CryptoMessageParameterNotFound, "SNI required")
+	}
+
+	var reply []byte
+	var err error
+	rejectionReason := handshakeOK
+	if scfg := h.findServerConfig(cryptoData); scfg != nil {
+		now := time.Now()
+		// make sure that this CHLO is not a replay, by checking that its client nonce wasn't used before
+		rejectionReason = h.scfgs.strikeRegister.Insert(cryptoData[TagNONC], now)
+		// the client nonce might not be newer than the horizon, e.g. right after a restart of the server, or the shared strike register might be unavailable
+		// a server nonce proves that the CHLO was sent in response to one of our REJs
+		if sno, ok := cryptoData[TagSNO]; ok && (rejectionReason == clientNonceInvalidTimeFailure || rejectionReason == clientNonceStrikeRegisterFailure) {
+			rejectionReason = h.scfgs.serverNonces.Verify(sno, now)
+		}
+		if rejectionReason == handshakeOK {
+			// We have a CHLO with the ID of a server config that didn't expire, do a 0-RTT handshake
+			h.scfg = scfg
+			reply, err = h.handleCHLO(sni, chloData, cryptoData)
+			if err != nil {
+				return false, err
+			}
+			_, err = h.cryptoStream.Write(reply)
+			if err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+		utils.Infof("Rejecting CHLO for connection %x: %s", h.connID, rejectionReason)
+	}
+
+	// We have an inchoate or non-matching CHLO, we now send a rejection
+	reply, err = h.handleInchoateCHLO(sni, chloData, cryptoData, rejectionReason)
+	if err != nil {
+		return false, err
+	}
+	_, err = h.cryptoStream.Write(reply)
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// negotiateKEX returns the key exchange algorithm the client chose in the CHLO
+// The client has to choose exactly one of the key exchanges offered in the server config.
+func (h *cryptoSetupServer) negotiateKEX(cryptoData map[Tag][]byte) (string, error) {
+	kexs, ok := cryptoData[TagKEXS]
+	if !ok {
+		return "", qerr.Error(qerr.CryptoMessageParameterNotFound, "KEXS")
+	}
+	if len(kexs) != 4 {
+		return "", qerr.Error(qerr.CryptoInvalidValueLength, "KEXS")
+	}
+	if _, ok := h.scfg.kexs[string(kexs)]; !ok {
+		return "", qerr.Error(qerr.CryptoNoSupport, "KEXS")
+	}
+	return string(kexs), nil
+}
+
+// negotiateAEAD returns the key derivation function for the AEAD the client chose in the CHLO
+// The client has to choose exactly one of the AEADs offered in the server config.
+func (h *cryptoSetupServer) negotiateAEAD(cryptoData map[Tag][]byte) (KeyDerivationFunction, error) {
+	aead, ok := cryptoData[TagAEAD]
+	if !ok {
+		return nil, qerr.Error(qerr.CryptoMessageParameterNotFound, "AEAD")
+	}
+	if len(aead) != 4 {
+		return nil, qerr.Error(qerr.CryptoInvalidValueLength, "AEAD")
+	}
+	keyDerivation, ok := h.keyDerivations[string(aead)]
+	if !ok || !containsTag(supportedAEADs, string(aead)) {
+		return nil, qerr.Error(qerr.CryptoNoSupport, "AEAD")
+	}
+	return keyDerivation, nil
+}
+
+// Open a message
+func (h *cryptoSetupServer) Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
+	// Open sets receivedSecurePacket and receivedForwardSecurePacket, so it needs the write lock
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.forwardSecureAEAD != nil {
+		res, err := h.forwardSecureAEAD.Open(dst, src, packetNumber, associatedData)
+		if err == nil {
+			if !h.receivedForwardSecurePacket {
+				// this is the first forward secure packet, the handshake is now complete
+				h.receivedForwardSecurePacket = true
+				// Open is called from the session's run loop, so this must not block
+				select {
+				case h.aeadChanged <- struct{}{}:
+				default:
+				}
+			}
+			return res, nil
+		}
+		if h.receivedForwardSecurePacket {
+			return nil, err
+		}
+	}
+	if h.secureAEAD != nil {
+		res, err := h.secureAEAD.Open(dst, src, packetNumber, associatedData)
+		if err == nil {
+			h.receivedSecurePacket = true
+			return res, nil
+		}
+		if h.receivedSecurePacket {
+			return nil, err
+		}
+	}
+	return (&crypto.NullAEAD{}).Open(dst, src, packetNumber, associatedData)
+}
+
+// Seal a message, call LockForSealing() before!
+func (h *cryptoSetupServer) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
+	if h.receivedForwardSecurePacket {
+		return h.forwardSecureAEAD.Seal(dst, src, packetNumber, associatedData)
+	} else if h.secureAEAD != nil {
+		return h.secureAEAD.Seal(dst, src, packetNumber, associatedData)
+	} else {
+		return (&crypto.NullAEAD{}).Seal(dst, src, packetNumber, associatedData)
+	}
+}
+
+// findServerConfig returns the server config referenced by the SCID of a full CHLO
+// It returns nil if the CHLO is inchoate, i.e. if it doesn't reference a server config that didn't expire yet, or if the STK is invalid.
+func (h *cryptoSetupServer) findServerConfig(cryptoData map[Tag][]byte) *ServerConfig {
+	scid, ok := cryptoData[TagSCID]
+	if !ok {
+		return nil
+	}
+	scfg := h.scfgs.Get(scid)
+	if scfg == nil {
+		return nil
+	}
+	if _, ok := cryptoData[TagPUBS]; !ok {
+		return nil
+	}
+	if err := h.scfgs.stkSource.VerifyToken(h.sourceAddr, cryptoData[TagSTK]); err != nil {
+		utils.Infof("STK invalid: %s", err.Error())
+		return nil
+	}
+	return scfg
+}
+
+// handleInchoateCHLO creates the REJ for a CHLO
+// If a full CHLO was rejected, the reason is sent to the client in the RREJ.
+func (h *cryptoSetupServer) handleInchoateCHLO(sni string, chlo []byte, cryptoData map[Tag][]byte, rejectionReason handshakeFailureReason) ([]byte, error) {
+	if len(chlo) < protocol.ClientHelloMinimumSize {
+		return nil, qerr.Error(qerr.CryptoInvalidValueLength, "CHLO too small")
+	}
+
+	token, err := h.scfgs.stkSource.NewToken(h.sourceAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	serverNonce, err := h.scfgs.serverNonces.New(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// the REJ always contains the primary server config, even if the client referenced an older one
+	scfg := h.scfgs.Primary()
+	replyMap := map[Tag][]byte{
+		TagSCFG: scfg.Get(),
+		TagSTK:  token,
+		TagSNO:  serverNonce,
+		TagSVID: []byte("quic-go"),
+	}
+	if rejectionReason != handshakeOK {
+		rrej := make([]byte, 4)
+		binary.LittleEndian.PutUint32(rrej, uint32(rejectionReason))
+		replyMap[TagRREJ] = rrej
+	}
+
+	if h.scfgs.stkSource.VerifyToken(h.sourceAddr, cryptoData[TagSTK]) == nil {
+		proof, err := scfg.Sign(sni, chlo)
+		if err != nil {
+			return nil, err
+		}
+
+		commonSetHashes := cryptoData[TagCCS]
+		cachedCertsHashes := cryptoData[TagCCRT]
+
+		certCompressed, err := scfg.GetCertsCompressed(sni, commonSetHashes, cachedCertsHashes)
+		if err != nil {
+			return nil, err
+		}
+		// Token was valid, send more details
+		replyMap[TagPROF] = proof
+		replyMap[TagCERT] = certCompressed
+	}
+
+	var serverReply bytes.Buffer
+	WriteHandshakeMessage(&serverReply, TagREJ, replyMap)
+	return serverReply.Bytes(), nil
+}
+
+func (h *cryptoSetupServer) handleCHLO(sni string, data []byte, cryptoData map[Tag][]byte) ([]byte, error) {
+	kexs, err := h.negotiateKEX(cryptoData)
+	if err != nil {
+		return nil, err
+	}
+	keyDerivation, err := h.negotiateAEAD(cryptoData)
+	if err != nil {
+		return nil, err
+	}
+
+	// We have a CHLO matching our server config, we can continue with the 0-RTT handshake
+	sharedSecret, err := h.scfg.kexs[kexs].CalculateSharedKey(cryptoData[TagPUBS])
+	if err != nil {
+		return nil, err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	certUncompressed, err := h.scfg.signer.GetLeafCert(sni)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 32)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	h.diversificationNonce = make([]byte, 32)
+	if _, err = rand.Read(h.diversificationNonce); err != nil {
+		return nil, err
+	}
+	h.clientNonce = cryptoData[TagNONC]
+
+	h.secureAEAD, err = keyDerivation(
+		false,
+		sharedSecret,
+		cryptoData[TagNONC],
+		h.connID,
+		data,
+		h.scfg.Get(),
+		certUncompressed,
+		h.diversificationNonce,
+		protocol.PerspectiveServer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate a new curve instance to derive the forward secure key
+	var fsNonce bytes.Buffer
+	fsNonce.Write(cryptoData[TagNONC])
+	fsNonce.Write(nonce)
+	ephermalKex := h.keyExchange(kexs)
+	if ephermalKex == nil {
+		return nil, qerr.Error(qerr.CryptoInternalError, "no ephermal KEX")
+	}
+	ephermalSharedSecret, err := ephermalKex.CalculateSharedKey(cryptoData[TagPUBS])
+	if err != nil {
+		return nil, err
+	}
+	h.forwardSecureAEAD, err = keyDerivation(
+		true,
+		ephermalSharedSecret,
+		fsNonce.Bytes(),
+		h.connID,
+		data,
+		h.scfg.Get(),
+		certUncompressed,
+		nil,
+		protocol.PerspectiveServer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.connectionParametersManager.SetFromMap(cryptoData)
+	if err != nil {
+		return nil, err
+	}
+
+	replyMap := h.connectionParametersManager.GetHelloMap()
+	// add crypto parameters
+	replyMap[TagPUBS] = ephermalKex.PublicKey()
+	replyMap[TagSNO] = nonce
+	replyMap[TagVER] = protocol.SupportedVersionsAsTags
+
+	var reply bytes.Buffer
+	WriteHandshakeMessage(&reply, TagSHLO, replyMap)
+
+	h.aeadChanged <- struct{}{}
+
+	return reply.Bytes(), nil
+}
+
+// DiversificationNonce returns a diversification nonce if required in the next packet to be Seal'ed. See LockForSealing()!
+func (h *cryptoSetupServer) DiversificationNonce() []byte {
+	if h.receivedForwardSecurePacket || h.secureAEAD == nil {
+		return nil
+	}
+	return h.diversificationNonce
+}
+
+// SetDiversificationNonce is only needed for the client
+func (h *cryptoSetupServer) SetDiversificationNonce(data []byte) error {
+	panic("not needed for cryptoSetupServer")
+}
+
+// LockForSealing should be called before Seal(). It is needed so that diversification nonces can be obtained before packets are sealed, and the AEADs are not changed in the meantime.
+func (h *cryptoSetupServer) LockForSealing() {
+	h.mutex.RLock()
+}
+
+// UnlockForSealing should be called after Seal() is complete, see LockForSealing().
+func (h *cryptoSetupServer) UnlockForSealing() {
+	h.mutex.RUnlock()
+}
+
+// PublicResetNonceProof returns the nonce proof for public resets
+// It is derived from the client nonce sent in the full CHLO.
+func (h *cryptoSetupServer) PublicResetNonceProof() uint64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if len(h.clientNonce) == 0 {
+		return 0
+	}
+	return crypto.DerivePublicResetNonceProof(h.clientNonce, h.connID)
+}
+
+// HandshakeComplete returns true after the first forward secure packet was received form the client.
+func (h *cryptoSetupServer) HandshakeComplete() bool {
+	return h.receivedForwardSecurePacket
+}
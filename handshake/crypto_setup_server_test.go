@@ -0,0 +1,822 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockKEX struct {
+	ephermal bool
+}
+
+func (m *mockKEX) PublicKey() []byte {
+	if m.ephermal {
+		return []byte("ephermal pub")
+	}
+	return []byte("initial public")
+}
+
+func (m *mockKEX) PrivateKey() []byte {
+	return []byte("private key")
+}
+
+func (m *mockKEX) CalculateSharedKey(otherPublic []byte) ([]byte, error) {
+	if m.ephermal {
+		return []byte("shared ephermal"), nil
+	}
+	return []byte("shared key"), nil
+}
+
+type mockSigner struct {
+	gotCHLO bool
+}
+
+func (s *mockSigner) SignServerProof(sni string, chlo []byte, serverConfigData []byte) ([]byte, error) {
+	if len(chlo) > 0 {
+		s.gotCHLO = true
+	}
+	return []byte("proof"), nil
+}
+func (*mockSigner) GetCertsCompressed(sni string, common, cached []byte) ([]byte, error) {
+	return []byte("certcompressed"), nil
+}
+func (*mockSigner) GetLeafCert(sni string) ([]byte, error) {
+	return []byte("certuncompressed"), nil
+}
+
+type mockAEAD struct {
+	forwardSecure bool
+	sharedSecret  []byte
+}
+
+func (m *mockAEAD) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
+	if cap(dst) < len(src)+12 {
+		dst = make([]byte, len(src)+12)
+	}
+	dst = dst[:len(src)+12]
+	copy(dst, src)
+	if !m.forwardSecure {
+		copy(dst[len(src):], []byte("  normal sec"))
+	} else {
+		copy(dst[len(src):], []byte(" forward sec"))
+	}
+	return dst
+}
+
+func (m *mockAEAD) Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
+	if m.forwardSecure && string(src) == "forward secure encrypted" {
+		return []byte("decrypted"), nil
+	} else if !m.forwardSecure && string(src) == "encrypted" {
+		return []byte("decrypted"), nil
+	}
+	return nil, errors.New("authentication failed")
+}
+
+func (mockAEAD) DiversificationNonce() []byte { return nil }
+
+var expectedInitialNonceLen int
+var expectedFSNonceLen int
+
+func mockKeyDerivation(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (crypto.AEAD, error) {
+	if forwardSecure {
+		Expect(nonces).To(HaveLen(expectedFSNonceLen))
+	} else {
+		Expect(nonces).To(HaveLen(expectedInitialNonceLen))
+	}
+	return &mockAEAD{forwardSecure: forwardSecure, sharedSecret: sharedSecret}, nil
+}
+
+type mockStream struct {
+	dataToRead  bytes.Buffer
+	dataWritten bytes.Buffer
+}
+
+func (s *mockStream) Read(p []byte) (int, error) {
+	return s.dataToRead.Read(p)
+}
+
+func (s *mockStream) ReadByte() (byte, error) {
+	return s.dataToRead.ReadByte()
+}
+
+func (s *mockStream) Write(p []byte) (int, error) {
+	return s.dataWritten.Write(p)
+}
+
+func (s *mockStream) Close() error                       { panic("not implemented") }
+func (mockStream) CloseRemote(offset protocol.ByteCount) { panic("not implemented") }
+func (mockStream) Reset(errorCode uint32)                { panic("not implemented") }
+func (s mockStream) StreamID() protocol.StreamID         { panic("not implemented") }
+func (mockStream) SetDeadline(time.Time) error           { panic("not implemented") }
+func (mockStream) SetReadDeadline(time.Time) error       { panic("not implemented") }
+func (mockStream) SetWriteDeadline(time.Time) error      { panic("not implemented") }
+
+type mockStkSource struct{}
+
+func (mockStkSource) NewToken(sourceAddr []byte) ([]byte, error) {
+	return append([]byte("token "), sourceAddr...), nil
+}
+
+func (mockStkSource) VerifyToken(sourceAddr []byte, token []byte) error {
+	split := bytes.Split(token, []byte(" "))
+	if len(split) != 2 {
+		return errors.New("stk required")
+	}
+	if !bytes.Equal(split[0], []byte("token")) {
+		return errors.New("no prefix match")
+	}
+	if !bytes.Equal(split[1], sourceAddr) {
+		return errors.New("ip wrong")
+	}
+	return nil
+}
+
+var _ = Describe("Server Crypto Setup", func() {
+	var (
+		kex         *mockKEX
+		signer      *mockSigner
+		scfgs       *ServerConfigStore
+		scfg        *ServerConfig
+		cs          *cryptoSetupServer
+		stream      *mockStream
+		cpm         *ConnectionParametersManager
+		aeadChanged chan struct{}
+		nonce32     []byte
+		ip          net.IP
+		validSTK    []byte
+	)
+
+	BeforeEach(func() {
+		var err error
+		ip = net.ParseIP("1.2.3.4")
+		validSTK, err = mockStkSource{}.NewToken(ip)
+		Expect(err).NotTo(HaveOccurred())
+		nonce32 = make([]byte, 32)
+		expectedInitialNonceLen = 32
+		expectedFSNonceLen = 64
+		aeadChanged = make(chan struct{}, 1)
+		stream = &mockStream{}
+		kex = &mockKEX{}
+		signer = &mockSigner{}
+		scfgs, err = NewServerConfigStore(signer, nil, time.Hour, nil)
+		Expect(err).NotTo(HaveOccurred())
+		scfgs.stkSource = &mockStkSource{}
+		// simulate a server that has been running for longer than the strike register window
+		scfgs.strikeRegister = newStrikeRegister(scfgs.obit, protocol.StrikeRegisterWindow, protocol.StrikeRegisterMaxEntries, time.Now().Add(-time.Hour))
+		scfgs.deriveKEXs = func(io.Reader) (map[string]crypto.KeyExchange, error) {
+			return map[string]crypto.KeyExchange{"C255": kex}, nil
+		}
+		scfg = scfgs.Primary()
+		scfg.kexs = map[string]crypto.KeyExchange{"C255": kex}
+		// a valid client nonce contains the current time and the orbit
+		binary.BigEndian.PutUint32(nonce32, uint32(time.Now().Unix()))
+		copy(nonce32[4:12], scfg.obit)
+		v := protocol.SupportedVersions[len(protocol.SupportedVersions)-1]
+		cpm = newDefaultConnectionParametersManager()
+		csInt, err := NewCryptoSetup(protocol.ConnectionID(42), ip, v, scfgs, stream, cpm, aeadChanged)
+		Expect(err).NotTo(HaveOccurred())
+		cs = csInt.(*cryptoSetupServer)
+		// some tests call handleCHLO directly, without selecting the server config from the CHLO first
+		cs.scfg = scfg
+		cs.keyDerivations = map[string]KeyDerivationFunction{"AESG": mockKeyDerivation, "CC20": mockKeyDerivation}
+		cs.keyExchange = func(string) crypto.KeyExchange { return &mockKEX{ephermal: true} }
+	})
+
+	Context("diversification nonce", func() {
+		BeforeEach(func() {
+			cs.version = protocol.Version35
+			cs.secureAEAD = &mockAEAD{}
+			cs.receivedForwardSecurePacket = false
+
+			Expect(cs.DiversificationNonce()).To(BeEmpty())
+			// Div nonce is created after CHLO
+			cs.handleCHLO("", nil, map[Tag][]byte{TagNONC: nonce32, TagKEXS: []byte("C255"), TagAEAD: []byte("AESG")})
+		})
+
+		It("returns diversification nonces", func() {
+			Expect(cs.DiversificationNonce()).To(HaveLen(32))
+		})
+
+		It("does not return nonce for FS packets", func() {
+			cs.receivedForwardSecurePacket = true
+			Expect(cs.DiversificationNonce()).To(BeEmpty())
+		})
+
+		It("does not return nonce for unencrypted packets", func() {
+			cs.secureAEAD = nil
+			Expect(cs.DiversificationNonce()).To(BeEmpty())
+		})
+	})
+
+	Context("when responding to client messages", func() {
+		It("generates REJ messages", func() {
+			response, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), nil, handshakeOK)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response).To(HavePrefix("REJ"))
+			Expect(response).To(ContainSubstring("initial public"))
+			Expect(signer.gotCHLO).To(BeFalse())
+		})
+
+		It("REJ messages don't include cert or proof without STK", func() {
+			response, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), nil, handshakeOK)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response).To(HavePrefix("REJ"))
+			Expect(response).ToNot(ContainSubstring("certcompressed"))
+			Expect(response).ToNot(ContainSubstring("proof"))
+			Expect(signer.gotCHLO).To(BeFalse())
+		})
+
+		It("REJ messages include a server nonce", func() {
+			response, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), nil, handshakeOK)
+			Expect(err).ToNot(HaveOccurred())
+			tag, reply, err := ParseHandshakeMessage(bytes.NewReader(response))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tag).To(Equal(TagREJ))
+			Expect(scfgs.serverNonces.Verify(reply[TagSNO], time.Now())).To(Equal(handshakeOK))
+		})
+
+		It("REJ messages include cert and proof with valid STK", func() {
+			response, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), map[Tag][]byte{
+				TagSTK: validSTK,
+				TagSNI: []byte("foo"),
+			}, handshakeOK)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response).To(HavePrefix("REJ"))
+			Expect(response).To(ContainSubstring("certcompressed"))
+			Expect(response).To(ContainSubstring("proof"))
+			Expect(signer.gotCHLO).To(BeTrue())
+		})
+
+		It("generates SHLO messages", func() {
+			response, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("C255"),
+				TagAEAD: []byte("AESG"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response).To(HavePrefix("SHLO"))
+			Expect(response).To(ContainSubstring("ephermal pub"))
+			Expect(response).To(ContainSubstring("SNO\x00"))
+			Expect(response).To(ContainSubstring(string(protocol.SupportedVersionsAsTags)))
+			Expect(cs.secureAEAD).ToNot(BeNil())
+			Expect(cs.secureAEAD.(*mockAEAD).forwardSecure).To(BeFalse())
+			Expect(cs.secureAEAD.(*mockAEAD).sharedSecret).To(Equal([]byte("shared key")))
+			Expect(cs.forwardSecureAEAD).ToNot(BeNil())
+			Expect(cs.forwardSecureAEAD.(*mockAEAD).sharedSecret).To(Equal([]byte("shared ephermal")))
+			Expect(cs.forwardSecureAEAD.(*mockAEAD).forwardSecure).To(BeTrue())
+		})
+
+		It("uses the key derivation for the AEAD chosen by the client", func() {
+			var usedChacha bool
+			cs.keyDerivations["CC20"] = func(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (crypto.AEAD, error) {
+				usedChacha = true
+				return mockKeyDerivation(forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, pers)
+			}
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("C255"),
+				TagAEAD: []byte("CC20"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(usedChacha).To(BeTrue())
+		})
+
+		It("errors if the CHLO doesn't contain an AEAD", func() {
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("C255"),
+			})
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "AEAD")))
+		})
+
+		It("errors if the CHLO contains more than one AEAD", func() {
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("C255"),
+				TagAEAD: []byte("AESGCC20"),
+			})
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "AEAD")))
+		})
+
+		It("errors if the CHLO contains an AEAD that we didn't offer", func() {
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("C255"),
+				TagAEAD: []byte("FOOB"),
+			})
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoNoSupport, "AEAD")))
+		})
+
+		It("uses the key exchange chosen by the client", func() {
+			var ephermalKEXS string
+			cs.keyExchange = func(kexs string) crypto.KeyExchange {
+				ephermalKEXS = kexs
+				return &mockKEX{ephermal: true}
+			}
+			scfg.kexs["P256"] = &mockKEX{}
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("P256"),
+				TagAEAD: []byte("AESG"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ephermalKEXS).To(Equal("P256"))
+		})
+
+		It("errors if the CHLO doesn't contain a KEXS", func() {
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagAEAD: []byte("AESG"),
+			})
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "KEXS")))
+		})
+
+		It("errors if the CHLO contains more than one KEXS", func() {
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("C255P256"),
+				TagAEAD: []byte("AESG"),
+			})
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "KEXS")))
+		})
+
+		It("errors if the CHLO contains a KEXS that the server config doesn't offer", func() {
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("P256"),
+				TagAEAD: []byte("AESG"),
+			})
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoNoSupport, "KEXS")))
+		})
+
+		It("derives the public reset nonce proof from the client nonce in the CHLO", func() {
+			Expect(cs.PublicResetNonceProof()).To(BeZero())
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("C255"),
+				TagAEAD: []byte("AESG"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.PublicResetNonceProof()).To(Equal(crypto.DerivePublicResetNonceProof(nonce32, cs.connID)))
+		})
+
+		It("handles long handshake", func() {
+			WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+				TagSNI: []byte("quic.clemente.io"),
+				TagSTK: validSTK,
+				TagPAD: bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+			})
+			WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+				TagSCID: scfg.ID,
+				TagSNI:  []byte("quic.clemente.io"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("C255"),
+				TagAEAD: []byte("AESG"),
+				TagSTK:  validSTK,
+				TagPUBS: nil,
+			})
+			err := cs.HandleCryptoStream()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stream.dataWritten.Bytes()).To(HavePrefix("REJ"))
+			Expect(stream.dataWritten.Bytes()).To(ContainSubstring("SHLO"))
+			Expect(aeadChanged).To(Receive())
+		})
+
+		It("handles 0-RTT handshake", func() {
+			WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+				TagSCID: scfg.ID,
+				TagSNI:  []byte("quic.clemente.io"),
+				TagNONC: nonce32,
+				TagKEXS: []byte("C255"),
+				TagAEAD: []byte("AESG"),
+				TagSTK:  validSTK,
+				TagPUBS: nil,
+			})
+			err := cs.HandleCryptoStream()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+			Expect(stream.dataWritten.Bytes()).ToNot(ContainSubstring("REJ"))
+			Expect(aeadChanged).To(Receive())
+		})
+
+		Context("client nonce validation", func() {
+			writeFullCHLO := func(nonce []byte) {
+				WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+					TagSCID: scfg.ID,
+					TagSNI:  []byte("quic.clemente.io"),
+					TagNONC: nonce,
+					TagKEXS: []byte("C255"),
+					TagAEAD: []byte("AESG"),
+					TagSTK:  validSTK,
+					TagPUBS: nil,
+					TagPAD:  bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+				})
+			}
+
+			writeFullCHLOWithSNO := func(nonce []byte, sno []byte) {
+				WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+					TagSCID: scfg.ID,
+					TagSNI:  []byte("quic.clemente.io"),
+					TagNONC: nonce,
+					TagSNO:  sno,
+					TagKEXS: []byte("C255"),
+					TagAEAD: []byte("AESG"),
+					TagSTK:  validSTK,
+					TagPUBS: nil,
+					TagPAD:  bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+				})
+			}
+
+			// parseREJ checks that the server replied with a REJ, and returns the rejection reasons
+			parseREJ := func() []byte {
+				tag, reply, err := ParseHandshakeMessage(&stream.dataWritten)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tag).To(Equal(TagREJ))
+				return reply[TagRREJ]
+			}
+
+			It("rejects a replayed CHLO", func() {
+				writeFullCHLO(nonce32)
+				err := cs.HandleCryptoStream()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+
+				// a new connection using the same CHLO
+				stream = &mockStream{}
+				csInt, err := NewCryptoSetup(protocol.ConnectionID(43), ip, cs.version, scfgs, stream, cpm, make(chan struct{}, 1))
+				Expect(err).NotTo(HaveOccurred())
+				cs = csInt.(*cryptoSetupServer)
+				cs.keyDerivations = map[string]KeyDerivationFunction{"AESG": mockKeyDerivation}
+				cs.keyExchange = func(string) crypto.KeyExchange { return &mockKEX{ephermal: true} }
+				writeFullCHLO(nonce32)
+				err = cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				Expect(parseREJ()).To(Equal([]byte{0x3, 0x0, 0x0, 0x0}))
+				Expect(cs.secureAEAD).To(BeNil())
+			})
+
+			It("rejects a CHLO with a client nonce for a different orbit", func() {
+				copy(nonce32[4:12], "notorbit")
+				writeFullCHLO(nonce32)
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				Expect(parseREJ()).To(Equal([]byte{0x4, 0x0, 0x0, 0x0}))
+			})
+
+			It("rejects a CHLO with an old client nonce", func() {
+				binary.BigEndian.PutUint32(nonce32, uint32(time.Now().Add(-time.Hour).Unix()))
+				writeFullCHLO(nonce32)
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				Expect(parseREJ()).To(Equal([]byte{0x5, 0x0, 0x0, 0x0}))
+			})
+
+			Context("if the shared strike register fails", func() {
+				BeforeEach(func() {
+					shared := &mockSharedStrikeRegister{err: errors.New("database unavailable")}
+					scfgs.strikeRegister = newSharedStrikeRegister(scfgs.obit, protocol.StrikeRegisterWindow, shared)
+				})
+
+				It("rejects a CHLO without a server nonce", func() {
+					writeFullCHLO(nonce32)
+					err := cs.HandleCryptoStream()
+					Expect(err).To(MatchError(qerr.HandshakeFailed))
+					Expect(parseREJ()).To(Equal([]byte{0x7, 0x0, 0x0, 0x0}))
+				})
+
+				It("accepts a CHLO with a valid server nonce", func() {
+					sno, err := scfgs.serverNonces.New(time.Now())
+					Expect(err).ToNot(HaveOccurred())
+					writeFullCHLOWithSNO(nonce32, sno)
+					err = cs.HandleCryptoStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+				})
+			})
+
+			Context("after a restart", func() {
+				BeforeEach(func() {
+					scfgs.strikeRegister = newStrikeRegister(scfgs.obit, protocol.StrikeRegisterWindow, protocol.StrikeRegisterMaxEntries, time.Now())
+				})
+
+				It("rejects a CHLO without a server nonce", func() {
+					writeFullCHLO(nonce32)
+					err := cs.HandleCryptoStream()
+					Expect(err).To(MatchError(qerr.HandshakeFailed))
+					Expect(parseREJ()).To(Equal([]byte{0x5, 0x0, 0x0, 0x0}))
+				})
+
+				It("accepts a CHLO with a valid server nonce", func() {
+					sno, err := scfgs.serverNonces.New(time.Now())
+					Expect(err).ToNot(HaveOccurred())
+					writeFullCHLOWithSNO(nonce32, sno)
+					err = cs.HandleCryptoStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+				})
+
+				It("rejects a CHLO with an invalid server nonce", func() {
+					sno, err := scfgs.serverNonces.New(time.Now())
+					Expect(err).ToNot(HaveOccurred())
+					sno[len(sno)-1] ^= 0xff
+					writeFullCHLOWithSNO(nonce32, sno)
+					err = cs.HandleCryptoStream()
+					Expect(err).To(MatchError(qerr.HandshakeFailed))
+					Expect(parseREJ()).To(Equal([]byte{0x8, 0x0, 0x0, 0x0}))
+				})
+
+				It("rejects a server nonce that was already used", func() {
+					sno, err := scfgs.serverNonces.New(time.Now())
+					Expect(err).ToNot(HaveOccurred())
+					Expect(scfgs.serverNonces.Verify(sno, time.Now())).To(Equal(handshakeOK))
+					writeFullCHLOWithSNO(nonce32, sno)
+					err = cs.HandleCryptoStream()
+					Expect(err).To(MatchError(qerr.HandshakeFailed))
+					Expect(parseREJ()).To(Equal([]byte{0xa, 0x0, 0x0, 0x0}))
+				})
+			})
+
+			It("doesn't send a rejection reason for inchoate CHLOs", func() {
+				WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+					TagSNI: []byte("quic.clemente.io"),
+					TagPAD: bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+				})
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				Expect(parseREJ()).To(BeNil())
+			})
+		})
+
+		Context("server config rotation", func() {
+			var oldScfg *ServerConfig
+
+			BeforeEach(func() {
+				oldScfg = scfg
+				scfgs.mutex.Lock()
+				err := scfgs.rotate(scfgs.primaryEpoch + 1)
+				scfgs.mutex.Unlock()
+				Expect(err).ToNot(HaveOccurred())
+				scfg = scfgs.Primary()
+				Expect(scfg.ID).ToNot(Equal(oldScfg.ID))
+			})
+
+			It("handles a 0-RTT handshake with an older server config", func() {
+				WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+					TagSCID: oldScfg.ID,
+					TagSNI:  []byte("quic.clemente.io"),
+					TagNONC: nonce32,
+					TagKEXS: []byte("C255"),
+					TagAEAD: []byte("AESG"),
+					TagSTK:  validSTK,
+					TagPUBS: nil,
+				})
+				err := cs.HandleCryptoStream()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+				Expect(cs.scfg).To(Equal(oldScfg))
+				Expect(aeadChanged).To(Receive())
+			})
+
+			It("rejects a CHLO for an expired server config", func() {
+				oldScfg.expiry = time.Now().Add(-time.Second)
+				WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+					TagSCID: oldScfg.ID,
+					TagSNI:  []byte("quic.clemente.io"),
+					TagNONC: nonce32,
+					TagKEXS: []byte("C255"),
+					TagAEAD: []byte("AESG"),
+					TagSTK:  validSTK,
+					TagPUBS: nil,
+					TagPAD:  bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+				})
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("REJ"))
+			})
+
+			It("sends the primary server config in the REJ", func() {
+				WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+					TagSCID: oldScfg.ID,
+					TagSNI:  []byte("quic.clemente.io"),
+					TagSTK:  validSTK,
+					TagPAD:  bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+				})
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				tag, reply, err := ParseHandshakeMessage(&stream.dataWritten)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tag).To(Equal(TagREJ))
+				Expect(reply[TagSCFG]).To(Equal(scfg.Get()))
+			})
+		})
+
+		It("recognizes inchoate CHLOs missing SCID", func() {
+			Expect(cs.findServerConfig(map[Tag][]byte{TagPUBS: nil, TagSTK: validSTK})).To(BeNil())
+		})
+
+		It("recognizes inchoate CHLOs with an unknown SCID", func() {
+			Expect(cs.findServerConfig(map[Tag][]byte{TagSCID: []byte("unknown"), TagPUBS: nil, TagSTK: validSTK})).To(BeNil())
+		})
+
+		It("recognizes inchoate CHLOs missing PUBS", func() {
+			Expect(cs.findServerConfig(map[Tag][]byte{TagSCID: scfg.ID, TagSTK: validSTK})).To(BeNil())
+		})
+
+		It("recognizes inchoate CHLOs with invalid tokens", func() {
+			Expect(cs.findServerConfig(map[Tag][]byte{
+				TagSCID: scfg.ID,
+				TagPUBS: nil,
+			})).To(BeNil())
+		})
+
+		It("recognizes proper CHLOs", func() {
+			Expect(cs.findServerConfig(map[Tag][]byte{
+				TagSCID: scfg.ID,
+				TagPUBS: nil,
+				TagSTK:  validSTK,
+			})).To(Equal(scfg))
+		})
+
+		It("errors on too short inchoate CHLOs", func() {
+			_, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize-1), nil, handshakeOK)
+			Expect(err).To(MatchError("CryptoInvalidValueLength: CHLO too small"))
+		})
+	})
+
+	It("errors without SNI", func() {
+		WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+			TagSTK: validSTK,
+		})
+		err := cs.HandleCryptoStream()
+		Expect(err).To(MatchError("CryptoMessageParameterNotFound: SNI required"))
+	})
+
+	It("errors with empty SNI", func() {
+		WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+			TagSTK: validSTK,
+			TagSNI: nil,
+		})
+		err := cs.HandleCryptoStream()
+		Expect(err).To(MatchError("CryptoMessageParameterNotFound: SNI required"))
+	})
+
+	It("errors with invalid message", func() {
+		err := cs.HandleCryptoStream()
+		Expect(err).To(MatchError(qerr.HandshakeFailed))
+	})
+
+	It("errors with non-CHLO message", func() {
+		WriteHandshakeMessage(&stream.dataToRead, TagPAD, nil)
+		err := cs.HandleCryptoStream()
+		Expect(err).To(MatchError(qerr.InvalidCryptoMessageType))
+	})
+
+	Context("escalating crypto", func() {
+		foobarFNVSigned := []byte{0x18, 0x6f, 0x44, 0xba, 0x97, 0x35, 0xd, 0x6f, 0xbf, 0x64, 0x3c, 0x79, 0x66, 0x6f, 0x6f, 0x62, 0x61, 0x72}
+
+		doCHLO := func() {
+			_, err := cs.handleCHLO("", []byte("chlo-data"), map[Tag][]byte{TagPUBS: []byte("pubs-c"), TagNONC: nonce32, TagKEXS: []byte("C255"), TagAEAD: []byte("AESG")})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Context("null encryption", func() {
+			It("is used initially", func() {
+				Expect(cs.Seal(nil, []byte("foobar"), 0, []byte{})).To(Equal(foobarFNVSigned))
+			})
+
+			It("is accepted initially", func() {
+				d, err := cs.Open(nil, foobarFNVSigned, 0, []byte{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(d).To(Equal([]byte("foobar")))
+			})
+
+			It("is still accepted after CHLO", func() {
+				doCHLO()
+				Expect(cs.secureAEAD).ToNot(BeNil())
+				_, err := cs.Open(nil, foobarFNVSigned, 0, []byte{})
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("is not accepted after receiving secure packet", func() {
+				doCHLO()
+				Expect(cs.secureAEAD).ToNot(BeNil())
+				d, err := cs.Open(nil, []byte("encrypted"), 0, []byte{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(d).To(Equal([]byte("decrypted")))
+				_, err = cs.Open(nil, foobarFNVSigned, 0, []byte{})
+				Expect(err).To(MatchError("authentication failed"))
+			})
+
+			It("is not used after CHLO", func() {
+				doCHLO()
+				d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+				Expect(d).ToNot(Equal(foobarFNVSigned))
+			})
+		})
+
+		Context("initial encryption", func() {
+			It("is used after CHLO", func() {
+				doCHLO()
+				d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+				Expect(d).To(Equal([]byte("foobar  normal sec")))
+			})
+
+			It("is accepted after CHLO", func() {
+				doCHLO()
+				d, err := cs.Open(nil, []byte("encrypted"), 0, []byte{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(d).To(Equal([]byte("decrypted")))
+			})
+
+			It("is not used after receiving forward secure packet", func() {
+				doCHLO()
+				_, err := cs.Open(nil, []byte("forward secure encrypted"), 0, []byte{})
+				Expect(err).ToNot(HaveOccurred())
+				d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+				Expect(d).To(Equal([]byte("foobar forward sec")))
+			})
+
+			It("is not accepted after receiving forward secure packet", func() {
+				doCHLO()
+				_, err := cs.Open(nil, []byte("forward secure encrypted"), 0, []byte{})
+				Expect(err).ToNot(HaveOccurred())
+				_, err = cs.Open(nil, []byte("encrypted"), 0, []byte{})
+				Expect(err).To(MatchError("authentication failed"))
+			})
+		})
+
+		Context("forward secure encryption", func() {
+			It("is used after receiving forward secure packet", func() {
+				doCHLO()
+				_, err := cs.Open(nil, []byte("forward secure encrypted"), 0, []byte{})
+				Expect(err).ToNot(HaveOccurred())
+				d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+				Expect(d).To(Equal([]byte("foobar forward sec")))
+			})
+
+			It("signals that the handshake is complete when receiving the first forward secure packet", func() {
+				doCHLO()
+				Expect(aeadChanged).To(Receive())
+				Expect(cs.HandshakeComplete()).To(BeFalse())
+				_, err := cs.Open(nil, []byte("forward secure encrypted"), 0, []byte{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.HandshakeComplete()).To(BeTrue())
+				Expect(aeadChanged).To(Receive())
+				_, err = cs.Open(nil, []byte("forward secure encrypted"), 1, []byte{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(aeadChanged).ToNot(Receive())
+			})
+		})
+	})
+
+	Context("STK verification and creation", func() {
+		It("requires STK", func() {
+			done, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), map[Tag][]byte{
+				TagSNI: []byte("foo"),
+			})
+			Expect(done).To(BeFalse())
+			Expect(err).To(BeNil())
+			Expect(stream.dataWritten.Bytes()).To(ContainSubstring(string(validSTK)))
+		})
+
+		It("works with proper STK", func() {
+			done, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), map[Tag][]byte{
+				TagSTK: validSTK,
+				TagSNI: []byte("foo"),
+			})
+			Expect(done).To(BeFalse())
+			Expect(err).To(BeNil())
+		})
+
+		It("errors if IP does not match", func() {
+			done, err := cs.handleMessage(bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), map[Tag][]byte{
+				TagSNI: []byte("foo"),
+				TagSTK: []byte("token \x04\x03\x03\x01"),
+			})
+			Expect(done).To(BeFalse())
+			Expect(err).To(BeNil())
+			Expect(stream.dataWritten.Bytes()).To(ContainSubstring(string(validSTK)))
+		})
+	})
+})
@@ -9,14 +9,19 @@ import (
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
+type ephermalKEX struct {
+	kex     crypto.KeyExchange
+	created time.Time
+}
+
 var (
-	kexLifetime    = protocol.EphermalKeyLifetime
-	kexCurrent     crypto.KeyExchange
-	kexCurrentTime time.Time
-	kexMutex       sync.RWMutex
+	kexLifetime = protocol.EphermalKeyLifetime
+	kexCurrent  = make(map[string]ephermalKEX)
+	kexMutex    sync.RWMutex
 )
 
-// getEphermalKEX returns the currently active KEX, which changes every protocol.EphermalKeyLifetime
+// getEphermalKEX returns the currently active KEX for a key exchange algorithm, which changes every protocol.EphermalKeyLifetime
+// It returns nil if the algorithm is not supported.
 // See the explanation from the QUIC crypto doc:
 //
 // A single connection is the usual scope for forward security, but the security
@@ -24,27 +29,31 @@ var (
 // used for all connections for 60 seconds is negligible. Thus we can amortise
 // the Diffie-Hellman key generation at the server over all the connections in a
 // small time span.
-func getEphermalKEX() (res crypto.KeyExchange) {
+func getEphermalKEX(kexs string) crypto.KeyExchange {
 	kexMutex.RLock()
-	res = kexCurrent
-	t := kexCurrentTime
+	current, ok := kexCurrent[kexs]
 	kexMutex.RUnlock()
-	if res != nil && time.Now().Sub(t) < kexLifetime {
-		return res
+	if ok && time.Now().Sub(current.created) < kexLifetime {
+		return current.kex
 	}
 
 	kexMutex.Lock()
 	defer kexMutex.Unlock()
 	// Check if still unfulfilled
-	if kexCurrent == nil || time.Now().Sub(kexCurrentTime) > kexLifetime {
-		kex, err := crypto.NewCurve25519KEX()
-		if err != nil {
-			utils.Errorf("could not set KEX: %s", err.Error())
-			return kexCurrent
-		}
-		kexCurrent = kex
-		kexCurrentTime = time.Now()
-		return kexCurrent
+	current, ok = kexCurrent[kexs]
+	if ok && time.Now().Sub(current.created) < kexLifetime {
+		return current.kex
+	}
+	newKEX, ok := kexConstructors[kexs]
+	if !ok {
+		utils.Errorf("could not set KEX: unsupported key exchange %s", kexs)
+		return nil
+	}
+	kex, err := newKEX()
+	if err != nil {
+		utils.Errorf("could not set KEX: %s", err.Error())
+		return current.kex
 	}
-	return kexCurrent
+	kexCurrent[kexs] = ephermalKEX{kex: kex, created: time.Now()}
+	return kex
 }
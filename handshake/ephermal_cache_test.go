@@ -11,9 +11,9 @@ import (
 
 var _ = Describe("Ephermal KEX", func() {
 	It("has a consistent KEX", func() {
-		kex1 := getEphermalKEX()
+		kex1 := getEphermalKEX("C255")
 		Expect(kex1).ToNot(BeNil())
-		kex2 := getEphermalKEX()
+		kex2 := getEphermalKEX("C255")
 		Expect(kex2).ToNot(BeNil())
 		Expect(kex1).To(Equal(kex2))
 	})
@@ -23,8 +23,21 @@ var _ = Describe("Ephermal KEX", func() {
 		defer func() {
 			kexLifetime = protocol.EphermalKeyLifetime
 		}()
-		kex := getEphermalKEX()
+		kex := getEphermalKEX("C255")
 		Expect(kex).ToNot(BeNil())
-		Eventually(func() crypto.KeyExchange { return getEphermalKEX() }).ShouldNot(Equal(kex))
+		Eventually(func() crypto.KeyExchange { return getEphermalKEX("C255") }).ShouldNot(Equal(kex))
+	})
+
+	It("caches one KEX per algorithm", func() {
+		c255 := getEphermalKEX("C255")
+		Expect(c255.PublicKey()).To(HaveLen(32))
+		p256 := getEphermalKEX("P256")
+		Expect(p256.PublicKey()).To(HaveLen(65))
+		Expect(getEphermalKEX("C255")).To(Equal(c255))
+		Expect(getEphermalKEX("P256")).To(Equal(p256))
+	})
+
+	It("returns nil for unsupported algorithms", func() {
+		Expect(getEphermalKEX("FOOB")).To(BeNil())
 	})
 })
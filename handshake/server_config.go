@@ -2,54 +2,79 @@ package handshake
 
 import (
 	"bytes"
-	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/utils"
 )
 
 // ServerConfig is a server config
 type ServerConfig struct {
-	kex       crypto.KeyExchange
-	signer    crypto.Signer
-	ID        []byte
-	stkSource crypto.StkSource
+	kexs   map[string]crypto.KeyExchange
+	signer crypto.Signer
+	ID     []byte
+	obit   []byte
+	expiry time.Time
 }
 
-// NewServerConfig creates a new server config
-func NewServerConfig(kex crypto.KeyExchange, signer crypto.Signer) (*ServerConfig, error) {
-	id := make([]byte, 16)
-	_, err := rand.Read(id)
-	if err != nil {
-		return nil, err
+// newServerConfig creates a new server config
+// kexs maps the key exchange algorithms offered to the client (e.g. "C255" or "P256") to the server's key for that algorithm.
+// A zero expiry means that the server config never expires.
+func newServerConfig(id []byte, kexs map[string]crypto.KeyExchange, signer crypto.Signer, obit []byte, expiry time.Time) (*ServerConfig, error) {
+	if len(kexs) == 0 {
+		return nil, fmt.Errorf("ServerConfig: no key exchange")
 	}
-
-	stkSecret := make([]byte, 32)
-	if _, err = rand.Read(stkSecret); err != nil {
-		return nil, err
-	}
-	stkSource, err := crypto.NewStkSource(stkSecret)
-	if err != nil {
-		return nil, err
+	for kexTag := range kexs {
+		if !containsTag(supportedKEXs, kexTag) {
+			return nil, fmt.Errorf("ServerConfig: unsupported key exchange %s", kexTag)
+		}
 	}
 
 	return &ServerConfig{
-		kex:       kex,
-		signer:    signer,
-		ID:        id,
-		stkSource: stkSource,
+		kexs:   kexs,
+		signer: signer,
+		ID:     id,
+		obit:   obit,
+		expiry: expiry,
 	}, nil
 }
 
+// IsExpired returns true if the server config expired
+func (s *ServerConfig) IsExpired() bool {
+	return !s.expiry.IsZero() && !time.Now().Before(s.expiry)
+}
+
 // Get the server config binary representation
 func (s *ServerConfig) Get() []byte {
+	// the PUBS contains one public value for every key exchange in KEXS, in the same order
+	// every public value is prefixed with its 3 byte length
+	var kexs, pubs bytes.Buffer
+	for i := 0; i+4 <= len(supportedKEXs); i += 4 {
+		kexTag := string(supportedKEXs[i : i+4])
+		kex, ok := s.kexs[kexTag]
+		if !ok {
+			continue
+		}
+		kexs.WriteString(kexTag)
+		utils.WriteUint24(&pubs, uint32(len(kex.PublicKey())))
+		pubs.Write(kex.PublicKey())
+	}
+
+	expy := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if !s.expiry.IsZero() {
+		binary.LittleEndian.PutUint64(expy, uint64(s.expiry.Unix()))
+	}
+
 	var serverConfig bytes.Buffer
 	WriteHandshakeMessage(&serverConfig, TagSCFG, map[Tag][]byte{
 		TagSCID: s.ID,
-		TagKEXS: []byte("C255"),
-		TagAEAD: []byte("AESG"),
-		TagPUBS: append([]byte{0x20, 0x00, 0x00}, s.kex.PublicKey()...),
-		TagOBIT: {0x0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7},
-		TagEXPY: {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		TagKEXS: kexs.Bytes(),
+		TagAEAD: supportedAEADs,
+		TagPUBS: pubs.Bytes(),
+		TagOBIT: s.obit,
+		TagEXPY: expy,
 	})
 	return serverConfig.Bytes()
 }
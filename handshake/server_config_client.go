@@ -0,0 +1,185 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+type serverConfigClient struct {
+	raw          []byte
+	ID           []byte
+	obit         []byte
+	expiry       time.Time
+	kex          crypto.KeyExchange
+	kexs         []byte // the key exchange chosen from the ones offered by the server
+	aead         []byte // the AEAD chosen from the ones offered by the server
+	sharedSecret []byte
+}
+
+var errMessageNotServerConfig = errors.New("ServerConfig must have TagSCFG")
+
+// parseServerConfig parses a server config
+func parseServerConfig(data []byte) (*serverConfigClient, error) {
+	tag, tagMap, err := ParseHandshakeMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if tag != TagSCFG {
+		return nil, errMessageNotServerConfig
+	}
+
+	scfg := &serverConfigClient{raw: data}
+	err = scfg.parseValues(tagMap)
+	if err != nil {
+		return nil, err
+	}
+
+	return scfg, nil
+}
+
+func (s *serverConfigClient) parseValues(tagMap map[Tag][]byte) error {
+	// SCID
+	scfgID, ok := tagMap[TagSCID]
+	if !ok {
+		return qerr.Error(qerr.CryptoMessageParameterNotFound, "SCID")
+	}
+	if len(scfgID) != 16 {
+		return qerr.Error(qerr.CryptoInvalidValueLength, "SCID")
+	}
+	s.ID = scfgID
+
+	// KEXS
+	kexs, ok := tagMap[TagKEXS]
+	if !ok {
+		return qerr.Error(qerr.CryptoMessageParameterNotFound, "KEXS")
+	}
+	if len(kexs)%4 != 0 {
+		return qerr.Error(qerr.CryptoInvalidValueLength, "KEXS")
+	}
+	// the index of the chosen key exchange in the KEXS, needed to find the matching PUBS value
+	kexIndex := -1
+	for i := 0; i+4 <= len(supportedKEXs); i += 4 {
+		if kexIndex = tagIndex(kexs, string(supportedKEXs[i:i+4])); kexIndex >= 0 {
+			s.kexs = supportedKEXs[i : i+4]
+			break
+		}
+	}
+	if kexIndex < 0 {
+		return qerr.Error(qerr.CryptoNoSupport, "KEXS")
+	}
+
+	// AEAD
+	aead, ok := tagMap[TagAEAD]
+	if !ok {
+		return qerr.Error(qerr.CryptoMessageParameterNotFound, "AEAD")
+	}
+	if len(aead)%4 != 0 {
+		return qerr.Error(qerr.CryptoInvalidValueLength, "AEAD")
+	}
+	s.aead = nil
+	for i := 0; i+4 <= len(supportedAEADs); i += 4 {
+		if containsTag(aead, string(supportedAEADs[i:i+4])) {
+			s.aead = supportedAEADs[i : i+4]
+			break
+		}
+	}
+	if s.aead == nil {
+		return qerr.Error(qerr.CryptoNoSupport, "AEAD")
+	}
+
+	// PUBS
+	pubsData, ok := tagMap[TagPUBS]
+	if !ok {
+		return qerr.Error(qerr.CryptoMessageParameterNotFound, "PUBS")
+	}
+	pubs, err := parsePubs(pubsData)
+	if err != nil || len(pubs) != len(kexs)/4 {
+		return qerr.Error(qerr.CryptoInvalidValueLength, "PUBS")
+	}
+
+	s.kex, err = kexConstructors[string(s.kexs)]()
+	if err != nil {
+		return err
+	}
+
+	s.sharedSecret, err = s.kex.CalculateSharedKey(pubs[kexIndex])
+	if err != nil {
+		return err
+	}
+
+	// OBIT
+	obit, ok := tagMap[TagOBIT]
+	if !ok {
+		return qerr.Error(qerr.CryptoMessageParameterNotFound, "OBIT")
+	}
+	if len(obit) != 8 {
+		return qerr.Error(qerr.CryptoInvalidValueLength, "OBIT")
+	}
+	s.obit = obit
+
+	// EXPY
+	expy, ok := tagMap[TagEXPY]
+	if !ok {
+		return qerr.Error(qerr.CryptoMessageParameterNotFound, "EXPY")
+	}
+	if len(expy) != 8 {
+		return qerr.Error(qerr.CryptoInvalidValueLength, "EXPY")
+	}
+	// make sure that the value doesn't overflow an int64
+	// furthermore, values close to MaxInt64 are not a valid input to time.Unix, thus set MaxInt64/2 as the maximum value here
+	expyTimestamp := utils.MinUint64(binary.LittleEndian.Uint64(expy), math.MaxInt64/2)
+	s.expiry = time.Unix(int64(expyTimestamp), 0)
+
+	// TODO: implement VER
+
+	return nil
+}
+
+// containsTag checks if a list of 4 byte tags, as used for KEXS and AEAD, contains the tag
+func containsTag(list []byte, tag string) bool {
+	return tagIndex(list, tag) >= 0
+}
+
+// tagIndex returns the position of the tag in a list of 4 byte tags, or -1 if the list doesn't contain the tag
+func tagIndex(list []byte, tag string) int {
+	for i := 0; i+4 <= len(list); i += 4 {
+		if string(list[i:i+4]) == tag {
+			return i / 4
+		}
+	}
+	return -1
+}
+
+// parsePubs splits the PUBS value into the public values
+// Every public value is prefixed with its 3 byte length.
+func parsePubs(data []byte) ([][]byte, error) {
+	var pubs [][]byte
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, errors.New("PUBS too short")
+		}
+		l := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+		data = data[3:]
+		if len(data) < l {
+			return nil, errors.New("PUBS too short")
+		}
+		pubs = append(pubs, data[:l])
+		data = data[l:]
+	}
+	return pubs, nil
+}
+
+func (s *serverConfigClient) IsExpired() bool {
+	return s.expiry.Before(time.Now())
+}
+
+func (s *serverConfigClient) Get() []byte {
+	return s.raw
+}
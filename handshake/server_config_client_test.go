@@ -0,0 +1,280 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func getDefaultServerConfigClient() map[Tag][]byte {
+	return map[Tag][]byte{
+		TagSCID: bytes.Repeat([]byte{'F'}, 16),
+		TagKEXS: []byte("C255"),
+		TagAEAD: []byte("AESG"),
+		TagPUBS: append([]byte{0x20, 0x00, 0x00}, bytes.Repeat([]byte{0}, 32)...),
+		TagOBIT: bytes.Repeat([]byte{0}, 8),
+		TagEXPY: []byte{0x0, 0x6c, 0x57, 0x78, 0, 0, 0, 0}, // 2033-12-24
+	}
+}
+
+var _ = Describe("Server Config", func() {
+	var tagMap map[Tag][]byte
+
+	BeforeEach(func() {
+		tagMap = getDefaultServerConfigClient()
+	})
+
+	It("returns the parsed server config", func() {
+		tagMap[TagSCID] = []byte{0xde, 0xad, 0xbe, 0xef, 0xde, 0xca, 0xfb, 0xad, 0xde, 0xad, 0xbe, 0xef, 0xde, 0xca, 0xfb, 0xad}
+		b := &bytes.Buffer{}
+		WriteHandshakeMessage(b, TagSCFG, tagMap)
+		scfg, err := parseServerConfig(b.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scfg.ID).To(Equal(tagMap[TagSCID]))
+	})
+
+	It("saves the raw server config", func() {
+		b := &bytes.Buffer{}
+		WriteHandshakeMessage(b, TagSCFG, tagMap)
+		scfg, err := parseServerConfig(b.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scfg.raw).To(Equal(b.Bytes()))
+		Expect(scfg.Get()).To(Equal(b.Bytes()))
+	})
+
+	It("tells if a server config is expired", func() {
+		scfg := &serverConfigClient{}
+		scfg.expiry = time.Now().Add(-time.Second)
+		Expect(scfg.IsExpired()).To(BeTrue())
+		scfg.expiry = time.Now().Add(time.Second)
+		Expect(scfg.IsExpired()).To(BeFalse())
+	})
+
+	It("rejects a message that is not a server config", func() {
+		b := &bytes.Buffer{}
+		WriteHandshakeMessage(b, TagCHLO, tagMap)
+		_, err := parseServerConfig(b.Bytes())
+		Expect(err).To(MatchError(errMessageNotServerConfig))
+	})
+
+	Context("parsing the server config values", func() {
+		var scfg *serverConfigClient
+
+		BeforeEach(func() {
+			scfg = &serverConfigClient{}
+		})
+
+		It("accepts a valid server config", func() {
+			err := scfg.parseValues(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("SCID", func() {
+			It("reads the SCID", func() {
+				err := scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.ID).To(Equal(tagMap[TagSCID]))
+			})
+
+			It("rejects a server config without a SCID", func() {
+				delete(tagMap, TagSCID)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "SCID")))
+			})
+
+			It("rejects a server config with an invalid SCID length", func() {
+				tagMap[TagSCID] = bytes.Repeat([]byte{'F'}, 17)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "SCID")))
+			})
+		})
+
+		Context("KEXS", func() {
+			It("rejects a server config without KEXS", func() {
+				delete(tagMap, TagKEXS)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "KEXS")))
+			})
+
+			It("rejects a KEXS with an invalid length", func() {
+				tagMap[TagKEXS] = []byte("C25")
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "KEXS")))
+			})
+
+			It("rejects a server config that doesn't support any of our key exchanges", func() {
+				tagMap[TagKEXS] = []byte("FOOB")
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoNoSupport, "KEXS")))
+			})
+
+			It("chooses C255", func() {
+				err := scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.kexs).To(Equal([]byte("C255")))
+			})
+
+			It("accepts a KEXS containing multiple values", func() {
+				kex, err := crypto.NewCurve25519KEX()
+				Expect(err).ToNot(HaveOccurred())
+				tagMap[TagKEXS] = []byte("P256C255")
+				tagMap[TagPUBS] = append([]byte{0x3, 0x0, 0x0, 'f', 'o', 'o', 0x20, 0x0, 0x0}, kex.PublicKey()...)
+				err = scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.kexs).To(Equal([]byte("C255")))
+				sharedSecret, err := kex.CalculateSharedKey(scfg.kex.PublicKey())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.sharedSecret).To(Equal(sharedSecret))
+			})
+
+			It("uses P256 if the server doesn't support C255", func() {
+				kex, err := crypto.NewP256KEX()
+				Expect(err).ToNot(HaveOccurred())
+				tagMap[TagKEXS] = []byte("P256")
+				tagMap[TagPUBS] = append([]byte{0x41, 0x0, 0x0}, kex.PublicKey()...)
+				err = scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.kexs).To(Equal([]byte("P256")))
+				Expect(scfg.kex.PublicKey()).To(HaveLen(65))
+				sharedSecret, err := kex.CalculateSharedKey(scfg.kex.PublicKey())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.sharedSecret).To(Equal(sharedSecret))
+			})
+		})
+
+		Context("AEAD", func() {
+			It("rejects a server config without AEAD", func() {
+				delete(tagMap, TagAEAD)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "AEAD")))
+			})
+
+			It("rejects an AEAD with an invalid length", func() {
+				tagMap[TagAEAD] = []byte("AESG1")
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "AEAD")))
+			})
+
+			It("rejects a server config that doesn't support any of our AEADs", func() {
+				tagMap[TagAEAD] = []byte("FOOB")
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoNoSupport, "AEAD")))
+			})
+
+			It("chooses AESG", func() {
+				err := scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.aead).To(Equal([]byte("AESG")))
+			})
+
+			It("chooses CC20 if the server doesn't support AESG", func() {
+				tagMap[TagAEAD] = []byte("FOOBCC20")
+				err := scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.aead).To(Equal([]byte("CC20")))
+			})
+
+			It("accepts an AEAD containing multiple values", func() {
+				tagMap[TagAEAD] = []byte("CC20AESG")
+				err := scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.aead).To(Equal([]byte("AESG")))
+			})
+		})
+
+		Context("PUBS", func() {
+			It("creates a key exchange and calculates the shared secret", func() {
+				kex, err := crypto.NewCurve25519KEX()
+				Expect(err).ToNot(HaveOccurred())
+				tagMap[TagPUBS] = append([]byte{0x20, 0x00, 0x00}, kex.PublicKey()...)
+				err = scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.kex).ToNot(BeNil())
+				sharedSecret, err := kex.CalculateSharedKey(scfg.kex.PublicKey())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.sharedSecret).To(Equal(sharedSecret))
+			})
+
+			It("rejects a server config without PUBS", func() {
+				delete(tagMap, TagPUBS)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "PUBS")))
+			})
+
+			It("rejects PUBS with an invalid length", func() {
+				tagMap[TagPUBS] = append([]byte{0x20, 0x00, 0x00}, bytes.Repeat([]byte{0}, 31)...)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "PUBS")))
+			})
+
+			It("rejects PUBS with a missing length", func() {
+				tagMap[TagPUBS] = []byte{0x20, 0x00}
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "PUBS")))
+			})
+
+			It("rejects PUBS that don't contain a value for every key exchange", func() {
+				tagMap[TagKEXS] = []byte("C255P256")
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "PUBS")))
+			})
+		})
+
+		Context("OBIT", func() {
+			It("reads the OBIT", func() {
+				tagMap[TagOBIT] = []byte{0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8}
+				err := scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.obit).To(Equal(tagMap[TagOBIT]))
+			})
+
+			It("rejects a server config without OBIT", func() {
+				delete(tagMap, TagOBIT)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "OBIT")))
+			})
+
+			It("rejects an OBIT with an invalid length", func() {
+				tagMap[TagOBIT] = bytes.Repeat([]byte{0}, 7)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "OBIT")))
+			})
+		})
+
+		Context("EXPY", func() {
+			It("reads the EXPY", func() {
+				expy := make([]byte, 8)
+				binary.LittleEndian.PutUint64(expy, 1482624000)
+				tagMap[TagEXPY] = expy
+				err := scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.expiry).To(Equal(time.Unix(1482624000, 0)))
+			})
+
+			It("limits the EXPY to a reasonable value", func() {
+				tagMap[TagEXPY] = bytes.Repeat([]byte{0xff}, 8)
+				err := scfg.parseValues(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(scfg.expiry.After(time.Now().Add(100 * 365 * 24 * time.Hour))).To(BeTrue())
+			})
+
+			It("rejects a server config without EXPY", func() {
+				delete(tagMap, TagEXPY)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "EXPY")))
+			})
+
+			It("rejects an EXPY with an invalid length", func() {
+				tagMap[TagEXPY] = bytes.Repeat([]byte{0}, 9)
+				err := scfg.parseValues(tagMap)
+				Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "EXPY")))
+			})
+		})
+	})
+})
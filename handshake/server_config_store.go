@@ -0,0 +1,209 @@
+package handshake
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// A ServerConfigStore holds the primary server config, and the older server configs that didn't expire yet
+// The primary server config is sent to clients in the REJ. Every rotation interval, it is replaced by a new server config with new keys.
+// Clients that cached an older server config can still use it for a 0-RTT handshake, until it expires two rotation intervals after its creation.
+// All server configs of a store share the orbit, the strike register and the source address token secret.
+// The keys and IDs of the server configs are derived from a secret and the number of rotation intervals since the Unix epoch,
+// so that stores sharing the secret, see Export, rotate to the same server configs at the same time.
+type ServerConfigStore struct {
+	signer           crypto.Signer
+	obit             []byte
+	scfgSecret       []byte
+	stkSecret        []byte
+	stkSource        crypto.StkSource
+	strikeRegister   *strikeRegister
+	serverNonces     *serverNonceSource
+	rotationInterval time.Duration
+	deriveKEXs       func(r io.Reader) (map[string]crypto.KeyExchange, error)
+
+	mutex        sync.RWMutex
+	primary      *ServerConfig
+	primaryEpoch int64                    // the rotation epoch of the primary server config, see epoch
+	configs      map[string]*ServerConfig // indexed by the server config ID
+}
+
+// NewServerConfigStore creates a new server config store, and its first primary server config
+// obit is the 8 byte orbit that client nonces have to contain. If it is nil, a random orbit is used.
+// If sharedRegister is nil, the client nonces are recorded in memory, and the orbit must not be used by any other server.
+func NewServerConfigStore(signer crypto.Signer, obit []byte, rotationInterval time.Duration, sharedRegister StrikeRegister) (*ServerConfigStore, error) {
+	if obit == nil {
+		obit = make([]byte, 8)
+		if _, err := rand.Read(obit); err != nil {
+			return nil, err
+		}
+	}
+	scfgSecret := make([]byte, 32)
+	if _, err := rand.Read(scfgSecret); err != nil {
+		return nil, err
+	}
+	stkSecret := make([]byte, 32)
+	if _, err := rand.Read(stkSecret); err != nil {
+		return nil, err
+	}
+	return newServerConfigStore(signer, obit, scfgSecret, stkSecret, rotationInterval, sharedRegister)
+}
+
+func newServerConfigStore(signer crypto.Signer, obit, scfgSecret, stkSecret []byte, rotationInterval time.Duration, sharedRegister StrikeRegister) (*ServerConfigStore, error) {
+	if len(obit) != 8 {
+		return nil, errors.New("ServerConfigStore: orbit must be 8 bytes")
+	}
+	if rotationInterval <= 0 {
+		return nil, errors.New("ServerConfigStore: rotation interval must be positive")
+	}
+
+	stkSource, err := crypto.NewStkSource(stkSecret)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	serverNonces, err := newServerNonceSource(obit, now)
+	if err != nil {
+		return nil, err
+	}
+	strikeRegister := newStrikeRegister(obit, protocol.StrikeRegisterWindow, protocol.StrikeRegisterMaxEntries, now)
+	if sharedRegister != nil {
+		strikeRegister = newSharedStrikeRegister(obit, protocol.StrikeRegisterWindow, sharedRegister)
+	}
+
+	s := &ServerConfigStore{
+		signer:           signer,
+		obit:             obit,
+		scfgSecret:       scfgSecret,
+		stkSecret:        stkSecret,
+		stkSource:        stkSource,
+		strikeRegister:   strikeRegister,
+		serverNonces:     serverNonces,
+		rotationInterval: rotationInterval,
+		deriveKEXs:       deriveServerKEXs,
+		configs:          make(map[string]*ServerConfig),
+	}
+	// the server config of the previous epoch didn't expire yet, and might have been sent to clients by other servers
+	epoch := s.epoch(now)
+	previous, err := s.deriveServerConfig(epoch - 1)
+	if err != nil {
+		return nil, err
+	}
+	s.configs[string(previous.ID)] = previous
+	if err := s.rotate(epoch); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// deriveServerKEXs derives a key for every supported key exchange algorithm
+func deriveServerKEXs(r io.Reader) (map[string]crypto.KeyExchange, error) {
+	kexs := make(map[string]crypto.KeyExchange)
+	for i := 0; i+4 <= len(supportedKEXs); i += 4 {
+		kexTag := string(supportedKEXs[i : i+4])
+		// not every value is a valid private key, e.g. for P256 it has to be smaller than the order of the curve
+		// Just try the next one. The probability of a single invalid value already is negligible.
+		var kex crypto.KeyExchange
+		for j := 0; kex == nil; j++ {
+			if j == 10 {
+				return nil, errors.New("ServerConfigStore: could not derive a private key")
+			}
+			priv := make([]byte, 32)
+			if _, err := io.ReadFull(r, priv); err != nil {
+				return nil, err
+			}
+			kex, _ = kexImporters[kexTag](priv)
+		}
+		kexs[kexTag] = kex
+	}
+	return kexs, nil
+}
+
+// epoch returns the number of rotation intervals since the Unix epoch
+func (s *ServerConfigStore) epoch(t time.Time) int64 {
+	return t.UnixNano() / int64(s.rotationInterval)
+}
+
+// deriveServerConfig derives the server config for an epoch from the scfgSecret
+// It is valid from the beginning of the epoch for two rotation intervals.
+func (s *ServerConfigStore) deriveServerConfig(epoch int64) (*ServerConfig, error) {
+	info := make([]byte, 8)
+	binary.BigEndian.PutUint64(info, uint64(epoch))
+	r := hkdf.New(sha256.New, s.scfgSecret, info, []byte("QUIC server config"))
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, err
+	}
+	kexs, err := s.deriveKEXs(r)
+	if err != nil {
+		return nil, err
+	}
+	created := time.Unix(0, epoch*int64(s.rotationInterval))
+	return newServerConfig(id, kexs, s.signer, s.obit, created.Add(2*s.rotationInterval))
+}
+
+// Primary returns the primary server config
+// If a new rotation interval started, it is replaced by a new one first.
+func (s *ServerConfigStore) Primary() *ServerConfig {
+	epoch := s.epoch(time.Now())
+	s.mutex.RLock()
+	primary := s.primary
+	rotate := epoch > s.primaryEpoch
+	s.mutex.RUnlock()
+	if !rotate {
+		return primary
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// Check if another call already rotated
+	if epoch > s.primaryEpoch {
+		if err := s.rotate(epoch); err != nil {
+			utils.Errorf("could not rotate the server config: %s", err.Error())
+		}
+	}
+	return s.primary
+}
+
+// Get returns the server config with the given ID
+// It returns nil if there is no such server config, or if it expired.
+func (s *ServerConfigStore) Get(id []byte) *ServerConfig {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	scfg, ok := s.configs[string(id)]
+	if !ok || scfg.IsExpired() {
+		return nil
+	}
+	return scfg
+}
+
+// rotate makes the server config of the epoch the primary, and removes the expired server configs
+// The caller must hold the mutex.
+func (s *ServerConfigStore) rotate(epoch int64) error {
+	scfg, err := s.deriveServerConfig(epoch)
+	if err != nil {
+		return err
+	}
+	utils.Infof("Rotating the server config, new server config ID %x", scfg.ID)
+	s.primary = scfg
+	s.primaryEpoch = epoch
+	s.configs[string(scfg.ID)] = scfg
+
+	for id, c := range s.configs {
+		if c.IsExpired() {
+			delete(s.configs, id)
+		}
+	}
+	return nil
+}
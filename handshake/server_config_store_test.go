@@ -0,0 +1,145 @@
+package handshake
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServerConfigStore", func() {
+	var scfgs *ServerConfigStore
+
+	BeforeEach(func() {
+		var err error
+		scfgs, err = NewServerConfigStore(nil, []byte("orbitorb"), time.Hour, nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("creates a primary server config offering all supported key exchanges", func() {
+		scfg := scfgs.Primary()
+		Expect(scfg).ToNot(BeNil())
+		Expect(scfg.kexs).To(HaveKey("C255"))
+		Expect(scfg.kexs).To(HaveKey("P256"))
+		Expect(scfg.expiry).To(BeTemporally(">", time.Now().Add(time.Hour)))
+		Expect(scfg.expiry).To(BeTemporally("<=", time.Now().Add(2*time.Hour)))
+		Expect(scfgs.Get(scfg.ID)).To(Equal(scfg))
+	})
+
+	It("uses the orbit it was configured with", func() {
+		_, tagMap, err := ParseHandshakeMessage(bytes.NewReader(scfgs.Primary().Get()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tagMap[TagOBIT]).To(Equal([]byte("orbitorb")))
+		Expect(scfgs.strikeRegister.orbit).To(Equal([]byte("orbitorb")))
+	})
+
+	It("uses the shared strike register", func() {
+		shared := &mockSharedStrikeRegister{}
+		scfgs, err := NewServerConfigStore(nil, nil, time.Hour, shared)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scfgs.strikeRegister.shared).To(Equal(shared))
+		Expect(scfgs.strikeRegister.orbit).To(Equal(scfgs.obit))
+	})
+
+	It("uses a random orbit", func() {
+		scfgs, err := NewServerConfigStore(nil, nil, time.Hour, nil)
+		Expect(err).ToNot(HaveOccurred())
+		scfgs2, err := NewServerConfigStore(nil, nil, time.Hour, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scfgs.obit).To(HaveLen(8))
+		Expect(scfgs.obit).ToNot(Equal(scfgs2.obit))
+	})
+
+	It("errors on orbits with an invalid length", func() {
+		_, err := NewServerConfigStore(nil, []byte("orbit"), time.Hour, nil)
+		Expect(err).To(MatchError("ServerConfigStore: orbit must be 8 bytes"))
+	})
+
+	It("errors if the rotation interval is not positive", func() {
+		_, err := NewServerConfigStore(nil, nil, 0, nil)
+		Expect(err).To(MatchError("ServerConfigStore: rotation interval must be positive"))
+	})
+
+	It("returns nil for unknown server config IDs", func() {
+		Expect(scfgs.Get([]byte("unknown"))).To(BeNil())
+	})
+
+	It("doesn't rotate the primary server config before the rotation interval", func() {
+		scfg := scfgs.Primary()
+		Expect(scfgs.Primary()).To(Equal(scfg))
+	})
+
+	It("keeps the server config of the previous rotation interval", func() {
+		previous, err := scfgs.deriveServerConfig(scfgs.primaryEpoch - 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scfgs.configs).To(HaveLen(2))
+		Expect(scfgs.Get(previous.ID)).ToNot(BeNil())
+		Expect(scfgs.Get(previous.ID).Get()).To(Equal(previous.Get()))
+	})
+
+	It("derives the server configs from the secret", func() {
+		other, err := newServerConfigStore(nil, scfgs.obit, scfgs.scfgSecret, scfgs.stkSecret, time.Hour, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other.Primary().Get()).To(Equal(scfgs.Primary().Get()))
+		for kexTag, kex := range scfgs.Primary().kexs {
+			Expect(other.Primary().kexs[kexTag].PrivateKey()).To(Equal(kex.PrivateKey()))
+		}
+	})
+
+	It("derives different server configs for different secrets", func() {
+		other, err := NewServerConfigStore(nil, scfgs.obit, time.Hour, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other.Primary().ID).ToNot(Equal(scfgs.Primary().ID))
+		Expect(other.Primary().kexs["C255"].PublicKey()).ToNot(Equal(scfgs.Primary().kexs["C255"].PublicKey()))
+	})
+
+	It("sets the expiry to two rotation intervals after the start of the epoch", func() {
+		scfg, err := scfgs.deriveServerConfig(10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scfg.expiry).To(Equal(time.Unix(0, 12*int64(time.Hour))))
+	})
+
+	Context("rotation", func() {
+		var oldScfg *ServerConfig
+
+		BeforeEach(func() {
+			// go back to the server config of the previous rotation interval
+			Expect(scfgs.rotate(scfgs.primaryEpoch - 1)).To(Succeed())
+			oldScfg = scfgs.primary
+		})
+
+		It("rotates the primary server config", func() {
+			scfg := scfgs.Primary()
+			Expect(scfg.ID).ToNot(Equal(oldScfg.ID))
+			Expect(scfg.kexs["C255"].PublicKey()).ToNot(Equal(oldScfg.kexs["C255"].PublicKey()))
+			Expect(scfgs.Primary()).To(Equal(scfg))
+		})
+
+		It("keeps older server configs until they expire", func() {
+			scfg := scfgs.Primary()
+			Expect(scfgs.Get(oldScfg.ID)).To(Equal(oldScfg))
+			Expect(scfgs.Get(scfg.ID)).To(Equal(scfg))
+			oldScfg.expiry = time.Now().Add(-time.Second)
+			Expect(scfgs.Get(oldScfg.ID)).To(BeNil())
+		})
+
+		It("removes expired server configs when rotating", func() {
+			oldScfg.expiry = time.Now().Add(-time.Second)
+			scfgs.Primary()
+			Expect(scfgs.configs).ToNot(HaveKey(string(oldScfg.ID)))
+			Expect(scfgs.configs).To(HaveLen(1))
+		})
+
+		It("keeps the primary server config if the rotation fails", func() {
+			scfgs.deriveKEXs = func(io.Reader) (map[string]crypto.KeyExchange, error) {
+				return nil, errors.New("kex error")
+			}
+			Expect(scfgs.Primary()).To(Equal(oldScfg))
+		})
+	})
+})
@@ -2,6 +2,7 @@ package handshake
 
 import (
 	"bytes"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/crypto"
 
@@ -13,22 +14,68 @@ var _ = Describe("ServerConfig", func() {
 	var (
 		kex  crypto.KeyExchange
 		scfg *ServerConfig
+		obit = []byte("orbitorb")
 	)
 
 	BeforeEach(func() {
 		var err error
 		kex, err = crypto.NewCurve25519KEX()
 		Expect(err).NotTo(HaveOccurred())
-		scfg, err = NewServerConfig(kex, nil)
+		scfg, err = newServerConfig([]byte("0123456789abcdef"), map[string]crypto.KeyExchange{"C255": kex}, nil, obit, time.Time{})
 		Expect(err).NotTo(HaveOccurred())
 	})
 
 	It("gets the proper binary representation", func() {
-		expected := bytes.NewBuffer([]byte{0x53, 0x43, 0x46, 0x47, 0x6, 0x0, 0x0, 0x0, 0x41, 0x45, 0x41, 0x44, 0x4, 0x0, 0x0, 0x0, 0x53, 0x43, 0x49, 0x44, 0x14, 0x0, 0x0, 0x0, 0x50, 0x55, 0x42, 0x53, 0x37, 0x0, 0x0, 0x0, 0x4b, 0x45, 0x58, 0x53, 0x3b, 0x0, 0x0, 0x0, 0x4f, 0x42, 0x49, 0x54, 0x43, 0x0, 0x0, 0x0, 0x45, 0x58, 0x50, 0x59, 0x4b, 0x0, 0x0, 0x0, 0x41, 0x45, 0x53, 0x47})
+		expected := bytes.NewBuffer([]byte{0x53, 0x43, 0x46, 0x47, 0x6, 0x0, 0x0, 0x0, 0x41, 0x45, 0x41, 0x44, 0x8, 0x0, 0x0, 0x0, 0x53, 0x43, 0x49, 0x44, 0x18, 0x0, 0x0, 0x0, 0x50, 0x55, 0x42, 0x53, 0x3b, 0x0, 0x0, 0x0, 0x4b, 0x45, 0x58, 0x53, 0x3f, 0x0, 0x0, 0x0, 0x4f, 0x42, 0x49, 0x54, 0x47, 0x0, 0x0, 0x0, 0x45, 0x58, 0x50, 0x59, 0x4f, 0x0, 0x0, 0x0, 0x41, 0x45, 0x53, 0x47, 0x43, 0x43, 0x32, 0x30})
 		expected.Write(scfg.ID)
 		expected.Write([]byte{0x20, 0x0, 0x0})
 		expected.Write(kex.PublicKey())
-		expected.Write([]byte{0x43, 0x32, 0x35, 0x35, 0x0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+		expected.Write([]byte{0x43, 0x32, 0x35, 0x35})
+		expected.Write(scfg.obit)
+		expected.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
 		Expect(scfg.Get()).To(Equal(expected.Bytes()))
 	})
+	It("offers multiple key exchanges, with one public value each", func() {
+		p256, err := crypto.NewP256KEX()
+		Expect(err).ToNot(HaveOccurred())
+		scfg, err = newServerConfig([]byte("0123456789abcdef"), map[string]crypto.KeyExchange{"P256": p256, "C255": kex}, nil, obit, time.Time{})
+		Expect(err).ToNot(HaveOccurred())
+		tag, tagMap, err := ParseHandshakeMessage(bytes.NewReader(scfg.Get()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tag).To(Equal(TagSCFG))
+		Expect(tagMap[TagKEXS]).To(Equal([]byte("C255P256")))
+		expectedPubs := append([]byte{0x20, 0x0, 0x0}, kex.PublicKey()...)
+		expectedPubs = append(expectedPubs, 0x41, 0x0, 0x0)
+		expectedPubs = append(expectedPubs, p256.PublicKey()...)
+		Expect(tagMap[TagPUBS]).To(Equal(expectedPubs))
+	})
+
+	It("errors without a key exchange", func() {
+		_, err := newServerConfig([]byte("0123456789abcdef"), map[string]crypto.KeyExchange{}, nil, obit, time.Time{})
+		Expect(err).To(MatchError("ServerConfig: no key exchange"))
+	})
+
+	It("errors on unsupported key exchanges", func() {
+		_, err := newServerConfig([]byte("0123456789abcdef"), map[string]crypto.KeyExchange{"FOOB": kex}, nil, obit, time.Time{})
+		Expect(err).To(MatchError("ServerConfig: unsupported key exchange FOOB"))
+	})
+	It("sends the expiry time", func() {
+		expiry := time.Unix(1500000000, 0)
+		scfg, err := newServerConfig([]byte("0123456789abcdef"), map[string]crypto.KeyExchange{"C255": kex}, nil, obit, expiry)
+		Expect(err).ToNot(HaveOccurred())
+		_, tagMap, err := ParseHandshakeMessage(bytes.NewReader(scfg.Get()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tagMap[TagEXPY]).To(Equal([]byte{0x0, 0x2f, 0x68, 0x59, 0x0, 0x0, 0x0, 0x0}))
+	})
+
+	It("never expires without an expiry time", func() {
+		Expect(scfg.IsExpired()).To(BeFalse())
+	})
+
+	It("expires", func() {
+		scfg.expiry = time.Now().Add(time.Hour)
+		Expect(scfg.IsExpired()).To(BeFalse())
+		scfg.expiry = time.Now().Add(-time.Second)
+		Expect(scfg.IsExpired()).To(BeTrue())
+	})
 })
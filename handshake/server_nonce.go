@@ -0,0 +1,71 @@
+package handshake
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// A serverNonceSource creates the server nonces sent in the REJ, and verifies them when the client sends them back in its next CHLO
+// A valid server nonce proves that the CHLO was sent in response to a REJ of this server instance,
+// so the CHLO can be accepted even if its client nonce is not newer than the horizon of the strike register, e.g. right after a restart.
+// Every server nonce is only accepted once.
+type serverNonceSource struct {
+	orbit    []byte
+	box      crypto.StkSource
+	register *strikeRegister
+}
+
+func newServerNonceSource(orbit []byte, now time.Time) (*serverNonceSource, error) {
+	// the secret is never exported, so server nonces created before a restart can't be verified afterwards
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	box, err := crypto.NewStkSource(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &serverNonceSource{
+		orbit: orbit,
+		box:   box,
+		// all server nonces are created after now, so the horizon doesn't need to be in the future
+		register: newStrikeRegister(orbit, protocol.StrikeRegisterWindow, protocol.StrikeRegisterMaxEntries, now.Add(-protocol.StrikeRegisterWindow-time.Second)),
+	}, nil
+}
+
+// New creates a new server nonce
+// It consists of a nonce in the format of a client nonce, followed by a token authenticating it.
+func (s *serverNonceSource) New(now time.Time) ([]byte, error) {
+	nonce := make([]byte, clientNonceLen)
+	binary.BigEndian.PutUint32(nonce, uint32(now.Unix()))
+	copy(nonce[4:12], s.orbit)
+	if _, err := rand.Read(nonce[12:]); err != nil {
+		return nil, err
+	}
+	token, err := s.box.NewToken(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, token...), nil
+}
+
+// Verify checks that a server nonce was created by this source, and that it wasn't used before
+func (s *serverNonceSource) Verify(data []byte, now time.Time) handshakeFailureReason {
+	if len(data) < clientNonceLen || s.box.VerifyToken(data[:clientNonceLen], data[clientNonceLen:]) != nil {
+		return serverNonceDecryptionFailure
+	}
+	switch s.register.Insert(data[:clientNonceLen], now) {
+	case handshakeOK:
+		return handshakeOK
+	case clientNonceNotUniqueFailure:
+		return serverNonceNotUniqueFailure
+	case clientNonceInvalidTimeFailure:
+		return serverNonceInvalidTimeFailure
+	default:
+		return serverNonceInvalidFailure
+	}
+}
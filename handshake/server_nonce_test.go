@@ -0,0 +1,63 @@
+package handshake
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server nonces", func() {
+	var (
+		source *serverNonceSource
+		now    time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		now = time.Now()
+		source, err = newServerNonceSource([]byte("orbitorb"), now)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("accepts a server nonce created right after startup", func() {
+		sno, err := source.New(now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source.Verify(sno, now)).To(Equal(handshakeOK))
+	})
+
+	It("accepts every server nonce only once", func() {
+		sno, err := source.New(now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source.Verify(sno, now)).To(Equal(handshakeOK))
+		Expect(source.Verify(sno, now.Add(time.Second))).To(Equal(serverNonceNotUniqueFailure))
+	})
+
+	It("rejects server nonces of a different source", func() {
+		// e.g. a server nonce that was created before a restart
+		otherSource, err := newServerNonceSource([]byte("orbitorb"), now)
+		Expect(err).ToNot(HaveOccurred())
+		sno, err := otherSource.New(now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source.Verify(sno, now)).To(Equal(serverNonceDecryptionFailure))
+	})
+
+	It("rejects server nonces that were modified", func() {
+		sno, err := source.New(now)
+		Expect(err).ToNot(HaveOccurred())
+		sno[0] ^= 0xff
+		Expect(source.Verify(sno, now)).To(Equal(serverNonceDecryptionFailure))
+	})
+
+	It("rejects server nonces that are too short", func() {
+		Expect(source.Verify([]byte("foobar"), now)).To(Equal(serverNonceDecryptionFailure))
+		Expect(source.Verify(nil, now)).To(Equal(serverNonceDecryptionFailure))
+	})
+
+	It("rejects old server nonces", func() {
+		sno, err := source.New(now)
+		Expect(err).ToNot(HaveOccurred())
+		later := now.Add(2 * time.Hour)
+		Expect(source.Verify(sno, later)).To(Equal(serverNonceInvalidTimeFailure))
+	})
+})
@@ -0,0 +1,96 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+)
+
+// PEM block types and headers used when exporting the server secrets
+const (
+	pemTypeServerConfigSecret = "QUIC SERVER CONFIG SECRET"
+	pemTypeStkSecret          = "QUIC STK SECRET"
+
+	pemHeaderOrbit            = "Orbit"
+	pemHeaderRotationInterval = "Rotation-Interval"
+)
+
+// Export serializes the secret that the server configs are derived from, together with the orbit and the rotation interval, and the secret used for source address tokens
+// The PEM encoded result can be loaded with NewServerConfigStoreFromPEM, so that multiple servers use the same server configs.
+// Since these servers also share the orbit, the store has to use a shared strike register.
+// The result contains private keys, and must be kept secret.
+func (s *ServerConfigStore) Export() ([]byte, error) {
+	if s.strikeRegister.shared == nil {
+		return nil, errors.New("ServerConfigStore: only server configs that use a shared strike register can be exported")
+	}
+
+	var out bytes.Buffer
+	if err := pem.Encode(&out, &pem.Block{
+		Type: pemTypeServerConfigSecret,
+		Headers: map[string]string{
+			pemHeaderOrbit:            hex.EncodeToString(s.obit),
+			pemHeaderRotationInterval: s.rotationInterval.String(),
+		},
+		Bytes: s.scfgSecret,
+	}); err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(&out, &pem.Block{Type: pemTypeStkSecret, Bytes: s.stkSecret}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// NewServerConfigStoreFromPEM creates a server config store from the secrets exported by ServerConfigStore.Export
+// It uses the same server configs as the exporting store, and rotates them at the same time, using the exported rotation interval.
+// All stores loading the same data must use the same shared strike register, since they share the orbit.
+func NewServerConfigStoreFromPEM(signer crypto.Signer, data []byte, sharedRegister StrikeRegister) (*ServerConfigStore, error) {
+	if sharedRegister == nil {
+		return nil, errors.New("ServerConfigStore: imported server configs require a shared strike register")
+	}
+
+	var scfgBlock *pem.Block
+	var stkSecret []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case pemTypeServerConfigSecret:
+			if scfgBlock != nil {
+				return nil, errors.New("ServerConfigStore: multiple server config secrets")
+			}
+			scfgBlock = block
+		case pemTypeStkSecret:
+			if stkSecret != nil {
+				return nil, errors.New("ServerConfigStore: multiple STK secrets")
+			}
+			stkSecret = block.Bytes
+		default:
+			return nil, fmt.Errorf("ServerConfigStore: unexpected PEM block %s", block.Type)
+		}
+	}
+
+	if scfgBlock == nil || len(scfgBlock.Bytes) == 0 {
+		return nil, errors.New("ServerConfigStore: no server config secret")
+	}
+	if len(stkSecret) == 0 {
+		return nil, errors.New("ServerConfigStore: no STK secret")
+	}
+	obit, err := hex.DecodeString(scfgBlock.Headers[pemHeaderOrbit])
+	if err != nil || len(obit) != 8 {
+		return nil, errors.New("ServerConfigStore: invalid orbit")
+	}
+	rotationInterval, err := time.ParseDuration(scfgBlock.Headers[pemHeaderRotationInterval])
+	if err != nil {
+		return nil, errors.New("ServerConfigStore: invalid rotation interval")
+	}
+	return newServerConfigStore(signer, obit, scfgBlock.Bytes, stkSecret, rotationInterval, sharedRegister)
+}
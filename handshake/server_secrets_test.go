@@ -0,0 +1,175 @@
+package handshake
+
+import (
+	"encoding/pem"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server secrets", func() {
+	var (
+		scfgs  *ServerConfigStore
+		signer crypto.Signer
+		shared *mockSharedStrikeRegister
+	)
+
+	BeforeEach(func() {
+		var err error
+		signer = &mockSigner{}
+		shared = &mockSharedStrikeRegister{}
+		scfgs, err = NewServerConfigStore(signer, []byte("orbitorb"), time.Hour, shared)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("exports and imports the server configs", func() {
+		data, err := scfgs.Export()
+		Expect(err).ToNot(HaveOccurred())
+		imported, err := NewServerConfigStoreFromPEM(signer, data, shared)
+		Expect(err).ToNot(HaveOccurred())
+		primary := imported.Primary()
+		Expect(primary.Get()).To(Equal(scfgs.Primary().Get()))
+		Expect(primary.signer).To(Equal(signer))
+		Expect(imported.obit).To(Equal([]byte("orbitorb")))
+		Expect(imported.rotationInterval).To(Equal(time.Hour))
+		Expect(imported.strikeRegister.orbit).To(Equal([]byte("orbitorb")))
+		Expect(imported.strikeRegister.shared).To(Equal(shared))
+	})
+
+	It("uses the same private keys", func() {
+		data, err := scfgs.Export()
+		Expect(err).ToNot(HaveOccurred())
+		imported, err := NewServerConfigStoreFromPEM(signer, data, shared)
+		Expect(err).ToNot(HaveOccurred())
+		for kexTag, kex := range scfgs.Primary().kexs {
+			Expect(imported.Primary().kexs[kexTag].PrivateKey()).To(Equal(kex.PrivateKey()))
+		}
+	})
+
+	It("shares the STK secret", func() {
+		data, err := scfgs.Export()
+		Expect(err).ToNot(HaveOccurred())
+		imported, err := NewServerConfigStoreFromPEM(signer, data, shared)
+		Expect(err).ToNot(HaveOccurred())
+		token, err := scfgs.stkSource.NewToken([]byte("ip"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(imported.stkSource.VerifyToken([]byte("ip"), token)).To(Succeed())
+	})
+
+	It("accepts the server config of the previous rotation interval", func() {
+		previous, err := scfgs.deriveServerConfig(scfgs.primaryEpoch - 1)
+		Expect(err).ToNot(HaveOccurred())
+		data, err := scfgs.Export()
+		Expect(err).ToNot(HaveOccurred())
+		imported, err := NewServerConfigStoreFromPEM(signer, data, shared)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(imported.Get(previous.ID)).ToNot(BeNil())
+		Expect(imported.Get(previous.ID).Get()).To(Equal(previous.Get()))
+	})
+
+	It("rotates to the same server configs as the exporting store", func() {
+		data, err := scfgs.Export()
+		Expect(err).ToNot(HaveOccurred())
+		imported, err := NewServerConfigStoreFromPEM(signer, data, shared)
+		Expect(err).ToNot(HaveOccurred())
+		primary := imported.Primary()
+		// simulate the start of the next rotation interval
+		for _, s := range []*ServerConfigStore{scfgs, imported} {
+			Expect(s.rotate(s.primaryEpoch + 1)).To(Succeed())
+		}
+		Expect(imported.Primary().ID).ToNot(Equal(primary.ID))
+		Expect(imported.Primary().Get()).To(Equal(scfgs.Primary().Get()))
+		for kexTag, kex := range scfgs.Primary().kexs {
+			Expect(imported.Primary().kexs[kexTag].PrivateKey()).To(Equal(kex.PrivateKey()))
+		}
+	})
+
+	It("doesn't export server configs that don't use a shared strike register", func() {
+		scfgs, err := NewServerConfigStore(signer, []byte("orbitorb"), time.Hour, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = scfgs.Export()
+		Expect(err).To(MatchError("ServerConfigStore: only server configs that use a shared strike register can be exported"))
+	})
+
+	Context("errors", func() {
+		var scfgBlock, stkBlock []byte
+
+		encodeScfgBlock := func(headers map[string]string, secret []byte) []byte {
+			return pem.EncodeToMemory(&pem.Block{Type: pemTypeServerConfigSecret, Headers: headers, Bytes: secret})
+		}
+
+		BeforeEach(func() {
+			scfgBlock = encodeScfgBlock(map[string]string{
+				pemHeaderOrbit:            "6f72626974",
+				pemHeaderRotationInterval: "1h0m0s",
+			}, scfgs.scfgSecret)
+			stkBlock = pem.EncodeToMemory(&pem.Block{Type: pemTypeStkSecret, Bytes: scfgs.stkSecret})
+		})
+
+		It("errors without a shared strike register", func() {
+			data, err := scfgs.Export()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = NewServerConfigStoreFromPEM(signer, data, nil)
+			Expect(err).To(MatchError("ServerConfigStore: imported server configs require a shared strike register"))
+		})
+
+		It("errors without a server config secret", func() {
+			_, err := NewServerConfigStoreFromPEM(signer, stkBlock, shared)
+			Expect(err).To(MatchError("ServerConfigStore: no server config secret"))
+		})
+
+		It("errors without an STK secret", func() {
+			data, err := scfgs.Export()
+			Expect(err).ToNot(HaveOccurred())
+			block, _ := pem.Decode(data)
+			_, err = NewServerConfigStoreFromPEM(signer, pem.EncodeToMemory(block), shared)
+			Expect(err).To(MatchError("ServerConfigStore: no STK secret"))
+		})
+
+		It("errors on multiple server config secrets", func() {
+			data, err := scfgs.Export()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = NewServerConfigStoreFromPEM(signer, append(data, scfgBlock...), shared)
+			Expect(err).To(MatchError("ServerConfigStore: multiple server config secrets"))
+		})
+
+		It("errors on multiple STK secrets", func() {
+			data, err := scfgs.Export()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = NewServerConfigStoreFromPEM(signer, append(data, stkBlock...), shared)
+			Expect(err).To(MatchError("ServerConfigStore: multiple STK secrets"))
+		})
+
+		It("errors on unexpected PEM blocks", func() {
+			data, err := scfgs.Export()
+			Expect(err).ToNot(HaveOccurred())
+			block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("foobar")})
+			_, err = NewServerConfigStoreFromPEM(signer, append(data, block...), shared)
+			Expect(err).To(MatchError("ServerConfigStore: unexpected PEM block CERTIFICATE"))
+		})
+
+		It("errors on invalid orbits", func() {
+			// the orbit is only 5 bytes long
+			_, err := NewServerConfigStoreFromPEM(signer, append(scfgBlock, stkBlock...), shared)
+			Expect(err).To(MatchError("ServerConfigStore: invalid orbit"))
+			scfgBlock = encodeScfgBlock(map[string]string{pemHeaderOrbit: "foobar", pemHeaderRotationInterval: "1h0m0s"}, scfgs.scfgSecret)
+			_, err = NewServerConfigStoreFromPEM(signer, append(scfgBlock, stkBlock...), shared)
+			Expect(err).To(MatchError("ServerConfigStore: invalid orbit"))
+		})
+
+		It("errors on invalid rotation intervals", func() {
+			scfgBlock = encodeScfgBlock(map[string]string{pemHeaderOrbit: "6f726269746f7262"}, scfgs.scfgSecret)
+			_, err := NewServerConfigStoreFromPEM(signer, append(scfgBlock, stkBlock...), shared)
+			Expect(err).To(MatchError("ServerConfigStore: invalid rotation interval"))
+		})
+
+		It("errors if the rotation interval is not positive", func() {
+			scfgBlock = encodeScfgBlock(map[string]string{pemHeaderOrbit: "6f726269746f7262", pemHeaderRotationInterval: "0s"}, scfgs.scfgSecret)
+			_, err := NewServerConfigStoreFromPEM(signer, append(scfgBlock, stkBlock...), shared)
+			Expect(err).To(MatchError("ServerConfigStore: rotation interval must be positive"))
+		})
+	})
+})
@@ -0,0 +1,197 @@
+package handshake
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// handshakeFailureReason is sent in the RREJ tag of a REJ, to tell the client why its CHLO was rejected
+// Values taken from Chrome.
+type handshakeFailureReason uint32
+
+const (
+	handshakeOK                      handshakeFailureReason = 0
+	clientNonceInvalidFailure        handshakeFailureReason = 2
+	clientNonceNotUniqueFailure      handshakeFailureReason = 3
+	clientNonceInvalidOrbitFailure   handshakeFailureReason = 4
+	clientNonceInvalidTimeFailure    handshakeFailureReason = 5
+	clientNonceStrikeRegisterFailure handshakeFailureReason = 7
+	serverNonceDecryptionFailure     handshakeFailureReason = 8
+	serverNonceInvalidFailure        handshakeFailureReason = 9
+	serverNonceNotUniqueFailure      handshakeFailureReason = 10
+	serverNonceInvalidTimeFailure    handshakeFailureReason = 11
+)
+
+func (r handshakeFailureReason) String() string {
+	switch r {
+	case handshakeOK:
+		return "handshake OK"
+	case clientNonceInvalidFailure:
+		return "invalid client nonce"
+	case clientNonceNotUniqueFailure:
+		return "client nonce not unique"
+	case clientNonceInvalidOrbitFailure:
+		return "invalid orbit in client nonce"
+	case clientNonceInvalidTimeFailure:
+		return "invalid time in client nonce"
+	case clientNonceStrikeRegisterFailure:
+		return "strike register failure"
+	case serverNonceDecryptionFailure:
+		return "server nonce decryption failure"
+	case serverNonceInvalidFailure:
+		return "invalid server nonce"
+	case serverNonceNotUniqueFailure:
+		return "server nonce not unique"
+	case serverNonceInvalidTimeFailure:
+		return "invalid time in server nonce"
+	default:
+		return fmt.Sprintf("unknown handshake failure reason %d", uint32(r))
+	}
+}
+
+// a client nonce consists of a 4 byte timestamp, the 8 byte orbit and 20 random bytes
+const clientNonceLen = 32
+
+type strikeRegisterEntry struct {
+	timestamp uint32
+	nonce     [clientNonceLen]byte
+}
+
+// strikeRegisterQueue is a min-heap of the remembered nonces, ordered by their timestamp
+type strikeRegisterQueue []strikeRegisterEntry
+
+func (q strikeRegisterQueue) Len() int            { return len(q) }
+func (q strikeRegisterQueue) Less(i, j int) bool  { return q[i].timestamp < q[j].timestamp }
+func (q strikeRegisterQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *strikeRegisterQueue) Push(x interface{}) { *q = append(*q, x.(strikeRegisterEntry)) }
+func (q *strikeRegisterQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// A StrikeRegister records the client nonces of all servers that use the same orbit
+// Servers that share an orbit, e.g. because they load the same server secrets, have to share a StrikeRegister,
+// typically one backed by a database. Otherwise a 0-RTT CHLO accepted by one server can be replayed to another one.
+type StrikeRegister interface {
+	// Insert records a client nonce. It returns false if the nonce was already recorded.
+	// The nonce has to be remembered until expiry, later it is rejected because of its timestamp.
+	Insert(nonce []byte, expiry time.Time) (bool, error)
+}
+
+// A strikeRegister makes sure that every client nonce is only accepted once, which prevents the replay of 0-RTT CHLOs
+// It only accepts nonces for its orbit, with a timestamp within the window around the current time.
+// The nonces in the window are remembered, up to maxEntries. When the register is full, the oldest nonce is evicted,
+// and the horizon is moved to its timestamp: nonces that are not newer than the horizon are rejected from then on.
+// The register is kept in memory, so nonces accepted before a restart of the server are forgotten.
+// To prevent replays across a restart, the horizon initially is set to the start time plus the window,
+// such that no nonce can be accepted that could have been used before the register was created.
+// Until then, clients can only complete the handshake using a server nonce, see serverNonceSource.
+// If a shared StrikeRegister is used, the nonces are recorded there instead, and there is no horizon.
+type strikeRegister struct {
+	mutex sync.Mutex
+
+	orbit      []byte
+	window     time.Duration
+	maxEntries int
+	shared     StrikeRegister
+
+	horizon uint32
+	nonces  map[[clientNonceLen]byte]struct{}
+	queue   strikeRegisterQueue
+}
+
+func newStrikeRegister(orbit []byte, window time.Duration, maxEntries int, now time.Time) *strikeRegister {
+	return &strikeRegister{
+		orbit:      orbit,
+		window:     window,
+		maxEntries: maxEntries,
+		horizon:    uint32(now.Add(window).Unix()),
+		nonces:     make(map[[clientNonceLen]byte]struct{}),
+	}
+}
+
+// newSharedStrikeRegister creates a strike register that checks the orbit and the timestamp of client nonces, and records them in the shared register
+func newSharedStrikeRegister(orbit []byte, window time.Duration, shared StrikeRegister) *strikeRegister {
+	return &strikeRegister{
+		orbit:  orbit,
+		window: window,
+		shared: shared,
+	}
+}
+
+// Insert checks if a client nonce is valid and wasn't used before
+// If it is, it is remembered and handshakeOK is returned.
+func (s *strikeRegister) Insert(nonce []byte, now time.Time) handshakeFailureReason {
+	if len(nonce) != clientNonceLen {
+		return clientNonceInvalidFailure
+	}
+	if !bytes.Equal(nonce[4:12], s.orbit) {
+		return clientNonceInvalidOrbitFailure
+	}
+	timestamp := binary.BigEndian.Uint32(nonce[:4])
+	nonceTime := time.Unix(int64(timestamp), 0)
+	if nonceTime.Before(now.Add(-s.window)) || nonceTime.After(now.Add(s.window)) {
+		return clientNonceInvalidTimeFailure
+	}
+
+	if s.shared != nil {
+		unique, err := s.shared.Insert(nonce, nonceTime.Add(s.window))
+		if err != nil {
+			utils.Errorf("Strike register failure: %s", err.Error())
+			return clientNonceStrikeRegisterFailure
+		}
+		if !unique {
+			return clientNonceNotUniqueFailure
+		}
+		return handshakeOK
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if timestamp <= s.horizon {
+		return clientNonceInvalidTimeFailure
+	}
+
+	var entry strikeRegisterEntry
+	entry.timestamp = timestamp
+	copy(entry.nonce[:], nonce)
+	if _, ok := s.nonces[entry.nonce]; ok {
+		return clientNonceNotUniqueFailure
+	}
+
+	// nonces outside of the window are rejected anyway, so they don't need to be remembered
+	minTimestamp := now.Add(-s.window).Unix()
+	for len(s.queue) > 0 && int64(s.queue[0].timestamp) < minTimestamp {
+		s.evictOldest()
+	}
+	if s.maxEntries <= 0 {
+		return clientNonceStrikeRegisterFailure
+	}
+	for len(s.queue) >= s.maxEntries {
+		if evicted := s.evictOldest(); evicted.timestamp > s.horizon {
+			s.horizon = evicted.timestamp
+		}
+	}
+	if timestamp <= s.horizon {
+		return clientNonceInvalidTimeFailure
+	}
+
+	s.nonces[entry.nonce] = struct{}{}
+	heap.Push(&s.queue, entry)
+	return handshakeOK
+}
+
+func (s *strikeRegister) evictOldest() strikeRegisterEntry {
+	entry := heap.Pop(&s.queue).(strikeRegisterEntry)
+	delete(s.nonces, entry.nonce)
+	return entry
+}
@@ -0,0 +1,196 @@
+package handshake
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockSharedStrikeRegister struct {
+	nonces map[string]time.Time
+	err    error
+}
+
+var _ StrikeRegister = &mockSharedStrikeRegister{}
+
+func (r *mockSharedStrikeRegister) Insert(nonce []byte, expiry time.Time) (bool, error) {
+	if r.err != nil {
+		return false, r.err
+	}
+	if r.nonces == nil {
+		r.nonces = make(map[string]time.Time)
+	}
+	if _, ok := r.nonces[string(nonce)]; ok {
+		return false, nil
+	}
+	r.nonces[string(nonce)] = expiry
+	return true, nil
+}
+
+var _ = Describe("Strike register", func() {
+	var (
+		register *strikeRegister
+		orbit    []byte
+		now      time.Time
+	)
+
+	newNonce := func(t time.Time) []byte {
+		nonce := make([]byte, 32)
+		binary.BigEndian.PutUint32(nonce, uint32(t.Unix()))
+		copy(nonce[4:12], orbit)
+		rand.Read(nonce[12:])
+		return nonce
+	}
+
+	BeforeEach(func() {
+		orbit = []byte("orbitorb")
+		now = time.Unix(1500000000, 0)
+		// start the register long enough ago, so that the initial horizon is in the past
+		register = newStrikeRegister(orbit, time.Minute, 3, now.Add(-time.Hour))
+	})
+
+	It("accepts a valid nonce", func() {
+		Expect(register.Insert(newNonce(now), now)).To(Equal(handshakeOK))
+	})
+
+	It("rejects a nonce that was already used", func() {
+		nonce := newNonce(now)
+		Expect(register.Insert(nonce, now)).To(Equal(handshakeOK))
+		Expect(register.Insert(nonce, now.Add(time.Second))).To(Equal(clientNonceNotUniqueFailure))
+	})
+
+	It("rejects nonces with an invalid length", func() {
+		Expect(register.Insert(newNonce(now)[:31], now)).To(Equal(clientNonceInvalidFailure))
+		Expect(register.Insert(nil, now)).To(Equal(clientNonceInvalidFailure))
+	})
+
+	It("rejects nonces for a different orbit", func() {
+		nonce := newNonce(now)
+		nonce[4] ^= 0xff
+		Expect(register.Insert(nonce, now)).To(Equal(clientNonceInvalidOrbitFailure))
+	})
+
+	It("rejects nonces outside of the window", func() {
+		Expect(register.Insert(newNonce(now.Add(-61*time.Second)), now)).To(Equal(clientNonceInvalidTimeFailure))
+		Expect(register.Insert(newNonce(now.Add(61*time.Second)), now)).To(Equal(clientNonceInvalidTimeFailure))
+		Expect(register.Insert(newNonce(now.Add(-59*time.Second)), now)).To(Equal(handshakeOK))
+		Expect(register.Insert(newNonce(now.Add(59*time.Second)), now)).To(Equal(handshakeOK))
+	})
+
+	It("forgets nonces that left the window", func() {
+		nonce := newNonce(now)
+		Expect(register.Insert(nonce, now)).To(Equal(handshakeOK))
+		later := now.Add(2 * time.Minute)
+		Expect(register.Insert(newNonce(later), later)).To(Equal(handshakeOK))
+		Expect(register.nonces).To(HaveLen(1))
+		Expect(register.horizon).To(Equal(uint32(now.Add(-59 * time.Minute).Unix())))
+		// the old nonce is still rejected, since it's outside of the window
+		Expect(register.Insert(nonce, later)).To(Equal(clientNonceInvalidTimeFailure))
+	})
+
+	Context("after a restart", func() {
+		It("sets the horizon to the start time plus the window", func() {
+			register = newStrikeRegister(orbit, time.Minute, 3, now)
+			Expect(register.horizon).To(Equal(uint32(now.Add(time.Minute).Unix())))
+		})
+
+		It("rejects nonces that could have been accepted before the restart", func() {
+			nonce := newNonce(now)
+			Expect(register.Insert(nonce, now)).To(Equal(handshakeOK))
+			// the server restarts, and the replayed nonce is still inside the window
+			restart := now.Add(10 * time.Second)
+			register = newStrikeRegister(orbit, time.Minute, 3, restart)
+			Expect(register.Insert(nonce, restart)).To(Equal(clientNonceInvalidTimeFailure))
+			Expect(register.Insert(newNonce(restart), restart)).To(Equal(clientNonceInvalidTimeFailure))
+			Expect(register.Insert(newNonce(restart.Add(time.Minute)), restart)).To(Equal(clientNonceInvalidTimeFailure))
+		})
+
+		It("accepts nonces once the window has passed", func() {
+			register = newStrikeRegister(orbit, time.Minute, 3, now)
+			later := now.Add(time.Minute + time.Second)
+			Expect(register.Insert(newNonce(later), later)).To(Equal(handshakeOK))
+		})
+	})
+
+	Context("when full", func() {
+		BeforeEach(func() {
+			for i := 0; i < 3; i++ {
+				t := now.Add(time.Duration(i) * time.Second)
+				Expect(register.Insert(newNonce(t), now)).To(Equal(handshakeOK))
+			}
+			Expect(register.nonces).To(HaveLen(3))
+		})
+
+		It("evicts the oldest nonce and moves the horizon", func() {
+			Expect(register.Insert(newNonce(now.Add(10*time.Second)), now)).To(Equal(handshakeOK))
+			Expect(register.nonces).To(HaveLen(3))
+			Expect(register.horizon).To(Equal(uint32(now.Unix())))
+		})
+
+		It("rejects nonces that are not newer than the horizon", func() {
+			Expect(register.Insert(newNonce(now.Add(10*time.Second)), now)).To(Equal(handshakeOK))
+			Expect(register.Insert(newNonce(now), now)).To(Equal(clientNonceInvalidTimeFailure))
+			Expect(register.Insert(newNonce(now.Add(-time.Second)), now)).To(Equal(clientNonceInvalidTimeFailure))
+			Expect(register.Insert(newNonce(now.Add(5*time.Second)), now)).To(Equal(handshakeOK))
+		})
+
+		It("rejects a nonce that would be evicted right away", func() {
+			Expect(register.Insert(newNonce(now.Add(-10*time.Second)), now)).To(Equal(clientNonceInvalidTimeFailure))
+			Expect(register.nonces).To(HaveLen(2))
+		})
+	})
+
+	It("rejects all nonces if it can't remember any", func() {
+		register = newStrikeRegister(orbit, time.Minute, 0, now.Add(-time.Hour))
+		Expect(register.Insert(newNonce(now), now)).To(Equal(clientNonceStrikeRegisterFailure))
+	})
+
+	Context("using a shared strike register", func() {
+		var shared *mockSharedStrikeRegister
+
+		BeforeEach(func() {
+			shared = &mockSharedStrikeRegister{}
+			register = newSharedStrikeRegister(orbit, time.Minute, shared)
+		})
+
+		It("records nonces in the shared register, until they leave the window", func() {
+			nonce := newNonce(now)
+			Expect(register.Insert(nonce, now)).To(Equal(handshakeOK))
+			Expect(shared.nonces).To(HaveKeyWithValue(string(nonce), now.Add(time.Minute)))
+			Expect(register.nonces).To(BeEmpty())
+		})
+
+		It("rejects a nonce that was already used by another server", func() {
+			nonce := newNonce(now)
+			other := newSharedStrikeRegister(orbit, time.Minute, shared)
+			Expect(other.Insert(nonce, now)).To(Equal(handshakeOK))
+			Expect(register.Insert(nonce, now)).To(Equal(clientNonceNotUniqueFailure))
+		})
+
+		It("doesn't have a horizon", func() {
+			Expect(register.Insert(newNonce(now.Add(-59*time.Second)), now)).To(Equal(handshakeOK))
+		})
+
+		It("checks the orbit and the timestamp before using the shared register", func() {
+			nonce := newNonce(now)
+			nonce[4] ^= 0xff
+			Expect(register.Insert(nonce, now)).To(Equal(clientNonceInvalidOrbitFailure))
+			Expect(register.Insert(newNonce(now.Add(-61*time.Second)), now)).To(Equal(clientNonceInvalidTimeFailure))
+			Expect(shared.nonces).To(BeEmpty())
+		})
+
+		It("rejects nonces if the shared register fails", func() {
+			shared.err = errors.New("database unavailable")
+			Expect(register.Insert(newNonce(now), now)).To(Equal(clientNonceStrikeRegisterFailure))
+		})
+	})
+
+	It("has a string representation for the failure reasons", func() {
+		Expect(clientNonceNotUniqueFailure.String()).To(Equal("client nonce not unique"))
+		Expect(handshakeFailureReason(100).String()).To(Equal("unknown handshake failure reason 100"))
+	})
+})
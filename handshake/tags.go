@@ -74,6 +74,8 @@ const (
 	TagEXPY Tag = 'E' + 'X'<<8 + 'P'<<16 + 'Y'<<24
 	// TagCERT is the CERT data
 	TagCERT Tag = 0xff545243
+	// TagRREJ is the list of reasons why a CHLO was rejected, sent in the REJ
+	TagRREJ Tag = 'R' + 'R'<<8 + 'E'<<16 + 'J'<<24
 
 	// TagSHLO is the server hello
 	TagSHLO Tag = 'S' + 'H'<<8 + 'L'<<16 + 'O'<<24
@@ -84,4 +86,6 @@ const (
 	TagRSEQ Tag = 'R' + 'S'<<8 + 'E'<<16 + 'Q'<<24
 	// TagRNON is the public reset nonce
 	TagRNON Tag = 'R' + 'N'<<8 + 'O'<<16 + 'N'<<24
+	// TagCADR is the client address observed by the server, sent in a public reset
+	TagCADR Tag = 'C' + 'A'<<8 + 'D'<<16 + 'R'<<24
 )
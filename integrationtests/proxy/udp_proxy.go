@@ -126,7 +126,7 @@ func (p *UDPProxy) runProxy() error {
 
 		raw := buffer[0:n]
 		r := bytes.NewReader(raw)
-		hdr, err := quic.ParsePublicHeader(r)
+		hdr, err := quic.ParsePublicHeader(r, protocol.PerspectiveClient)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,74 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// The mtuDiscoverer finds the largest packet size that can be sent on a path
+// It sends probe packets padded to the probe size, and does a binary search between the largest acknowledged and the smallest lost probe.
+type mtuDiscoverer struct {
+	// current is the largest packet size that is known to work
+	current protocol.ByteCount
+	// max is the largest packet size that might still work
+	max protocol.ByteCount
+
+	probeInFlight bool
+	probeNumber   protocol.PacketNumber
+	probeSize     protocol.ByteCount
+}
+
+func newMTUDiscoverer(current, max protocol.ByteCount) *mtuDiscoverer {
+	return &mtuDiscoverer{
+		current: current,
+		max:     max,
+	}
+}
+
+// ShouldSendProbe says if a probe packet should be sent
+// Only one probe is in flight at any time.
+func (d *mtuDiscoverer) ShouldSendProbe() bool {
+	return !d.probeInFlight && d.max-d.current >= protocol.PMTUDiscoveryGranularity
+}
+
+// NextProbeSize gets the size of the next probe packet
+func (d *mtuDiscoverer) NextProbeSize() protocol.ByteCount {
+	return (d.current + d.max + 1) / 2
+}
+
+// SentProbe is called when a probe packet was sent
+func (d *mtuDiscoverer) SentProbe(packetNumber protocol.PacketNumber, size protocol.ByteCount) {
+	d.probeInFlight = true
+	d.probeNumber = packetNumber
+	d.probeSize = size
+}
+
+// ProbeLost is called when a probe packet is lost, or couldn't be sent at all
+func (d *mtuDiscoverer) ProbeLost() {
+	utils.Debugf("PMTU discovery: probe of %d bytes lost", d.probeSize)
+	d.probeInFlight = false
+	d.max = d.probeSize - 1
+}
+
+// ReceivedAck processes an ACK frame
+// If the probe was acknowledged, it returns the new maximum packet size.
+// A probe is considered lost if a packet sent after it is acknowledged, but the probe is not.
+func (d *mtuDiscoverer) ReceivedAck(frame *frames.AckFrame) (protocol.ByteCount, bool) {
+	if !d.probeInFlight || frame.LargestAcked < d.probeNumber {
+		return 0, false
+	}
+	if !frame.AcksPacket(d.probeNumber) {
+		d.ProbeLost()
+		return 0, false
+	}
+	utils.Debugf("PMTU discovery: probe of %d bytes acknowledged", d.probeSize)
+	d.probeInFlight = false
+	d.current = d.probeSize
+	return d.current, true
+}
+
+// CurrentSize returns the largest packet size that is known to work
+func (d *mtuDiscoverer) CurrentSize() protocol.ByteCount {
+	return d.current
+}
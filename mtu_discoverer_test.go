@@ -0,0 +1,84 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MTU Discoverer", func() {
+	var d *mtuDiscoverer
+
+	BeforeEach(func() {
+		d = newMTUDiscoverer(1000, 2000)
+	})
+
+	ackProbe := func() (protocol.ByteCount, bool) {
+		return d.ReceivedAck(&frames.AckFrame{LargestAcked: d.probeNumber, LowestAcked: 1})
+	}
+
+	It("probes the size in the middle of the search interval", func() {
+		Expect(d.CurrentSize()).To(Equal(protocol.ByteCount(1000)))
+		Expect(d.ShouldSendProbe()).To(BeTrue())
+		Expect(d.NextProbeSize()).To(Equal(protocol.ByteCount(1500)))
+	})
+
+	It("only sends one probe at a time", func() {
+		d.SentProbe(10, 1500)
+		Expect(d.ShouldSendProbe()).To(BeFalse())
+	})
+
+	It("increases the size when the probe is acknowledged", func() {
+		d.SentProbe(10, 1500)
+		size, ok := ackProbe()
+		Expect(ok).To(BeTrue())
+		Expect(size).To(Equal(protocol.ByteCount(1500)))
+		Expect(d.CurrentSize()).To(Equal(protocol.ByteCount(1500)))
+		Expect(d.ShouldSendProbe()).To(BeTrue())
+		Expect(d.NextProbeSize()).To(Equal(protocol.ByteCount(1750)))
+	})
+
+	It("ignores ACKs for packets sent before the probe", func() {
+		d.SentProbe(10, 1500)
+		_, ok := d.ReceivedAck(&frames.AckFrame{LargestAcked: 9, LowestAcked: 1})
+		Expect(ok).To(BeFalse())
+		Expect(d.ShouldSendProbe()).To(BeFalse())
+	})
+
+	It("declares the probe lost when a later packet is acknowledged", func() {
+		d.SentProbe(10, 1500)
+		_, ok := d.ReceivedAck(&frames.AckFrame{
+			LargestAcked: 11,
+			LowestAcked:  1,
+			AckRanges: []frames.AckRange{
+				{FirstPacketNumber: 11, LastPacketNumber: 11},
+				{FirstPacketNumber: 1, LastPacketNumber: 9},
+			},
+		})
+		Expect(ok).To(BeFalse())
+		Expect(d.CurrentSize()).To(Equal(protocol.ByteCount(1000)))
+		Expect(d.ShouldSendProbe()).To(BeTrue())
+		Expect(d.NextProbeSize()).To(Equal(protocol.ByteCount(1250)))
+	})
+
+	It("stops probing when the search interval is small enough", func() {
+		sizes := []protocol.ByteCount{}
+		var pn protocol.PacketNumber
+		for d.ShouldSendProbe() {
+			pn++
+			size := d.NextProbeSize()
+			sizes = append(sizes, size)
+			d.SentProbe(pn, size)
+			// the path MTU is 1400 bytes
+			if size <= 1400 {
+				ackProbe()
+			} else {
+				d.ProbeLost()
+			}
+		}
+		Expect(len(sizes)).To(BeNumerically("<", 10))
+		Expect(d.CurrentSize()).To(BeNumerically("<=", 1400))
+		Expect(d.CurrentSize()).To(BeNumerically(">", 1400-protocol.PMTUDiscoveryGranularity))
+	})
+})
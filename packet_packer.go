@@ -8,6 +8,7 @@ import (
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
 )
 
 type packedPacket struct {
@@ -18,8 +19,9 @@ type packedPacket struct {
 
 type packetPacker struct {
 	connectionID protocol.ConnectionID
+	perspective  protocol.Perspective
 	version      protocol.VersionNumber
-	cryptoSetup  *handshake.CryptoSetup
+	cryptoSetup  handshake.CryptoSetup
 
 	packetNumberGenerator *packetNumberGenerator
 
@@ -27,16 +29,20 @@ type packetPacker struct {
 
 	streamFramer  *streamFramer
 	controlFrames []frames.Frame
+
+	maxPacketSize protocol.ByteCount
 }
 
-func newPacketPacker(connectionID protocol.ConnectionID, cryptoSetup *handshake.CryptoSetup, connectionParametersHandler *handshake.ConnectionParametersManager, streamFramer *streamFramer, version protocol.VersionNumber) *packetPacker {
+func newPacketPacker(connectionID protocol.ConnectionID, cryptoSetup handshake.CryptoSetup, connectionParametersHandler *handshake.ConnectionParametersManager, streamFramer *streamFramer, perspective protocol.Perspective, version protocol.VersionNumber, maxPacketSize protocol.ByteCount) *packetPacker {
 	return &packetPacker{
 		cryptoSetup:                 cryptoSetup,
 		connectionID:                connectionID,
+		perspective:                 perspective,
 		connectionParametersManager: connectionParametersHandler,
 		version:                     version,
 		streamFramer:                streamFramer,
 		packetNumberGenerator:       newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength),
+		maxPacketSize:               maxPacketSize,
 	}
 }
 
@@ -53,23 +59,16 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, con
 		p.controlFrames = append(p.controlFrames, controlFrames...)
 	}
 
-	currentPacketNumber := p.packetNumberGenerator.Peek()
-
 	// cryptoSetup needs to be locked here, so that the AEADs are not changed between
 	// calling DiversificationNonce() and Seal().
 	p.cryptoSetup.LockForSealing()
 	defer p.cryptoSetup.UnlockForSealing()
 
-	packetNumberLen := protocol.GetPacketNumberLengthForPublicHeader(currentPacketNumber, leastUnacked)
-	responsePublicHeader := &PublicHeader{
-		ConnectionID:         p.connectionID,
-		PacketNumber:         currentPacketNumber,
-		PacketNumberLen:      packetNumberLen,
-		TruncateConnectionID: p.connectionParametersManager.TruncateConnectionID(),
-		DiversificationNonce: p.cryptoSetup.DiversificationNonce(),
-	}
+	responsePublicHeader := p.getPublicHeader(leastUnacked)
+	currentPacketNumber := responsePublicHeader.PacketNumber
+	packetNumberLen := responsePublicHeader.PacketNumberLen
 
-	publicHeaderLength, err := responsePublicHeader.GetLength()
+	publicHeaderLength, err := responsePublicHeader.GetLength(p.perspective)
 	if err != nil {
 		return nil, err
 	}
@@ -110,10 +109,66 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, con
 		}
 	}
 
-	raw := getPacketBuffer()
+	return p.writeAndSealPacket(responsePublicHeader, payloadFrames, 0)
+}
+
+// PackPMTUProbe packs a packet that only contains a PING frame, padded to the given size
+// The size may be larger than the maximum packet size.
+func (p *packetPacker) PackPMTUProbe(size protocol.ByteCount, leastUnacked protocol.PacketNumber) (*packedPacket, error) {
+	p.cryptoSetup.LockForSealing()
+	defer p.cryptoSetup.UnlockForSealing()
+
+	return p.writeAndSealPacket(p.getPublicHeader(leastUnacked), []frames.Frame{&frames.PingFrame{}}, size)
+}
+
+// PackAckPacket packs a packet that only contains an ACK frame, and a STOP_WAITING frame if one is passed
+func (p *packetPacker) PackAckPacket(stopWaitingFrame *frames.StopWaitingFrame, ack *frames.AckFrame, leastUnacked protocol.PacketNumber) (*packedPacket, error) {
+	p.cryptoSetup.LockForSealing()
+	defer p.cryptoSetup.UnlockForSealing()
+
+	responsePublicHeader := p.getPublicHeader(leastUnacked)
+	var payloadFrames []frames.Frame
+	if stopWaitingFrame != nil {
+		stopWaitingFrame.PacketNumber = responsePublicHeader.PacketNumber
+		stopWaitingFrame.PacketNumberLen = responsePublicHeader.PacketNumberLen
+		payloadFrames = append(payloadFrames, stopWaitingFrame)
+	}
+	payloadFrames = append(payloadFrames, ack)
+	return p.writeAndSealPacket(responsePublicHeader, payloadFrames, 0)
+}
+
+// SetMaxPacketSize sets the maximum size of the packets, e.g. when a larger path MTU was discovered
+func (p *packetPacker) SetMaxPacketSize(size protocol.ByteCount) {
+	p.maxPacketSize = size
+}
+
+// getPublicHeader gets the public header for the next packet
+// The cryptoSetup must be locked for sealing when calling this function.
+func (p *packetPacker) getPublicHeader(leastUnacked protocol.PacketNumber) *PublicHeader {
+	currentPacketNumber := p.packetNumberGenerator.Peek()
+
+	// the client sends the version number in every packet, until the handshake is complete
+	sendVersion := p.perspective == protocol.PerspectiveClient && !p.cryptoSetup.HandshakeComplete()
+
+	return &PublicHeader{
+		ConnectionID:         p.connectionID,
+		PacketNumber:         currentPacketNumber,
+		PacketNumberLen:      protocol.GetPacketNumberLengthForPublicHeader(currentPacketNumber, leastUnacked),
+		TruncateConnectionID: p.connectionParametersManager.TruncateConnectionID(),
+		DiversificationNonce: p.cryptoSetup.DiversificationNonce(),
+		VersionFlag:          sendVersion,
+	}
+}
+
+// writeAndSealPacket writes the packet and seals it
+// If paddedLength is larger than the packet, padding is added after the frames.
+func (p *packetPacker) writeAndSealPacket(publicHeader *PublicHeader, payloadFrames []frames.Frame, paddedLength protocol.ByteCount) (*packedPacket, error) {
+	currentPacketNumber := publicHeader.PacketNumber
+
+	raw := getPacketBuffer(utils.MaxByteCount(p.maxPacketSize, paddedLength))
 	buffer := bytes.NewBuffer(raw)
 
-	if err = responsePublicHeader.Write(buffer, p.version); err != nil {
+	if err := publicHeader.Write(buffer, p.version, p.perspective); err != nil {
 		return nil, err
 	}
 
@@ -126,7 +181,18 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, con
 		}
 	}
 
-	if protocol.ByteCount(buffer.Len()+12) > protocol.MaxPacketSize {
+	maxPacketSize := p.maxPacketSize
+	if paddedLength > 0 {
+		if paddedLength > protocol.MaxReceivePacketSize {
+			return nil, errors.New("PacketPacker BUG: padded length too large")
+		}
+		if padding := int(paddedLength) - 12 - buffer.Len(); padding > 0 {
+			buffer.Write(make([]byte, padding))
+		}
+		maxPacketSize = utils.MaxByteCount(maxPacketSize, paddedLength)
+	}
+
+	if protocol.ByteCount(buffer.Len()+12) > maxPacketSize {
 		return nil, errors.New("PacketPacker BUG: packet too large")
 	}
 
@@ -150,7 +216,7 @@ func (p *packetPacker) composeNextPacket(stopWaitingFrame *frames.StopWaitingFra
 	var payloadLength protocol.ByteCount
 	var payloadFrames []frames.Frame
 
-	maxFrameSize := protocol.MaxFrameAndPublicHeaderSize - publicHeaderLength
+	maxFrameSize := p.maxPacketSize - 12 /*crypto signature*/ - publicHeaderLength
 
 	if stopWaitingFrame != nil {
 		payloadFrames = append(payloadFrames, stopWaitingFrame)
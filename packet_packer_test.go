@@ -3,6 +3,7 @@ package quic
 import (
 	"bytes"
 
+	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
@@ -10,6 +11,28 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+type mockCryptoSetup struct {
+	handshakeComplete bool
+	divNonce          []byte
+	nonceProof        uint64
+}
+
+func (m *mockCryptoSetup) HandleCryptoStream() error { panic("not implemented") }
+func (m *mockCryptoSetup) Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
+	return (&crypto.NullAEAD{}).Open(dst, src, packetNumber, associatedData)
+}
+func (m *mockCryptoSetup) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
+	return (&crypto.NullAEAD{}).Seal(dst, src, packetNumber, associatedData)
+}
+func (m *mockCryptoSetup) LockForSealing()                      {}
+func (m *mockCryptoSetup) UnlockForSealing()                    {}
+func (m *mockCryptoSetup) HandshakeComplete() bool              { return m.handshakeComplete }
+func (m *mockCryptoSetup) PublicResetNonceProof() uint64        { return m.nonceProof }
+func (m *mockCryptoSetup) DiversificationNonce() []byte         { return m.divNonce }
+func (m *mockCryptoSetup) SetDiversificationNonce([]byte) error { panic("not implemented") }
+
+var _ handshake.CryptoSetup = &mockCryptoSetup{}
+
 var _ = Describe("Packet packer", func() {
 	var (
 		packer          *packetPacker
@@ -23,13 +46,16 @@ var _ = Describe("Packet packer", func() {
 		fcm.sendWindowSizes[5] = protocol.MaxByteCount
 		fcm.sendWindowSizes[7] = protocol.MaxByteCount
 
-		streamFramer = newStreamFramer(newStreamsMap(nil), fcm)
+		cpm := newDefaultConnectionParametersManager()
+		streamFramer = newStreamFramer(newStreamsMap(nil, protocol.PerspectiveServer, cpm), fcm)
 
 		packer = &packetPacker{
-			cryptoSetup:                 &handshake.CryptoSetup{},
-			connectionParametersManager: handshake.NewConnectionParamatersManager(),
+			cryptoSetup:                 &mockCryptoSetup{},
+			connectionParametersManager: cpm,
 			packetNumberGenerator:       newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength),
 			streamFramer:                streamFramer,
+			perspective:                 protocol.PerspectiveServer,
+			maxPacketSize:               protocol.DefaultMaxPacketSize,
 		}
 		publicHeaderLen = 1 + 8 + 2 // 1 flag byte, 8 connection ID, 2 packet number
 		packer.version = protocol.Version34
@@ -56,6 +82,54 @@ var _ = Describe("Packet packer", func() {
 		Expect(p.raw).To(ContainSubstring(string(b.Bytes())))
 	})
 
+	It("includes a diversification nonce, when acting as a server", func() {
+		packer.perspective = protocol.PerspectiveServer
+		nonce := bytes.Repeat([]byte{'e'}, 32)
+		packer.cryptoSetup.(*mockCryptoSetup).divNonce = nonce
+		f := &frames.StreamFrame{
+			StreamID: 5,
+			Data:     []byte{0xDE, 0xCA, 0xFB, 0xAD},
+		}
+		streamFramer.AddFrameForRetransmission(f)
+		p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
+		Expect(p).ToNot(BeNil())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.raw[9:41]).To(Equal(nonce))
+	})
+
+	Context("version flag", func() {
+		BeforeEach(func() {
+			f := &frames.StreamFrame{
+				StreamID: 5,
+				Data:     []byte{0xDE, 0xCA, 0xFB, 0xAD},
+			}
+			streamFramer.AddFrameForRetransmission(f)
+		})
+
+		It("sets the version flag for the client, before the handshake is complete", func() {
+			packer.perspective = protocol.PerspectiveClient
+			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.raw[0] & 0x01).To(Equal(uint8(0x01)))
+			Expect(p.raw[9:13]).To(Equal([]byte{'Q', '0', '3', '4'}))
+		})
+
+		It("doesn't set the version flag for the client, once the handshake is complete", func() {
+			packer.perspective = protocol.PerspectiveClient
+			packer.cryptoSetup.(*mockCryptoSetup).handshakeComplete = true
+			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.raw[0] & 0x01).To(BeZero())
+		})
+
+		It("never sets the version flag for the server", func() {
+			packer.perspective = protocol.PerspectiveServer
+			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.raw[0] & 0x01).To(BeZero())
+		})
+	})
+
 	It("packs a ConnectionCloseFrame", func() {
 		ccf := frames.ConnectionCloseFrame{
 			ErrorCode:    0x1337,
@@ -78,6 +152,55 @@ var _ = Describe("Packet packer", func() {
 		Expect(p.frames[0]).To(Equal(&ccf))
 	})
 
+	Context("ACK-only packets", func() {
+		It("packs an ACK frame and ignores all other frames", func() {
+			ack := &frames.AckFrame{LargestAcked: 42}
+			streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+			packer.QueueControlFrameForNextPacket(&frames.WindowUpdateFrame{StreamID: 37})
+			p, err := packer.PackAckPacket(nil, ack, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.frames).To(Equal([]frames.Frame{ack}))
+			Expect(streamFramer.HasFramesForRetransmission()).To(BeTrue())
+			Expect(packer.controlFrames).To(HaveLen(1))
+		})
+
+		It("packs a STOP_WAITING frame", func() {
+			ack := &frames.AckFrame{LargestAcked: 42}
+			swf := &frames.StopWaitingFrame{LeastUnacked: 1}
+			p, err := packer.PackAckPacket(swf, ack, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.frames).To(Equal([]frames.Frame{swf, ack}))
+			Expect(swf.PacketNumber).To(Equal(p.number))
+		})
+	})
+
+	Context("path MTU discovery", func() {
+		It("packs a probe padded to the given size", func() {
+			p, err := packer.PackPMTUProbe(5000, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.frames).To(Equal([]frames.Frame{&frames.PingFrame{}}))
+			Expect(p.raw).To(HaveLen(5000))
+			Expect(p.number).To(Equal(protocol.PacketNumber(1)))
+		})
+
+		It("doesn't pack probes larger than the buffer", func() {
+			_, err := packer.PackPMTUProbe(protocol.MaxReceivePacketSize+1, 0)
+			Expect(err).To(MatchError("PacketPacker BUG: padded length too large"))
+		})
+
+		It("sends larger packets after the packet size was increased", func() {
+			packer.SetMaxPacketSize(5000)
+			f := &frames.StreamFrame{
+				StreamID: 5,
+				Data:     bytes.Repeat([]byte{'f'}, 6000),
+			}
+			streamFramer.AddFrameForRetransmission(f)
+			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.raw).To(HaveLen(5000))
+		})
+	})
+
 	It("packs only control frames", func() {
 		p, err := packer.PackPacket(nil, []frames.Frame{&frames.ConnectionCloseFrame{}}, 0, true)
 		Expect(p).ToNot(BeNil())
@@ -98,7 +221,7 @@ var _ = Describe("Packet packer", func() {
 
 	It("packs a StopWaitingFrame first", func() {
 		packer.packetNumberGenerator.next = 15
-		swf := &frames.StopWaitingFrame{LeastUnacked: 10}
+		swf := &frames.StopWaitingFrame{LeastUnacked: 1}
 		p, err := packer.PackPacket(swf, []frames.Frame{&frames.ConnectionCloseFrame{}}, 0, true)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(p).ToNot(BeNil())
@@ -116,7 +239,7 @@ var _ = Describe("Packet packer", func() {
 	})
 
 	It("does not pack a packet containing only a StopWaitingFrame", func() {
-		swf := &frames.StopWaitingFrame{LeastUnacked: 10}
+		swf := &frames.StopWaitingFrame{LeastUnacked: 1}
 		p, err := packer.PackPacket(swf, []frames.Frame{}, 0, true)
 		Expect(p).To(BeNil())
 		Expect(err).ToNot(HaveOccurred())
@@ -220,7 +343,7 @@ var _ = Describe("Packet packer", func() {
 			streamFramer.AddFrameForRetransmission(f2)
 			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(p.raw).To(HaveLen(int(protocol.MaxPacketSize - 1)))
+			Expect(p.raw).To(HaveLen(int(protocol.DefaultMaxPacketSize - 1)))
 			Expect(p.frames).To(HaveLen(1))
 			Expect(p.frames[0].(*frames.StreamFrame).DataLenPresent).To(BeFalse())
 			p, err = packer.PackPacket(nil, []frames.Frame{}, 0, true)
@@ -303,13 +426,13 @@ var _ = Describe("Packet packer", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(p.frames).To(HaveLen(1))
 			Expect(p.frames[0].(*frames.StreamFrame).DataLenPresent).To(BeFalse())
-			Expect(p.raw).To(HaveLen(int(protocol.MaxPacketSize)))
+			Expect(p.raw).To(HaveLen(int(protocol.DefaultMaxPacketSize)))
 			p, err = packer.PackPacket(nil, []frames.Frame{}, 0, true)
 			Expect(p.frames).To(HaveLen(2))
 			Expect(p.frames[0].(*frames.StreamFrame).DataLenPresent).To(BeTrue())
 			Expect(p.frames[1].(*frames.StreamFrame).DataLenPresent).To(BeFalse())
 			Expect(err).ToNot(HaveOccurred())
-			Expect(p.raw).To(HaveLen(int(protocol.MaxPacketSize)))
+			Expect(p.raw).To(HaveLen(int(protocol.DefaultMaxPacketSize)))
 			p, err = packer.PackPacket(nil, []frames.Frame{}, 0, true)
 			Expect(p.frames).To(HaveLen(1))
 			Expect(p.frames[0].(*frames.StreamFrame).DataLenPresent).To(BeFalse())
@@ -331,7 +454,19 @@ var _ = Describe("Packet packer", func() {
 			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(p).ToNot(BeNil())
-			Expect(p.raw).To(HaveLen(int(protocol.MaxPacketSize)))
+			Expect(p.raw).To(HaveLen(int(protocol.DefaultMaxPacketSize)))
+		})
+
+		It("uses the configured maximum packet size", func() {
+			packer.maxPacketSize = 1250
+			f := &frames.StreamFrame{
+				StreamID: 5,
+				Data:     bytes.Repeat([]byte{'f'}, 2000),
+			}
+			streamFramer.AddFrameForRetransmission(f)
+			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.raw).To(HaveLen(1250))
 		})
 
 		It("splits a stream frame larger than the maximum size", func() {
@@ -21,7 +21,7 @@ type packetUnpacker struct {
 }
 
 func (u *packetUnpacker) Unpack(publicHeaderBinary []byte, hdr *PublicHeader, data []byte) (*unpackedPacket, error) {
-	buf := getPacketBuffer()
+	buf := getPacketBuffer(protocol.ByteCount(len(data)))
 	defer putPacketBuffer(buf)
 	decrypted, err := u.aead.Open(buf, data, hdr.PacketNumber, publicHeaderBinary)
 	if err != nil {
@@ -36,12 +36,20 @@ type ByteCount uint64
 // MaxByteCount is the maximum value of a ByteCount
 const MaxByteCount = math.MaxUint64
 
-// MaxPacketSize is the maximum packet size, including the public header
+// DefaultMaxPacketSize is the default maximum size of the packets we send, including the public header
 // This is the value used by Chromium for a QUIC packet sent using IPv6 (for IPv4 it would be 1370)
-const MaxPacketSize ByteCount = 1350
+const DefaultMaxPacketSize ByteCount = 1350
 
-// MaxFrameAndPublicHeaderSize is the maximum size of a QUIC frame plus PublicHeader
-const MaxFrameAndPublicHeaderSize = MaxPacketSize - 12 /*crypto signature*/
+// MaxReceivePacketSize is the maximum size of a packet we accept, including the public header
+// This is the maximum UDP payload for an Ethernet jumbo frame MTU of 9000 bytes with an IPv6 header.
+// It is also the largest packet size that path MTU discovery probes for.
+const MaxReceivePacketSize ByteCount = 8952
+
+// MinMaxPacketSize is the smallest value that the maximum packet size can be configured to
+const MinMaxPacketSize ByteCount = 1200
+
+// MaxFrameAndPublicHeaderSize is the maximum size of a QUIC frame plus PublicHeader, when using the DefaultMaxPacketSize
+const MaxFrameAndPublicHeaderSize = DefaultMaxPacketSize - 12 /*crypto signature*/
 
 // DefaultTCPMSS is the default maximum packet size used in the Linux TCP implementation.
 // Used in QUIC for congestion window computations in bytes.
@@ -62,5 +70,15 @@ const MinRetransmissionTime = 200 * time.Millisecond
 // MaxRetransmissionTime is the maximum RTO time
 const MaxRetransmissionTime = 60 * time.Second
 
+// MaxTailLossProbes is the number of tail loss probes sent before the RTO fires
+const MaxTailLossProbes = 2
+
+// MinTailLossProbeTime is the minimum time before a tail loss probe is sent
+const MinTailLossProbeTime = 10 * time.Millisecond
+
+// PeerDelayedAckTime is the time the peer might delay an ACK when it only received a single packet
+// It is used to calculate the timeout of a tail loss probe, if only one packet is outstanding.
+const PeerDelayedAckTime = 25 * time.Millisecond
+
 // ClientHelloMinimumSize is the minimum size the server expects an inchoate CHLO to have.
 const ClientHelloMinimumSize = 1024
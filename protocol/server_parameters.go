@@ -5,8 +5,8 @@ import "time"
 // DefaultMaxCongestionWindow is the default for the max congestion window
 const DefaultMaxCongestionWindow = 1000
 
-// InitialCongestionWindow is the initial congestion window in QUIC packets
-const InitialCongestionWindow = 32
+// DefaultInitialCongestionWindow is the default initial congestion window in QUIC packets
+const DefaultInitialCongestionWindow = 32
 
 // MaxUndecryptablePackets limits the number of undecryptable packets that a
 // session queues for later until it sends a public reset.
@@ -15,19 +15,19 @@ const MaxUndecryptablePackets = 10
 // AckSendDelay is the maximal time delay applied to packets containing only ACKs
 const AckSendDelay = 5 * time.Millisecond
 
-// ReceiveStreamFlowControlWindow is the stream-level flow control window for receiving data
+// DefaultReceiveStreamFlowControlWindow is the default stream-level flow control window for receiving data
 // This is the value that Google servers are using
-const ReceiveStreamFlowControlWindow ByteCount = (1 << 20) // 1 MB
+const DefaultReceiveStreamFlowControlWindow ByteCount = (1 << 20) // 1 MB
 
-// ReceiveConnectionFlowControlWindow is the stream-level flow control window for receiving data
+// DefaultReceiveConnectionFlowControlWindow is the default connection-level flow control window for receiving data
 // This is the value that Google servers are using
-const ReceiveConnectionFlowControlWindow ByteCount = (1 << 20) * 1.5 // 1.5 MB
+const DefaultReceiveConnectionFlowControlWindow ByteCount = (1 << 20) * 1.5 // 1.5 MB
 
-// MaxStreamsPerConnection is the maximum value accepted for the number of streams per connection
-const MaxStreamsPerConnection = 100
+// DefaultMaxStreamsPerConnection is the default maximum value accepted for the number of streams per connection
+const DefaultMaxStreamsPerConnection = 100
 
-// MaxIncomingDynamicStreams is the maximum value accepted for the incoming number of dynamic streams per connection
-const MaxIncomingDynamicStreams = 100
+// DefaultMaxIncomingDynamicStreams is the default maximum value accepted for the incoming number of dynamic streams per connection
+const DefaultMaxIncomingDynamicStreams = 100
 
 // MaxStreamsMultiplier is the slack the client is allowed for the maximum number of streams per connection, needed e.g. when packets are out of order or dropped. The minimum of this procentual increase and the absolute increment specified by MaxStreamsMinimumIncrement is used.
 const MaxStreamsMultiplier = 1.1
@@ -35,16 +35,20 @@ const MaxStreamsMultiplier = 1.1
 // MaxStreamsMinimumIncrement is the slack the client is allowed for the maximum number of streams per connection, needed e.g. when packets are out of order or dropped. The minimum of this absolute increment and the procentual increase specified by MaxStreamsMultiplier is used.
 const MaxStreamsMinimumIncrement = 10
 
-// MaxNewStreamIDDelta is the maximum difference between and a newly opened Stream and the highest StreamID that a client has ever opened
-// note that the number of streams is half this value, since the client can only open streams with open StreamID
-const MaxNewStreamIDDelta = 4 * MaxStreamsPerConnection
+// PacketBatchSize is the maximum number of packets that are read or written with a single syscall
+const PacketBatchSize = 64
 
-// MaxSessionUnprocessedPackets is the max number of packets stored in each session that are not yet processed.
-const MaxSessionUnprocessedPackets = DefaultMaxCongestionWindow
+// DefaultAcceptQueueSize is the default maximum number of sessions that the server queues for accepting
+// If the queue is full, new sessions are closed as soon as they complete the handshake.
+const DefaultAcceptQueueSize = 32
 
 // RetransmissionThreshold + 1 is the number of times a packet has to be NACKed so that it gets retransmitted
 const RetransmissionThreshold = 3
 
+// PMTUDiscoveryGranularity is the precision of path MTU discovery
+// No more probes are sent once the largest confirmed packet size and the smallest lost probe are closer than this.
+const PMTUDiscoveryGranularity ByteCount = 20
+
 // SkipPacketAveragePeriodLength is the average period length in which one packet number is skipped to prevent an Optimistic ACK attack
 const SkipPacketAveragePeriodLength PacketNumber = 500
 
@@ -54,7 +58,7 @@ const MaxTrackedSkippedPackets = 10
 // STKExpiryTimeSec is the valid time of a source address token in seconds
 const STKExpiryTimeSec = 24 * 60 * 60
 
-// MaxTrackedSentPackets is maximum number of sent packets saved for either later retransmission or entropy calculation
+// MaxTrackedSentPackets is maximum number of sent packets saved for either later retransmission or entropy calculation, when using the DefaultMaxCongestionWindow
 const MaxTrackedSentPackets = 2 * DefaultMaxCongestionWindow
 
 // MaxTrackedReceivedPackets is the maximum number of received packets saved for doing the entropy calculations
@@ -74,6 +78,21 @@ const CryptoMaxParams = 128
 // CryptoParameterMaxLength is the upper limit for the length of a parameter in a crypto message.
 const CryptoParameterMaxLength = 2000
 
+// MaxClientHellos is the maximum number of times we're willing to send a CHLO, before giving up on the handshake
+const MaxClientHellos = 3
+
+// StrikeRegisterWindow is the maximum difference between the timestamp in a client nonce and the server's time
+// Value taken from Chrome.
+const StrikeRegisterWindow = 10 * time.Minute
+
+// DefaultServerConfigRotationInterval is the default interval after which the server replaces its primary server config
+// Older server configs are still accepted for two rotation intervals after their creation.
+const DefaultServerConfigRotationInterval = 24 * time.Hour
+
+// StrikeRegisterMaxEntries is the maximum number of client nonces remembered by the strike register
+// Value taken from Chrome.
+const StrikeRegisterMaxEntries = 1 << 10
+
 // EphermalKeyLifetime is the lifetime of the ephermal key during the handshake, see handshake.getEphermalKEX.
 const EphermalKeyLifetime = time.Minute
 
@@ -83,11 +102,14 @@ const InitialIdleTimeout = 5 * time.Second
 // DefaultIdleTimeout is the default idle timeout.
 const DefaultIdleTimeout = 30 * time.Second
 
-// MaxIdleTimeout is the maximum idle timeout that can be negotiated.
-const MaxIdleTimeout = 1 * time.Minute
+// DefaultMaxIdleTimeout is the default maximum idle timeout that can be negotiated.
+const DefaultMaxIdleTimeout = 1 * time.Minute
+
+// DefaultHandshakeTimeout is the default timeout for a connection until the crypto handshake succeeds.
+const DefaultHandshakeTimeout = 10 * time.Second
 
-// MaxTimeForCryptoHandshake is the default timeout for a connection until the crypto handshake succeeds.
-const MaxTimeForCryptoHandshake = 10 * time.Second
+// DefaultClosedSessionTimeout is the default time the server keeps the connection ID of a closed session, to answer late packets.
+const DefaultClosedSessionTimeout = time.Minute
 
 // NumCachedCertificates is the number of cached compressed certificate chains, each taking ~1K space
 const NumCachedCertificates = 128
@@ -49,6 +49,21 @@ func IsSupportedVersion(v VersionNumber) bool {
 	return false
 }
 
+// HighestSupportedVersion finds the highest version number that is both present in other and in SupportedVersions
+// the versions in other do not need to be ordered
+// it returns true and the version number, if there is one, otherwise false
+func HighestSupportedVersion(other []VersionNumber) (bool, VersionNumber) {
+	for i := len(SupportedVersions) - 1; i >= 0; i-- {
+		for _, ver := range other {
+			if ver == SupportedVersions[i] {
+				return true, ver
+			}
+		}
+	}
+
+	return false, 0
+}
+
 func init() {
 	var b bytes.Buffer
 	for _, v := range SupportedVersions {
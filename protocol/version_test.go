@@ -28,4 +28,45 @@ var _ = Describe("Version", func() {
 		Expect(IsSupportedVersion(0)).To(BeFalse())
 		Expect(IsSupportedVersion(SupportedVersions[0])).To(BeTrue())
 	})
+
+	Context("highest supported version", func() {
+		var initialSupportedVersions []VersionNumber
+
+		BeforeEach(func() {
+			initialSupportedVersions = make([]VersionNumber, len(SupportedVersions))
+			copy(initialSupportedVersions, SupportedVersions)
+		})
+
+		AfterEach(func() {
+			SupportedVersions = initialSupportedVersions
+		})
+
+		It("finds the supported version", func() {
+			SupportedVersions = []VersionNumber{1, 2, 3}
+			other := []VersionNumber{3, 6, 2, 5}
+			found, ver := HighestSupportedVersion(other)
+			Expect(found).To(BeTrue())
+			Expect(ver).To(Equal(VersionNumber(3)))
+		})
+
+		It("picks the highest version, even if the other side lists it first", func() {
+			SupportedVersions = []VersionNumber{34, 35, 36}
+			other := []VersionNumber{35, 34, 36}
+			found, ver := HighestSupportedVersion(other)
+			Expect(found).To(BeTrue())
+			Expect(ver).To(Equal(VersionNumber(36)))
+		})
+
+		It("handles empty inputs", func() {
+			SupportedVersions = []VersionNumber{101, 102}
+			Expect(HighestSupportedVersion([]VersionNumber{})).To(BeFalse())
+			SupportedVersions = []VersionNumber{}
+			Expect(HighestSupportedVersion([]VersionNumber{1, 2})).To(BeFalse())
+		})
+
+		It("returns false if no version is supported", func() {
+			SupportedVersions = []VersionNumber{1, 2}
+			Expect(HighestSupportedVersion([]VersionNumber{3, 4})).To(BeFalse())
+		})
+	})
 })
@@ -16,6 +16,7 @@ var (
 	errReceivedTruncatedConnectionID  = qerr.Error(qerr.InvalidPacketHeader, "receiving packets with truncated ConnectionID is not supported")
 	errInvalidConnectionID            = qerr.Error(qerr.InvalidPacketHeader, "connection ID cannot be 0")
 	errGetLengthOnlyForRegularPackets = errors.New("PublicHeader: GetLength can only be called for regular packets")
+	errInvalidDiversificationNonce    = errors.New("PublicHeader: invalid diversification nonce length")
 )
 
 // The PublicHeader of a QUIC packet
@@ -29,10 +30,13 @@ type PublicHeader struct {
 	PacketNumber         protocol.PacketNumber
 	VersionNumber        protocol.VersionNumber
 	DiversificationNonce []byte
+	// SupportedVersions is only set for version negotiation packets sent by the server
+	SupportedVersions []protocol.VersionNumber
 }
 
-// Write writes a public header
-func (h *PublicHeader) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+// Write writes a public header.
+// If the VersionFlag is set, a client writes the version number, while a server writes a version negotiation packet header.
+func (h *PublicHeader) Write(b *bytes.Buffer, version protocol.VersionNumber, pers protocol.Perspective) error {
 	publicFlagByte := uint8(0x00)
 	if h.VersionFlag && h.ResetFlag {
 		return errResetAndVersionFlagSet
@@ -49,12 +53,14 @@ func (h *PublicHeader) Write(b *bytes.Buffer, version protocol.VersionNumber) er
 
 	if len(h.DiversificationNonce) > 0 {
 		if len(h.DiversificationNonce) != 32 {
-			return errors.New("invalid diversification nonce length")
+			return errInvalidDiversificationNonce
 		}
 		publicFlagByte |= 0x04
 	}
 
-	if !h.ResetFlag && !h.VersionFlag {
+	// a packet sent by the client with the VersionFlag set still carries a packet number
+	isVersionNegotiation := h.VersionFlag && pers == protocol.PerspectiveServer
+	if !h.ResetFlag && !isVersionNegotiation {
 		switch h.PacketNumberLen {
 		case protocol.PacketNumberLen1:
 			publicFlagByte |= 0x00
@@ -73,11 +79,15 @@ func (h *PublicHeader) Write(b *bytes.Buffer, version protocol.VersionNumber) er
 		utils.WriteUint64(b, uint64(h.ConnectionID))
 	}
 
+	if h.VersionFlag && pers == protocol.PerspectiveClient {
+		utils.WriteUint32(b, protocol.VersionNumberToTag(version))
+	}
+
 	if len(h.DiversificationNonce) > 0 {
 		b.Write(h.DiversificationNonce)
 	}
 
-	if !h.ResetFlag && !h.VersionFlag {
+	if !h.ResetFlag && !isVersionNegotiation {
 		switch h.PacketNumberLen {
 		case protocol.PacketNumberLen1:
 			b.WriteByte(uint8(h.PacketNumber))
@@ -95,8 +105,9 @@ func (h *PublicHeader) Write(b *bytes.Buffer, version protocol.VersionNumber) er
 	return nil
 }
 
-// ParsePublicHeader parses a QUIC packet's public header
-func ParsePublicHeader(b io.ByteReader) (*PublicHeader, error) {
+// ParsePublicHeader parses a QUIC packet's public header.
+// The packetSentBy is the perspective of the peer that sent this packet.
+func ParsePublicHeader(b io.ByteReader, packetSentBy protocol.Perspective) (*PublicHeader, error) {
 	header := &PublicHeader{}
 
 	// First byte
@@ -138,6 +149,23 @@ func ParsePublicHeader(b io.ByteReader) (*PublicHeader, error) {
 		return nil, errInvalidConnectionID
 	}
 
+	// Public resets don't carry a packet number
+	if header.ResetFlag && packetSentBy == protocol.PerspectiveServer {
+		return header, nil
+	}
+
+	// A version negotiation packet sent by the server lists the versions it supports
+	if header.VersionFlag && packetSentBy == protocol.PerspectiveServer {
+		for {
+			versionTag, err := utils.ReadUint32(b)
+			if err != nil {
+				break
+			}
+			header.SupportedVersions = append(header.SupportedVersions, protocol.VersionTagToNumber(versionTag))
+		}
+		return header, nil
+	}
+
 	// Version (optional)
 	if header.VersionFlag {
 		var versionTag uint32
@@ -148,6 +176,17 @@ func ParsePublicHeader(b io.ByteReader) (*PublicHeader, error) {
 		header.VersionNumber = protocol.VersionTagToNumber(versionTag)
 	}
 
+	// Diversification nonce (optional), only sent by the server
+	if packetSentBy == protocol.PerspectiveServer && publicFlagByte&0x04 > 0 {
+		header.DiversificationNonce = make([]byte, 32)
+		for i := range header.DiversificationNonce {
+			header.DiversificationNonce[i], err = b.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	// Packet number
 	packetNumber, err := utils.ReadUintN(b, uint8(header.PacketNumberLen))
 	if err != nil {
@@ -160,8 +199,8 @@ func ParsePublicHeader(b io.ByteReader) (*PublicHeader, error) {
 
 // GetLength gets the length of the publicHeader in bytes
 // can only be called for regular packets
-func (h *PublicHeader) GetLength() (protocol.ByteCount, error) {
-	if h.VersionFlag || h.ResetFlag {
+func (h *PublicHeader) GetLength(pers protocol.Perspective) (protocol.ByteCount, error) {
+	if h.ResetFlag || (h.VersionFlag && pers == protocol.PerspectiveServer) {
 		return 0, errGetLengthOnlyForRegularPackets
 	}
 
@@ -172,6 +211,9 @@ func (h *PublicHeader) GetLength() (protocol.ByteCount, error) {
 	if !h.TruncateConnectionID {
 		length += 8 // 8 bytes for the connection ID
 	}
+	if h.VersionFlag {
+		length += 4
+	}
 	length += protocol.ByteCount(len(h.DiversificationNonce))
 	length += protocol.ByteCount(h.PacketNumberLen)
 	return length, nil
@@ -12,7 +12,7 @@ var _ = Describe("Public Header", func() {
 	Context("when parsing", func() {
 		It("accepts a sample client header", func() {
 			b := bytes.NewReader([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x34, 0x01})
-			hdr, err := ParsePublicHeader(b)
+			hdr, err := ParsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.VersionFlag).To(BeTrue())
 			Expect(hdr.ResetFlag).To(BeFalse())
@@ -24,19 +24,19 @@ var _ = Describe("Public Header", func() {
 
 		It("does not accept 0-byte connection ID", func() {
 			b := bytes.NewReader([]byte{0x00, 0x01})
-			_, err := ParsePublicHeader(b)
+			_, err := ParsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).To(MatchError(errReceivedTruncatedConnectionID))
 		})
 
 		It("rejects 0 as a connection ID", func() {
 			b := bytes.NewReader([]byte{0x09, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x51, 0x30, 0x33, 0x30, 0x01})
-			_, err := ParsePublicHeader(b)
+			_, err := ParsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).To(MatchError(errInvalidConnectionID))
 		})
 
 		It("accepts 1-byte packet numbers", func() {
 			b := bytes.NewReader([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xde})
-			hdr, err := ParsePublicHeader(b)
+			hdr, err := ParsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0xde)))
 			Expect(b.Len()).To(BeZero())
@@ -44,7 +44,7 @@ var _ = Describe("Public Header", func() {
 
 		It("accepts 2-byte packet numbers", func() {
 			b := bytes.NewReader([]byte{0x18, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xde, 0xca})
-			hdr, err := ParsePublicHeader(b)
+			hdr, err := ParsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0xcade)))
 			Expect(b.Len()).To(BeZero())
@@ -52,7 +52,7 @@ var _ = Describe("Public Header", func() {
 
 		It("accepts 4-byte packet numbers", func() {
 			b := bytes.NewReader([]byte{0x28, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xad, 0xfb, 0xca, 0xde})
-			hdr, err := ParsePublicHeader(b)
+			hdr, err := ParsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0xdecafbad)))
 			Expect(b.Len()).To(BeZero())
@@ -60,7 +60,7 @@ var _ = Describe("Public Header", func() {
 
 		It("accepts 6-byte packet numbers", func() {
 			b := bytes.NewReader([]byte{0x38, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x23, 0x42, 0xad, 0xfb, 0xca, 0xde})
-			hdr, err := ParsePublicHeader(b)
+			hdr, err := ParsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0xdecafbad4223)))
 			Expect(b.Len()).To(BeZero())
@@ -71,9 +71,39 @@ var _ = Describe("Public Header", func() {
 				0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1,
 				0x01,
 			})
-			_, err := ParsePublicHeader(b)
+			_, err := ParsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).To(MatchError("diversification nonces should only be sent by servers"))
 		})
+
+		Context("packets sent by the server", func() {
+			It("reads the diversification nonce", func() {
+				divNonce := bytes.Repeat([]byte{2}, 32)
+				b := bytes.NewReader(append(append([]byte{0x0c, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c}, divNonce...), 0x37))
+				hdr, err := ParsePublicHeader(b, protocol.PerspectiveServer)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hdr.DiversificationNonce).To(Equal(divNonce))
+				Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0x37)))
+				Expect(b.Len()).To(BeZero())
+			})
+
+			It("parses version negotiation packets", func() {
+				b := bytes.NewReader([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 'Q', '0', '3', '4', 'Q', '0', '3', '6'})
+				hdr, err := ParsePublicHeader(b, protocol.PerspectiveServer)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hdr.VersionFlag).To(BeTrue())
+				Expect(hdr.SupportedVersions).To(Equal([]protocol.VersionNumber{protocol.Version34, protocol.Version36}))
+				Expect(b.Len()).To(BeZero())
+			})
+
+			It("parses public reset packets", func() {
+				b := bytes.NewReader([]byte{0x0a, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 'P', 'R', 'S', 'T'})
+				hdr, err := ParsePublicHeader(b, protocol.PerspectiveServer)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hdr.ResetFlag).To(BeTrue())
+				Expect(hdr.ConnectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+				Expect(b.Len()).To(Equal(4))
+			})
+		})
 	})
 
 	Context("when writing", func() {
@@ -84,7 +114,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    2,
 				PacketNumberLen: protocol.PacketNumberLen6,
 			}
-			hdr.Write(b, protocol.Version35)
+			hdr.Write(b, protocol.Version35, protocol.PerspectiveServer)
 			Expect(b.Bytes()).To(Equal([]byte{0x38, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 2, 0, 0, 0, 0, 0}))
 		})
 
@@ -96,13 +126,26 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    2,
 				PacketNumberLen: protocol.PacketNumberLen6,
 			}
-			hdr.Write(b, protocol.VersionWhatever)
+			hdr.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer)
 			// must be the first assertion
 			Expect(b.Len()).To(Equal(1 + 8)) // 1 FlagByte + 8 ConnectionID
 			firstByte, _ := b.ReadByte()
 			Expect(firstByte & 0x01).To(Equal(uint8(1)))
 		})
 
+		It("writes the version number for packets sent by the client", func() {
+			b := &bytes.Buffer{}
+			hdr := PublicHeader{
+				VersionFlag:     true,
+				ConnectionID:    0x4cfa9f9b668619f6,
+				PacketNumber:    2,
+				PacketNumberLen: protocol.PacketNumberLen1,
+			}
+			err := hdr.Write(b, protocol.Version34, protocol.PerspectiveClient)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b.Bytes()).To(Equal([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 'Q', '0', '3', '4', 0x02}))
+		})
+
 		It("sets the Reset Flag", func() {
 			b := &bytes.Buffer{}
 			hdr := PublicHeader{
@@ -111,7 +154,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    2,
 				PacketNumberLen: protocol.PacketNumberLen6,
 			}
-			hdr.Write(b, protocol.VersionWhatever)
+			hdr.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer)
 			// must be the first assertion
 			Expect(b.Len()).To(Equal(1 + 8)) // 1 FlagByte + 8 ConnectionID
 			firstByte, _ := b.ReadByte()
@@ -127,7 +170,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    2,
 				PacketNumberLen: protocol.PacketNumberLen6,
 			}
-			err := hdr.Write(b, protocol.VersionWhatever)
+			err := hdr.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer)
 			Expect(err).To(MatchError(errResetAndVersionFlagSet))
 		})
 
@@ -139,7 +182,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumberLen:      protocol.PacketNumberLen6,
 				PacketNumber:         1,
 			}
-			err := hdr.Write(b, protocol.VersionWhatever)
+			err := hdr.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(b.Bytes()).To(Equal([]byte{0x30, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0}))
 		})
@@ -151,7 +194,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    1,
 				PacketNumberLen: protocol.PacketNumberLen1,
 			}
-			err := hdr.Write(b, protocol.Version35)
+			err := hdr.Write(b, protocol.Version35, protocol.PerspectiveServer)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(b.Bytes()).To(Equal([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01}))
 		})
@@ -164,7 +207,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumberLen:      protocol.PacketNumberLen1,
 				DiversificationNonce: bytes.Repeat([]byte{1}, 32),
 			}
-			err := hdr.Write(b, protocol.Version35)
+			err := hdr.Write(b, protocol.Version35, protocol.PerspectiveServer)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(b.Bytes()).To(Equal([]byte{
 				0x0c, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c,
@@ -176,13 +219,13 @@ var _ = Describe("Public Header", func() {
 		Context("GetLength", func() {
 			It("errors when calling GetLength for Version Negotiation packets", func() {
 				hdr := PublicHeader{VersionFlag: true}
-				_, err := hdr.GetLength()
+				_, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).To(MatchError(errGetLengthOnlyForRegularPackets))
 			})
 
 			It("errors when calling GetLength for Public Reset packets", func() {
 				hdr := PublicHeader{ResetFlag: true}
-				_, err := hdr.GetLength()
+				_, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).To(MatchError(errGetLengthOnlyForRegularPackets))
 			})
 
@@ -191,7 +234,7 @@ var _ = Describe("Public Header", func() {
 					ConnectionID: 0x4cfa9f9b668619f6,
 					PacketNumber: 0xDECAFBAD,
 				}
-				_, err := hdr.GetLength()
+				_, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).To(MatchError(errPacketNumberLenNotSet))
 			})
 
@@ -201,7 +244,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xDECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen6,
 				}
-				length, err := hdr.GetLength()
+				length, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(length).To(Equal(protocol.ByteCount(1 + 8 + 6))) // 1 byte public flag, 8 bytes connectionID, and packet number
 			})
@@ -213,7 +256,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:         0xDECAFBAD,
 					PacketNumberLen:      protocol.PacketNumberLen6,
 				}
-				length, err := hdr.GetLength()
+				length, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(length).To(Equal(protocol.ByteCount(1 + 6))) // 1 byte public flag, and packet number
 			})
@@ -224,17 +267,28 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xDECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen2,
 				}
-				length, err := hdr.GetLength()
+				length, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(length).To(Equal(protocol.ByteCount(1 + 8 + 2))) // 1 byte public flag, 8 byte connectionID, and packet number
 			})
 
+			It("includes the version number for packets sent by the client", func() {
+				hdr := PublicHeader{
+					VersionFlag:     true,
+					ConnectionID:    0x4cfa9f9b668619f6,
+					PacketNumberLen: protocol.PacketNumberLen2,
+				}
+				length, err := hdr.GetLength(protocol.PerspectiveClient)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(length).To(Equal(protocol.ByteCount(1 + 8 + 4 + 2))) // 1 byte public flag, 8 byte connectionID, 4 byte version, and packet number
+			})
+
 			It("works with diversification nonce", func() {
 				hdr := PublicHeader{
 					DiversificationNonce: []byte("foo"),
 					PacketNumberLen:      protocol.PacketNumberLen1,
 				}
-				length, err := hdr.GetLength()
+				length, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(length).To(Equal(protocol.ByteCount(1 + 8 + 3 + 1)))
 			})
@@ -247,7 +301,7 @@ var _ = Describe("Public Header", func() {
 					ConnectionID: 0x4cfa9f9b668619f6,
 					PacketNumber: 0xDECAFBAD,
 				}
-				err := hdr.Write(b, protocol.VersionWhatever)
+				err := hdr.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer)
 				Expect(err).To(MatchError(errPacketNumberLenNotSet))
 			})
 
@@ -258,7 +312,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xDECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen1,
 				}
-				err := hdr.Write(b, protocol.VersionWhatever)
+				err := hdr.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(b.Bytes()).To(Equal([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xAD}))
 			})
@@ -270,7 +324,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xDECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen2,
 				}
-				err := hdr.Write(b, protocol.VersionWhatever)
+				err := hdr.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(b.Bytes()).To(Equal([]byte{0x18, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xAD, 0xFB}))
 			})
@@ -282,7 +336,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0x13DECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen4,
 				}
-				err := hdr.Write(b, protocol.VersionWhatever)
+				err := hdr.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(b.Bytes()).To(Equal([]byte{0x28, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xAD, 0xFB, 0xCA, 0xDE}))
 			})
@@ -294,7 +348,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xBE1337DECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen6,
 				}
-				err := hdr.Write(b, protocol.VersionWhatever)
+				err := hdr.Write(b, protocol.VersionWhatever, protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(b.Bytes()).To(Equal([]byte{0x38, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xAD, 0xFB, 0xCA, 0xDE, 0x37, 0x13}))
 			})
@@ -2,23 +2,116 @@ package quic
 
 import (
 	"bytes"
+	"encoding/binary"
+	"net"
 
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
-func writePublicReset(connectionID protocol.ConnectionID, rejectedPacketNumber protocol.PacketNumber, nonceProof uint64) []byte {
+// address families used for encoding the client address, as in Chromium's QuicSocketAddressCoder
+const (
+	addressFamilyIPv4 = 2
+	addressFamilyIPv6 = 10
+)
+
+// A PublicReset is the message of a public reset packet
+type PublicReset struct {
+	RejectedPacketNumber protocol.PacketNumber
+	// NonceProof proves that the public reset was sent by the server, see crypto.DerivePublicResetNonceProof
+	NonceProof uint64
+	// ClientAddress is the address of the client, as observed by the server
+	// It is nil if the server didn't send it.
+	ClientAddress *net.UDPAddr
+}
+
+// writePublicReset writes a public reset packet
+// The client address is only included if it is a UDP address.
+func writePublicReset(connectionID protocol.ConnectionID, rejectedPacketNumber protocol.PacketNumber, nonceProof uint64, clientAddr net.Addr) []byte {
 	b := &bytes.Buffer{}
 	b.WriteByte(0x0a)
 	utils.WriteUint64(b, uint64(connectionID))
-	utils.WriteUint32(b, uint32(handshake.TagPRST))
-	utils.WriteUint32(b, 2)
-	utils.WriteUint32(b, uint32(handshake.TagRNON))
-	utils.WriteUint32(b, 8)
-	utils.WriteUint32(b, uint32(handshake.TagRSEQ))
-	utils.WriteUint32(b, 16)
-	utils.WriteUint64(b, nonceProof)
-	utils.WriteUint64(b, uint64(rejectedPacketNumber))
+
+	data := map[handshake.Tag][]byte{
+		handshake.TagRNON: make([]byte, 8),
+		handshake.TagRSEQ: make([]byte, 8),
+	}
+	binary.LittleEndian.PutUint64(data[handshake.TagRNON], nonceProof)
+	binary.LittleEndian.PutUint64(data[handshake.TagRSEQ], uint64(rejectedPacketNumber))
+	if udpAddr, ok := clientAddr.(*net.UDPAddr); ok && udpAddr != nil {
+		data[handshake.TagCADR] = encodeClientAddress(udpAddr)
+	}
+	handshake.WriteHandshakeMessage(b, handshake.TagPRST, data)
+	return b.Bytes()
+}
+
+// ParsePublicReset parses the message of a public reset packet
+// The reader must be positioned after the public header.
+func ParsePublicReset(r *bytes.Reader) (*PublicReset, error) {
+	tag, data, err := handshake.ParseHandshakeMessage(r)
+	if err != nil {
+		return nil, qerr.Error(qerr.InvalidPublicRstPacket, err.Error())
+	}
+	if tag != handshake.TagPRST {
+		return nil, qerr.Error(qerr.InvalidPublicRstPacket, "wrong message tag")
+	}
+
+	pr := &PublicReset{}
+	rseq, ok := data[handshake.TagRSEQ]
+	if !ok || len(rseq) != 8 {
+		return nil, qerr.Error(qerr.InvalidPublicRstPacket, "invalid RSEQ tag")
+	}
+	pr.RejectedPacketNumber = protocol.PacketNumber(binary.LittleEndian.Uint64(rseq))
+
+	rnon, ok := data[handshake.TagRNON]
+	if !ok || len(rnon) != 8 {
+		return nil, qerr.Error(qerr.InvalidPublicRstPacket, "invalid RNON tag")
+	}
+	pr.NonceProof = binary.LittleEndian.Uint64(rnon)
+
+	if cadr, ok := data[handshake.TagCADR]; ok {
+		pr.ClientAddress, err = decodeClientAddress(cadr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pr, nil
+}
+
+// encodeClientAddress encodes an address as the address family, the IP and the port
+func encodeClientAddress(addr *net.UDPAddr) []byte {
+	b := &bytes.Buffer{}
+	if ip := addr.IP.To4(); ip != nil {
+		utils.WriteUint16(b, addressFamilyIPv4)
+		b.Write(ip)
+	} else {
+		utils.WriteUint16(b, addressFamilyIPv6)
+		b.Write(addr.IP.To16())
+	}
+	utils.WriteUint16(b, uint16(addr.Port))
 	return b.Bytes()
 }
+
+func decodeClientAddress(data []byte) (*net.UDPAddr, error) {
+	if len(data) < 2 {
+		return nil, qerr.Error(qerr.InvalidPublicRstPacket, "invalid CADR tag")
+	}
+	var ipLen int
+	switch binary.LittleEndian.Uint16(data) {
+	case addressFamilyIPv4:
+		ipLen = net.IPv4len
+	case addressFamilyIPv6:
+		ipLen = net.IPv6len
+	default:
+		return nil, qerr.Error(qerr.InvalidPublicRstPacket, "invalid address family in CADR tag")
+	}
+	if len(data) != 2+ipLen+2 {
+		return nil, qerr.Error(qerr.InvalidPublicRstPacket, "invalid CADR tag")
+	}
+	return &net.UDPAddr{
+		IP:   net.IP(append([]byte{}, data[2:2+ipLen]...)),
+		Port: int(binary.LittleEndian.Uint16(data[2+ipLen:])),
+	}, nil
+}
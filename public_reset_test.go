@@ -1,6 +1,12 @@
 package quic
 
 import (
+	"bytes"
+	"net"
+
+	"github.com/lucas-clemente/quic-go/handshake"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -8,7 +14,7 @@ import (
 var _ = Describe("public reset", func() {
 	Context("writing", func() {
 		It("writes public reset packets", func() {
-			Expect(writePublicReset(0xdeadbeef, 0x8badf00d, 0xdecafbad)).To(Equal([]byte{
+			Expect(writePublicReset(0xdeadbeef, 0x8badf00d, 0xdecafbad, nil)).To(Equal([]byte{
 				0x0a,
 				0xef, 0xbe, 0xad, 0xde, 0x00, 0x00, 0x00, 0x00,
 				'P', 'R', 'S', 'T',
@@ -21,5 +27,83 @@ var _ = Describe("public reset", func() {
 				0x0d, 0xf0, 0xad, 0x8b, 0x0, 0x0, 0x0, 0x0,
 			}))
 		})
+
+		It("includes the client address", func() {
+			addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0x1337}
+			Expect(writePublicReset(0xdeadbeef, 0x8badf00d, 0xdecafbad, addr)).To(Equal([]byte{
+				0x0a,
+				0xef, 0xbe, 0xad, 0xde, 0x00, 0x00, 0x00, 0x00,
+				'P', 'R', 'S', 'T',
+				0x03, 0x00, 0x00, 0x00,
+				'R', 'N', 'O', 'N',
+				0x08, 0x00, 0x00, 0x00,
+				'R', 'S', 'E', 'Q',
+				0x10, 0x00, 0x00, 0x00,
+				'C', 'A', 'D', 'R',
+				0x18, 0x00, 0x00, 0x00,
+				0xad, 0xfb, 0xca, 0xde, 0x0, 0x0, 0x0, 0x0,
+				0x0d, 0xf0, 0xad, 0x8b, 0x0, 0x0, 0x0, 0x0,
+				0x02, 0x00,
+				127, 0, 0, 1,
+				0x37, 0x13,
+			}))
+		})
+	})
+
+	Context("parsing", func() {
+		It("parses public resets", func() {
+			addr := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+			packet := writePublicReset(0xdeadbeef, 0x8badf00d, 0xdecafbad, addr)
+			pr, err := ParsePublicReset(bytes.NewReader(packet[9:]))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pr.RejectedPacketNumber).To(Equal(protocol.PacketNumber(0x8badf00d)))
+			Expect(pr.NonceProof).To(Equal(uint64(0xdecafbad)))
+			Expect(pr.ClientAddress).To(Equal(addr))
+		})
+
+		It("parses public resets without a client address", func() {
+			packet := writePublicReset(0xdeadbeef, 0x8badf00d, 0xdecafbad, mockAddr("foo"))
+			pr, err := ParsePublicReset(bytes.NewReader(packet[9:]))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pr.ClientAddress).To(BeNil())
+		})
+
+		It("errors on messages with the wrong tag", func() {
+			b := &bytes.Buffer{}
+			handshake.WriteHandshakeMessage(b, handshake.TagCHLO, map[handshake.Tag][]byte{})
+			_, err := ParsePublicReset(bytes.NewReader(b.Bytes()))
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidPublicRstPacket, "wrong message tag")))
+		})
+
+		It("errors if the rejected packet number is missing", func() {
+			b := &bytes.Buffer{}
+			handshake.WriteHandshakeMessage(b, handshake.TagPRST, map[handshake.Tag][]byte{handshake.TagRNON: make([]byte, 8)})
+			_, err := ParsePublicReset(bytes.NewReader(b.Bytes()))
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidPublicRstPacket, "invalid RSEQ tag")))
+		})
+
+		It("errors if the nonce proof is missing", func() {
+			b := &bytes.Buffer{}
+			handshake.WriteHandshakeMessage(b, handshake.TagPRST, map[handshake.Tag][]byte{handshake.TagRSEQ: make([]byte, 8)})
+			_, err := ParsePublicReset(bytes.NewReader(b.Bytes()))
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidPublicRstPacket, "invalid RNON tag")))
+		})
+
+		It("errors on invalid client addresses", func() {
+			b := &bytes.Buffer{}
+			handshake.WriteHandshakeMessage(b, handshake.TagPRST, map[handshake.Tag][]byte{
+				handshake.TagRSEQ: make([]byte, 8),
+				handshake.TagRNON: make([]byte, 8),
+				handshake.TagCADR: {0x02, 0x00, 127, 0, 0},
+			})
+			_, err := ParsePublicReset(bytes.NewReader(b.Bytes()))
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidPublicRstPacket, "invalid CADR tag")))
+		})
+
+		It("errors on truncated messages", func() {
+			packet := writePublicReset(0xdeadbeef, 0x8badf00d, 0xdecafbad, nil)
+			_, err := ParsePublicReset(bytes.NewReader(packet[9 : len(packet)-1]))
+			Expect(err).To(HaveOccurred())
+		})
 	})
 })
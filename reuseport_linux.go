@@ -0,0 +1,102 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+)
+
+// socket options, see asm-generic/socket.h
+const (
+	soReusePort = 15
+	// soAttachReusePortCBPF attaches a classic BPF program to a SO_REUSEPORT group
+	soAttachReusePortCBPF = 51
+)
+
+// listenReusePort binds n UDP sockets to the same address, using SO_REUSEPORT
+// The order of the sockets is the order of the SO_REUSEPORT group, which is used by the BPF program.
+func listenReusePort(addr *net.UDPAddr, n int) ([]*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}}
+
+	conns := make([]*net.UDPConn, 0, n)
+	closeAll := func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	address := addr.String()
+	for i := 0; i < n; i++ {
+		c, err := lc.ListenPacket(context.Background(), "udp", address)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		conn, ok := c.(*net.UDPConn)
+		if !ok {
+			c.Close()
+			closeAll()
+			return nil, errors.New("listenReusePort: not a UDP connection")
+		}
+		conns = append(conns, conn)
+		// if the port was chosen by the kernel, the other sockets have to use the same port
+		address = conn.LocalAddr().String()
+	}
+	return conns, nil
+}
+
+// attachShardingFilter attaches a BPF program to the SO_REUSEPORT group of conn
+// The program selects the socket by the connection ID of a packet, in the same way as shardIndex.
+// Packets without a connection ID are assigned to a socket by the kernel's 4-tuple hash.
+func attachShardingFilter(conn *net.UDPConn, n int) error {
+	// the program operates on the UDP payload
+	prog, err := bpf.Assemble([]bpf.Instruction{
+		// load the public flags
+		bpf.LoadAbsolute{Off: 0, Size: 1},
+		// check the connection ID flag
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x08, SkipFalse: 3},
+		// load the first 4 bytes of the connection ID
+		bpf.LoadAbsolute{Off: 1, Size: 4},
+		bpf.ALUOpConstant{Op: bpf.ALUOpMod, Val: uint32(n)},
+		bpf.RetA{},
+		// an invalid index makes the kernel fall back to the 4-tuple hash
+		bpf.RetConstant{Val: uint32(n)},
+	})
+	if err != nil {
+		return err
+	}
+	filter := make([]syscall.SockFilter, len(prog))
+	for i, ins := range prog {
+		filter[i] = syscall.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	fprog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := rawConn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_SETSOCKOPT, fd, syscall.SOL_SOCKET, soAttachReusePortCBPF, uintptr(unsafe.Pointer(&fprog)), unsafe.Sizeof(fprog), 0)
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package quic
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SO_REUSEPORT", func() {
+	const numSockets = 4
+
+	var conns []*net.UDPConn
+
+	BeforeEach(func() {
+		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		conns, err = listenReusePort(addr, numSockets)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	It("binds all sockets to the same address", func() {
+		Expect(conns).To(HaveLen(numSockets))
+		for _, conn := range conns {
+			Expect(conn.LocalAddr()).To(Equal(conns[0].LocalAddr()))
+		}
+	})
+
+	It("routes packets to the socket selected by the connection ID", func() {
+		err := attachShardingFilter(conns[0], numSockets)
+		Expect(err).ToNot(HaveOccurred())
+
+		clientConn, err := net.DialUDP("udp", nil, conns[0].LocalAddr().(*net.UDPAddr))
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+
+		for id := protocol.ConnectionID(1); id <= 20; id++ {
+			b := &bytes.Buffer{}
+			b.WriteByte(0x08)
+			utils.WriteUint64(b, uint64(id<<32|id))
+			_, err = clientConn.Write(b.Bytes())
+			Expect(err).ToNot(HaveOccurred())
+
+			index := shardIndex(id<<32|id, numSockets)
+			conns[index].SetReadDeadline(time.Now().Add(time.Second))
+			data := make([]byte, 100)
+			n, _, err := conns[index].ReadFromUDP(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data[:n]).To(Equal(b.Bytes()))
+		}
+	})
+})
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+func listenReusePort(addr *net.UDPAddr, n int) ([]*net.UDPConn, error) {
+	return nil, errors.New("binding multiple sockets with SO_REUSEPORT is not supported on this platform")
+}
+
+func attachShardingFilter(conn *net.UDPConn, n int) error {
+	return errors.New("attaching a BPF program to a SO_REUSEPORT group is not supported on this platform")
+}
@@ -2,7 +2,7 @@ package quic
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"net"
 	"strings"
 	"sync"
@@ -18,116 +18,372 @@ import (
 // packetHandler handles packets
 type packetHandler interface {
 	handlePacket(*receivedPacket)
-	run()
+	run() error
 	Close(error) error
+	CloseGracefully(error) error
+}
+
+// A Listener for incoming QUIC connections
+type Listener interface {
+	// Close the server, sending CONNECTION_CLOSE frames to each peer.
+	Close() error
+	// Addr returns the local network addr that the server is listening on.
+	Addr() net.Addr
+	// Accept returns new sessions. It should be called in a loop.
+	// Sessions that complete the handshake while Config.AcceptQueueSize sessions are waiting to be accepted are closed.
+	Accept() (*Session, error)
 }
 
 // A Server of QUIC
 type Server struct {
 	addr *net.UDPAddr
 
-	conn      *net.UDPConn
+	conns     []net.PacketConn
 	connMutex sync.Mutex
 
+	config *Config
 	signer crypto.Signer
-	scfg   *handshake.ServerConfig
+	scfgs  *handshake.ServerConfigStore
+
+	// shards holds the sessions, there is one shard for every socket
+	// The shard of a session is selected by its connection ID, see shardIndex.
+	// It is set together with conns.
+	shards []*serverShard
 
-	sessions      map[protocol.ConnectionID]packetHandler
-	sessionsMutex sync.RWMutex
+	// sessionQueue holds the sessions that completed the handshake, until they are returned by Accept()
+	sessionQueue chan *Session
+	// errorChan is closed when the server stops serving, serverError is the error that caused it
+	errorChan     chan struct{}
+	errorChanOnce sync.Once
+	serverError   error
 
+	// streamCallback is set for servers created by NewServer, their sessions are never queued for Accept
 	streamCallback StreamCallback
 
-	newSession func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error)
+	newSession func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfgs *handshake.ServerConfigStore, config *Config, streamCallback StreamCallback, closeCallback closeCallback, cryptoChangeCallback cryptoChangeCallback) (packetHandler, error)
+}
+
+var _ Listener = &Server{}
+
+// A serverShard holds the sessions belonging to one socket of the server
+type serverShard struct {
+	// conn is the socket used for sending packets of the sessions in this shard
+	conn net.PacketConn
+
+	sessions map[protocol.ConnectionID]packetHandler
+	// closedSessions holds the recently closed sessions, they are removed after the ClosedSessionTimeout
+	closedSessions map[protocol.ConnectionID]*closedSession
+	sessionsMutex  sync.RWMutex
+	// closing is set once CloseGracefully was called, no new sessions are created after that
+	closing bool
+}
+
+func newServerShard(conn net.PacketConn) *serverShard {
+	return &serverShard{
+		conn:           conn,
+		sessions:       map[protocol.ConnectionID]packetHandler{},
+		closedSessions: map[protocol.ConnectionID]*closedSession{},
+	}
+}
+
+// shardIndex returns the index of the shard that a connection ID belongs to
+// It uses the first 4 bytes of the connection ID as they are sent on the wire, read as a big endian number.
+// This has to match the BPF program that is attached to the sockets, see attachShardingFilter.
+func shardIndex(connectionID protocol.ConnectionID, numShards int) int {
+	v := uint32(connectionID)
+	v = v<<24 | (v&0xff00)<<8 | (v>>8)&0xff00 | v>>24
+	return int(v % uint32(numShards))
+}
+
+// Listen listens for QUIC connections on a given net.PacketConn.
+// Sessions are returned by Accept once the handshake completed.
+// The config must contain a tls.Config with a certificate, all other values are optional.
+func Listen(conn net.PacketConn, config *Config) (Listener, error) {
+	s, err := newServer(config)
+	if err != nil {
+		return nil, err
+	}
+	s.setConns([]net.PacketConn{conn})
+	go s.serve(conn)
+	return s, nil
 }
 
 // NewServer makes a new server
-func NewServer(addr string, tlsConfig *tls.Config, cb StreamCallback) (*Server, error) {
-	signer, err := crypto.NewProofSource(tlsConfig)
+// The StreamCallback is called for every stream opened by a peer, synchronously from the session's run loop, as soon as the first frame of the stream is received.
+// This includes the crypto stream, and streams opened before the handshake completed. The streams are not returned by Session.AcceptStream, and the sessions are not returned by Accept.
+// The config must contain a tls.Config with a certificate, all other values are optional.
+func NewServer(addr string, config *Config, cb StreamCallback) (*Server, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
 	}
 
-	kex, err := crypto.NewCurve25519KEX()
+	s, err := newServer(config)
 	if err != nil {
 		return nil, err
 	}
-	scfg, err := handshake.NewServerConfig(kex, signer)
+	s.addr = udpAddr
+	s.streamCallback = cb
+	return s, nil
+}
+
+func newServer(config *Config) (*Server, error) {
+	config, err := populateConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	signer, err := crypto.NewProofSource(config.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var scfgs *handshake.ServerConfigStore
+	if config.ServerSecrets != nil {
+		scfgs, err = handshake.NewServerConfigStoreFromPEM(signer, config.ServerSecrets, config.StrikeRegister)
+	} else {
+		scfgs, err = handshake.NewServerConfigStore(signer, config.Orbit, config.ServerConfigRotationInterval, config.StrikeRegister)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	return &Server{
-		addr:           udpAddr,
-		signer:         signer,
-		scfg:           scfg,
-		streamCallback: cb,
-		sessions:       map[protocol.ConnectionID]packetHandler{},
-		newSession:     newSession,
+		config:       config,
+		signer:       signer,
+		scfgs:        scfgs,
+		sessionQueue: make(chan *Session, config.AcceptQueueSize),
+		errorChan:    make(chan struct{}),
+		newSession:   newSession,
 	}, nil
 }
 
 // ListenAndServe listens and serves a connection
+// If the config sets NumSockets, it binds that many sockets to the address using SO_REUSEPORT.
 func (s *Server) ListenAndServe() error {
-	conn, err := net.ListenUDP("udp", s.addr)
+	if s.config.NumSockets <= 1 {
+		conn, err := net.ListenUDP("udp", s.addr)
+		if err != nil {
+			return err
+		}
+		return s.Serve(conn)
+	}
+	udpConns, err := listenReusePort(s.addr, s.config.NumSockets)
 	if err != nil {
 		return err
 	}
-	return s.Serve(conn)
+	if err := attachShardingFilter(udpConns[0], len(udpConns)); err != nil {
+		// packets are then forwarded to the right shard by the read loops
+		utils.Infof("Couldn't attach BPF program for routing packets to sockets: %s", err.Error())
+	}
+	conns := make([]net.PacketConn, len(udpConns))
+	for i, c := range udpConns {
+		conns[i] = c
+	}
+	return s.serveConns(conns)
+}
+
+// Serve on an existing connection.
+// Usually this is a *net.UDPConn, but any packet-oriented transport can be used.
+func (s *Server) Serve(conn net.PacketConn) error {
+	return s.serveConns([]net.PacketConn{conn})
+}
+
+// serveConns runs a read loop for every connection, until all of them are closed
+func (s *Server) serveConns(conns []net.PacketConn) error {
+	for _, conn := range conns {
+		s.config = prepareForPMTUDiscovery(conn, s.config)
+	}
+	s.setConns(conns)
+	if len(conns) == 1 {
+		return s.serve(conns[0])
+	}
+
+	errChan := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn net.PacketConn) {
+			errChan <- s.serve(conn)
+		}(conn)
+	}
+	var err error
+	for range conns {
+		if e := <-errChan; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
-// Serve on an existing UDP connection.
-func (s *Server) Serve(conn *net.UDPConn) error {
+func (s *Server) setConns(conns []net.PacketConn) {
+	shards := make([]*serverShard, len(conns))
+	for i, conn := range conns {
+		shards[i] = newServerShard(conn)
+	}
 	s.connMutex.Lock()
-	s.conn = conn
+	s.conns = conns
+	s.shards = shards
 	s.connMutex.Unlock()
+}
 
+// getShards returns the shards, for use outside of the read loops and the sessions' callbacks
+func (s *Server) getShards() []*serverShard {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	return s.shards
+}
+
+// serve reads packets from the connection until it is closed
+// On Linux, multiple packets are read from UDP sockets with a single syscall.
+func (s *Server) serve(conn net.PacketConn) error {
+	bc := newBatchConn(conn)
+	// the receive buffers are large enough for any packet, and are reused for every read
+	// Received packets are copied into a buffer from the pool that fits their size.
+	buffers := make([][]byte, protocol.PacketBatchSize)
+	addrs := make([]net.Addr, protocol.PacketBatchSize)
 	for {
-		data := getPacketBuffer()
-		data = data[:protocol.MaxPacketSize]
-		n, remoteAddr, err := conn.ReadFromUDP(data)
+		for i := range buffers {
+			if buffers[i] == nil {
+				buffers[i] = make([]byte, protocol.MaxReceivePacketSize)
+			}
+			buffers[i] = buffers[i][:protocol.MaxReceivePacketSize]
+		}
+		n, err := bc.ReadBatch(buffers, addrs)
 		if err != nil {
+			s.errorChanOnce.Do(func() {
+				s.serverError = err
+				close(s.errorChan)
+			})
 			if strings.HasSuffix(err.Error(), "use of closed network connection") {
 				return nil
 			}
 			return err
 		}
-		data = data[:n]
-		if err := s.handlePacket(conn, remoteAddr, data); err != nil {
-			utils.Errorf("error handling packet: %s", err.Error())
+		for i := 0; i < n; i++ {
+			data := append(getPacketBuffer(protocol.ByteCount(len(buffers[i]))), buffers[i]...)
+			if err := s.handlePacket(conn, addrs[i], data); err != nil {
+				utils.Errorf("error handling packet: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Accept returns the next session that completed the handshake
+// It blocks until a session is available, or the server stops serving.
+func (s *Server) Accept() (*Session, error) {
+	select {
+	case <-s.errorChan:
+		return nil, s.serverError
+	case session := <-s.sessionQueue:
+		return session, nil
+	}
+}
+
+// ExportServerSecrets returns the PEM encoded server config and source address token secrets of the server
+// Servers that load them using Config.ServerSecrets share the server's identity, so that clients can do 0-RTT handshakes with any of them.
+// The server must use a Config.StrikeRegister, which is shared with all these servers.
+// The result contains the secret that the private keys are derived from, and must be kept secret.
+func (s *Server) ExportServerSecrets() ([]byte, error) {
+	return s.scfgs.Export()
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	if len(s.conns) > 0 {
+		return s.conns[0].LocalAddr()
+	}
+	if s.addr != nil {
+		return s.addr
+	}
+	return nil
+}
+
+// shutdownPollInterval is how often Shutdown checks if all sessions are closed
+var shutdownPollInterval = 50 * time.Millisecond
+
+// CloseGracefully stops accepting new sessions and sends a GOAWAY frame on every open session.
+// Each session is closed once all of its streams are finished. The UDP socket is not closed, call Close or Shutdown for that.
+func (s *Server) CloseGracefully() error {
+	var sessions []packetHandler
+	for _, shard := range s.getShards() {
+		shard.sessionsMutex.Lock()
+		shard.closing = true
+		for _, session := range shard.sessions {
+			sessions = append(sessions, session)
 		}
+		shard.sessionsMutex.Unlock()
+	}
+
+	for _, session := range sessions {
+		_ = session.CloseGracefully(nil)
 	}
+	return nil
+}
+
+// Shutdown closes the server gracefully, like http.Server.Shutdown.
+// It calls CloseGracefully, waits for all sessions to close, and then closes the server.
+// If the context expires first, the remaining sessions are closed immediately and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.CloseGracefully(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.numberOfOpenSessions() == 0 {
+			return s.Close()
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) numberOfOpenSessions() int {
+	var n int
+	for _, shard := range s.getShards() {
+		shard.sessionsMutex.RLock()
+		n += len(shard.sessions)
+		shard.sessionsMutex.RUnlock()
+	}
+	return n
 }
 
 // Close the server
 func (s *Server) Close() error {
-	s.sessionsMutex.Lock()
-	for _, session := range s.sessions {
-		if session != nil {
-			s.sessionsMutex.Unlock()
+	for _, shard := range s.getShards() {
+		shard.sessionsMutex.Lock()
+		for _, session := range shard.sessions {
+			shard.sessionsMutex.Unlock()
 			_ = session.Close(nil)
-			s.sessionsMutex.Lock()
+			shard.sessionsMutex.Lock()
 		}
+		shard.sessionsMutex.Unlock()
 	}
-	s.sessionsMutex.Unlock()
 
 	s.connMutex.Lock()
-	conn := s.conn
-	s.conn = nil
+	conns := s.conns
+	s.conns = nil
 	s.connMutex.Unlock()
 
-	if conn == nil {
-		return nil
+	var err error
+	for _, conn := range conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
 	}
-	return conn.Close()
+	return err
 }
 
-func (s *Server) handlePacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, packet []byte) error {
-	if protocol.ByteCount(len(packet)) > protocol.MaxPacketSize {
+// handlePacket handles a packet received on conn
+// The packet is passed to the shard selected by its connection ID, which is not necessarily the shard of conn.
+// This happens if no BPF program is attached to the sockets, and the kernel selects the socket by the 4-tuple, e.g. after a NAT rebinding.
+func (s *Server) handlePacket(conn net.PacketConn, remoteAddr net.Addr, packet []byte) error {
+	if protocol.ByteCount(len(packet)) > protocol.MaxReceivePacketSize {
 		return qerr.PacketTooLarge
 	}
 
@@ -135,7 +391,7 @@ func (s *Server) handlePacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, packet
 
 	r := bytes.NewReader(packet)
 
-	hdr, err := ParsePublicHeader(r)
+	hdr, err := ParsePublicHeader(r, protocol.PerspectiveClient)
 	if err != nil {
 		return qerr.Error(qerr.InvalidPacketHeader, err.Error())
 	}
@@ -144,35 +400,50 @@ func (s *Server) handlePacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, packet
 	// Send Version Negotiation Packet if the client is speaking a different protocol version
 	if hdr.VersionFlag && !protocol.IsSupportedVersion(hdr.VersionNumber) {
 		utils.Infof("Client offered version %d, sending VersionNegotiationPacket", hdr.VersionNumber)
-		_, err = conn.WriteToUDP(composeVersionNegotiation(hdr.ConnectionID), remoteAddr)
+		_, err = conn.WriteTo(composeVersionNegotiation(hdr.ConnectionID), remoteAddr)
 		return err
 	}
 
-	s.sessionsMutex.RLock()
-	session, ok := s.sessions[hdr.ConnectionID]
-	s.sessionsMutex.RUnlock()
+	shard := s.shards[shardIndex(hdr.ConnectionID, len(s.shards))]
+	shard.sessionsMutex.RLock()
+	session, ok := shard.sessions[hdr.ConnectionID]
+	closedSession := shard.closedSessions[hdr.ConnectionID]
+	closing := shard.closing
+	shard.sessionsMutex.RUnlock()
+
+	if closedSession != nil {
+		return closedSession.handlePacket(conn, remoteAddr, hdr)
+	}
 
 	if !ok {
+		if closing {
+			utils.Debugf("Server is shutting down, ignoring packet for new connection %x", hdr.ConnectionID)
+			return nil
+		}
 		utils.Infof("Serving new connection: %x, version %d from %v", hdr.ConnectionID, hdr.VersionNumber, remoteAddr)
 		session, err = s.newSession(
-			&udpConn{conn: conn, currentAddr: remoteAddr},
+			&udpConn{conn: shard.conn, currentAddr: remoteAddr},
 			hdr.VersionNumber,
 			hdr.ConnectionID,
-			s.scfg,
+			s.scfgs,
+			s.config,
 			s.streamCallback,
 			s.closeCallback,
+			s.cryptoChangeCallback,
 		)
 		if err != nil {
 			return err
 		}
+		// insert the session before running it, so that the closeCallback always finds it
+		shard.sessionsMutex.Lock()
+		shard.sessions[hdr.ConnectionID] = session
+		closing = shard.closing
+		shard.sessionsMutex.Unlock()
 		go session.run()
-		s.sessionsMutex.Lock()
-		s.sessions[hdr.ConnectionID] = session
-		s.sessionsMutex.Unlock()
-	}
-	if session == nil {
-		// Late packet for closed session
-		return nil
+		if closing {
+			// CloseGracefully was called while the session was being created
+			_ = session.CloseGracefully(nil)
+		}
 	}
 	session.handlePacket(&receivedPacket{
 		remoteAddr:   remoteAddr,
@@ -183,10 +454,39 @@ func (s *Server) handlePacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, packet
 	return nil
 }
 
-func (s *Server) closeCallback(id protocol.ConnectionID) {
-	s.sessionsMutex.Lock()
-	s.sessions[id] = nil
-	s.sessionsMutex.Unlock()
+// closeCallback is called by the sessions once they are closed
+// The session is replaced by a closedSession, which answers late packets until the ClosedSessionTimeout expires.
+func (s *Server) closeCallback(id protocol.ConnectionID, closedSession *closedSession) {
+	shard := s.shards[shardIndex(id, len(s.shards))]
+	shard.sessionsMutex.Lock()
+	delete(shard.sessions, id)
+	shard.closedSessions[id] = closedSession
+	shard.sessionsMutex.Unlock()
+
+	time.AfterFunc(s.config.ClosedSessionTimeout, func() {
+		shard.sessionsMutex.Lock()
+		// a new session with the same connection ID might have been closed in the meantime
+		if shard.closedSessions[id] == closedSession {
+			delete(shard.closedSessions, id)
+		}
+		shard.sessionsMutex.Unlock()
+	})
+}
+
+// cryptoChangeCallback is called by the sessions when the encryption level changes
+// Once the handshake is complete, the session is queued for Accept.
+func (s *Server) cryptoChangeCallback(session *Session, isForwardSecure bool) {
+	// sessions of servers using a StreamCallback are never accepted
+	if !isForwardSecure || s.streamCallback != nil {
+		return
+	}
+	select {
+	case s.sessionQueue <- session:
+	default:
+		// the application doesn't accept new sessions fast enough
+		utils.Infof("Accept queue full, closing session %x", session.connectionID)
+		session.Close(qerr.Error(qerr.PeerGoingAway, "accept queue full"))
+	}
 }
 
 func composeVersionNegotiation(connectionID protocol.ConnectionID) []byte {
@@ -196,7 +496,7 @@ func composeVersionNegotiation(connectionID protocol.ConnectionID) []byte {
 		PacketNumber: 1,
 		VersionFlag:  true,
 	}
-	err := responsePublicHeader.Write(fullReply, protocol.Version35)
+	err := responsePublicHeader.Write(fullReply, protocol.Version35, protocol.PerspectiveServer)
 	if err != nil {
 		utils.Errorf("error composing version negotiation packet: %s", err.Error())
 	}
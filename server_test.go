@@ -2,13 +2,17 @@ package quic
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"net"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/testdata"
+	"github.com/lucas-clemente/quic-go/utils"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -18,21 +22,49 @@ type mockSession struct {
 	connectionID protocol.ConnectionID
 	packetCount  int
 	closed       bool
+	goingAway    bool
+	runFunc      func()
 }
 
 func (s *mockSession) handlePacket(*receivedPacket) {
 	s.packetCount++
 }
 
-func (s *mockSession) run()              {}
+func (s *mockSession) run() error {
+	if s.runFunc != nil {
+		s.runFunc()
+	}
+	return nil
+}
 func (s *mockSession) Close(error) error { s.closed = true; return nil }
+func (s *mockSession) CloseGracefully(error) error {
+	s.goingAway = true
+	return nil
+}
 
-func newMockSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error) {
+func newMockSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfgs *handshake.ServerConfigStore, config *Config, streamCallback StreamCallback, closeCallback closeCallback, cryptoChangeCallback cryptoChangeCallback) (packetHandler, error) {
 	return &mockSession{
 		connectionID: connectionID,
 	}, nil
 }
 
+type mockStrikeRegister struct {
+	nonces map[string]time.Time
+}
+
+var _ handshake.StrikeRegister = &mockStrikeRegister{}
+
+func (r *mockStrikeRegister) Insert(nonce []byte, expiry time.Time) (bool, error) {
+	if r.nonces == nil {
+		r.nonces = make(map[string]time.Time)
+	}
+	if _, ok := r.nonces[string(nonce)]; ok {
+		return false, nil
+	}
+	r.nonces[string(nonce)] = expiry
+	return true, nil
+}
+
 var _ = Describe("Server", func() {
 	Describe("with mock session", func() {
 		var (
@@ -40,9 +72,14 @@ var _ = Describe("Server", func() {
 		)
 
 		BeforeEach(func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
 			server = &Server{
-				sessions:   map[protocol.ConnectionID]packetHandler{},
-				newSession: newMockSession,
+				config:       config,
+				shards:       []*serverShard{newServerShard(nil)},
+				newSession:   newMockSession,
+				sessionQueue: make(chan *Session, config.AcceptQueueSize),
+				errorChan:    make(chan struct{}),
 			}
 		})
 
@@ -57,9 +94,9 @@ var _ = Describe("Server", func() {
 		It("creates new sessions", func() {
 			err := server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
 			Expect(err).ToNot(HaveOccurred())
-			Expect(server.sessions).To(HaveLen(1))
-			Expect(server.sessions[0x4cfa9f9b668619f6].(*mockSession).connectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
-			Expect(server.sessions[0x4cfa9f9b668619f6].(*mockSession).packetCount).To(Equal(1))
+			Expect(server.shards[0].sessions).To(HaveLen(1))
+			Expect(server.shards[0].sessions[0x4cfa9f9b668619f6].(*mockSession).connectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+			Expect(server.shards[0].sessions[0x4cfa9f9b668619f6].(*mockSession).packetCount).To(Equal(1))
 		})
 
 		It("assigns packets to existing sessions", func() {
@@ -67,9 +104,9 @@ var _ = Describe("Server", func() {
 			Expect(err).ToNot(HaveOccurred())
 			err = server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
 			Expect(err).ToNot(HaveOccurred())
-			Expect(server.sessions).To(HaveLen(1))
-			Expect(server.sessions[0x4cfa9f9b668619f6].(*mockSession).connectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
-			Expect(server.sessions[0x4cfa9f9b668619f6].(*mockSession).packetCount).To(Equal(2))
+			Expect(server.shards[0].sessions).To(HaveLen(1))
+			Expect(server.shards[0].sessions[0x4cfa9f9b668619f6].(*mockSession).connectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+			Expect(server.shards[0].sessions[0x4cfa9f9b668619f6].(*mockSession).packetCount).To(Equal(2))
 		})
 
 		It("closes and deletes sessions", func() {
@@ -77,27 +114,290 @@ var _ = Describe("Server", func() {
 			pheader := []byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, byte(version), 0x01}
 			err := server.handlePacket(nil, nil, append(pheader, (&crypto.NullAEAD{}).Seal(nil, nil, 0, pheader)...))
 			Expect(err).ToNot(HaveOccurred())
-			Expect(server.sessions).To(HaveLen(1))
-			server.closeCallback(0x4cfa9f9b668619f6)
-			// The server should now have closed the session, leaving a nil value in the sessions map
-			Expect(server.sessions).To(HaveLen(1))
-			Expect(server.sessions[0x4cfa9f9b668619f6]).To(BeNil())
+			Expect(server.shards[0].sessions).To(HaveLen(1))
+			server.closeCallback(0x4cfa9f9b668619f6, newClosedSession(0x4cfa9f9b668619f6, nil, 0))
+			// The server should now have replaced the session by a closedSession
+			Expect(server.shards[0].sessions).To(BeEmpty())
+			Expect(server.shards[0].closedSessions).To(HaveKey(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+		})
+
+		It("deletes sessions that close immediately after being created", func() {
+			runDone := make(chan struct{})
+			server.newSession = func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfgs *handshake.ServerConfigStore, config *Config, streamCallback StreamCallback, closeCallback closeCallback, cryptoChangeCallback cryptoChangeCallback) (packetHandler, error) {
+				return &mockSession{
+					connectionID: connectionID,
+					runFunc: func() {
+						closeCallback(connectionID, newClosedSession(connectionID, nil, 0))
+						close(runDone)
+					},
+				}, nil
+			}
+			err := server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(runDone).Should(BeClosed())
+			server.shards[0].sessionsMutex.RLock()
+			defer server.shards[0].sessionsMutex.RUnlock()
+			Expect(server.shards[0].sessions).To(BeEmpty())
+			Expect(server.shards[0].closedSessions).To(HaveKey(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+		})
+
+		It("passes the StreamCallback to new sessions", func() {
+			var cb StreamCallback
+			server.streamCallback = func(*Session, utils.Stream) {}
+			server.newSession = func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfgs *handshake.ServerConfigStore, config *Config, streamCallback StreamCallback, closeCallback closeCallback, cryptoChangeCallback cryptoChangeCallback) (packetHandler, error) {
+				cb = streamCallback
+				return &mockSession{connectionID: connectionID}, nil
+			}
+			err := server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cb).ToNot(BeNil())
 		})
 
 		It("closes sessions when Close is called", func() {
 			session := &mockSession{}
-			server.sessions[1] = session
+			server.shards[0].sessions[1] = session
 			err := server.Close()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(session.closed).To(BeTrue())
 		})
 
-		It("ignores packets for closed sessions", func() {
-			server.sessions[0x4cfa9f9b668619f6] = nil
-			err := server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
-			Expect(err).ToNot(HaveOccurred())
-			Expect(server.sessions).To(HaveLen(1))
-			Expect(server.sessions[0x4cfa9f9b668619f6]).To(BeNil())
+		Context("closing gracefully", func() {
+			It("sends a GOAWAY on every session", func() {
+				session := &mockSession{}
+				server.shards[0].sessions[1] = session
+				err := server.CloseGracefully()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.goingAway).To(BeTrue())
+				Expect(session.closed).To(BeFalse())
+			})
+
+			It("doesn't create new sessions", func() {
+				err := server.CloseGracefully()
+				Expect(err).ToNot(HaveOccurred())
+				err = server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(server.shards[0].sessions).To(BeEmpty())
+			})
+
+			It("still passes packets to existing sessions", func() {
+				session := &mockSession{}
+				server.shards[0].sessions[0x4cfa9f9b668619f6] = session
+				err := server.CloseGracefully()
+				Expect(err).ToNot(HaveOccurred())
+				err = server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.packetCount).To(Equal(1))
+			})
+
+			It("waits for all sessions to close on Shutdown", func() {
+				session := &mockSession{}
+				server.shards[0].sessions[1] = session
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					err := server.Shutdown(context.Background())
+					Expect(err).ToNot(HaveOccurred())
+					close(done)
+				}()
+				Consistently(done).ShouldNot(BeClosed())
+				server.closeCallback(1, newClosedSession(1, nil, 0))
+				Eventually(done).Should(BeClosed())
+				Expect(session.closed).To(BeFalse())
+			})
+
+			It("closes the remaining sessions when the context expires", func() {
+				session := &mockSession{}
+				server.shards[0].sessions[1] = session
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				err := server.Shutdown(ctx)
+				Expect(err).To(MatchError(context.Canceled))
+				Expect(session.goingAway).To(BeTrue())
+				Expect(session.closed).To(BeTrue())
+			})
+		})
+
+		Context("with multiple shards", func() {
+			BeforeEach(func() {
+				server.shards = []*serverShard{newServerShard(nil), newServerShard(nil), newServerShard(nil)}
+			})
+
+			It("selects the shard by the first 4 bytes of the connection ID on the wire", func() {
+				Expect(shardIndex(0x4cfa9f9b668619f6, 1)).To(BeZero())
+				Expect(shardIndex(0x4cfa9f9b668619f6, 7)).To(Equal(int(0xf6198666 % 7)))
+				Expect(shardIndex(0x4cfa9f9b668619f6, 1000)).To(Equal(int(0xf6198666 % 1000)))
+			})
+
+			It("adds new sessions to the shard of the connection ID", func() {
+				err := server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+				Expect(err).ToNot(HaveOccurred())
+				index := shardIndex(0x4cfa9f9b668619f6, 3)
+				for i, shard := range server.shards {
+					if i == index {
+						Expect(shard.sessions).To(HaveKey(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+					} else {
+						Expect(shard.sessions).To(BeEmpty())
+					}
+				}
+				server.closeCallback(0x4cfa9f9b668619f6, newClosedSession(0x4cfa9f9b668619f6, nil, 0))
+				Expect(server.shards[index].sessions).To(BeEmpty())
+				Expect(server.shards[index].closedSessions).To(HaveKey(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+			})
+
+			It("passes packets to the session, regardless of the socket they were received on", func() {
+				session := &mockSession{}
+				server.shards[shardIndex(0x4cfa9f9b668619f6, 3)].sessions[0x4cfa9f9b668619f6] = session
+				for _, shard := range server.shards {
+					err := server.handlePacket(shard.conn, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(session.packetCount).To(Equal(3))
+			})
+
+			It("closes the sessions of all shards", func() {
+				session1 := &mockSession{}
+				session2 := &mockSession{}
+				server.shards[0].sessions[1] = session1
+				server.shards[2].sessions[2] = session2
+				Expect(server.numberOfOpenSessions()).To(Equal(2))
+				err := server.CloseGracefully()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session1.goingAway).To(BeTrue())
+				Expect(session2.goingAway).To(BeTrue())
+				for _, shard := range server.shards {
+					Expect(shard.closing).To(BeTrue())
+				}
+				err = server.Close()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session1.closed).To(BeTrue())
+				Expect(session2.closed).To(BeTrue())
+			})
+		})
+
+		Context("closed sessions", func() {
+			var (
+				conn       *mockPacketConn
+				remoteAddr net.Addr
+			)
+
+			BeforeEach(func() {
+				conn = newMockPacketConn(mockAddr("server"))
+				remoteAddr = mockAddr("client")
+			})
+
+			It("resends the CONNECTION_CLOSE for packets of closed sessions", func() {
+				server.closeCallback(0x4cfa9f9b668619f6, newClosedSession(0x4cfa9f9b668619f6, []byte("connection close"), 0))
+				err := server.handlePacket(conn, remoteAddr, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(server.shards[0].sessions).To(BeEmpty())
+				Expect(conn.dataWritten).To(Receive(Equal(mockPacket{data: []byte("connection close"), addr: remoteAddr})))
+			})
+
+			It("sends a public reset if the session didn't send a CONNECTION_CLOSE", func() {
+				server.closeCallback(0x4cfa9f9b668619f6, newClosedSession(0x4cfa9f9b668619f6, nil, 0))
+				err := server.handlePacket(conn, remoteAddr, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(server.shards[0].sessions).To(BeEmpty())
+				Expect(conn.dataWritten).To(Receive(Equal(mockPacket{data: writePublicReset(0x4cfa9f9b668619f6, 1, 0, remoteAddr), addr: remoteAddr})))
+			})
+
+			It("removes closed sessions after the timeout", func() {
+				server.config.ClosedSessionTimeout = 50 * time.Millisecond
+				server.closeCallback(0x4cfa9f9b668619f6, newClosedSession(0x4cfa9f9b668619f6, []byte("connection close"), 0))
+				getClosedSessions := func() int {
+					server.shards[0].sessionsMutex.RLock()
+					defer server.shards[0].sessionsMutex.RUnlock()
+					return len(server.shards[0].closedSessions)
+				}
+				Expect(getClosedSessions()).To(Equal(1))
+				Eventually(getClosedSessions).Should(BeZero())
+				// a packet for the connection ID now creates a new session
+				err := server.handlePacket(conn, remoteAddr, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(server.shards[0].sessions).To(HaveLen(1))
+				Expect(conn.dataWritten).To(BeEmpty())
+			})
+
+			It("doesn't remove a newer closed session with the same connection ID", func() {
+				server.config.ClosedSessionTimeout = 200 * time.Millisecond
+				server.closeCallback(0x4cfa9f9b668619f6, newClosedSession(0x4cfa9f9b668619f6, nil, 0))
+				time.Sleep(100 * time.Millisecond)
+				server.closeCallback(0x4cfa9f9b668619f6, newClosedSession(0x4cfa9f9b668619f6, []byte("connection close"), 0))
+				// the timer of the first closed session has fired, the one of the second hasn't
+				time.Sleep(150 * time.Millisecond)
+				server.shards[0].sessionsMutex.RLock()
+				Expect(server.shards[0].closedSessions).To(HaveKey(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+				server.shards[0].sessionsMutex.RUnlock()
+			})
+		})
+
+		Context("accepting sessions", func() {
+			It("returns sessions that completed the handshake", func() {
+				session := &Session{connectionID: 1}
+				server.cryptoChangeCallback(session, false)
+				Expect(server.sessionQueue).To(BeEmpty())
+				server.cryptoChangeCallback(session, true)
+				s, err := server.Accept()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s).To(Equal(session))
+			})
+
+			It("blocks until a session is available", func() {
+				session := &Session{connectionID: 1}
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					s, err := server.Accept()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(s).To(Equal(session))
+					close(done)
+				}()
+				Consistently(done).ShouldNot(BeClosed())
+				server.cryptoChangeCallback(session, true)
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("closes sessions when the accept queue is full", func() {
+				for i := 0; i < server.config.AcceptQueueSize; i++ {
+					server.cryptoChangeCallback(&Session{}, true)
+				}
+				closed := make(chan struct{})
+				pSession, err := newSession(
+					&mockConnection{},
+					protocol.Version35,
+					1,
+					server.scfgs,
+					server.config,
+					nil,
+					func(protocol.ConnectionID, *closedSession) { close(closed) },
+					func(*Session, bool) {},
+				)
+				Expect(err).ToNot(HaveOccurred())
+				go pSession.run()
+				server.cryptoChangeCallback(pSession.(*Session), true)
+				Eventually(closed).Should(BeClosed())
+				Expect(server.sessionQueue).To(HaveLen(server.config.AcceptQueueSize))
+			})
+
+			It("doesn't queue sessions of servers with a StreamCallback", func() {
+				server.streamCallback = func(*Session, utils.Stream) {}
+				server.cryptoChangeCallback(&Session{connectionID: 1}, true)
+				Expect(server.sessionQueue).To(BeEmpty())
+			})
+
+			It("uses the configured accept queue size", func() {
+				server, err := newServer(&Config{TLSConfig: testdata.GetTLSConfig(), AcceptQueueSize: 3})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cap(server.sessionQueue)).To(Equal(3))
+			})
+
+			It("returns the error once the server stopped serving", func() {
+				testErr := errors.New("test error")
+				server.serverError = testErr
+				close(server.errorChan)
+				_, err := server.Accept()
+				Expect(err).To(MatchError(testErr))
+			})
 		})
 
 		It("errors on invalid public header", func() {
@@ -106,7 +406,7 @@ var _ = Describe("Server", func() {
 		})
 
 		It("errors on large packets", func() {
-			err := server.handlePacket(nil, nil, bytes.Repeat([]byte{'a'}, int(protocol.MaxPacketSize)+1))
+			err := server.handlePacket(nil, nil, bytes.Repeat([]byte{'a'}, int(protocol.MaxReceivePacketSize)+1))
 			Expect(err).To(MatchError(qerr.PacketTooLarge))
 		})
 	})
@@ -115,7 +415,7 @@ var _ = Describe("Server", func() {
 		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
 		Expect(err).ToNot(HaveOccurred())
 
-		server, err := NewServer("", testdata.GetTLSConfig(), nil)
+		server, err := NewServer("", &Config{TLSConfig: testdata.GetTLSConfig()}, nil)
 		Expect(err).ToNot(HaveOccurred())
 
 		serverConn, err := net.ListenUDP("udp", addr)
@@ -150,11 +450,39 @@ var _ = Describe("Server", func() {
 		Expect(err).ToNot(HaveOccurred())
 	})
 
+	It("serves on connections that are not UDP sockets", func() {
+		server, err := NewServer("", &Config{TLSConfig: testdata.GetTLSConfig()}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		pconn := newMockPacketConn(mockAddr("server"))
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			err2 := server.Serve(pconn)
+			Expect(err2).ToNot(HaveOccurred())
+			close(done)
+		}()
+
+		pconn.dataToRead <- mockPacket{
+			data: []byte{0x09, 0x01, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x01, 'Q', '0', '0', '0', 0x01},
+			addr: mockAddr("client"),
+		}
+		var p mockPacket
+		Eventually(pconn.dataWritten).Should(Receive(&p))
+		Expect(p.addr).To(Equal(mockAddr("client")))
+		Expect(p.data).To(Equal(composeVersionNegotiation(1)))
+		Expect(server.Addr()).To(Equal(mockAddr("server")))
+
+		err = server.Close()
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(done).Should(BeClosed())
+	})
+
 	It("setups and responds with error on invalid frame", func(done Done) {
 		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
 		Expect(err).ToNot(HaveOccurred())
 
-		server, err := NewServer("", testdata.GetTLSConfig(), nil)
+		server, err := NewServer("", &Config{TLSConfig: testdata.GetTLSConfig()}, nil)
 		Expect(err).ToNot(HaveOccurred())
 
 		serverConn, err := net.ListenUDP("udp", addr)
@@ -183,4 +511,55 @@ var _ = Describe("Server", func() {
 		err = server.Close()
 		Expect(err).ToNot(HaveOccurred())
 	})
+
+	It("errors when the config is invalid", func() {
+		_, err := NewServer("", &Config{TLSConfig: testdata.GetTLSConfig(), MaxPacketSize: 100}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("loads exported server secrets", func() {
+		strikeRegister := &mockStrikeRegister{}
+		server, err := newServer(&Config{TLSConfig: testdata.GetTLSConfig(), StrikeRegister: strikeRegister})
+		Expect(err).ToNot(HaveOccurred())
+		secrets, err := server.ExportServerSecrets()
+		Expect(err).ToNot(HaveOccurred())
+		server2, err := newServer(&Config{TLSConfig: testdata.GetTLSConfig(), ServerSecrets: secrets, StrikeRegister: strikeRegister})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server2.scfgs.Primary().Get()).To(Equal(server.scfgs.Primary().Get()))
+	})
+
+	It("doesn't export the server secrets without a strike register", func() {
+		server, err := newServer(&Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = server.ExportServerSecrets()
+		Expect(err).To(MatchError("ServerConfigStore: only server configs that use a shared strike register can be exported"))
+	})
+
+	It("errors when the server secrets are invalid", func() {
+		_, err := newServer(&Config{TLSConfig: testdata.GetTLSConfig(), ServerSecrets: []byte("foobar"), StrikeRegister: &mockStrikeRegister{}})
+		Expect(err).To(MatchError("ServerConfigStore: no server config secret"))
+	})
+
+	It("listens on a UDP connection", func() {
+		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		serverConn, err := net.ListenUDP("udp", addr)
+		Expect(err).NotTo(HaveOccurred())
+
+		ln, err := Listen(serverConn, &Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ln.Addr()).To(Equal(serverConn.LocalAddr()))
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err2 := ln.Accept()
+			Expect(err2).To(HaveOccurred())
+			close(done)
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+		err = ln.Close()
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(done).Should(BeClosed())
+	})
 })
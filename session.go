@@ -2,7 +2,6 @@ package quic
 
 import (
 	"errors"
-	"fmt"
 	"net"
 	"runtime"
 	"sync/atomic"
@@ -22,7 +21,7 @@ type unpacker interface {
 }
 
 type receivedPacket struct {
-	remoteAddr   interface{}
+	remoteAddr   net.Addr
 	publicHeader *PublicHeader
 	data         []byte
 	rcvTime      time.Time
@@ -31,21 +30,40 @@ type receivedPacket struct {
 var (
 	errRstStreamOnInvalidStream   = errors.New("RST_STREAM received for unknown stream")
 	errWindowUpdateOnClosedStream = errors.New("WINDOW_UPDATE received for an already closed stream")
+	errGoawayReceived             = qerr.Error(qerr.PeerGoingAway, "peer sent a GOAWAY")
+	errSessionGoingAway           = errors.New("session is going away")
 )
 
 // StreamCallback gets a stream frame and returns a reply frame
 type StreamCallback func(*Session, utils.Stream)
 
+// ConnectionMigrationCallback is called when the peer of a session changed its address
+type ConnectionMigrationCallback func(session *Session, oldAddr, newAddr net.Addr)
+
 // closeCallback is called when a session is closed
-type closeCallback func(id protocol.ConnectionID)
+// The closedSession can be used to answer packets that arrive for the connection afterwards.
+type closeCallback func(id protocol.ConnectionID, closed *closedSession)
+
+// cryptoChangeCallback is called every time the encryption level changes
+// Once the callback has been called with isForwardSecure = true, it is guarantueed to not be called with isForwardSecure = false after that
+type cryptoChangeCallback func(session *Session, isForwardSecure bool)
+
+type closeError struct {
+	err       error
+	sendClose bool // send a CONNECTION_CLOSE frame
+}
 
 // A Session is a QUIC session
 type Session struct {
 	connectionID protocol.ConnectionID
+	perspective  protocol.Perspective
 	version      protocol.VersionNumber
+	config       *Config
 
-	streamCallback StreamCallback
-	closeCallback  closeCallback
+	// streamCallback is only set for sessions of servers created with NewServer
+	streamCallback       StreamCallback
+	closeCallback        closeCallback
+	cryptoChangeCallback cryptoChangeCallback
 
 	conn connection
 
@@ -60,14 +78,18 @@ type Session struct {
 	unpacker unpacker
 	packer   *packetPacker
 
-	cryptoSetup *handshake.CryptoSetup
+	cryptoSetup handshake.CryptoSetup
 
 	receivedPackets  chan *receivedPacket
 	sendingScheduled chan struct{}
 	// closeChan is used to notify the run loop that it should terminate.
-	// If the value is not nil, the error is sent as a CONNECTION_CLOSE.
-	closeChan chan *qerr.QuicError
+	closeChan chan closeError
 	closed    uint32 // atomic bool
+	// goawayChan is used to notify the run loop that it should send a GOAWAY, and close the session once all streams are finished
+	goawayChan chan error
+	goaway     uint32 // atomic bool, set once CloseGracefully has been called
+	// goawayErr is the error passed to CloseGracefully, only accessed by the run loop
+	goawayErr error
 
 	undecryptablePackets []*receivedPacket
 	aeadChanged          chan struct{}
@@ -87,62 +109,112 @@ type Session struct {
 	timer           *time.Timer
 	currentDeadline time.Time
 	timerRead       bool
-}
+	// pacingDeadline is set when sending was stopped by the pacer
+	pacingDeadline time.Time
 
-// newSession makes a new session
-func newSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error) {
-	connectionParametersManager := handshake.NewConnectionParamatersManager()
-	flowControlManager := flowcontrol.NewFlowControlManager(connectionParametersManager)
+	// mtuDiscoverer is nil if path MTU discovery is disabled
+	mtuDiscoverer *mtuDiscoverer
 
-	var sentPacketHandler ackhandler.SentPacketHandler
-	var receivedPacketHandler ackhandler.ReceivedPacketHandler
-
-	sentPacketHandler = ackhandler.NewSentPacketHandler()
-	receivedPacketHandler = ackhandler.NewReceivedPacketHandler()
+	// sendQueue contains packets that were packed, but not yet written to the connection
+	sendQueue [][]byte
+}
 
-	now := time.Now()
+// newSession makes a new session
+func newSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfgs *handshake.ServerConfigStore, config *Config, streamCallback StreamCallback, closeCallback closeCallback, cryptoChangeCallback cryptoChangeCallback) (packetHandler, error) {
 	session := &Session{
 		conn:         conn,
 		connectionID: connectionID,
+		perspective:  protocol.PerspectiveServer,
 		version:      v,
+		config:       config,
 
-		streamCallback: streamCallback,
-		closeCallback:  closeCallback,
+		streamCallback:       streamCallback,
+		closeCallback:        closeCallback,
+		cryptoChangeCallback: cryptoChangeCallback,
+	}
 
-		connectionParametersManager: connectionParametersManager,
-		sentPacketHandler:           sentPacketHandler,
-		receivedPacketHandler:       receivedPacketHandler,
-		flowControlManager:          flowControlManager,
+	session.setup()
+	cryptoStream, _ := session.GetOrOpenStream(1)
+	var err error
+	session.cryptoSetup, err = handshake.NewCryptoSetup(connectionID, sourceAddrForSTK(conn.RemoteAddr()), v, sCfgs, cryptoStream, session.connectionParametersManager, session.aeadChanged)
+	if err != nil {
+		return nil, err
+	}
 
-		receivedPackets:      make(chan *receivedPacket, protocol.MaxSessionUnprocessedPackets),
-		closeChan:            make(chan *qerr.QuicError, 1),
-		sendingScheduled:     make(chan struct{}, 1),
-		undecryptablePackets: make([]*receivedPacket, 0, protocol.MaxUndecryptablePackets),
-		aeadChanged:          make(chan struct{}, 1),
+	session.packer = newPacketPacker(connectionID, session.cryptoSetup, session.connectionParametersManager, session.streamFramer, session.perspective, v, config.MaxPacketSize)
+	session.unpacker = &packetUnpacker{aead: session.cryptoSetup, version: v}
 
-		timer: time.NewTimer(0),
-		lastNetworkActivityTime: now,
-		sessionCreationTime:     now,
-	}
+	return session, err
+}
 
-	session.streamsMap = newStreamsMap(session.newStream)
+func newClientSession(conn connection, hostname string, v protocol.VersionNumber, connectionID protocol.ConnectionID, config *Config, closeCallback closeCallback, cryptoChangeCallback cryptoChangeCallback) (*Session, error) {
+	session := &Session{
+		conn:         conn,
+		connectionID: connectionID,
+		perspective:  protocol.PerspectiveClient,
+		version:      v,
+		config:       config,
 
-	cryptoStream, _ := session.GetOrOpenStream(1)
+		closeCallback:        closeCallback,
+		cryptoChangeCallback: cryptoChangeCallback,
+	}
+
+	session.setup()
+	cryptoStream, _ := session.OpenStream()
 	var err error
-	session.cryptoSetup, err = handshake.NewCryptoSetup(connectionID, conn.RemoteAddr().IP, v, sCfg, cryptoStream, session.connectionParametersManager, session.aeadChanged)
+	session.cryptoSetup, err = handshake.NewCryptoSetupClient(hostname, connectionID, v, cryptoStream, config.TLSConfig, session.connectionParametersManager, session.aeadChanged)
 	if err != nil {
 		return nil, err
 	}
 
-	session.streamFramer = newStreamFramer(session.streamsMap, flowControlManager)
-	session.packer = newPacketPacker(connectionID, session.cryptoSetup, session.connectionParametersManager, session.streamFramer, v)
+	session.packer = newPacketPacker(connectionID, session.cryptoSetup, session.connectionParametersManager, session.streamFramer, session.perspective, v, config.MaxPacketSize)
 	session.unpacker = &packetUnpacker{aead: session.cryptoSetup, version: v}
 
 	return session, err
 }
 
+// setup is called from newSession and newClientSession and initializes values that are independent of the perspective
+func (s *Session) setup() {
+	s.connectionParametersManager = handshake.NewConnectionParamatersManager(
+		s.config.ReceiveStreamFlowControlWindow,
+		s.config.ReceiveConnectionFlowControlWindow,
+		s.config.IdleTimeout,
+		s.config.MaxIdleTimeout,
+		s.config.MaxStreamsPerConnection,
+		s.config.MaxIncomingDynamicStreams,
+	)
+	s.flowControlManager = flowcontrol.NewFlowControlManager(s.connectionParametersManager)
+	congestionControl := s.config.CongestionControl
+	if s.config.CongestionControlForConnection != nil {
+		congestionControl = s.config.CongestionControlForConnection(s.conn.RemoteAddr())
+	}
+	s.sentPacketHandler = ackhandler.NewSentPacketHandler(congestionControl, s.config.InitialCongestionWindow, s.config.MaxCongestionWindow, !s.config.DisableEarlyLossDetection)
+	s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler()
+	if s.config.EnablePMTUDiscovery {
+		s.mtuDiscoverer = newMTUDiscoverer(s.config.MaxPacketSize, protocol.MaxReceivePacketSize)
+	}
+
+	s.receivedPackets = make(chan *receivedPacket, s.config.MaxCongestionWindow)
+	s.closeChan = make(chan closeError, 1)
+	s.goawayChan = make(chan error, 1)
+	s.sendingScheduled = make(chan struct{}, 1)
+	s.undecryptablePackets = make([]*receivedPacket, 0, protocol.MaxUndecryptablePackets)
+	s.aeadChanged = make(chan struct{}, 1)
+
+	s.timer = time.NewTimer(0)
+	now := time.Now()
+	s.lastNetworkActivityTime = now
+	s.sessionCreationTime = now
+
+	s.streamsMap = newStreamsMap(s.newStream, s.perspective, s.connectionParametersManager)
+	if s.streamCallback != nil {
+		s.streamsMap.streamCallback = func(str *stream) { s.streamCallback(s, str) }
+	}
+	s.streamFramer = newStreamFramer(s.streamsMap, s.flowControlManager)
+}
+
 // run the session main loop
-func (s *Session) run() {
+func (s *Session) run() error {
 	// Start the crypto stream handler
 	go func() {
 		if err := s.cryptoSetup.HandleCryptoStream(); err != nil {
@@ -150,14 +222,13 @@ func (s *Session) run() {
 		}
 	}()
 
+	var closeErr closeError
+runLoop:
 	for {
 		// Close immediately if requested
 		select {
-		case errForConnClose := <-s.closeChan:
-			if errForConnClose != nil {
-				s.sendConnectionClose(errForConnClose)
-			}
-			return
+		case closeErr = <-s.closeChan:
+			break runLoop
 		default:
 		}
 
@@ -165,11 +236,8 @@ func (s *Session) run() {
 
 		var err error
 		select {
-		case errForConnClose := <-s.closeChan:
-			if errForConnClose != nil {
-				s.sendConnectionClose(errForConnClose)
-			}
-			return
+		case closeErr = <-s.closeChan:
+			break runLoop
 		case <-s.timer.C:
 			s.timerRead = true
 			// We do all the interesting stuff after the switch statement, so
@@ -191,6 +259,9 @@ func (s *Session) run() {
 			}
 		case <-s.aeadChanged:
 			s.tryDecryptingQueuedPackets()
+			s.cryptoChangeCallback(s, s.cryptoSetup.HandshakeComplete())
+		case e := <-s.goawayChan:
+			s.sendGoaway(e)
 		}
 
 		if err != nil {
@@ -203,11 +274,26 @@ func (s *Session) run() {
 		if time.Now().Sub(s.lastNetworkActivityTime) >= s.idleTimeout() {
 			s.Close(qerr.Error(qerr.NetworkIdleTimeout, "No recent network activity."))
 		}
-		if !s.cryptoSetup.HandshakeComplete() && time.Now().Sub(s.sessionCreationTime) >= protocol.MaxTimeForCryptoHandshake {
+		if !s.cryptoSetup.HandshakeComplete() && time.Now().Sub(s.sessionCreationTime) >= s.config.HandshakeTimeout {
 			s.Close(qerr.Error(qerr.NetworkIdleTimeout, "Crypto handshake did not complete in time."))
 		}
 		s.garbageCollectStreams()
+		// streams are finished once the FIN was sent, so we also need to wait until the peer acknowledged all data
+		// otherwise the CONNECTION_CLOSE could overtake the last STREAM frames
+		if s.goawayErr != nil && s.streamsMap.NumberOfDataStreams() == 0 && !s.streamFramer.HasFramesForRetransmission() && !s.sentPacketHandler.HasOutstandingRetransmittablePackets() {
+			s.Close(s.goawayErr)
+		}
+	}
+
+	var connectionClose []byte
+	if closeErr.sendClose {
+		if packet, err := s.sendConnectionClose(qerr.ToQuicError(closeErr.err)); err == nil {
+			// the packet was allocated from the buffer pool, copy it to not keep the whole buffer
+			connectionClose = append([]byte{}, packet...)
+		}
 	}
+	s.closeCallback(s.connectionID, newClosedSession(s.connectionID, connectionClose, s.cryptoSetup.PublicResetNonceProof()))
+	return closeErr.err
 }
 
 func (s *Session) maybeResetTimer() {
@@ -219,8 +305,11 @@ func (s *Session) maybeResetTimer() {
 	if rtoTime := s.sentPacketHandler.TimeOfFirstRTO(); !rtoTime.IsZero() {
 		nextDeadline = utils.MinTime(nextDeadline, rtoTime)
 	}
+	if !s.pacingDeadline.IsZero() {
+		nextDeadline = utils.MinTime(nextDeadline, s.pacingDeadline)
+	}
 	if !s.cryptoSetup.HandshakeComplete() {
-		handshakeDeadline := s.sessionCreationTime.Add(protocol.MaxTimeForCryptoHandshake)
+		handshakeDeadline := s.sessionCreationTime.Add(s.config.HandshakeTimeout)
 		nextDeadline = utils.MinTime(nextDeadline, handshakeDeadline)
 	}
 
@@ -267,8 +356,12 @@ func (s *Session) handlePacketImpl(p *receivedPacket) error {
 		utils.Debugf("<- Reading packet 0x%x (%d bytes) for connection %x", hdr.PacketNumber, len(data)+len(hdr.Raw), hdr.ConnectionID)
 	}
 
-	// TODO: Only do this after authenticating
-	s.conn.setCurrentRemoteAddr(p.remoteAddr)
+	// the client needs the diversification nonce to derive the server's initial key
+	if s.perspective == protocol.PerspectiveClient && len(hdr.DiversificationNonce) > 0 {
+		if err := s.cryptoSetup.SetDiversificationNonce(hdr.DiversificationNonce); err != nil {
+			return err
+		}
+	}
 
 	packet, err := s.unpacker.Unpack(hdr.Raw, hdr, data)
 	if err != nil {
@@ -295,9 +388,36 @@ func (s *Session) handlePacketImpl(p *receivedPacket) error {
 		return err
 	}
 
+	// Only migrate for packets that were authenticated, and that are not reordered
+	if s.perspective == protocol.PerspectiveServer && hdr.PacketNumber == s.largestRcvdPacketNumber {
+		s.maybeMigrateConnection(p.remoteAddr)
+	}
+
 	return s.handleFrames(packet.frames)
 }
 
+// maybeMigrateConnection switches to the remote address of a received packet, if it differs from the current one
+func (s *Session) maybeMigrateConnection(newAddr net.Addr) {
+	if newAddr == nil {
+		return
+	}
+	oldAddr := s.conn.RemoteAddr()
+	if oldAddr != nil && addrsEqual(oldAddr, newAddr) {
+		return
+	}
+	utils.Infof("Connection %x migrated from %s to %s", s.connectionID, oldAddr, newAddr)
+	s.conn.setCurrentRemoteAddr(newAddr)
+	s.sentPacketHandler.OnConnectionMigration()
+	if s.mtuDiscoverer != nil {
+		// the new path might have a smaller MTU
+		s.mtuDiscoverer = newMTUDiscoverer(s.config.MaxPacketSize, protocol.MaxReceivePacketSize)
+		s.packer.SetMaxPacketSize(s.config.MaxPacketSize)
+	}
+	if s.config.ConnectionMigrationCallback != nil {
+		s.config.ConnectionMigrationCallback(s, oldAddr, newAddr)
+	}
+}
+
 func (s *Session) handleFrames(fs []frames.Frame) error {
 	for _, ff := range fs {
 		var err error
@@ -305,13 +425,12 @@ func (s *Session) handleFrames(fs []frames.Frame) error {
 		switch frame := ff.(type) {
 		case *frames.StreamFrame:
 			err = s.handleStreamFrame(frame)
-			// TODO: send RstStreamFrame
 		case *frames.AckFrame:
 			err = s.handleAckFrame(frame)
 		case *frames.ConnectionCloseFrame:
 			s.closeImpl(qerr.Error(frame.ErrorCode, frame.ReasonPhrase), true)
 		case *frames.GoawayFrame:
-			err = errors.New("unimplemented: handling GOAWAY frames")
+			err = s.handleGoawayFrame(frame)
 		case *frames.StopWaitingFrame:
 			err = s.receivedPacketHandler.ReceivedStopWaiting(frame)
 		case *frames.RstStreamFrame:
@@ -344,7 +463,7 @@ func (s *Session) handleFrames(fs []frames.Frame) error {
 // handlePacket is called by the server with a new packet
 func (s *Session) handlePacket(p *receivedPacket) {
 	// Discard packets once the amount of queued packets is larger than
-	// the channel size, protocol.MaxSessionUnprocessedPackets
+	// the channel size, which is the max congestion window
 	select {
 	case s.receivedPackets <- p:
 	default:
@@ -362,7 +481,13 @@ func (s *Session) handleStreamFrame(frame *frames.StreamFrame) error {
 	}
 	err = str.AddStreamFrame(frame)
 	if err != nil {
-		return err
+		switch err.(type) {
+		case *qerr.QuicError, qerr.ErrorCode:
+			return err
+		}
+		// the error only affects this stream, reset it instead of closing the connection
+		utils.Infof("Resetting stream %d: %s", frame.StreamID, err.Error())
+		str.reset(rstStreamErrorProcessingStream, err)
 	}
 	return nil
 }
@@ -381,7 +506,6 @@ func (s *Session) handleWindowUpdateFrame(frame *frames.WindowUpdateFrame) error
 	return err
 }
 
-// TODO: Handle frame.byteOffset
 func (s *Session) handleRstStreamFrame(frame *frames.RstStreamFrame) error {
 	str, err := s.streamsMap.GetOrOpenStream(frame.StreamID)
 	if err != nil {
@@ -390,14 +514,32 @@ func (s *Session) handleRstStreamFrame(frame *frames.RstStreamFrame) error {
 	if str == nil {
 		return errRstStreamOnInvalidStream
 	}
-	s.closeStreamWithError(str, fmt.Errorf("RST_STREAM received with code %d", frame.ErrorCode))
-	return nil
+	return str.RegisterRemoteReset(frame)
+}
+
+// handleGoawayFrame stops opening new streams, and closes all streams that the peer didn't process
+func (s *Session) handleGoawayFrame(frame *frames.GoawayFrame) error {
+	utils.Infof("Peer is going away (%s: %s), last good stream %d", frame.ErrorCode, frame.ReasonPhrase, frame.LastGoodStream)
+	s.streamsMap.StopOpeningStreams(errGoawayReceived)
+	return s.streamsMap.Iterate(func(str *stream) (bool, error) {
+		id := str.StreamID()
+		if id > frame.LastGoodStream && !s.streamsMap.isOpenedByPeer(id) {
+			s.closeStreamWithError(str, errGoawayReceived)
+		}
+		return true, nil
+	})
 }
 
 func (s *Session) handleAckFrame(frame *frames.AckFrame) error {
 	if err := s.sentPacketHandler.ReceivedAck(frame, s.lastRcvdPacketNumber, s.lastNetworkActivityTime); err != nil {
 		return err
 	}
+	if s.mtuDiscoverer != nil {
+		if size, ok := s.mtuDiscoverer.ReceivedAck(frame); ok {
+			utils.Infof("Connection %x: increasing the packet size to %d bytes", s.connectionID, size)
+			s.packer.SetMaxPacketSize(size)
+		}
+	}
 	return nil
 }
 
@@ -406,6 +548,38 @@ func (s *Session) Close(e error) error {
 	return s.closeImpl(e, false)
 }
 
+// CloseGracefully sends a GOAWAY frame, and closes the connection once all open streams are finished.
+// No new streams are opened or accepted after calling this function.
+// If err is nil it will be set to qerr.PeerGoingAway.
+// The session can still be closed immediately by calling Close.
+func (s *Session) CloseGracefully(e error) error {
+	// Only send one GOAWAY
+	if !atomic.CompareAndSwapUint32(&s.goaway, 0, 1) {
+		return nil
+	}
+	if e == nil {
+		e = qerr.PeerGoingAway
+	}
+	s.goawayChan <- e
+	return nil
+}
+
+// sendGoaway must only be called from the run loop
+func (s *Session) sendGoaway(e error) {
+	quicErr := qerr.ToQuicError(e)
+	utils.Infof("Sending GOAWAY for connection %x: %s", s.connectionID, quicErr.Error())
+
+	s.goawayErr = e
+	s.streamsMap.StopOpeningStreams(errSessionGoingAway)
+	lastGoodStream := s.streamsMap.RejectNewStreams()
+	s.packer.QueueControlFrameForNextPacket(&frames.GoawayFrame{
+		ErrorCode:      quicErr.ErrorCode,
+		LastGoodStream: lastGoodStream,
+		ReasonPhrase:   quicErr.ErrorMessage,
+	})
+	s.scheduleSending()
+}
+
 func (s *Session) closeImpl(e error, remoteClose bool) error {
 	// Only close once
 	if !atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
@@ -426,24 +600,24 @@ func (s *Session) closeImpl(e error, remoteClose bool) error {
 	}
 
 	s.closeStreamsWithError(quicErr)
-	s.closeCallback(s.connectionID)
 
 	if remoteClose {
 		// If this is a remote close we don't need to send a CONNECTION_CLOSE
-		s.closeChan <- nil
+		s.closeChan <- closeError{err: e, sendClose: false}
 		return nil
 	}
 
 	if quicErr.ErrorCode == qerr.DecryptionFailure {
 		// If we send a public reset, don't send a CONNECTION_CLOSE
-		s.closeChan <- nil
+		s.closeChan <- closeError{err: e, sendClose: false}
 		return s.sendPublicReset(s.lastRcvdPacketNumber)
 	}
-	s.closeChan <- quicErr
+	s.closeChan <- closeError{err: e, sendClose: true}
 	return nil
 }
 
 func (s *Session) closeStreamsWithError(err error) {
+	s.streamsMap.CloseWithError(err)
 	s.streamsMap.Iterate(func(str *stream) (bool, error) {
 		s.closeStreamWithError(str, err)
 		return true, nil
@@ -454,7 +628,15 @@ func (s *Session) closeStreamWithError(str *stream, err error) {
 	str.RegisterError(err)
 }
 
-func (s *Session) sendPacket() error {
+// sendPacket packs packets until there's no more data to send, and writes them in batches
+func (s *Session) sendPacket() (err error) {
+	s.pacingDeadline = time.Time{}
+	defer func() {
+		if flushErr := s.flushSendQueue(); err == nil {
+			err = flushErr
+		}
+	}()
+
 	// Repeatedly try sending until we don't have any more data, or run out of the congestion window
 	for {
 		err := s.sentPacketHandler.CheckForError()
@@ -468,6 +650,18 @@ func (s *Session) sendPacket() error {
 		if !s.sentPacketHandler.SendingAllowed() {
 			return nil
 		}
+		// The timer is set to the pacing deadline in maybeResetTimer.
+		// Only retransmittable packets are paced, so we might still send an ACK.
+		if s.pacingDeadline = s.sentPacketHandler.TimeUntilSend(); !s.pacingDeadline.IsZero() {
+			return s.maybeSendAckOnlyPacket()
+		}
+
+		if s.mtuDiscoverer != nil && s.mtuDiscoverer.ShouldSendProbe() && s.cryptoSetup.HandshakeComplete() {
+			if err := s.sendPMTUProbe(); err != nil {
+				return err
+			}
+			continue
+		}
 
 		var controlFrames []frames.Frame
 
@@ -495,6 +689,15 @@ func (s *Session) sendPacket() error {
 			controlFrames = append(controlFrames, wuf)
 		}
 
+		rstStreamFrames, err := s.getRstStreamFrames()
+		if err != nil {
+			return err
+		}
+
+		for _, rst := range rstStreamFrames {
+			controlFrames = append(controlFrames, rst)
+		}
+
 		ack, err := s.receivedPacketHandler.GetAckFrame(false)
 		if err != nil {
 			return err
@@ -545,24 +748,102 @@ func (s *Session) sendPacket() error {
 		s.logPacket(packet)
 		s.delayedAckOriginTime = time.Time{}
 
-		err = s.conn.write(packet.raw)
-		putPacketBuffer(packet.raw)
-		if err != nil {
-			return err
+		s.sendQueue = append(s.sendQueue, packet.raw)
+		if len(s.sendQueue) >= protocol.PacketBatchSize {
+			if err := s.flushSendQueue(); err != nil {
+				return err
+			}
 		}
 	}
 }
 
-func (s *Session) sendConnectionClose(quicErr *qerr.QuicError) error {
-	packet, err := s.packer.PackConnectionClose(&frames.ConnectionCloseFrame{ErrorCode: quicErr.ErrorCode, ReasonPhrase: quicErr.ErrorMessage}, s.sentPacketHandler.GetLeastUnacked())
+// maybeSendAckOnlyPacket sends a packet that only contains an ACK (and a STOP_WAITING), if an ACK is due
+func (s *Session) maybeSendAckOnlyPacket() error {
+	if runtime.GOOS != "windows" && time.Now().Sub(s.delayedAckOriginTime) <= protocol.AckSendDelay {
+		return nil
+	}
+	ack, err := s.receivedPacketHandler.GetAckFrame(true)
+	if err != nil {
+		return err
+	}
+	if ack == nil {
+		return nil
+	}
+	packet, err := s.packer.PackAckPacket(s.sentPacketHandler.GetStopWaitingFrame(false), ack, s.sentPacketHandler.GetLeastUnacked())
 	if err != nil {
 		return err
 	}
+	err = s.sentPacketHandler.SentPacket(&ackhandler.Packet{
+		PacketNumber: packet.number,
+		Frames:       packet.frames,
+		Length:       protocol.ByteCount(len(packet.raw)),
+	})
+	if err != nil {
+		return err
+	}
+	s.logPacket(packet)
+	s.delayedAckOriginTime = time.Time{}
+	s.sendQueue = append(s.sendQueue, packet.raw)
+	return nil
+}
+
+// flushSendQueue writes all queued packets to the connection
+func (s *Session) flushSendQueue() error {
+	if len(s.sendQueue) == 0 {
+		return nil
+	}
+	err := s.conn.writeBatch(s.sendQueue)
+	for i, p := range s.sendQueue {
+		putPacketBuffer(p)
+		s.sendQueue[i] = nil
+	}
+	s.sendQueue = s.sendQueue[:0]
+	return err
+}
+
+// sendPMTUProbe sends a probe packet for path MTU discovery
+func (s *Session) sendPMTUProbe() error {
+	// the probe is written immediately, so write all packets that were packed before it first
+	if err := s.flushSendQueue(); err != nil {
+		return err
+	}
+
+	size := s.mtuDiscoverer.NextProbeSize()
+	packet, err := s.packer.PackPMTUProbe(size, s.sentPacketHandler.GetLeastUnacked())
+	if err != nil {
+		return err
+	}
+	s.mtuDiscoverer.SentProbe(packet.number, size)
+
+	s.logPacket(packet)
+	err = s.conn.write(packet.raw)
+	putPacketBuffer(packet.raw)
+	if err != nil {
+		// Sending fails if the probe is larger than the MTU of the interface.
+		// The packet number is then treated as a skipped packet number by the sentPacketHandler.
+		utils.Debugf("PMTU discovery: sending probe failed: %s", err.Error())
+		s.mtuDiscoverer.ProbeLost()
+		return nil
+	}
+
+	// a lost probe only means that the probe size is too large, it's not a sign of congestion
+	return s.sentPacketHandler.SentPMTUProbe(packet.number)
+}
+
+// sendConnectionClose sends a CONNECTION_CLOSE packet, and returns it
+func (s *Session) sendConnectionClose(quicErr *qerr.QuicError) ([]byte, error) {
+	packet, err := s.packer.PackConnectionClose(&frames.ConnectionCloseFrame{ErrorCode: quicErr.ErrorCode, ReasonPhrase: quicErr.ErrorMessage}, s.sentPacketHandler.GetLeastUnacked())
+	if err != nil {
+		return nil, err
+	}
 	if packet == nil {
-		return errors.New("Session BUG: expected packet not to be nil")
+		return nil, errors.New("Session BUG: expected packet not to be nil")
 	}
 	s.logPacket(packet)
-	return s.conn.write(packet.raw)
+	if err := s.conn.write(packet.raw); err != nil {
+		return nil, err
+	}
+	return packet.raw, nil
 }
 
 func (s *Session) logPacket(packet *packedPacket) {
@@ -579,14 +860,39 @@ func (s *Session) logPacket(packet *packedPacket) {
 }
 
 // GetOrOpenStream either returns an existing stream, a newly opened stream, or nil if a stream with the provided ID is already closed.
-// Newly opened streams should only originate from the client. To open a stream from the server, OpenStream should be used.
+// Newly opened streams should only originate from the peer. To open a stream ourselves, OpenStream should be used.
 func (s *Session) GetOrOpenStream(id protocol.StreamID) (utils.Stream, error) {
 	return s.streamsMap.GetOrOpenStream(id)
 }
 
-// OpenStream opens a stream from the server's side
-func (s *Session) OpenStream(id protocol.StreamID) (utils.Stream, error) {
-	return s.streamsMap.OpenStream(id)
+// AcceptStream returns the next stream opened by the peer
+// It blocks until a new stream is opened, or the session is closed.
+func (s *Session) AcceptStream() (utils.Stream, error) {
+	str, err := s.streamsMap.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return str, nil
+}
+
+// OpenStream opens a new stream, using the next available stream ID
+// It returns an error if the peer doesn't allow us to open any more streams.
+func (s *Session) OpenStream() (utils.Stream, error) {
+	str, err := s.streamsMap.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return str, nil
+}
+
+// OpenStreamSync opens a new stream, using the next available stream ID
+// If the peer doesn't allow us to open any more streams, it blocks until a stream is closed, or the session is closed.
+func (s *Session) OpenStreamSync() (utils.Stream, error) {
+	str, err := s.streamsMap.OpenStreamSync()
+	if err != nil {
+		return nil, err
+	}
+	return str, nil
 }
 
 func (s *Session) newStreamImpl(id protocol.StreamID) (*stream, error) {
@@ -606,8 +912,6 @@ func (s *Session) newStream(id protocol.StreamID) (*stream, error) {
 		s.flowControlManager.NewStream(id, true)
 	}
 
-	s.streamCallback(s, stream)
-
 	return stream, nil
 }
 
@@ -627,9 +931,25 @@ func (s *Session) garbageCollectStreams() {
 	})
 }
 
+// handlePublicReset closes the session, if the public reset contains the expected nonce proof
+func (s *Session) handlePublicReset(pr *PublicReset) {
+	nonceProof := s.cryptoSetup.PublicResetNonceProof()
+	// Before the client nonce was sent, the nonce proof can't be checked, and anyone could reset the connection.
+	if nonceProof == 0 {
+		utils.Infof("Received a public reset for connection %x before sending a client nonce, ignoring it", s.connectionID)
+		return
+	}
+	if pr.NonceProof != nonceProof {
+		utils.Infof("Received a public reset with an invalid nonce proof for connection %x, ignoring it", s.connectionID)
+		return
+	}
+	utils.Infof("Received a public reset for connection %x, rejected packet number 0x%x", s.connectionID, pr.RejectedPacketNumber)
+	s.closeImpl(qerr.Error(qerr.PublicReset, "Received a public reset"), true)
+}
+
 func (s *Session) sendPublicReset(rejectedPacketNumber protocol.PacketNumber) error {
 	utils.Infof("Sending public reset for connection %x, packet number %d", s.connectionID, rejectedPacketNumber)
-	return s.conn.write(writePublicReset(s.connectionID, rejectedPacketNumber, 0))
+	return s.conn.write(writePublicReset(s.connectionID, rejectedPacketNumber, s.cryptoSetup.PublicResetNonceProof(), s.conn.RemoteAddr()))
 }
 
 // scheduleSending signals that we have data for sending
@@ -667,7 +987,27 @@ func (s *Session) getWindowUpdateFrames() ([]*frames.WindowUpdateFrame, error) {
 	return res, nil
 }
 
-// RemoteAddr returns the net.UDPAddr of the client
-func (s *Session) RemoteAddr() *net.UDPAddr {
+// getRstStreamFrames gets the RST_STREAM frames for all streams that were reset since the last call
+// Since the peer discards all data for these streams, their frames are not retransmitted anymore.
+func (s *Session) getRstStreamFrames() ([]*frames.RstStreamFrame, error) {
+	var res []*frames.RstStreamFrame
+	fn := func(str *stream) (bool, error) {
+		if f := str.getRstStreamFrame(); f != nil {
+			res = append(res, f)
+		}
+		return true, nil
+	}
+	if err := s.streamsMap.Iterate(fn); err != nil {
+		return nil, err
+	}
+	for _, f := range res {
+		s.sentPacketHandler.CancelRetransmissionsForStream(f.StreamID)
+		s.streamFramer.CancelRetransmissionsForStream(f.StreamID)
+	}
+	return res, nil
+}
+
+// RemoteAddr returns the address of the peer
+func (s *Session) RemoteAddr() net.Addr {
 	return s.conn.RemoteAddr()
 }
@@ -15,6 +15,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/lucas-clemente/quic-go/ackhandler"
+	"github.com/lucas-clemente/quic-go/congestion"
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
@@ -25,22 +26,51 @@ import (
 )
 
 type mockConnection struct {
-	written [][]byte
+	written    [][]byte
+	remoteAddr net.Addr
+	writeErr   error
+	// the number of calls to writeBatch
+	batches int
 }
 
 func (m *mockConnection) write(p []byte) error {
+	if m.writeErr != nil {
+		return m.writeErr
+	}
 	b := make([]byte, len(p))
 	copy(b, p)
 	m.written = append(m.written, b)
 	return nil
 }
 
-func (*mockConnection) setCurrentRemoteAddr(addr interface{}) {}
-func (*mockConnection) RemoteAddr() *net.UDPAddr              { return &net.UDPAddr{} }
+func (m *mockConnection) writeBatch(ps [][]byte) error {
+	m.batches++
+	for _, p := range ps {
+		if err := m.write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mockConnection) setCurrentRemoteAddr(addr net.Addr) {
+	m.remoteAddr = addr
+}
+func (m *mockConnection) RemoteAddr() net.Addr {
+	if m.remoteAddr == nil {
+		return &net.UDPAddr{}
+	}
+	return m.remoteAddr
+}
 
-type mockUnpacker struct{}
+type mockUnpacker struct {
+	unpackErr error
+}
 
 func (m *mockUnpacker) Unpack(publicHeaderBinary []byte, hdr *PublicHeader, data []byte) (*unpackedPacket, error) {
+	if m.unpackErr != nil {
+		return nil, m.unpackErr
+	}
 	return &unpackedPacket{
 		frames: nil,
 	}, nil
@@ -50,31 +80,46 @@ type mockSentPacketHandler struct {
 	retransmissionQueue  []*ackhandler.Packet
 	sentPackets          []*ackhandler.Packet
 	congestionLimited    bool
+	timeUntilSend        time.Time
 	maybeQueueRTOsCalled bool
 	requestedStopWaiting bool
+	migrated             bool
+	cancelledStreams     []protocol.StreamID
+	outstandingPackets   bool
 }
 
 func (h *mockSentPacketHandler) SentPacket(packet *ackhandler.Packet) error {
 	h.sentPackets = append(h.sentPackets, packet)
 	return nil
 }
+func (h *mockSentPacketHandler) SentPMTUProbe(protocol.PacketNumber) error { return nil }
 func (h *mockSentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNumber protocol.PacketNumber, recvTime time.Time) error {
 	return nil
 }
 func (h *mockSentPacketHandler) BytesInFlight() protocol.ByteCount      { return 0 }
 func (h *mockSentPacketHandler) GetLeastUnacked() protocol.PacketNumber { return 1 }
+func (h *mockSentPacketHandler) HasOutstandingRetransmittablePackets() bool {
+	return h.outstandingPackets
+}
 func (h *mockSentPacketHandler) GetStopWaitingFrame(force bool) *frames.StopWaitingFrame {
 	h.requestedStopWaiting = true
 	return &frames.StopWaitingFrame{LeastUnacked: 0x1337}
 }
 func (h *mockSentPacketHandler) SendingAllowed() bool      { return !h.congestionLimited }
+func (h *mockSentPacketHandler) TimeUntilSend() time.Time  { return h.timeUntilSend }
 func (h *mockSentPacketHandler) CheckForError() error      { return nil }
-func (h *mockSentPacketHandler) TimeOfFirstRTO() time.Time { panic("not implemented") }
+func (h *mockSentPacketHandler) TimeOfFirstRTO() time.Time { return time.Time{} }
+
+func (h *mockSentPacketHandler) OnConnectionMigration() { h.migrated = true }
 
 func (h *mockSentPacketHandler) MaybeQueueRTOs() {
 	h.maybeQueueRTOsCalled = true
 }
 
+func (h *mockSentPacketHandler) CancelRetransmissionsForStream(streamID protocol.StreamID) {
+	h.cancelledStreams = append(h.cancelledStreams, streamID)
+}
+
 func (h *mockSentPacketHandler) DequeuePacketForRetransmission() *ackhandler.Packet {
 	if len(h.retransmissionQueue) > 0 {
 		packet := h.retransmissionQueue[0]
@@ -90,36 +135,114 @@ func newMockSentPacketHandler() ackhandler.SentPacketHandler {
 
 var _ = Describe("Session", func() {
 	var (
-		session              *Session
-		streamCallbackCalled bool
-		closeCallbackCalled  bool
-		conn                 *mockConnection
+		session             *Session
+		closeCallbackCalled bool
+		connectionClose     []byte
+		conn                *mockConnection
+		scfgs               *handshake.ServerConfigStore
 	)
 
 	BeforeEach(func() {
 		conn = &mockConnection{}
-		streamCallbackCalled = false
 		closeCallbackCalled = false
+		connectionClose = nil
 
 		signer, err := crypto.NewProofSource(testdata.GetTLSConfig())
 		Expect(err).ToNot(HaveOccurred())
-		kex, err := crypto.NewCurve25519KEX()
+		scfgs, err = handshake.NewServerConfigStore(signer, nil, protocol.DefaultServerConfigRotationInterval, nil)
 		Expect(err).NotTo(HaveOccurred())
-		scfg, err := handshake.NewServerConfig(kex, signer)
+		config, err := populateConfig(nil)
 		Expect(err).NotTo(HaveOccurred())
 		pSession, err := newSession(
 			conn,
 			protocol.Version35,
 			0,
-			scfg,
-			func(*Session, utils.Stream) { streamCallbackCalled = true },
-			func(protocol.ConnectionID) { closeCallbackCalled = true },
+			scfgs,
+			config,
+			nil,
+			func(_ protocol.ConnectionID, closed *closedSession) {
+				closeCallbackCalled = true
+				connectionClose = closed.connectionClose
+			},
+			func(*Session, bool) {},
 		)
 		Expect(err).NotTo(HaveOccurred())
 		session = pSession.(*Session)
 		Expect(session.streamsMap.NumberOfStreams()).To(Equal(1)) // Crypto stream
 	})
 
+	It("uses the values from the config", func() {
+		config, err := populateConfig(&Config{
+			ReceiveStreamFlowControlWindow:     0x1000,
+			ReceiveConnectionFlowControlWindow: 0x2000,
+			IdleTimeout:                        5 * time.Second,
+			MaxStreamsPerConnection:            20,
+			MaxIncomingDynamicStreams:          30,
+			MaxPacketSize:                      1300,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		pSession, err := newSession(conn, protocol.Version35, 0, scfgs, config, nil, func(protocol.ConnectionID, *closedSession) {}, func(*Session, bool) {})
+		Expect(err).ToNot(HaveOccurred())
+		s := pSession.(*Session)
+		Expect(s.connectionParametersManager.GetReceiveStreamFlowControlWindow()).To(Equal(protocol.ByteCount(0x1000)))
+		Expect(s.connectionParametersManager.GetReceiveConnectionFlowControlWindow()).To(Equal(protocol.ByteCount(0x2000)))
+		Expect(s.connectionParametersManager.GetIdleConnectionStateLifetime()).To(Equal(5 * time.Second))
+		Expect(s.connectionParametersManager.GetMaxStreamsPerConnection()).To(Equal(uint32(20)))
+		Expect(s.streamsMap.maxIncomingStreams).To(Equal(uint32(33)))
+		Expect(s.packer.maxPacketSize).To(Equal(protocol.ByteCount(1300)))
+	})
+
+	It("selects the congestion control algorithm for the connection", func() {
+		remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1000}
+		conn.remoteAddr = remoteAddr
+		var selectedFor net.Addr
+		config, err := populateConfig(&Config{
+			CongestionControlForConnection: func(addr net.Addr) congestion.Algorithm {
+				selectedFor = addr
+				return congestion.AlgorithmBBR
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = newSession(conn, protocol.Version35, 0, scfgs, config, nil, func(protocol.ConnectionID, *closedSession) {}, func(*Session, bool) {})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(selectedFor).To(Equal(remoteAddr))
+	})
+
+	It("enables path MTU discovery", func() {
+		config, err := populateConfig(&Config{EnablePMTUDiscovery: true})
+		Expect(err).ToNot(HaveOccurred())
+		pSession, err := newSession(conn, protocol.Version35, 0, scfgs, config, nil, func(protocol.ConnectionID, *closedSession) {}, func(*Session, bool) {})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pSession.(*Session).mtuDiscoverer).ToNot(BeNil())
+		Expect(session.mtuDiscoverer).To(BeNil())
+	})
+
+	Context("opening streams", func() {
+		It("opens streams with even IDs", func() {
+			str, err := session.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.StreamID()).To(Equal(protocol.StreamID(2)))
+			str, err = session.OpenStreamSync()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.StreamID()).To(Equal(protocol.StreamID(4)))
+		})
+
+		It("registers the streams with the flow control manager", func() {
+			str, err := session.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = session.flowControlManager.SendWindowSize(str.StreamID())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("doesn't return a stream when the peer doesn't allow opening more streams", func() {
+			err := session.connectionParametersManager.SetFromMap(map[handshake.Tag][]byte{handshake.TagMIDS: {0, 0, 0, 0}})
+			Expect(err).ToNot(HaveOccurred())
+			str, err := session.OpenStream()
+			Expect(err).To(MatchError(qerr.TooManyOpenStreams))
+			Expect(str).To(BeNil())
+		})
+	})
+
 	Context("when handling stream frames", func() {
 		It("makes new streams", func() {
 			session.handleStreamFrame(&frames.StreamFrame{
@@ -127,7 +250,6 @@ var _ = Describe("Session", func() {
 				Data:     []byte{0xde, 0xca, 0xfb, 0xad},
 			})
 			Expect(session.streamsMap.NumberOfStreams()).To(Equal(2))
-			Expect(streamCallbackCalled).To(BeTrue())
 			p := make([]byte, 4)
 			str, _ := session.streamsMap.GetOrOpenStream(5)
 			Expect(str).ToNot(BeNil())
@@ -136,6 +258,34 @@ var _ = Describe("Session", func() {
 			Expect(p).To(Equal([]byte{0xde, 0xca, 0xfb, 0xad}))
 		})
 
+		It("returns new streams from AcceptStream", func() {
+			session.handleStreamFrame(&frames.StreamFrame{
+				StreamID: 5,
+				Data:     []byte{0xde, 0xca, 0xfb, 0xad},
+			})
+			str, err := session.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.StreamID()).To(Equal(protocol.StreamID(5)))
+		})
+
+		It("calls the StreamCallback for new streams, before the handshake completed", func() {
+			var streams []utils.Stream
+			session.streamCallback = func(s *Session, str utils.Stream) {
+				Expect(s).To(Equal(session))
+				streams = append(streams, str)
+			}
+			session.streamsMap.streamCallback = func(str *stream) { session.streamCallback(session, str) }
+			Expect(session.cryptoSetup.HandshakeComplete()).To(BeFalse())
+			err := session.handleStreamFrame(&frames.StreamFrame{
+				StreamID: 5,
+				Data:     []byte{0xde, 0xca, 0xfb, 0xad},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(streams).To(HaveLen(1))
+			Expect(streams[0].StreamID()).To(Equal(protocol.StreamID(5)))
+			Expect(session.streamsMap.acceptQueue).To(BeEmpty())
+		})
+
 		It("does not reject existing streams with even StreamIDs", func() {
 			_, err := session.GetOrOpenStream(5)
 			Expect(err).ToNot(HaveOccurred())
@@ -152,7 +302,6 @@ var _ = Describe("Session", func() {
 				Data:     []byte{0xde, 0xca},
 			})
 			Expect(session.streamsMap.NumberOfStreams()).To(Equal(2))
-			Expect(streamCallbackCalled).To(BeTrue())
 			session.handleStreamFrame(&frames.StreamFrame{
 				StreamID: 5,
 				Offset:   2,
@@ -186,7 +335,6 @@ var _ = Describe("Session", func() {
 			Expect(session.streamsMap.NumberOfStreams()).To(Equal(2))
 			str, _ := session.streamsMap.GetOrOpenStream(5)
 			Expect(str).ToNot(BeNil())
-			Expect(streamCallbackCalled).To(BeTrue())
 			p := make([]byte, 4)
 			_, err := str.Read(p)
 			Expect(err).To(MatchError(io.EOF))
@@ -206,7 +354,6 @@ var _ = Describe("Session", func() {
 			Expect(session.streamsMap.NumberOfStreams()).To(Equal(2))
 			str, _ := session.streamsMap.GetOrOpenStream(5)
 			Expect(str).ToNot(BeNil())
-			Expect(streamCallbackCalled).To(BeTrue())
 			p := make([]byte, 4)
 			_, err := str.Read(p)
 			Expect(err).To(MatchError(io.EOF))
@@ -238,7 +385,6 @@ var _ = Describe("Session", func() {
 			Expect(session.streamsMap.NumberOfStreams()).To(Equal(2))
 			str, _ := session.streamsMap.GetOrOpenStream(5)
 			Expect(str).ToNot(BeNil())
-			Expect(streamCallbackCalled).To(BeTrue())
 			p := make([]byte, 4)
 			_, err := str.Read(p)
 			session.closeStreamsWithError(testErr)
@@ -294,6 +440,29 @@ var _ = Describe("Session", func() {
 			})
 			Expect(err).To(BeNil())
 		})
+
+		It("resets the stream if a StreamFrame can't be added", func() {
+			err := session.handleStreamFrame(&frames.StreamFrame{
+				StreamID: 5,
+				Data:     []byte{},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			str, _ := session.streamsMap.GetOrOpenStream(5)
+			Expect(str).ToNot(BeNil())
+			_, err = str.Read([]byte{0})
+			Expect(err).To(MatchError(errEmptyStreamData))
+			Expect(str.getRstStreamFrame().ErrorCode).To(Equal(rstStreamErrorProcessingStream))
+		})
+
+		It("doesn't reset the stream on connection errors", func() {
+			err := session.handleStreamFrame(&frames.StreamFrame{
+				StreamID: 5,
+				Data:     bytes.Repeat([]byte{'f'}, int(protocol.DefaultReceiveStreamFlowControlWindow)+1),
+			})
+			Expect(err).To(MatchError(qerr.FlowControlReceivedTooMuchData))
+			str, _ := session.streamsMap.GetOrOpenStream(5)
+			Expect(str.getRstStreamFrame()).To(BeNil())
+		})
 	})
 
 	Context("handling RST_STREAM frames", func() {
@@ -313,6 +482,39 @@ var _ = Describe("Session", func() {
 			Expect(err).To(MatchError("RST_STREAM received with code 42"))
 		})
 
+		It("acknowledges the RST_STREAM", func() {
+			_, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			err = session.handleRstStreamFrame(&frames.RstStreamFrame{
+				StreamID:   5,
+				ErrorCode:  42,
+				ByteOffset: 0x1000,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(conn.written[0]).To(ContainSubstring(string([]byte{0x01, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, byte(rstStreamAcknowledgement), 0, 0, 0})))
+		})
+
+		It("settles connection-level flow control with the final offset", func() {
+			_, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			err = session.handleRstStreamFrame(&frames.RstStreamFrame{
+				StreamID:   5,
+				ByteOffset: protocol.DefaultReceiveStreamFlowControlWindow,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			session.garbageCollectStreams()
+			str, _ := session.streamsMap.GetOrOpenStream(5)
+			Expect(str).To(BeNil())
+			updates := session.flowControlManager.GetWindowUpdates()
+			Expect(updates).To(HaveLen(1))
+			Expect(updates[0].StreamID).To(BeZero())
+		})
+
 		It("ignores the error when the stream is not known", func() {
 			err := session.handleFrames([]frames.Frame{&frames.RstStreamFrame{
 				StreamID:  5,
@@ -388,11 +590,6 @@ var _ = Describe("Session", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
-	It("errors on GOAWAY frames", func() {
-		err := session.handleFrames([]frames.Frame{&frames.GoawayFrame{}})
-		Expect(err).To(MatchError("unimplemented: handling GOAWAY frames"))
-	})
-
 	It("handles STOP_WAITING frames", func() {
 		err := session.handleFrames([]frames.Frame{&frames.StopWaitingFrame{LeastUnacked: 10}})
 		Expect(err).NotTo(HaveOccurred())
@@ -420,10 +617,19 @@ var _ = Describe("Session", func() {
 
 		It("shuts down without error", func() {
 			session.Close(nil)
-			Expect(closeCallbackCalled).To(BeTrue())
 			Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
+			Expect(closeCallbackCalled).To(BeTrue())
 			Expect(conn.written).To(HaveLen(1))
 			Expect(conn.written[0][len(conn.written[0])-7:]).To(Equal([]byte{0x02, byte(qerr.PeerGoingAway), 0, 0, 0, 0, 0}))
+			Expect(connectionClose).To(Equal(conn.written[0]))
+		})
+
+		It("doesn't pass a CONNECTION_CLOSE to the close callback for remote closes", func() {
+			session.closeImpl(qerr.Error(qerr.PeerGoingAway, "remote close"), true)
+			Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
+			Expect(closeCallbackCalled).To(BeTrue())
+			Expect(conn.written).To(BeEmpty())
+			Expect(connectionClose).To(BeNil())
 		})
 
 		It("only closes once", func() {
@@ -438,8 +644,8 @@ var _ = Describe("Session", func() {
 			s, err := session.GetOrOpenStream(5)
 			Expect(err).NotTo(HaveOccurred())
 			session.Close(testErr)
-			Expect(closeCallbackCalled).To(BeTrue())
 			Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
+			Expect(closeCallbackCalled).To(BeTrue())
 			n, err := s.Read([]byte{0})
 			Expect(n).To(BeZero())
 			Expect(err.Error()).To(ContainSubstring(testErr.Error()))
@@ -447,6 +653,126 @@ var _ = Describe("Session", func() {
 			Expect(n).To(BeZero())
 			Expect(err.Error()).To(ContainSubstring(testErr.Error()))
 		})
+
+		It("makes AcceptStream return the error", func() {
+			testErr := errors.New("test error")
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := session.AcceptStream()
+				Expect(err.Error()).To(ContainSubstring(testErr.Error()))
+				close(done)
+			}()
+			session.Close(testErr)
+			Eventually(done).Should(BeClosed())
+			Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
+		})
+	})
+
+	Context("handling GOAWAY frames", func() {
+		It("doesn't open new streams", func() {
+			err := session.handleFrames([]frames.Frame{&frames.GoawayFrame{ErrorCode: qerr.PeerGoingAway, LastGoodStream: 3}})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = session.OpenStream()
+			Expect(err).To(MatchError(errGoawayReceived))
+			_, err = session.OpenStreamSync()
+			Expect(err).To(MatchError(errGoawayReceived))
+		})
+
+		It("closes the streams that the peer didn't process", func() {
+			str2, err := session.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			str4, err := session.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			err = session.handleFrames([]frames.Frame{&frames.GoawayFrame{ErrorCode: qerr.PeerGoingAway, LastGoodStream: 2}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str2.(*stream).err).ToNot(HaveOccurred())
+			_, err = str4.Write([]byte("foobar"))
+			Expect(err).To(MatchError(errGoawayReceived))
+		})
+
+		It("doesn't close streams opened by the peer", func() {
+			str, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			err = session.handleFrames([]frames.Frame{&frames.GoawayFrame{ErrorCode: qerr.PeerGoingAway, LastGoodStream: 2}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.(*stream).err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("closing gracefully", func() {
+		var sph *mockSentPacketHandler
+
+		BeforeEach(func() {
+			sph = &mockSentPacketHandler{}
+			session.sentPacketHandler = sph
+			go session.run()
+		})
+
+		AfterEach(func() {
+			session.Close(nil)
+		})
+
+		It("sends a GOAWAY and closes the session when there are no open streams", func() {
+			err := session.CloseGracefully(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(func() uint32 { return atomic.LoadUint32(&session.closed) }).Should(Equal(uint32(1)))
+			Eventually(func() int { return len(conn.written) }).Should(Equal(2))
+			goaway := []byte{0x03, byte(qerr.PeerGoingAway), 0, 0, 0, 1, 0, 0, 0, 0, 0}
+			Expect(conn.written[0][len(conn.written[0])-len(goaway):]).To(Equal(goaway))
+			Expect(conn.written[1][len(conn.written[1])-7:]).To(Equal([]byte{0x02, byte(qerr.PeerGoingAway), 0, 0, 0, 0, 0}))
+		})
+
+		It("sends the error code and the last good stream in the GOAWAY", func() {
+			_, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			err = session.CloseGracefully(qerr.Error(qerr.InternalError, "foo"))
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(func() int { return len(conn.written) }).Should(Equal(1))
+			goaway := []byte{0x03, byte(qerr.InternalError), 0, 0, 0, 5, 0, 0, 0, 3, 0, 'f', 'o', 'o'}
+			Expect(conn.written[0][len(conn.written[0])-len(goaway):]).To(Equal(goaway))
+		})
+
+		It("waits for open streams to finish", func() {
+			s, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			str := s.(*stream)
+			err = session.CloseGracefully(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(func() uint32 { return atomic.LoadUint32(&session.closed) }).Should(BeZero())
+			atomic.StoreInt32(&str.eof, 1)
+			str.RegisterError(errors.New("test done"))
+			session.scheduleSending()
+			Eventually(func() uint32 { return atomic.LoadUint32(&session.closed) }).Should(Equal(uint32(1)))
+		})
+
+		It("waits until the peer acknowledged all data", func() {
+			sph.outstandingPackets = true
+			err := session.CloseGracefully(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(func() uint32 { return atomic.LoadUint32(&session.closed) }).Should(BeZero())
+			sph.outstandingPackets = false
+			session.scheduleSending()
+			Eventually(func() uint32 { return atomic.LoadUint32(&session.closed) }).Should(Equal(uint32(1)))
+		})
+
+		It("doesn't open or accept new streams", func() {
+			err := session.CloseGracefully(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(func() error { _, err := session.OpenStream(); return err }).Should(MatchError(errSessionGoingAway))
+			str, err := session.GetOrOpenStream(7)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str).To(BeNil())
+		})
+
+		It("only sends one GOAWAY", func() {
+			_, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			session.CloseGracefully(nil)
+			session.CloseGracefully(nil)
+			Eventually(func() int { return len(conn.written) }).Should(Equal(1))
+			Consistently(func() int { return len(conn.written) }).Should(Equal(1))
+		})
 	})
 
 	Context("receiving packets", func() {
@@ -493,6 +819,114 @@ var _ = Describe("Session", func() {
 			err = session.handlePacketImpl(&receivedPacket{publicHeader: hdr})
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		Context("migrating connections", func() {
+			var (
+				sph              *mockSentPacketHandler
+				origAddr         *net.UDPAddr
+				newAddr          *net.UDPAddr
+				migrationOldAddr net.Addr
+				migrationNewAddr net.Addr
+			)
+
+			BeforeEach(func() {
+				sph = &mockSentPacketHandler{}
+				session.sentPacketHandler = sph
+				origAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1000}
+				newAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 2000}
+				conn.remoteAddr = origAddr
+				migrationOldAddr = nil
+				migrationNewAddr = nil
+				session.config.ConnectionMigrationCallback = func(s *Session, oldAddr, newAddr net.Addr) {
+					Expect(s).To(BeIdenticalTo(session))
+					migrationOldAddr = oldAddr
+					migrationNewAddr = newAddr
+				}
+			})
+
+			It("migrates to a new remote address", func() {
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: newAddr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.remoteAddr).To(Equal(newAddr))
+				Expect(sph.migrated).To(BeTrue())
+				Expect(migrationOldAddr).To(Equal(origAddr))
+				Expect(migrationNewAddr).To(Equal(newAddr))
+			})
+
+			It("restarts path MTU discovery", func() {
+				session.mtuDiscoverer = newMTUDiscoverer(protocol.DefaultMaxPacketSize, protocol.MaxReceivePacketSize)
+				session.mtuDiscoverer.current = 1400
+				session.packer.SetMaxPacketSize(1400)
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: newAddr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.mtuDiscoverer.CurrentSize()).To(Equal(protocol.DefaultMaxPacketSize))
+				Expect(session.packer.maxPacketSize).To(Equal(protocol.DefaultMaxPacketSize))
+			})
+
+			It("doesn't migrate if the address didn't change", func() {
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1000}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.remoteAddr).To(BeIdenticalTo(origAddr))
+				Expect(sph.migrated).To(BeFalse())
+				Expect(migrationNewAddr).To(BeNil())
+			})
+
+			It("doesn't migrate if the packet can't be decrypted", func() {
+				session.unpacker = &mockUnpacker{unpackErr: errors.New("decryption failed")}
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: newAddr})
+				Expect(err).To(MatchError("decryption failed"))
+				Expect(conn.remoteAddr).To(Equal(origAddr))
+				Expect(sph.migrated).To(BeFalse())
+				Expect(migrationNewAddr).To(BeNil())
+			})
+
+			It("doesn't migrate for reordered packets", func() {
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: origAddr})
+				Expect(err).ToNot(HaveOccurred())
+				hdr.PacketNumber = 4
+				err = session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: newAddr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.remoteAddr).To(Equal(origAddr))
+				Expect(sph.migrated).To(BeFalse())
+			})
+
+			It("doesn't migrate for duplicate packets", func() {
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: origAddr})
+				Expect(err).ToNot(HaveOccurred())
+				err = session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: newAddr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.remoteAddr).To(Equal(origAddr))
+				Expect(sph.migrated).To(BeFalse())
+			})
+
+			It("migrates connections that don't use UDP addresses", func() {
+				conn.remoteAddr = mockAddr("foo")
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: mockAddr("foo")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sph.migrated).To(BeFalse())
+				hdr.PacketNumber = 6
+				err = session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: mockAddr("bar")})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.remoteAddr).To(Equal(mockAddr("bar")))
+				Expect(sph.migrated).To(BeTrue())
+			})
+
+			It("doesn't migrate as a client", func() {
+				session.perspective = protocol.PerspectiveClient
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: newAddr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.remoteAddr).To(Equal(origAddr))
+				Expect(sph.migrated).To(BeFalse())
+			})
+		})
 	})
 
 	Context("sending packets", func() {
@@ -508,7 +942,7 @@ var _ = Describe("Session", func() {
 		It("sends two WindowUpdate frames", func() {
 			_, err := session.GetOrOpenStream(5)
 			Expect(err).ToNot(HaveOccurred())
-			session.flowControlManager.AddBytesRead(5, protocol.ReceiveStreamFlowControlWindow)
+			session.flowControlManager.AddBytesRead(5, protocol.DefaultReceiveStreamFlowControlWindow)
 			err = session.sendPacket()
 			Expect(err).NotTo(HaveOccurred())
 			err = session.sendPacket()
@@ -520,12 +954,140 @@ var _ = Describe("Session", func() {
 			Expect(conn.written[1]).To(ContainSubstring(string([]byte{0x04, 0x05, 0, 0, 0})))
 		})
 
+		It("sends a RST_STREAM when a stream is reset", func() {
+			s, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			s.Reset(42)
+			err = session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(conn.written[0]).To(ContainSubstring(string([]byte{0x01, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 42, 0, 0, 0})))
+		})
+
+		It("cancels retransmissions for reset streams", func() {
+			sph := &mockSentPacketHandler{}
+			session.sentPacketHandler = sph
+			s, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			session.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+			s.Reset(42)
+			err = session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sph.cancelledStreams).To(Equal([]protocol.StreamID{5}))
+			Expect(session.streamFramer.HasFramesForRetransmission()).To(BeFalse())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(conn.written[0]).ToNot(ContainSubstring("foobar"))
+		})
+
+		It("writes packets in batches", func() {
+			session.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: bytes.Repeat([]byte{'f'}, 10*int(protocol.DefaultMaxPacketSize))})
+			err := session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(conn.written)).To(BeNumerically(">", 10))
+			Expect(conn.batches).To(Equal(1))
+			Expect(session.sendQueue).To(BeEmpty())
+		})
+
+		It("returns write errors", func() {
+			conn.writeErr = errors.New("write failed")
+			session.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+			err := session.sendPacket()
+			Expect(err).To(MatchError("write failed"))
+			Expect(session.sendQueue).To(BeEmpty())
+		})
+
 		It("sends public reset", func() {
 			err := session.sendPublicReset(1)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(conn.written).To(HaveLen(1))
 			Expect(conn.written[0]).To(ContainSubstring(string([]byte("PRST"))))
 		})
+
+		It("includes the nonce proof and the client address in public resets", func() {
+			session.cryptoSetup = &mockCryptoSetup{nonceProof: 0xdecafbad}
+			err := session.sendPublicReset(1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(conn.written[0]).To(Equal(writePublicReset(session.connectionID, 1, 0xdecafbad, conn.RemoteAddr())))
+		})
+	})
+
+	Context("receiving public resets", func() {
+		It("closes the session if the nonce proof matches", func() {
+			session.cryptoSetup = &mockCryptoSetup{nonceProof: 0xdecafbad}
+			session.handlePublicReset(&PublicReset{RejectedPacketNumber: 1, NonceProof: 0xdecafbad})
+			Expect(atomic.LoadUint32(&session.closed)).To(Equal(uint32(1)))
+		})
+
+		It("ignores public resets while the nonce proof is not known", func() {
+			session.cryptoSetup = &mockCryptoSetup{nonceProof: 0}
+			session.handlePublicReset(&PublicReset{RejectedPacketNumber: 1, NonceProof: 0})
+			Expect(atomic.LoadUint32(&session.closed)).To(BeZero())
+		})
+
+		It("ignores public resets with an invalid nonce proof", func() {
+			session.cryptoSetup = &mockCryptoSetup{nonceProof: 0xdecafbad}
+			session.handlePublicReset(&PublicReset{RejectedPacketNumber: 1, NonceProof: 0x1337})
+			Expect(atomic.LoadUint32(&session.closed)).To(BeZero())
+		})
+	})
+
+	Context("path MTU discovery", func() {
+		BeforeEach(func() {
+			session.mtuDiscoverer = newMTUDiscoverer(protocol.DefaultMaxPacketSize, protocol.MaxReceivePacketSize)
+		})
+
+		completeHandshake := func() {
+			*(*bool)(unsafe.Pointer(reflect.ValueOf(session.cryptoSetup).Elem().FieldByName("receivedForwardSecurePacket").UnsafeAddr())) = true
+			*(*crypto.AEAD)(unsafe.Pointer(reflect.ValueOf(session.cryptoSetup).Elem().FieldByName("forwardSecureAEAD").UnsafeAddr())) = &crypto.NullAEAD{}
+		}
+
+		It("doesn't send probes before the handshake completes", func() {
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.written).To(BeEmpty())
+		})
+
+		It("sends a probe", func() {
+			completeHandshake()
+			size := session.mtuDiscoverer.NextProbeSize()
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(conn.written[0]).To(HaveLen(int(size)))
+		})
+
+		It("doesn't pass probes to congestion control and retransmission", func() {
+			completeHandshake()
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(session.sentPacketHandler.BytesInFlight()).To(BeZero())
+			Expect(session.sentPacketHandler.HasOutstandingRetransmittablePackets()).To(BeFalse())
+		})
+
+		It("increases the packet size when the probe is acknowledged", func() {
+			completeHandshake()
+			size := session.mtuDiscoverer.NextProbeSize()
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			session.lastRcvdPacketNumber = 1
+			probeNumber := session.mtuDiscoverer.probeNumber
+			err = session.handleAckFrame(&frames.AckFrame{LargestAcked: probeNumber, LowestAcked: probeNumber})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.packer.maxPacketSize).To(Equal(size))
+		})
+
+		It("searches for a smaller size if sending a probe fails", func() {
+			completeHandshake()
+			conn.writeErr = errors.New("message too long")
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.mtuDiscoverer.ShouldSendProbe()).To(BeFalse())
+			Expect(session.mtuDiscoverer.CurrentSize()).To(Equal(protocol.DefaultMaxPacketSize))
+			Expect(session.packer.maxPacketSize).To(Equal(protocol.DefaultMaxPacketSize))
+			Expect(session.sentPacketHandler.BytesInFlight()).To(BeZero())
+		})
 	})
 
 	Context("retransmissions", func() {
@@ -589,7 +1151,7 @@ var _ = Describe("Session", func() {
 
 			f := &frames.StreamFrame{
 				StreamID: 0x5,
-				Data:     bytes.Repeat([]byte{'f'}, int(1.5*float32(protocol.MaxPacketSize))),
+				Data:     bytes.Repeat([]byte{'f'}, int(1.5*float32(protocol.DefaultMaxPacketSize))),
 			}
 			session.streamFramer.AddFrameForRetransmission(f)
 
@@ -607,6 +1169,71 @@ var _ = Describe("Session", func() {
 			Expect(ok).To(BeTrue())
 		})
 
+		It("doesn't send when the pacer doesn't allow it, and sets the timer", func() {
+			sph := newMockSentPacketHandler()
+			deadline := time.Now().Add(5 * time.Millisecond)
+			sph.(*mockSentPacketHandler).timeUntilSend = deadline
+			session.sentPacketHandler = sph
+			_, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			session.flowControlManager.AddBytesRead(5, protocol.DefaultReceiveStreamFlowControlWindow)
+			err = session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(BeEmpty())
+			Expect(session.pacingDeadline).To(Equal(deadline))
+			session.maybeResetTimer()
+			Expect(session.currentDeadline).To(Equal(deadline))
+		})
+
+		It("sends ACK-only packets when the pacer doesn't allow sending", func() {
+			sph := newMockSentPacketHandler()
+			sph.(*mockSentPacketHandler).timeUntilSend = time.Now().Add(5 * time.Millisecond)
+			session.sentPacketHandler = sph
+			_, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			session.flowControlManager.AddBytesRead(5, protocol.DefaultReceiveStreamFlowControlWindow)
+			session.receivedPacketHandler.ReceivedPacket(0x035E)
+			// make sure the packet number is higher than the LeastUnacked of the mock StopWaitingFrame
+			session.packer.packetNumberGenerator.next = 0x1337 + 9
+			err = session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(conn.written[0]).To(ContainSubstring(string([]byte{0x5E, 0x03})))
+			sentPackets := sph.(*mockSentPacketHandler).sentPackets
+			Expect(sentPackets).To(HaveLen(1))
+			Expect(sentPackets[0].Frames).To(HaveLen(2))
+			Expect(sentPackets[0].Frames[0]).To(BeAssignableToTypeOf(&frames.StopWaitingFrame{}))
+			Expect(sentPackets[0].Frames[1]).To(BeAssignableToTypeOf(&frames.AckFrame{}))
+		})
+
+		It("doesn't send ACK-only packets before the ACK delay when paced", func() {
+			sph := newMockSentPacketHandler()
+			sph.(*mockSentPacketHandler).timeUntilSend = time.Now().Add(5 * time.Millisecond)
+			session.sentPacketHandler = sph
+			session.receivedPacketHandler.ReceivedPacket(0x035E)
+			session.delayedAckOriginTime = time.Now()
+			err := session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(BeEmpty())
+		})
+
+		It("sends once the pacer allows it", func() {
+			sph := newMockSentPacketHandler()
+			sph.(*mockSentPacketHandler).timeUntilSend = time.Now().Add(5 * time.Millisecond)
+			session.sentPacketHandler = sph
+			_, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			session.flowControlManager.AddBytesRead(5, protocol.DefaultReceiveStreamFlowControlWindow)
+			err = session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(BeEmpty())
+			sph.(*mockSentPacketHandler).timeUntilSend = time.Time{}
+			err = session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).ToNot(BeEmpty())
+			Expect(session.pacingDeadline.IsZero()).To(BeTrue())
+		})
+
 		It("calls MaybeQueueRTOs even if congestion blocked, so that bytesInFlight is updated", func() {
 			sph := newMockSentPacketHandler()
 			sph.(*mockSentPacketHandler).congestionLimited = true
@@ -800,9 +1427,13 @@ var _ = Describe("Session", func() {
 		Expect(err).To(MatchError(ackhandler.ErrTooManyTrackedSentPackets))
 	})
 
-	It("stores up to MaxSessionUnprocessedPackets packets", func(done Done) {
+	It("sizes the queue of unprocessed packets from the max congestion window", func() {
+		Expect(session.receivedPackets).To(HaveCap(int(session.config.MaxCongestionWindow)))
+	})
+
+	It("stores up to MaxCongestionWindow unprocessed packets", func(done Done) {
 		// Nothing here should block
-		for i := protocol.PacketNumber(0); i < protocol.MaxSessionUnprocessedPackets+10; i++ {
+		for i := protocol.PacketNumber(0); i < session.config.MaxCongestionWindow+10; i++ {
 			session.handlePacket(&receivedPacket{})
 		}
 		close(done)
@@ -880,25 +1511,25 @@ var _ = Describe("Session", func() {
 
 	Context("window updates", func() {
 		It("gets stream level window updates", func() {
-			err := session.flowControlManager.AddBytesRead(1, protocol.ReceiveStreamFlowControlWindow)
+			err := session.flowControlManager.AddBytesRead(1, protocol.DefaultReceiveStreamFlowControlWindow)
 			Expect(err).NotTo(HaveOccurred())
 			frames, err := session.getWindowUpdateFrames()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(frames).To(HaveLen(1))
 			Expect(frames[0].StreamID).To(Equal(protocol.StreamID(1)))
-			Expect(frames[0].ByteOffset).To(Equal(protocol.ReceiveStreamFlowControlWindow * 2))
+			Expect(frames[0].ByteOffset).To(Equal(protocol.DefaultReceiveStreamFlowControlWindow * 2))
 		})
 
 		It("gets connection level window updates", func() {
 			_, err := session.GetOrOpenStream(5)
 			Expect(err).NotTo(HaveOccurred())
-			err = session.flowControlManager.AddBytesRead(5, protocol.ReceiveConnectionFlowControlWindow)
+			err = session.flowControlManager.AddBytesRead(5, protocol.DefaultReceiveConnectionFlowControlWindow)
 			Expect(err).NotTo(HaveOccurred())
 			frames, err := session.getWindowUpdateFrames()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(frames).To(HaveLen(1))
 			Expect(frames[0].StreamID).To(Equal(protocol.StreamID(0)))
-			Expect(frames[0].ByteOffset).To(Equal(protocol.ReceiveConnectionFlowControlWindow * 2))
+			Expect(frames[0].ByteOffset).To(Equal(protocol.DefaultReceiveConnectionFlowControlWindow * 2))
 		})
 	})
 })
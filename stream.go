@@ -1,10 +1,13 @@
 package quic
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/flowcontrol"
 	"github.com/lucas-clemente/quic-go/frames"
@@ -13,6 +16,23 @@ import (
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
+// error codes for RST_STREAM frames, as defined by QuicRstStreamErrorCode in Chromium
+const (
+	rstStreamErrorProcessingStream uint32 = 1
+	rstStreamAcknowledgement       uint32 = 7
+)
+
+var errStreamReset = errors.New("stream was reset")
+
+// deadlineError is returned by Read and Write when the deadline set by SetReadDeadline or SetWriteDeadline has passed
+type deadlineError struct{}
+
+func (deadlineError) Error() string   { return "deadline exceeded" }
+func (deadlineError) Temporary() bool { return true }
+func (deadlineError) Timeout() bool   { return true }
+
+var errDeadline net.Error = &deadlineError{}
+
 // A Stream assembles the data from StreamFrames and provides a super-convenient Read-Interface
 //
 // Read() and Write() may be called concurrently, but multiple calls to Read() or Write() individually must be synchronized manually.
@@ -40,6 +60,19 @@ type stream struct {
 	finSent              bool
 	doneWritingOrErrCond sync.Cond
 
+	// the deadline timers wake up Read and Write, so that they can check the deadline
+	readDeadline       time.Time
+	readDeadlineTimer  *time.Timer
+	writeDeadline      time.Time
+	writeDeadlineTimer *time.Timer
+
+	// resetLocally is set if we reset the stream, rstErrorCode is the error code sent in the RST_STREAM
+	resetLocally bool
+	rstErrorCode uint32
+	rstSent      bool
+	// finalOffsetKnown is set once the peer sent a FIN or a RST_STREAM, i.e. we know how much data the peer sent
+	finalOffsetKnown bool
+
 	flowControlManager flowcontrol.FlowControlManager
 }
 
@@ -76,14 +109,19 @@ func (s *stream) Read(p []byte) (int, error) {
 
 		var err error
 		for {
+			// return the data that was already received before returning an error
+			if frame != nil {
+				s.readPosInFrame = int(s.readOffset - frame.Offset)
+				break
+			}
 			// Stop waiting on errors
 			if s.err != nil {
 				err = s.err
 				break
 			}
-			if frame != nil {
-				s.readPosInFrame = int(s.readOffset - frame.Offset)
-				break
+			if deadlinePassed(s.readDeadline) {
+				s.mutex.Unlock()
+				return bytesRead, errDeadline
 			}
 			s.newFrameOrErrCond.Wait()
 			frame = s.frameQueue.Head()
@@ -147,6 +185,12 @@ func (s *stream) Write(p []byte) (int, error) {
 	s.onData()
 
 	for s.dataForWriting != nil && s.err == nil {
+		if deadlinePassed(s.writeDeadline) {
+			// the data that was already handed to the stream framer can't be taken back
+			n := len(p) - len(s.dataForWriting)
+			s.dataForWriting = nil
+			return n, errDeadline
+		}
 		s.doneWritingOrErrCond.Wait()
 	}
 
@@ -157,6 +201,55 @@ func (s *stream) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// SetReadDeadline sets the deadline for pending and future Read calls.
+// A zero value for t means Read will not time out.
+func (s *stream) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readDeadline = t
+	s.readDeadlineTimer = s.resetDeadlineTimer(s.readDeadlineTimer, t, &s.newFrameOrErrCond)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for pending and future Write calls.
+// A zero value for t means Write will not time out.
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writeDeadline = t
+	s.writeDeadlineTimer = s.resetDeadlineTimer(s.writeDeadlineTimer, t, &s.doneWritingOrErrCond)
+	return nil
+}
+
+// SetDeadline sets the read and write deadlines
+func (s *stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	s.SetWriteDeadline(t)
+	return nil
+}
+
+// resetDeadlineTimer stops the old timer, and returns a timer that wakes up the waiting goroutine when the deadline passes
+// It also wakes up the waiting goroutine immediately, so that it can pick up the new deadline.
+// The caller must hold the mutex.
+func (s *stream) resetDeadlineTimer(timer *time.Timer, t time.Time, cond *sync.Cond) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	cond.Signal()
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(t.Sub(time.Now()), func() {
+		s.mutex.Lock()
+		cond.Signal()
+		s.mutex.Unlock()
+	})
+}
+
+func deadlinePassed(t time.Time) bool {
+	return !t.IsZero() && !time.Now().Before(t)
+}
+
 func (s *stream) lenOfDataForWriting() protocol.ByteCount {
 	s.mutex.Lock()
 	l := protocol.ByteCount(len(s.dataForWriting))
@@ -221,6 +314,9 @@ func (s *stream) AddStreamFrame(frame *frames.StreamFrame) error {
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	if frame.FinBit {
+		s.finalOffsetKnown = true
+	}
 	err = s.frameQueue.Push(frame)
 	if err != nil && err != errDuplicateStreamData {
 		return err
@@ -248,6 +344,74 @@ func (s *stream) RegisterError(err error) {
 	s.newFrameOrErrCond.Signal()
 }
 
+// Reset aborts the stream in both directions. All pending and future calls to Read and Write return an error.
+// The peer is informed by a RST_STREAM frame containing the error code.
+func (s *stream) Reset(errorCode uint32) {
+	s.reset(errorCode, errStreamReset)
+}
+
+func (s *stream) reset(errorCode uint32, err error) {
+	atomic.StoreInt32(&s.closed, 1)
+	s.mutex.Lock()
+	if s.resetLocally {
+		s.mutex.Unlock()
+		return
+	}
+	s.resetLocally = true
+	s.rstErrorCode = errorCode
+	s.dataForWriting = nil
+	if s.err == nil { // s.err must not be changed!
+		s.err = err
+	}
+	s.doneWritingOrErrCond.Signal()
+	s.newFrameOrErrCond.Signal()
+	s.mutex.Unlock()
+	s.onData()
+}
+
+// RegisterRemoteReset is called when a RST_STREAM frame is received for this stream
+// It updates flow control with the final offset, and makes the stream send a RST_STREAM in response, unless we already finished writing.
+func (s *stream) RegisterRemoteReset(frame *frames.RstStreamFrame) error {
+	err := s.flowControlManager.UpdateHighestReceived(s.streamID, frame.ByteOffset)
+	if err == flowcontrol.ErrStreamFlowControlViolation || err == flowcontrol.ErrConnectionFlowControlViolation {
+		return qerr.FlowControlReceivedTooMuchData
+	}
+	if err != nil {
+		return err
+	}
+
+	s.RegisterError(fmt.Errorf("RST_STREAM received with code %d", frame.ErrorCode))
+
+	s.mutex.Lock()
+	s.finalOffsetKnown = true
+	sendRst := !s.finSent && !s.resetLocally
+	if sendRst {
+		s.resetLocally = true
+		s.rstErrorCode = rstStreamAcknowledgement
+	}
+	s.mutex.Unlock()
+	if sendRst {
+		s.onData()
+	}
+	return nil
+}
+
+// getRstStreamFrame returns the RST_STREAM frame, if the stream was reset and the RST_STREAM wasn't sent yet
+// The ByteOffset is the final offset of the data we sent.
+func (s *stream) getRstStreamFrame() *frames.RstStreamFrame {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.resetLocally || s.rstSent {
+		return nil
+	}
+	s.rstSent = true
+	return &frames.RstStreamFrame{
+		StreamID:   s.streamID,
+		ErrorCode:  s.rstErrorCode,
+		ByteOffset: s.writeOffset,
+	}
+}
+
 func (s *stream) finishedReading() bool {
 	return atomic.LoadInt32(&s.eof) != 0
 }
@@ -259,6 +423,14 @@ func (s *stream) finishedWriting() bool {
 }
 
 func (s *stream) finished() bool {
+	s.mutex.Lock()
+	resetLocally := s.resetLocally
+	resetDone := s.rstSent && s.finalOffsetKnown
+	s.mutex.Unlock()
+	if resetLocally {
+		// keep the stream until we know the peer's final offset, so that connection-level flow control can be settled
+		return resetDone
+	}
 	return s.finishedReading() && s.finishedWriting()
 }
 
@@ -27,6 +27,17 @@ func (f *streamFramer) AddFrameForRetransmission(frame *frames.StreamFrame) {
 	f.retransmissionQueue = append(f.retransmissionQueue, frame)
 }
 
+// CancelRetransmissionsForStream removes all queued retransmissions for a stream
+func (f *streamFramer) CancelRetransmissionsForStream(id protocol.StreamID) {
+	queue := f.retransmissionQueue[:0]
+	for _, frame := range f.retransmissionQueue {
+		if frame.StreamID != id {
+			queue = append(queue, frame)
+		}
+	}
+	f.retransmissionQueue = queue
+}
+
 func (f *streamFramer) PopStreamFrames(maxLen protocol.ByteCount) []*frames.StreamFrame {
 	fs, currentLen := f.maybePopFramesForRetransmission(maxLen)
 	return append(fs, f.maybePopNormalFrames(maxLen-currentLen)...)
@@ -31,7 +31,7 @@ var _ = Describe("Stream Framer", func() {
 		stream1 = &stream{streamID: 10}
 		stream2 = &stream{streamID: 11}
 
-		streamsMap = newStreamsMap(nil)
+		streamsMap = newStreamsMap(nil, protocol.PerspectiveServer, newDefaultConnectionParametersManager())
 		streamsMap.putStream(stream1)
 		streamsMap.putStream(stream2)
 
@@ -49,6 +49,14 @@ var _ = Describe("Stream Framer", func() {
 		Expect(framer.HasFramesForRetransmission()).To(BeTrue())
 	})
 
+	It("cancels retransmissions for a stream", func() {
+		framer.AddFrameForRetransmission(retransmittedFrame1)
+		framer.AddFrameForRetransmission(retransmittedFrame2)
+		framer.CancelRetransmissionsForStream(retransmittedFrame1.StreamID)
+		fs := framer.PopStreamFrames(protocol.MaxByteCount)
+		Expect(fs).To(Equal([]*frames.StreamFrame{retransmittedFrame2}))
+	})
+
 	It("sets the DataLenPresent for dequeued retransmitted frames", func() {
 		framer.AddFrameForRetransmission(retransmittedFrame1)
 		fs := framer.PopStreamFrames(protocol.MaxByteCount)
@@ -3,12 +3,13 @@ package quic
 import (
 	"errors"
 	"io"
+	"net"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/flowcontrol"
 	"github.com/lucas-clemente/quic-go/frames"
-	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -109,7 +110,7 @@ var _ = Describe("Stream", func() {
 	BeforeEach(func() {
 		onDataCalled = false
 		var streamID protocol.StreamID = 1337
-		cpm := handshake.NewConnectionParamatersManager()
+		cpm := newDefaultConnectionParametersManager()
 		flowControlManager := flowcontrol.NewFlowControlManager(cpm)
 		flowControlManager.NewStream(streamID, true)
 		str, _ = newStream(streamID, onData, flowControlManager)
@@ -530,6 +531,21 @@ var _ = Describe("Stream", func() {
 				Expect(n).To(BeZero())
 				Expect(err).To(MatchError(testErr))
 			})
+
+			It("returns all data that was received before the error", func() {
+				err := str.AddStreamFrame(&frames.StreamFrame{Offset: 0, Data: []byte{0xDE, 0xAD}})
+				Expect(err).ToNot(HaveOccurred())
+				err = str.AddStreamFrame(&frames.StreamFrame{Offset: 2, Data: []byte{0xBE, 0xEF}})
+				Expect(err).ToNot(HaveOccurred())
+				str.RegisterError(testErr)
+				b := make([]byte, 3)
+				n, err := str.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b[:n]).To(Equal([]byte{0xDE, 0xAD, 0xBE}))
+				n, err = str.Read(b)
+				Expect(b[:n]).To(Equal([]byte{0xEF}))
+				Expect(err).To(MatchError(testErr))
+			})
 		})
 
 		Context("when CloseRemote is called", func() {
@@ -542,4 +558,196 @@ var _ = Describe("Stream", func() {
 			})
 		})
 	})
+
+	Context("deadlines", func() {
+		It("returns an error when Read is called after the deadline", func() {
+			str.SetReadDeadline(time.Now().Add(-time.Second))
+			b := make([]byte, 6)
+			n, err := str.Read(b)
+			Expect(err).To(MatchError(errDeadline))
+			Expect(err.(net.Error).Timeout()).To(BeTrue())
+			Expect(n).To(BeZero())
+		})
+
+		It("unblocks Read when the deadline is reached", func() {
+			deadline := time.Now().Add(20 * time.Millisecond)
+			str.SetReadDeadline(deadline)
+			b := make([]byte, 6)
+			n, err := str.Read(b)
+			Expect(err).To(MatchError(errDeadline))
+			Expect(n).To(BeZero())
+			Expect(time.Now()).To(BeTemporally("~", deadline, 20*time.Millisecond))
+		})
+
+		It("unblocks Read when the deadline is moved into the past", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.Read(make([]byte, 6))
+				Expect(err).To(MatchError(errDeadline))
+				close(done)
+			}()
+			Consistently(done).ShouldNot(BeClosed())
+			str.SetReadDeadline(time.Now().Add(-time.Second))
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("reads data after the deadline was removed", func() {
+			str.SetReadDeadline(time.Now().Add(-time.Second))
+			_, err := str.Read(make([]byte, 6))
+			Expect(err).To(MatchError(errDeadline))
+			str.SetReadDeadline(time.Time{})
+			err = str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+			Expect(err).ToNot(HaveOccurred())
+			b := make([]byte, 6)
+			n, err := str.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			Expect(b).To(Equal([]byte("foobar")))
+		})
+
+		It("unblocks Write when the deadline is reached, and returns the number of bytes already sent", func() {
+			deadline := time.Now().Add(20 * time.Millisecond)
+			str.SetWriteDeadline(deadline)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.Write([]byte("foobar"))
+				Expect(err).To(MatchError(errDeadline))
+				Expect(err.(net.Error).Timeout()).To(BeTrue())
+				Expect(n).To(Equal(2))
+				Expect(time.Now()).To(BeTemporally("~", deadline, 20*time.Millisecond))
+				close(done)
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
+			Expect(str.getDataForWriting(2)).To(Equal([]byte("fo")))
+			Eventually(done).Should(BeClosed())
+			Expect(str.getDataForWriting(1000)).To(BeNil())
+		})
+
+		It("sets both deadlines with SetDeadline", func() {
+			str.SetDeadline(time.Now().Add(-time.Second))
+			_, err := str.Read(make([]byte, 6))
+			Expect(err).To(MatchError(errDeadline))
+			n, err := str.Write([]byte("foobar"))
+			Expect(err).To(MatchError(errDeadline))
+			Expect(n).To(BeZero())
+		})
+	})
+
+	Context("resetting", func() {
+		It("makes Read and Write return an error", func() {
+			str.Reset(42)
+			_, err := str.Read(make([]byte, 4))
+			Expect(err).To(MatchError(errStreamReset))
+			_, err = str.Write([]byte("foobar"))
+			Expect(err).To(MatchError(errStreamReset))
+			Expect(onDataCalled).To(BeTrue())
+		})
+
+		It("unblocks a pending Write", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.Write([]byte("foobar"))
+				Expect(err).To(MatchError(errStreamReset))
+				close(done)
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
+			str.Reset(42)
+			Eventually(done).Should(BeClosed())
+			Expect(str.getDataForWriting(1000)).To(BeNil())
+		})
+
+		It("sends a RST_STREAM with the final offset once", func() {
+			go str.Write([]byte("foobar"))
+			Eventually(func() []byte { return str.getDataForWriting(4) }).Should(Equal([]byte("foob")))
+			str.Reset(42)
+			Expect(str.getRstStreamFrame()).To(Equal(&frames.RstStreamFrame{
+				StreamID:   1337,
+				ErrorCode:  42,
+				ByteOffset: 4,
+			}))
+			Expect(str.getRstStreamFrame()).To(BeNil())
+		})
+
+		It("doesn't send a RST_STREAM if it wasn't reset", func() {
+			Expect(str.getRstStreamFrame()).To(BeNil())
+		})
+
+		It("doesn't send a FIN after a reset", func() {
+			str.Close()
+			str.Reset(42)
+			Expect(str.shouldSendFin()).To(BeFalse())
+		})
+
+		It("keeps the first error code", func() {
+			str.Reset(42)
+			str.Reset(1337)
+			Expect(str.getRstStreamFrame().ErrorCode).To(Equal(uint32(42)))
+		})
+
+		It("is finished once the RST_STREAM was sent and the peer's final offset is known", func() {
+			str.Reset(42)
+			Expect(str.finished()).To(BeFalse())
+			str.getRstStreamFrame()
+			Expect(str.finished()).To(BeFalse())
+			err := str.AddStreamFrame(&frames.StreamFrame{Offset: 0, Data: []byte("foo"), FinBit: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.finished()).To(BeTrue())
+		})
+
+		Context("by the peer", func() {
+			It("returns the error", func() {
+				err := str.RegisterRemoteReset(&frames.RstStreamFrame{StreamID: 1337, ErrorCode: 42, ByteOffset: 10})
+				Expect(err).ToNot(HaveOccurred())
+				_, err = str.Read(make([]byte, 4))
+				Expect(err).To(MatchError("RST_STREAM received with code 42"))
+			})
+
+			It("updates flow control with the final offset", func() {
+				str.flowControlManager = &mockFlowControlHandler{}
+				err := str.RegisterRemoteReset(&frames.RstStreamFrame{StreamID: 1337, ByteOffset: 0x1000})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.flowControlManager.(*mockFlowControlHandler).highestReceivedForStream).To(Equal(str.streamID))
+				Expect(str.flowControlManager.(*mockFlowControlHandler).highestReceived).To(Equal(protocol.ByteCount(0x1000)))
+			})
+
+			It("errors if the final offset violates flow control", func() {
+				err := str.RegisterRemoteReset(&frames.RstStreamFrame{StreamID: 1337, ByteOffset: protocol.DefaultReceiveStreamFlowControlWindow + 1})
+				Expect(err).To(MatchError(qerr.FlowControlReceivedTooMuchData))
+			})
+
+			It("acknowledges the reset with a RST_STREAM", func() {
+				go str.Write([]byte("foobar"))
+				Eventually(func() []byte { return str.getDataForWriting(6) }).Should(Equal([]byte("foobar")))
+				err := str.RegisterRemoteReset(&frames.RstStreamFrame{StreamID: 1337, ByteOffset: 10})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(onDataCalled).To(BeTrue())
+				Expect(str.getRstStreamFrame()).To(Equal(&frames.RstStreamFrame{
+					StreamID:   1337,
+					ErrorCode:  rstStreamAcknowledgement,
+					ByteOffset: 6,
+				}))
+				Expect(str.finished()).To(BeTrue())
+			})
+
+			It("doesn't acknowledge the reset if we already sent a FIN", func() {
+				str.Close()
+				str.sentFin()
+				err := str.RegisterRemoteReset(&frames.RstStreamFrame{StreamID: 1337, ByteOffset: 10})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.getRstStreamFrame()).To(BeNil())
+			})
+
+			It("doesn't send a second RST_STREAM if we already reset the stream", func() {
+				str.Reset(42)
+				Expect(str.getRstStreamFrame()).ToNot(BeNil())
+				err := str.RegisterRemoteReset(&frames.RstStreamFrame{StreamID: 1337, ByteOffset: 10})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.getRstStreamFrame()).To(BeNil())
+				Expect(str.finished()).To(BeTrue())
+			})
+		})
+	})
 })
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
@@ -13,14 +14,37 @@ import (
 type streamsMap struct {
 	mutex sync.RWMutex
 
+	perspective          protocol.Perspective
+	connectionParameters *handshake.ConnectionParametersManager
+
 	streams     map[protocol.StreamID]*stream
 	openStreams []protocol.StreamID
-
-	highestStreamOpenedByClient          protocol.StreamID
+	// acceptQueue holds the streams opened by the peer that haven't been returned by AcceptStream() yet
+	acceptQueue   []*stream
+	newStreamCond sync.Cond
+	// streamCallback, if set, is called for every stream opened by the peer, instead of queueing it for AcceptStream()
+	// It is called with the mutex held.
+	streamCallback func(*stream)
+	// openStreamOrErrCond is signaled when a stream opened by us is removed, or when the map is closed
+	openStreamOrErrCond sync.Cond
+	closeErr            error
+	// openStreamsErr is returned by OpenStream once we may not open any new streams, e.g. after a GOAWAY
+	openStreamsErr error
+	// rejectNewStreams is set once we sent a GOAWAY, all new streams opened by the peer are ignored
+	rejectNewStreams bool
+
+	nextStream                           protocol.StreamID // StreamID of the next Stream that will be returned by OpenStream()
+	highestStreamOpenedByPeer            protocol.StreamID
 	streamsOpenedAfterLastGarbageCollect int
+	// maxNewStreamIDDelta is the maximum difference between a newly opened stream and the highest StreamID that the peer has ever opened
+	// note that the number of streams is half this value, since the peer can only open streams with odd (or even) StreamIDs
+	maxNewStreamIDDelta protocol.StreamID
+
+	newStream newStreamLambda
 
-	newStream     newStreamLambda
-	maxNumStreams int
+	numOutgoingStreams uint32
+	numIncomingStreams uint32
+	maxIncomingStreams uint32
 
 	roundRobinIndex int
 }
@@ -32,19 +56,34 @@ var (
 	errMapAccess = errors.New("streamsMap: Error accessing the streams map")
 )
 
-func newStreamsMap(newStream newStreamLambda) *streamsMap {
-	maxNumStreams := utils.Max(int(float32(protocol.MaxIncomingDynamicStreams)*protocol.MaxStreamsMultiplier), int(protocol.MaxIncomingDynamicStreams))
-
-	return &streamsMap{
-		streams:       map[protocol.StreamID]*stream{},
-		openStreams:   make([]protocol.StreamID, 0, maxNumStreams),
-		newStream:     newStream,
-		maxNumStreams: maxNumStreams,
+func newStreamsMap(newStream newStreamLambda, pers protocol.Perspective, connectionParameters *handshake.ConnectionParametersManager) *streamsMap {
+	maxIncomingDynamicStreams := connectionParameters.GetMaxIncomingStreams()
+	maxIncomingStreams := utils.MaxUint32(uint32(float32(maxIncomingDynamicStreams)*protocol.MaxStreamsMultiplier), maxIncomingDynamicStreams)
+
+	sm := streamsMap{
+		perspective:          pers,
+		connectionParameters: connectionParameters,
+		streams:              map[protocol.StreamID]*stream{},
+		openStreams:          make([]protocol.StreamID, 0, maxIncomingStreams),
+		newStream:            newStream,
+		maxIncomingStreams:   maxIncomingStreams,
+		maxNewStreamIDDelta:  4 * protocol.StreamID(maxIncomingDynamicStreams),
+	}
+	sm.newStreamCond.L = &sm.mutex
+	sm.openStreamOrErrCond.L = &sm.mutex
+
+	// the client opens streams with odd, the server with even StreamIDs
+	if pers == protocol.PerspectiveClient {
+		sm.nextStream = 1
+	} else {
+		sm.nextStream = 2
 	}
+
+	return &sm
 }
 
 // GetOrOpenStream either returns an existing stream, a newly opened stream, or nil if a stream with the provided ID is already closed.
-// Newly opened streams should only originate from the client. To open a stream from the server, OpenStream should be used.
+// Newly opened streams should only originate from the peer. To open a stream ourselves, OpenStream should be used.
 func (m *streamsMap) GetOrOpenStream(id protocol.StreamID) (*stream, error) {
 	m.mutex.RLock()
 	s, ok := m.streams[id]
@@ -61,14 +100,29 @@ func (m *streamsMap) GetOrOpenStream(id protocol.StreamID) (*stream, error) {
 	if ok {
 		return s, nil
 	}
-	if len(m.openStreams) == m.maxNumStreams {
-		return nil, qerr.TooManyOpenStreams
-	}
-	if id%2 == 0 {
+	if m.perspective == protocol.PerspectiveServer && id%2 == 0 {
+		if id < m.nextStream {
+			// a stream that we opened ourselves, and that was already garbage collected
+			return nil, nil
+		}
 		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("attempted to open stream %d from client-side", id))
 	}
-	if id+protocol.MaxNewStreamIDDelta < m.highestStreamOpenedByClient {
-		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("attempted to open stream %d, which is a lot smaller than the highest opened stream, %d", id, m.highestStreamOpenedByClient))
+	if m.perspective == protocol.PerspectiveClient && id%2 == 1 {
+		if id < m.nextStream {
+			// a stream that we opened ourselves, and that was already garbage collected
+			return nil, nil
+		}
+		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("attempted to open stream %d from server-side", id))
+	}
+	if id+m.maxNewStreamIDDelta < m.highestStreamOpenedByPeer {
+		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("attempted to open stream %d, which is a lot smaller than the highest opened stream, %d", id, m.highestStreamOpenedByPeer))
+	}
+	if m.rejectNewStreams {
+		// we sent a GOAWAY, the peer knows that we won't process this stream
+		return nil, nil
+	}
+	if m.numIncomingStreams >= m.maxIncomingStreams {
+		return nil, qerr.TooManyOpenStreams
 	}
 
 	s, err := m.newStream(id)
@@ -76,22 +130,127 @@ func (m *streamsMap) GetOrOpenStream(id protocol.StreamID) (*stream, error) {
 		return nil, err
 	}
 
-	if id > m.highestStreamOpenedByClient {
-		m.highestStreamOpenedByClient = id
+	if id > m.highestStreamOpenedByPeer {
+		m.highestStreamOpenedByPeer = id
 	}
 
 	m.streamsOpenedAfterLastGarbageCollect++
-	if m.streamsOpenedAfterLastGarbageCollect%protocol.MaxNewStreamIDDelta == 0 {
+	if m.streamsOpenedAfterLastGarbageCollect%int(m.maxNewStreamIDDelta) == 0 {
 		m.garbageCollectClosedStreams()
 	}
 
+	m.putStream(s)
+	if m.streamCallback != nil {
+		m.streamCallback(s)
+		return s, nil
+	}
+	// the crypto stream is handled by the session itself, and never returned by AcceptStream()
+	if id != 1 {
+		m.acceptQueue = append(m.acceptQueue, s)
+		m.newStreamCond.Signal()
+	}
+	return s, nil
+}
+
+// AcceptStream returns the next stream opened by the peer
+// it blocks until a new stream is opened, or the streams map is closed
+func (m *streamsMap) AcceptStream() (*stream, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for {
+		if m.closeErr != nil {
+			return nil, m.closeErr
+		}
+		if len(m.acceptQueue) > 0 {
+			s := m.acceptQueue[0]
+			m.acceptQueue[0] = nil
+			m.acceptQueue = m.acceptQueue[1:]
+			return s, nil
+		}
+		m.newStreamCond.Wait()
+	}
+}
+
+// CloseWithError makes all pending and future calls to AcceptStream return the error
+func (m *streamsMap) CloseWithError(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.closeErr = err
+	m.acceptQueue = nil
+	m.newStreamCond.Broadcast()
+	m.openStreamOrErrCond.Broadcast()
+}
+
+// OpenStream opens the next available stream
+// It returns qerr.TooManyOpenStreams if the peer doesn't allow us to open any more streams.
+func (m *streamsMap) OpenStream() (*stream, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closeErr != nil {
+		return nil, m.closeErr
+	}
+	if m.openStreamsErr != nil {
+		return nil, m.openStreamsErr
+	}
+	return m.openStreamImpl()
+}
+
+// OpenStreamSync opens the next available stream
+// If the peer doesn't allow us to open any more streams, it blocks until one of our streams is closed, or the streams map is closed.
+func (m *streamsMap) OpenStreamSync() (*stream, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for {
+		if m.closeErr != nil {
+			return nil, m.closeErr
+		}
+		if m.openStreamsErr != nil {
+			return nil, m.openStreamsErr
+		}
+		s, err := m.openStreamImpl()
+		if err != qerr.TooManyOpenStreams {
+			return s, err
+		}
+		m.openStreamOrErrCond.Wait()
+	}
+}
+
+// openStreamImpl must be called with the mutex held
+func (m *streamsMap) openStreamImpl() (*stream, error) {
+	if m.numOutgoingStreams >= m.connectionParameters.GetMaxOutgoingStreams() {
+		return nil, qerr.TooManyOpenStreams
+	}
+
+	id := m.nextStream
+	s, err := m.newStream(id)
+	if err != nil {
+		return nil, err
+	}
+	m.nextStream += 2
+
 	m.putStream(s)
 	return s, nil
 }
 
-// OpenStream opens a stream from the server's side
-func (m *streamsMap) OpenStream(id protocol.StreamID) (*stream, error) {
-	panic("OpenStream: not implemented")
+// StopOpeningStreams makes all pending and future calls to OpenStream and OpenStreamSync return the error
+func (m *streamsMap) StopOpeningStreams(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.openStreamsErr == nil {
+		m.openStreamsErr = err
+	}
+	m.openStreamOrErrCond.Broadcast()
+}
+
+// RejectNewStreams makes the streams map ignore all streams opened by the peer from now on
+// It returns the highest StreamID opened by the peer, which should be sent in the GOAWAY frame.
+func (m *streamsMap) RejectNewStreams() protocol.StreamID {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rejectNewStreams = true
+	return m.highestStreamOpenedByPeer
 }
 
 func (m *streamsMap) Iterate(fn streamLambda) error {
@@ -168,6 +327,11 @@ func (m *streamsMap) putStream(s *stream) error {
 
 	m.streams[id] = s
 	m.openStreams = append(m.openStreams, id)
+	if m.isOpenedByPeer(id) {
+		m.numIncomingStreams++
+	} else {
+		m.numOutgoingStreams++
+	}
 
 	return nil
 }
@@ -179,7 +343,17 @@ func (m *streamsMap) RemoveStream(id protocol.StreamID) error {
 		return fmt.Errorf("attempted to remove non-existing stream: %d", id)
 	}
 
-	m.streams[id] = nil
+	if m.isOpenedByPeer(id) {
+		// keep a nil value, so that retransmitted frames for this stream don't open it again
+		// It is deleted by the garbage collection once the peer opened enough new streams.
+		m.streams[id] = nil
+		m.numIncomingStreams--
+	} else {
+		// streams that we opened can be deleted right away, since GetOrOpenStream never reopens StreamIDs below nextStream
+		delete(m.streams, id)
+		m.numOutgoingStreams--
+		m.openStreamOrErrCond.Signal()
+	}
 
 	for i, s := range m.openStreams {
 		if s == id {
@@ -196,6 +370,14 @@ func (m *streamsMap) RemoveStream(id protocol.StreamID) error {
 	return nil
 }
 
+func (m *streamsMap) isOpenedByPeer(id protocol.StreamID) bool {
+	// the client opens streams with odd, the server with even StreamIDs
+	if m.perspective == protocol.PerspectiveServer {
+		return id%2 == 1
+	}
+	return id%2 == 0
+}
+
 // NumberOfStreams gets the number of open streams
 func (m *streamsMap) NumberOfStreams() int {
 	m.mutex.RLock()
@@ -204,14 +386,27 @@ func (m *streamsMap) NumberOfStreams() int {
 	return n
 }
 
-// garbageCollectClosedStreams deletes nil values in the streams if they are smaller than protocol.MaxNewStreamIDDelta than the highest stream opened by the client
+// NumberOfDataStreams gets the number of open streams, not counting the crypto- and the header-stream (StreamIDs 1 and 3)
+func (m *streamsMap) NumberOfDataStreams() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var n int
+	for _, id := range m.openStreams {
+		if id != 1 && id != 3 {
+			n++
+		}
+	}
+	return n
+}
+
+// garbageCollectClosedStreams deletes nil values in the streams if they are smaller than maxNewStreamIDDelta than the highest stream opened by the peer
 // note that this garbage collection is relatively expensive, since it iterates over the whole streams map. It should not be called every time a stream is openend or closed
 func (m *streamsMap) garbageCollectClosedStreams() {
 	for id, str := range m.streams {
 		if str != nil {
 			continue
 		}
-		if id+protocol.MaxNewStreamIDDelta <= m.highestStreamOpenedByClient {
+		if id+m.maxNewStreamIDDelta <= m.highestStreamOpenedByPeer {
 			delete(m.streams, id)
 		}
 	}
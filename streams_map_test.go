@@ -2,20 +2,35 @@ package quic
 
 import (
 	"errors"
+	"time"
 
+	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+func newDefaultConnectionParametersManager() *handshake.ConnectionParametersManager {
+	return handshake.NewConnectionParamatersManager(
+		protocol.DefaultReceiveStreamFlowControlWindow,
+		protocol.DefaultReceiveConnectionFlowControlWindow,
+		protocol.DefaultIdleTimeout,
+		protocol.DefaultMaxIdleTimeout,
+		protocol.DefaultMaxStreamsPerConnection,
+		protocol.DefaultMaxIncomingDynamicStreams,
+	)
+}
+
 var _ = Describe("Streams Map", func() {
 	var (
-		m *streamsMap
+		m   *streamsMap
+		cpm *handshake.ConnectionParametersManager
 	)
 
 	BeforeEach(func() {
-		m = newStreamsMap(nil)
+		cpm = newDefaultConnectionParametersManager()
+		m = newStreamsMap(nil, protocol.PerspectiveServer, cpm)
 	})
 
 	Context("getting and creating streams", func() {
@@ -54,22 +69,79 @@ var _ = Describe("Streams Map", func() {
 			Expect(s).To(BeNil())
 		})
 
-		It("panics on OpenStream", func() {
-			Expect(func() { m.OpenStream(0) }).To(Panic())
+		It("opens streams with even IDs", func() {
+			s, err := m.OpenStream()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.StreamID()).To(Equal(protocol.StreamID(2)))
+			s, err = m.OpenStream()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.StreamID()).To(Equal(protocol.StreamID(4)))
+		})
+
+		It("returns nil for closed streams opened by us", func() {
+			s, err := m.GetOrOpenStream(2)
+			Expect(err).To(HaveOccurred())
+			Expect(s).To(BeNil())
+			_, err = m.OpenStream()
+			Expect(err).NotTo(HaveOccurred())
+			err = m.RemoveStream(2)
+			Expect(err).NotTo(HaveOccurred())
+			s, err = m.GetOrOpenStream(2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(BeNil())
+		})
+
+		Context("as a client", func() {
+			BeforeEach(func() {
+				m.perspective = protocol.PerspectiveClient
+				m.nextStream = 1
+			})
+
+			It("opens streams with odd IDs", func() {
+				s, err := m.OpenStream()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s.StreamID()).To(Equal(protocol.StreamID(1)))
+				s, err = m.OpenStream()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s.StreamID()).To(Equal(protocol.StreamID(3)))
+			})
+
+			It("gets streams opened by the server", func() {
+				s, err := m.GetOrOpenStream(4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s.StreamID()).To(Equal(protocol.StreamID(4)))
+			})
+
+			It("rejects streams with odd IDs", func() {
+				_, err := m.GetOrOpenStream(5)
+				Expect(err).To(MatchError("InvalidStreamID: attempted to open stream 5 from server-side"))
+			})
 		})
 
 		Context("counting streams", func() {
 			It("errors when too many streams are opened", func() {
-				for i := 0; i < m.maxNumStreams; i++ {
+				for i := 0; i < int(m.maxIncomingStreams); i++ {
 					_, err := m.GetOrOpenStream(protocol.StreamID(i*2 + 1))
 					Expect(err).NotTo(HaveOccurred())
 				}
-				_, err := m.GetOrOpenStream(protocol.StreamID(m.maxNumStreams))
+				_, err := m.GetOrOpenStream(protocol.StreamID(2*m.maxIncomingStreams + 1))
 				Expect(err).To(MatchError(qerr.TooManyOpenStreams))
 			})
 
+			It("allows a bit more than the configured number of incoming streams", func() {
+				cpm = handshake.NewConnectionParamatersManager(0x1000, 0x1000, time.Second, time.Second, 20, 20)
+				m = newStreamsMap(m.newStream, protocol.PerspectiveServer, cpm)
+				Expect(m.maxIncomingStreams).To(Equal(uint32(22)))
+			})
+
+			It("derives the maximum StreamID delta from the configured number of incoming streams", func() {
+				cpm = handshake.NewConnectionParamatersManager(0x1000, 0x1000, time.Second, time.Second, 20, 20)
+				m = newStreamsMap(m.newStream, protocol.PerspectiveServer, cpm)
+				Expect(m.maxNewStreamIDDelta).To(Equal(protocol.StreamID(80)))
+			})
+
 			It("does not error when many streams are opened and closed", func() {
-				for i := 2; i < 10*m.maxNumStreams; i++ {
+				for i := 2; i < 10*int(m.maxIncomingStreams); i++ {
 					_, err := m.GetOrOpenStream(protocol.StreamID(i*2 + 1))
 					Expect(err).NotTo(HaveOccurred())
 					m.RemoveStream(protocol.StreamID(i*2 + 1))
@@ -79,10 +151,10 @@ var _ = Describe("Streams Map", func() {
 
 		Context("DoS mitigation", func() {
 			It("opens and closes a lot of streams", func() {
-				for i := 1; i < 2*protocol.MaxNewStreamIDDelta; i += 2 {
+				for i := 1; i < 2*int(m.maxNewStreamIDDelta); i += 2 {
 					streamID := protocol.StreamID(i)
 					_, err := m.GetOrOpenStream(streamID)
-					Expect(m.highestStreamOpenedByClient).To(Equal(streamID))
+					Expect(m.highestStreamOpenedByPeer).To(Equal(streamID))
 					Expect(err).NotTo(HaveOccurred())
 					err = m.RemoveStream(streamID)
 					Expect(err).NotTo(HaveOccurred())
@@ -90,7 +162,7 @@ var _ = Describe("Streams Map", func() {
 			})
 
 			It("prevents opening of streams with very low StreamIDs, if higher streams have already been opened", func() {
-				for i := 1; i < protocol.MaxNewStreamIDDelta+14; i += 2 {
+				for i := 1; i < int(m.maxNewStreamIDDelta)+14; i += 2 {
 					if i == 11 || i == 13 {
 						continue
 					}
@@ -100,7 +172,7 @@ var _ = Describe("Streams Map", func() {
 					err = m.RemoveStream(streamID)
 					Expect(err).NotTo(HaveOccurred())
 				}
-				Expect(m.highestStreamOpenedByClient).To(Equal(protocol.StreamID(protocol.MaxNewStreamIDDelta + 13)))
+				Expect(m.highestStreamOpenedByPeer).To(Equal(m.maxNewStreamIDDelta + 13))
 				_, err := m.GetOrOpenStream(11)
 				Expect(err).To(MatchError("InvalidStreamID: attempted to open stream 11, which is a lot smaller than the highest opened stream, 413"))
 				_, err = m.GetOrOpenStream(13)
@@ -108,19 +180,19 @@ var _ = Describe("Streams Map", func() {
 			})
 
 			It("garbage-collects closed streams", func() {
-				for i := 1; i < 4*protocol.MaxNewStreamIDDelta; i += 2 {
+				for i := 1; i < 4*int(m.maxNewStreamIDDelta); i += 2 {
 					streamID := protocol.StreamID(i)
 					_, err := m.GetOrOpenStream(streamID)
-					Expect(m.highestStreamOpenedByClient).To(Equal(streamID))
+					Expect(m.highestStreamOpenedByPeer).To(Equal(streamID))
 					Expect(err).NotTo(HaveOccurred())
 					err = m.RemoveStream(streamID)
 					Expect(err).NotTo(HaveOccurred())
 				}
 				m.garbageCollectClosedStreams()
-				for i := 1; i < 3*protocol.MaxNewStreamIDDelta; i += 2 {
+				for i := 1; i < 3*int(m.maxNewStreamIDDelta); i += 2 {
 					Expect(m.streams).ToNot(HaveKey(protocol.StreamID(i)))
 				}
-				for i := 3*protocol.MaxNewStreamIDDelta + 1; i < 4*protocol.MaxNewStreamIDDelta; i += 2 {
+				for i := 3*int(m.maxNewStreamIDDelta) + 1; i < 4*int(m.maxNewStreamIDDelta); i += 2 {
 					Expect(m.streams).To(HaveKey(protocol.StreamID(i)))
 				}
 			})
@@ -129,7 +201,7 @@ var _ = Describe("Streams Map", func() {
 				for i := 1; i < 1002; i += 2 {
 					streamID := protocol.StreamID(i)
 					_, err := m.GetOrOpenStream(streamID)
-					Expect(m.highestStreamOpenedByClient).To(Equal(streamID))
+					Expect(m.highestStreamOpenedByPeer).To(Equal(streamID))
 					Expect(err).NotTo(HaveOccurred())
 					if streamID != 23 {
 						err = m.RemoveStream(streamID)
@@ -146,10 +218,10 @@ var _ = Describe("Streams Map", func() {
 			It("runs garbage-collection after a bunch of streams have been opened", func() {
 				numGarbageCollections := 0
 				numSavedStreams := 0
-				for i := 1; i < 4*protocol.MaxNewStreamIDDelta; i += 2 {
+				for i := 1; i < 4*int(m.maxNewStreamIDDelta); i += 2 {
 					streamID := protocol.StreamID(i)
 					_, err := m.GetOrOpenStream(streamID)
-					Expect(m.highestStreamOpenedByClient).To(Equal(streamID))
+					Expect(m.highestStreamOpenedByPeer).To(Equal(streamID))
 					Expect(err).NotTo(HaveOccurred())
 					err = m.RemoveStream(streamID)
 					Expect(err).NotTo(HaveOccurred())
@@ -160,11 +232,292 @@ var _ = Describe("Streams Map", func() {
 				}
 				Expect(numGarbageCollections).ToNot(BeZero())
 				Expect(numGarbageCollections).To(BeNumerically("<", 4))
-				Expect(len(m.streams)).To(BeNumerically("<", 2*protocol.MaxNewStreamIDDelta))
+				Expect(len(m.streams)).To(BeNumerically("<", 2*int(m.maxNewStreamIDDelta)))
+			})
+		})
+	})
+
+	Context("opening streams", func() {
+		BeforeEach(func() {
+			m.newStream = func(id protocol.StreamID) (*stream, error) {
+				return &stream{streamID: id}, nil
+			}
+		})
+
+		setMaxOutgoingStreams := func(tag handshake.Tag, n byte) {
+			err := cpm.SetFromMap(map[handshake.Tag][]byte{tag: {n, 0, 0, 0}})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		It("respects the MIDS sent by the peer", func() {
+			setMaxOutgoingStreams(handshake.TagMIDS, 2)
+			_, err := m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = m.OpenStream()
+			Expect(err).To(MatchError(qerr.TooManyOpenStreams))
+		})
+
+		It("respects the negotiated MSPC", func() {
+			setMaxOutgoingStreams(handshake.TagMSPC, 1)
+			_, err := m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = m.OpenStream()
+			Expect(err).To(MatchError(qerr.TooManyOpenStreams))
+		})
+
+		It("doesn't count streams opened by the peer", func() {
+			setMaxOutgoingStreams(handshake.TagMIDS, 1)
+			for i := 1; i < 20; i += 2 {
+				_, err := m.GetOrOpenStream(protocol.StreamID(i))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			_, err := m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.numIncomingStreams).To(Equal(uint32(10)))
+			Expect(m.numOutgoingStreams).To(Equal(uint32(1)))
+		})
+
+		It("doesn't count streams opened by us towards the limit for incoming streams", func() {
+			for i := 0; i < int(m.maxIncomingStreams); i++ {
+				_, err := m.GetOrOpenStream(protocol.StreamID(2*i + 1))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			_, err := m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("allows opening a new stream after a stream was removed", func() {
+			setMaxOutgoingStreams(handshake.TagMIDS, 1)
+			str, err := m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			err = m.RemoveStream(str.StreamID())
+			Expect(err).ToNot(HaveOccurred())
+			str, err = m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.StreamID()).To(Equal(protocol.StreamID(4)))
+		})
+
+		It("returns the error when the map is closed", func() {
+			testErr := errors.New("test error")
+			m.CloseWithError(testErr)
+			_, err := m.OpenStream()
+			Expect(err).To(MatchError(testErr))
+			_, err = m.OpenStreamSync()
+			Expect(err).To(MatchError(testErr))
+		})
+
+		Context("synchronously", func() {
+			It("opens a stream", func() {
+				str, err := m.OpenStreamSync()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.StreamID()).To(Equal(protocol.StreamID(2)))
+			})
+
+			It("blocks until a stream is removed", func() {
+				setMaxOutgoingStreams(handshake.TagMIDS, 1)
+				_, err := m.OpenStream()
+				Expect(err).ToNot(HaveOccurred())
+				var str *stream
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					var err2 error
+					str, err2 = m.OpenStreamSync()
+					Expect(err2).ToNot(HaveOccurred())
+					close(done)
+				}()
+				Consistently(done).ShouldNot(BeClosed())
+				m.mutex.Lock()
+				err = m.RemoveStream(2)
+				m.mutex.Unlock()
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(done).Should(BeClosed())
+				Expect(str.StreamID()).To(Equal(protocol.StreamID(4)))
+			})
+
+			It("returns the error when the map is closed while waiting", func() {
+				setMaxOutgoingStreams(handshake.TagMIDS, 1)
+				_, err := m.OpenStream()
+				Expect(err).ToNot(HaveOccurred())
+				testErr := errors.New("test error")
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					_, err2 := m.OpenStreamSync()
+					Expect(err2).To(MatchError(testErr))
+					close(done)
+				}()
+				Consistently(done).ShouldNot(BeClosed())
+				m.CloseWithError(testErr)
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("returns the error when opening streams is stopped while waiting", func() {
+				setMaxOutgoingStreams(handshake.TagMIDS, 1)
+				_, err := m.OpenStream()
+				Expect(err).ToNot(HaveOccurred())
+				testErr := errors.New("test error")
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					_, err2 := m.OpenStreamSync()
+					Expect(err2).To(MatchError(testErr))
+					close(done)
+				}()
+				Consistently(done).ShouldNot(BeClosed())
+				m.StopOpeningStreams(testErr)
+				Eventually(done).Should(BeClosed())
+			})
+		})
+
+		Context("stopping", func() {
+			It("returns the error", func() {
+				testErr := errors.New("test error")
+				m.StopOpeningStreams(testErr)
+				_, err := m.OpenStream()
+				Expect(err).To(MatchError(testErr))
+				_, err = m.OpenStreamSync()
+				Expect(err).To(MatchError(testErr))
+			})
+
+			It("keeps the first error", func() {
+				testErr := errors.New("test error")
+				m.StopOpeningStreams(testErr)
+				m.StopOpeningStreams(errors.New("another error"))
+				_, err := m.OpenStream()
+				Expect(err).To(MatchError(testErr))
 			})
 		})
 	})
 
+	Context("rejecting new streams", func() {
+		BeforeEach(func() {
+			m.newStream = func(id protocol.StreamID) (*stream, error) {
+				return &stream{streamID: id}, nil
+			}
+		})
+
+		It("returns the highest stream opened by the peer", func() {
+			_, err := m.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.RejectNewStreams()).To(Equal(protocol.StreamID(5)))
+		})
+
+		It("ignores new streams opened by the peer", func() {
+			m.RejectNewStreams()
+			str, err := m.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str).To(BeNil())
+			Expect(m.NumberOfStreams()).To(BeZero())
+		})
+
+		It("still returns existing streams", func() {
+			_, err := m.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			m.RejectNewStreams()
+			str, err := m.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.StreamID()).To(Equal(protocol.StreamID(5)))
+		})
+	})
+
+	Context("accepting streams", func() {
+		BeforeEach(func() {
+			m.newStream = func(id protocol.StreamID) (*stream, error) {
+				return &stream{streamID: id}, nil
+			}
+		})
+
+		It("returns streams opened by the peer, in order", func() {
+			_, err := m.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = m.GetOrOpenStream(3)
+			Expect(err).ToNot(HaveOccurred())
+			str, err := m.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.StreamID()).To(Equal(protocol.StreamID(5)))
+			str, err = m.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
+			Expect(m.acceptQueue).To(BeEmpty())
+		})
+
+		It("doesn't return the crypto stream", func() {
+			_, err := m.GetOrOpenStream(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.acceptQueue).To(BeEmpty())
+		})
+
+		It("doesn't return streams opened by ourselves", func() {
+			_, err := m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.acceptQueue).To(BeEmpty())
+		})
+
+		It("doesn't return a stream twice", func() {
+			_, err := m.GetOrOpenStream(3)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = m.GetOrOpenStream(3)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.acceptQueue).To(HaveLen(1))
+		})
+
+		It("blocks until a stream is opened", func() {
+			var str *stream
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				var err error
+				str, err = m.AcceptStream()
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			Consistently(done).ShouldNot(BeClosed())
+			_, err := m.GetOrOpenStream(3)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(done).Should(BeClosed())
+			Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
+		})
+
+		It("returns the error when the map is closed", func() {
+			testErr := errors.New("test error")
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := m.AcceptStream()
+				Expect(err).To(MatchError(testErr))
+				close(done)
+			}()
+			Consistently(done).ShouldNot(BeClosed())
+			m.CloseWithError(testErr)
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("calls the stream callback instead of queueing the stream", func() {
+			var streams []protocol.StreamID
+			m.streamCallback = func(str *stream) { streams = append(streams, str.StreamID()) }
+			_, err := m.GetOrOpenStream(1)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = m.GetOrOpenStream(3)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(streams).To(Equal([]protocol.StreamID{1, 3}))
+			Expect(m.acceptQueue).To(BeEmpty())
+		})
+
+		It("returns the error for calls after closing, even if streams are queued", func() {
+			_, err := m.GetOrOpenStream(3)
+			Expect(err).ToNot(HaveOccurred())
+			testErr := errors.New("test error")
+			m.CloseWithError(testErr)
+			_, err = m.AcceptStream()
+			Expect(err).To(MatchError(testErr))
+		})
+	})
+
 	Context("deleting streams", func() {
 		BeforeEach(func() {
 			for i := 1; i <= 5; i++ {
@@ -207,6 +560,18 @@ var _ = Describe("Streams Map", func() {
 			}
 			Expect(m.openStreams).To(BeEmpty())
 		})
+
+		It("deletes streams opened by us right away", func() {
+			err := m.RemoveStream(4)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.streams).ToNot(HaveKey(protocol.StreamID(4)))
+		})
+
+		It("keeps a nil value for streams opened by the peer", func() {
+			err := m.RemoveStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.streams).To(HaveKeyWithValue(protocol.StreamID(5), BeNil()))
+		})
 	})
 
 	Context("number of streams", func() {
@@ -227,6 +592,15 @@ var _ = Describe("Streams Map", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(m.NumberOfStreams()).To(BeZero())
 		})
+
+		It("doesn't count the crypto and the header stream as data streams", func() {
+			for _, id := range []protocol.StreamID{1, 3, 5} {
+				err := m.putStream(&stream{streamID: id})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(m.NumberOfStreams()).To(Equal(3))
+			Expect(m.NumberOfDataStreams()).To(Equal(1))
+		})
 	})
 
 	Context("Iterate", func() {
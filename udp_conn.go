@@ -1,39 +1,93 @@
 package quic
 
 import (
+	"errors"
 	"net"
 	"sync"
+
+	"github.com/lucas-clemente/quic-go/utils"
 )
 
 type connection interface {
 	write([]byte) error
-	setCurrentRemoteAddr(interface{})
-	RemoteAddr() *net.UDPAddr
+	// writeBatch writes multiple packets, with a single syscall if possible
+	writeBatch([][]byte) error
+	setCurrentRemoteAddr(net.Addr)
+	RemoteAddr() net.Addr
 }
 
+// udpConn sends packets on a net.PacketConn
+// Batches of packets are written with a single syscall if the net.PacketConn supports it, see newBatchConn.
 type udpConn struct {
 	mutex sync.RWMutex
 
-	conn        *net.UDPConn
-	currentAddr *net.UDPAddr
+	conn        net.PacketConn
+	currentAddr net.Addr
+	// batchConn is created when it is used for the first time
+	batchConn batchConn
 }
 
 var _ connection = &udpConn{}
 
 func (c *udpConn) write(p []byte) error {
-	_, err := c.conn.WriteToUDP(p, c.currentAddr)
+	_, err := c.conn.WriteTo(p, c.RemoteAddr())
 	return err
 }
 
-func (c *udpConn) setCurrentRemoteAddr(addr interface{}) {
+func (c *udpConn) writeBatch(ps [][]byte) error {
+	if c.batchConn == nil {
+		c.batchConn = newBatchConn(c.conn)
+	}
+	return c.batchConn.WriteBatch(ps, c.RemoteAddr())
+}
+
+func (c *udpConn) setCurrentRemoteAddr(addr net.Addr) {
 	c.mutex.Lock()
-	c.currentAddr = addr.(*net.UDPAddr)
+	c.currentAddr = addr
 	c.mutex.Unlock()
 }
 
-func (c *udpConn) RemoteAddr() *net.UDPAddr {
+func (c *udpConn) RemoteAddr() net.Addr {
 	c.mutex.RLock()
 	addr := c.currentAddr
 	c.mutex.RUnlock()
 	return addr
 }
+
+// addrsEqual says if two addresses refer to the same peer
+func addrsEqual(a, b net.Addr) bool {
+	if ua, ok := a.(*net.UDPAddr); ok {
+		if ub, ok := b.(*net.UDPAddr); ok {
+			return ua.IP.Equal(ub.IP) && ua.Port == ub.Port && ua.Zone == ub.Zone
+		}
+	}
+	return a.Network() == b.Network() && a.String() == b.String()
+}
+
+// sourceAddrForSTK returns the source address that is stored in a source address token
+// For UDP, this is the IP address of the peer, for other transports the string representation of the address.
+func sourceAddrForSTK(addr net.Addr) []byte {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP
+	}
+	return []byte(addr.String())
+}
+
+// prepareForPMTUDiscovery sets the Don't Fragment bit on the connection, if path MTU discovery is enabled
+// If that's not possible, it returns a copy of the config with path MTU discovery disabled.
+func prepareForPMTUDiscovery(conn net.PacketConn, config *Config) *Config {
+	if !config.EnablePMTUDiscovery {
+		return config
+	}
+	err := errors.New("not a UDP connection")
+	if udpConn, ok := conn.(*net.UDPConn); ok {
+		err = setDontFragment(udpConn)
+	}
+	if err != nil {
+		utils.Infof("Disabling path MTU discovery: %s", err.Error())
+		c := *config
+		c.EnablePMTUDiscovery = false
+		return &c
+	}
+	return config
+}
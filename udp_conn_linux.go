@@ -0,0 +1,27 @@
+package quic
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment sets the Don't Fragment bit on all packets sent on the connection
+// Packets that are larger than the path MTU are then dropped by the network, instead of being fragmented.
+func setDontFragment(conn *net.UDPConn) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errIPv4, errIPv6 error
+	if err := rawConn.Control(func(fd uintptr) {
+		// Set both options, since an IPv6 socket might be used for IPv4 as well
+		errIPv4 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		errIPv6 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+	}); err != nil {
+		return err
+	}
+	if errIPv4 != nil && errIPv6 != nil {
+		return errIPv4
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package quic
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Don't Fragment bit", func() {
+	It("sets the Don't Fragment bit", func() {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		err = setDontFragment(conn)
+		Expect(err).ToNot(HaveOccurred())
+		rawConn, err := conn.SyscallConn()
+		Expect(err).ToNot(HaveOccurred())
+		var val int
+		err = rawConn.Control(func(fd uintptr) {
+			val, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER)
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(syscall.IP_PMTUDISC_DO))
+	})
+
+	It("keeps the connection in non-blocking mode", func() {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		err = setDontFragment(conn)
+		Expect(err).ToNot(HaveOccurred())
+		conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		_, _, err = conn.ReadFromUDP(make([]byte, 100))
+		Expect(err).To(HaveOccurred())
+		Expect(err.(net.Error).Timeout()).To(BeTrue())
+	})
+})
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+func setDontFragment(conn *net.UDPConn) error {
+	return errors.New("setting the Don't Fragment bit is not supported on this platform")
+}
@@ -0,0 +1,117 @@
+package quic
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockAddr string
+
+func (a mockAddr) Network() string { return "mock" }
+func (a mockAddr) String() string  { return string(a) }
+
+type mockPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// mockPacketConn is an in-memory net.PacketConn
+type mockPacketConn struct {
+	addr        net.Addr
+	dataToRead  chan mockPacket
+	dataWritten chan mockPacket
+	closed      chan struct{}
+}
+
+var _ net.PacketConn = &mockPacketConn{}
+
+func newMockPacketConn(addr net.Addr) *mockPacketConn {
+	return &mockPacketConn{
+		addr:        addr,
+		dataToRead:  make(chan mockPacket, 10),
+		dataWritten: make(chan mockPacket, 10),
+		closed:      make(chan struct{}),
+	}
+}
+
+func (c *mockPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-c.dataToRead:
+		return copy(b, p.data), p.addr, nil
+	case <-c.closed:
+		return 0, nil, errors.New("use of closed network connection")
+	}
+}
+
+func (c *mockPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.dataWritten <- mockPacket{data: append([]byte{}, b...), addr: addr}
+	return len(b), nil
+}
+
+func (c *mockPacketConn) Close() error                       { close(c.closed); return nil }
+func (c *mockPacketConn) LocalAddr() net.Addr                { return c.addr }
+func (c *mockPacketConn) SetDeadline(t time.Time) error      { panic("not implemented") }
+func (c *mockPacketConn) SetReadDeadline(t time.Time) error  { panic("not implemented") }
+func (c *mockPacketConn) SetWriteDeadline(t time.Time) error { panic("not implemented") }
+
+var _ = Describe("Connection", func() {
+	var (
+		c     *udpConn
+		pconn *mockPacketConn
+	)
+
+	BeforeEach(func() {
+		pconn = newMockPacketConn(mockAddr("local"))
+		c = &udpConn{conn: pconn, currentAddr: mockAddr("remote")}
+	})
+
+	It("writes packets to the current remote address", func() {
+		err := c.write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pconn.dataWritten).To(Receive(Equal(mockPacket{data: []byte("foobar"), addr: mockAddr("remote")})))
+	})
+
+	It("writes batches to connections that are not UDP sockets", func() {
+		c.setCurrentRemoteAddr(mockAddr("new remote"))
+		Expect(c.RemoteAddr()).To(Equal(mockAddr("new remote")))
+		err := c.writeBatch([][]byte{[]byte("foo"), []byte("bar")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pconn.dataWritten).To(Receive(Equal(mockPacket{data: []byte("foo"), addr: mockAddr("new remote")})))
+		Expect(pconn.dataWritten).To(Receive(Equal(mockPacket{data: []byte("bar"), addr: mockAddr("new remote")})))
+	})
+
+	It("disables path MTU discovery for connections that are not UDP sockets", func() {
+		config := &Config{EnablePMTUDiscovery: true}
+		Expect(prepareForPMTUDiscovery(pconn, config).EnablePMTUDiscovery).To(BeFalse())
+		Expect(config.EnablePMTUDiscovery).To(BeTrue())
+	})
+
+	Context("comparing addresses", func() {
+		It("compares UDP addresses", func() {
+			addr1 := &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1000}
+			addr2 := &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37).To4(), Port: 1000}
+			Expect(addrsEqual(addr1, addr2)).To(BeTrue())
+			Expect(addrsEqual(addr1, &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 2000})).To(BeFalse())
+		})
+
+		It("compares other addresses", func() {
+			Expect(addrsEqual(mockAddr("foo"), mockAddr("foo"))).To(BeTrue())
+			Expect(addrsEqual(mockAddr("foo"), mockAddr("bar"))).To(BeFalse())
+			Expect(addrsEqual(mockAddr("127.0.0.1:1000"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000})).To(BeFalse())
+		})
+	})
+
+	Context("source addresses for STKs", func() {
+		It("uses the IP of UDP addresses", func() {
+			Expect(sourceAddrForSTK(&net.UDPAddr{IP: net.IPv4(192, 168, 13, 37).To4(), Port: 1000})).To(Equal([]byte{192, 168, 13, 37}))
+		})
+
+		It("uses the string representation of other addresses", func() {
+			Expect(sourceAddrForSTK(mockAddr("foo"))).To(Equal([]byte("foo")))
+		})
+	})
+})
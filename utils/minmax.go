@@ -50,6 +50,14 @@ func MinUint32(a, b uint32) uint32 {
 	return b
 }
 
+// MinUint64 returns the minimum of two uint64
+func MinUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 // MinInt64 returns the minimum of two int64
 func MinInt64(a, b int64) int64 {
 	if a < b {
@@ -74,6 +82,14 @@ func MinByteCount(a, b protocol.ByteCount) protocol.ByteCount {
 	return b
 }
 
+// MaxByteCount returns the maximum of two ByteCounts
+func MaxByteCount(a, b protocol.ByteCount) protocol.ByteCount {
+	if a < b {
+		return b
+	}
+	return a
+}
+
 // MaxDuration returns the max duration
 func MaxDuration(a, b time.Duration) time.Duration {
 	if a > b {
@@ -44,6 +44,11 @@ var _ = Describe("Min / Max", func() {
 			Expect(MaxPacketNumber(1, 2)).To(Equal(protocol.PacketNumber(2)))
 			Expect(MaxPacketNumber(2, 1)).To(Equal(protocol.PacketNumber(2)))
 		})
+
+		It("returns the maximum ByteCount", func() {
+			Expect(MaxByteCount(7, 5)).To(Equal(protocol.ByteCount(7)))
+			Expect(MaxByteCount(5, 7)).To(Equal(protocol.ByteCount(7)))
+		})
 	})
 
 	Context("Min", func() {
@@ -57,6 +62,11 @@ var _ = Describe("Min / Max", func() {
 			Expect(MinUint32(5, 7)).To(Equal(uint32(5)))
 		})
 
+		It("returns the minimum uint64", func() {
+			Expect(MinUint64(7, 5)).To(Equal(uint64(5)))
+			Expect(MinUint64(5, 7)).To(Equal(uint64(5)))
+		})
+
 		It("returns the minimum int64", func() {
 			Expect(MinInt64(7, 5)).To(Equal(int64(5)))
 			Expect(MinInt64(5, 7)).To(Equal(int64(5)))
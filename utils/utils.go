@@ -3,6 +3,7 @@ package utils
 import (
 	"bytes"
 	"io"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 )
@@ -14,6 +15,13 @@ type Stream interface {
 	io.Closer
 	StreamID() protocol.StreamID
 	CloseRemote(offset protocol.ByteCount)
+	// Reset aborts the stream, and sends a RST_STREAM frame with the error code to the peer
+	Reset(errorCode uint32)
+	// SetDeadline, SetReadDeadline and SetWriteDeadline behave like the respective methods of net.Conn.
+	// If a deadline is exceeded, Read and Write return a net.Error with Timeout() == true.
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
 }
 
 // ReadUintN reads N bytes
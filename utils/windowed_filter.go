@@ -0,0 +1,75 @@
+package utils
+
+type windowedFilterSample struct {
+	value uint64
+	time  uint64
+}
+
+// A WindowedMaxFilter tracks the maximum of a value over a sliding window.
+// It uses the algorithm by Kathleen Nichols, as used in the Linux kernel and in Chromium:
+// Only the best, second best and third best estimates are kept, so updating the filter is O(1).
+// Time is measured in arbitrary units, e.g. in round trips.
+type WindowedMaxFilter struct {
+	windowLength uint64
+	estimates    [3]windowedFilterSample
+}
+
+// NewWindowedMaxFilter creates a new WindowedMaxFilter
+func NewWindowedMaxFilter(windowLength uint64) *WindowedMaxFilter {
+	return &WindowedMaxFilter{windowLength: windowLength}
+}
+
+// Update adds a new sample
+// The time must not be smaller than the time of any previous sample.
+func (f *WindowedMaxFilter) Update(value, time uint64) {
+	// Reset all estimates if they have not yet been initialized, if the new sample is a new best,
+	// or if the newest recorded estimate is too old.
+	if f.estimates[0].value == 0 || value >= f.estimates[0].value || time-f.estimates[2].time > f.windowLength {
+		f.Reset(value, time)
+		return
+	}
+
+	sample := windowedFilterSample{value: value, time: time}
+	if value >= f.estimates[1].value {
+		f.estimates[1] = sample
+		f.estimates[2] = sample
+	} else if value >= f.estimates[2].value {
+		f.estimates[2] = sample
+	}
+
+	// Expire and update estimates as necessary.
+	if time-f.estimates[0].time > f.windowLength {
+		// The best estimate hasn't been updated for an entire window, so promote second and third best estimates.
+		f.estimates[0] = f.estimates[1]
+		f.estimates[1] = f.estimates[2]
+		f.estimates[2] = sample
+		// Need to iterate one more time. Check if the new best estimate is outside the window as well,
+		// since it may also have been recorded a long time ago.
+		if time-f.estimates[0].time > f.windowLength {
+			f.estimates[0] = f.estimates[1]
+			f.estimates[1] = f.estimates[2]
+		}
+		return
+	}
+	if f.estimates[1].value == f.estimates[0].value && time-f.estimates[1].time > f.windowLength/4 {
+		// A quarter of the window has passed without a better sample, so the second best estimate is taken from the second quarter of the window.
+		f.estimates[1] = sample
+		f.estimates[2] = sample
+		return
+	}
+	if f.estimates[2].value == f.estimates[1].value && time-f.estimates[2].time > f.windowLength/2 {
+		// We've passed a half of the window without a better estimate, so take a third best estimate from the second half of the window.
+		f.estimates[2] = sample
+	}
+}
+
+// Reset resets all estimates to the new sample
+func (f *WindowedMaxFilter) Reset(value, time uint64) {
+	sample := windowedFilterSample{value: value, time: time}
+	f.estimates = [3]windowedFilterSample{sample, sample, sample}
+}
+
+// Get returns the best estimate
+func (f *WindowedMaxFilter) Get() uint64 {
+	return f.estimates[0].value
+}
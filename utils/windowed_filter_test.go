@@ -0,0 +1,72 @@
+package utils
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Windowed max filter", func() {
+	var filter *WindowedMaxFilter
+
+	BeforeEach(func() {
+		filter = NewWindowedMaxFilter(10)
+	})
+
+	It("is zero before the first sample", func() {
+		Expect(filter.Get()).To(BeZero())
+	})
+
+	It("returns the first sample", func() {
+		filter.Update(100, 1)
+		Expect(filter.Get()).To(Equal(uint64(100)))
+	})
+
+	It("returns a new maximum immediately", func() {
+		filter.Update(100, 1)
+		filter.Update(200, 2)
+		Expect(filter.Get()).To(Equal(uint64(200)))
+	})
+
+	It("keeps the maximum within the window", func() {
+		filter.Update(200, 1)
+		for t := uint64(2); t <= 11; t++ {
+			filter.Update(100, t)
+			Expect(filter.Get()).To(Equal(uint64(200)))
+		}
+	})
+
+	It("expires the maximum after the window", func() {
+		filter.Update(200, 1)
+		filter.Update(100, 5)
+		filter.Update(50, 8)
+		Expect(filter.Get()).To(Equal(uint64(200)))
+		filter.Update(50, 12)
+		Expect(filter.Get()).To(Equal(uint64(100)))
+		filter.Update(50, 16)
+		Expect(filter.Get()).To(Equal(uint64(50)))
+	})
+
+	It("falls back to the new sample if all estimates are too old", func() {
+		filter.Update(200, 1)
+		filter.Update(100, 20)
+		Expect(filter.Get()).To(Equal(uint64(100)))
+	})
+
+	It("decreases gradually with decreasing samples", func() {
+		var t uint64
+		for v := uint64(1000); v > 0; v -= 10 {
+			t++
+			filter.Update(v, t)
+			if t > 11 {
+				// the estimate is never older than the window
+				Expect(filter.Get()).To(BeNumerically("<=", v+11*10))
+			}
+		}
+	})
+
+	It("resets", func() {
+		filter.Update(200, 1)
+		filter.Reset(10, 2)
+		Expect(filter.Get()).To(Equal(uint64(10)))
+	})
+})